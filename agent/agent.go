@@ -23,6 +23,7 @@ type Agent struct {
 	pbm     *pbm.PBM
 	node    *pbm.Node
 	bcp     *currentBackup
+	rst     *currentRestore
 	pitrjob *currentPitr
 	mx      sync.Mutex
 	log     *log.Logger
@@ -32,6 +33,9 @@ type Agent struct {
 
 	// prevOO is previous pitr.oplogOnly value
 	prevOO *bool
+	// pitrFailed is whether the slicing failure was already notified.
+	// It's accessed by the slicing routine only.
+	pitrFailed bool
 }
 
 func New(pbm *pbm.PBM) *Agent {
@@ -105,8 +109,11 @@ func (a *Agent) Start() error {
 				a.CancelBackup()
 			case pbm.CmdRestore:
 				a.Restore(cmd.Restore, cmd.OPID, ep)
+			case pbm.CmdCancelRestore:
+				a.CancelRestore()
 			case pbm.CmdReplay:
-				a.OplogReplay(cmd.Replay, cmd.OPID, ep)
+				// replay runs in the go-routine so it can be canceled
+				go a.OplogReplay(cmd.Replay, cmd.OPID, ep)
 			case pbm.CmdResync:
 				a.Resync(cmd.OPID, ep)
 			case pbm.CmdPITRestore:
@@ -189,6 +196,7 @@ func (a *Agent) Delete(d *pbm.DeleteBackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 		l = a.pbm.Logger().NewEvent(string(pbm.CmdDeleteBackup), obj, opid.String(), ep.TS())
 		l.Info("deleting backups older than %v", t)
 		err := a.pbm.DeleteOlderThan(t, l)
+		a.notifyDelete("backups", obj, err, l)
 		if err != nil {
 			l.Error("deleting: %v", err)
 			return
@@ -197,6 +205,7 @@ func (a *Agent) Delete(d *pbm.DeleteBackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 		l = a.pbm.Logger().NewEvent(string(pbm.CmdDeleteBackup), d.Backup, opid.String(), ep.TS())
 		l.Info("deleting backup")
 		err := a.pbm.DeleteBackup(d.Backup, l)
+		a.notifyDelete("backup", d.Backup, err, l)
 		if err != nil {
 			l.Error("deleting: %v", err)
 			return
@@ -261,10 +270,12 @@ func (a *Agent) DeletePITR(d *pbm.DeletePITRCmd, opid pbm.OPID, ep pbm.Epoch) {
 		l = a.pbm.Logger().NewEvent(string(pbm.CmdDeletePITR), obj, opid.String(), ep.TS())
 		l.Info("deleting pitr chunks older than %v", t)
 		err = a.pbm.DeletePITR(&t, l)
+		a.notifyDelete("pitr", obj, err, l)
 	} else {
 		l = a.pbm.Logger().NewEvent(string(pbm.CmdDeletePITR), "_all_", opid.String(), ep.TS())
 		l.Info("deleting all pitr chunks")
 		err = a.pbm.DeletePITR(nil, l)
+		a.notifyDelete("pitr", "_all_", err, l)
 	}
 	if err != nil {
 		l.Error("deleting: %v", err)
@@ -317,19 +328,39 @@ func (a *Agent) Cleanup(d *pbm.CleanupCmd, opid pbm.OPID, ep pbm.Epoch) {
 		}
 	}()
 
+	// cleanupErr is the first error of the cleanup (if any) to notify about
+	var cleanupErr error
+	defer func() {
+		obj := "retention"
+		if !d.Retention {
+			obj = time.Unix(int64(d.OlderThan.T), 0).UTC().Format("2006-01-02T15:04:05Z")
+		}
+		a.notifyDelete("cleanup", obj, cleanupErr, l)
+	}()
+
 	stg, err := a.pbm.GetStorage(l)
 	if err != nil {
 		l.Error("get storage: " + err.Error())
+		cleanupErr = errors.WithMessage(err, "get storage")
 	}
 
 	eg := errgroup.Group{}
 	eg.SetLimit(runtime.NumCPU())
 
-	cr, err := pbm.MakeCleanupInfo(a.pbm.Context(), a.pbm.Conn, d.OlderThan)
+	var cr pbm.CleanupInfo
+	if d.Retention {
+		cr, err = a.retentionInfo()
+	} else {
+		cr, err = pbm.MakeCleanupInfo(a.pbm.Context(), a.pbm.Conn, d.OlderThan)
+	}
 	if err != nil {
 		l.Error("make cleanup report: " + err.Error())
+		cleanupErr = errors.WithMessage(err, "make cleanup report")
 		return
 	}
+	if d.Retention {
+		l.Info("retention: deleting %d backup(s) and %d chunk(s)", len(cr.Backups), len(cr.Chunks))
+	}
 
 	for i := range cr.Chunks {
 		name := cr.Chunks[i].FName
@@ -341,23 +372,50 @@ func (a *Agent) Cleanup(d *pbm.CleanupCmd, opid pbm.OPID, ep pbm.Epoch) {
 	}
 	if err := eg.Wait(); err != nil {
 		l.Error(err.Error())
+		if cleanupErr == nil {
+			cleanupErr = err
+		}
 	}
 
+	cfg, err := a.pbm.GetConfig()
+	if err != nil {
+		l.Error("get config: " + err.Error())
+		if cleanupErr == nil {
+			cleanupErr = errors.WithMessage(err, "get config")
+		}
+		return
+	}
+	stgs := pbm.NewStorages(cfg, l)
 	for i := range cr.Backups {
 		bcp := &cr.Backups[i]
 
+		bstg, err := stgs.Backup(bcp)
+		if err != nil {
+			l.Error("get storage of backup %q: %v", bcp.Name, err)
+			if cleanupErr == nil {
+				cleanupErr = errors.WithMessagef(err, "get storage of backup %q", bcp.Name)
+			}
+			continue
+		}
+
 		eg.Go(func() error {
-			err := a.pbm.DeleteBackupFiles(bcp, stg)
+			err := a.pbm.DeleteBackupFiles(bcp, bstg)
 			return errors.WithMessagef(err, "delete backup files %q", bcp.Name)
 		})
 	}
 	if err := eg.Wait(); err != nil {
 		l.Error(err.Error())
+		if cleanupErr == nil {
+			cleanupErr = err
+		}
 	}
 
 	err = a.pbm.ResyncStorage(l)
 	if err != nil {
 		l.Error("storage resync: " + err.Error())
+		if cleanupErr == nil {
+			cleanupErr = errors.WithMessage(err, "storage resync")
+		}
 	}
 }
 
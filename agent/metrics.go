@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/metrics"
+)
+
+var (
+	mBackupLastSuccess = metrics.Default.NewGauge("pbm_backup_last_success_timestamp_seconds",
+		"Completion time of the last successful backup")
+	mBackupLastSize = metrics.Default.NewGauge("pbm_backup_last_success_size_bytes",
+		"Size of the last successful backup")
+	mBackupDuration = metrics.Default.NewGauge("pbm_backup_duration_seconds",
+		"Duration of the last successful backup on the node", "type")
+	mRestoreDuration = metrics.Default.NewGauge("pbm_restore_duration_seconds",
+		"Duration of the last successful restore on the node", "type")
+	mPITRLag = metrics.Default.NewGauge("pbm_pitr_lag_seconds",
+		"Time since the end of the last PITR chunk of the replset")
+	mLockHeld = metrics.Default.NewGauge("pbm_lock_held",
+		"Whether the node holds the lock of the given operation type", "type")
+	mLockHbAge = metrics.Default.NewGauge("pbm_lock_heartbeat_age_seconds",
+		"Time since the last heartbeat of the lock held by the node", "type")
+	mAgentOK = metrics.Default.NewGauge("pbm_agent_ok",
+		"Whether the agent reports all subsystems as healthy")
+	mAgentSubsysOK = metrics.Default.NewGauge("pbm_agent_subsystem_ok",
+		"Whether the agent subsystem is healthy", "subsystem")
+	mAgentHbAge = metrics.Default.NewGauge("pbm_agent_heartbeat_age_seconds",
+		"Time since the last agent heartbeat")
+)
+
+// ServeMetrics exposes agent metrics in the Prometheus format
+// on the addr at /metrics.
+func (a *Agent) ServeMetrics(addr string) error {
+	metrics.Default.SetConstLabel("rs", a.node.RS())
+	metrics.Default.SetConstLabel("node", a.node.Name())
+	metrics.Default.OnCollect(a.collectMetrics)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+
+	a.log.Printf("serving metrics on %s", addr)
+	return errors.Wrap(http.ListenAndServe(addr, mux), "serve metrics")
+}
+
+// collectMetrics updates the metrics which values are read from
+// the PBM collections.
+func (a *Agent) collectMetrics() {
+	now := time.Now().UTC().Unix()
+	rs, node := a.node.RS(), a.node.Name()
+
+	bcp, err := a.pbm.LastSuccessfulBackup()
+	if err == nil {
+		mBackupLastSuccess.With().Set(float64(bcp.LastTransitionTS))
+		mBackupLastSize.With().Set(float64(bcp.Size))
+	} else if !errors.Is(err, pbm.ErrNotFound) {
+		a.metricsErr("get last backup: %v", err)
+	}
+
+	chnk, err := a.pbm.PITRLastChunkMeta(rs)
+	if err != nil {
+		a.metricsErr("get last pitr chunk: %v", err)
+	} else if chnk != nil {
+		mPITRLag.With().Set(float64(now - int64(chnk.EndTS.T)))
+	}
+
+	mLockHeld.Reset()
+	mLockHbAge.Reset()
+	for _, get := range []func(*pbm.LockHeader) ([]pbm.LockData, error){a.pbm.GetLocks, a.pbm.GetOpLocks} {
+		locks, err := get(&pbm.LockHeader{Replset: rs})
+		if err != nil {
+			a.metricsErr("get locks: %v", err)
+			continue
+		}
+		for _, l := range locks {
+			if l.Node != node {
+				continue
+			}
+			mLockHeld.With(string(l.Type)).Set(1)
+			mLockHbAge.With(string(l.Type)).Set(float64(now - int64(l.Heartbeat.T)))
+		}
+	}
+	for _, t := range []pbm.Command{pbm.CmdBackup, pbm.CmdRestore, pbm.CmdPITR} {
+		mLockHeld.With(string(t)).Add(0)
+	}
+
+	stat, err := a.pbm.GetAgentStatus(rs, node)
+	if err != nil {
+		a.metricsErr("get agent status: %v", err)
+		return
+	}
+	ok, _ := stat.OK()
+	mAgentOK.With().SetBool(ok)
+	mAgentSubsysOK.With("pbm").SetBool(stat.PBMStatus.OK)
+	mAgentSubsysOK.With("node").SetBool(stat.NodeStatus.OK)
+	mAgentSubsysOK.With("storage").SetBool(stat.StorageStatus.OK)
+	mAgentHbAge.With().Set(float64(now - int64(stat.Heartbeat.T)))
+}
+
+func (a *Agent) metricsErr(msg string, args ...interface{}) {
+	a.log.Debug("", "", "", primitive.Timestamp{}, "metrics: "+msg, args...)
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/notify"
+)
+
+// webhooks returns the notification webhooks of the current config
+func (a *Agent) webhooks(l *log.Event) []pbm.WebhookConf {
+	cfg, err := a.pbm.GetConfig()
+	if err != nil {
+		l.Warning("notify: get config: %v", err)
+		return nil
+	}
+	if cfg.Notifications == nil {
+		return nil
+	}
+
+	return cfg.Notifications.Webhooks
+}
+
+// notify sends the event to the configured webhooks.
+// The delivery runs in the background.
+func (a *Agent) notify(e *notify.Event, l *log.Event) {
+	hooks := a.webhooks(l)
+	if len(hooks) == 0 {
+		return
+	}
+
+	go notify.Send(hooks, e, l)
+}
+
+// notifyBackup sends the final state of the backup
+func (a *Agent) notifyBackup(name string, l *log.Event) {
+	bcp, err := a.pbm.GetBackupMeta(name)
+	if err != nil {
+		l.Warning("notify: get backup meta: %v", err)
+		return
+	}
+	if !notify.IsTerminal(bcp.Status) {
+		l.Debug("notify: skip backup in %s state", bcp.Status)
+		return
+	}
+
+	a.notify(notify.BackupEvent(bcp), l)
+}
+
+// notifyRestore sends the final state of the logical restore
+func (a *Agent) notifyRestore(name string, l *log.Event) {
+	meta, err := a.pbm.GetRestoreMeta(name)
+	if err != nil {
+		l.Warning("notify: get restore meta: %v", err)
+		return
+	}
+	if !notify.IsTerminal(meta.Status) {
+		l.Debug("notify: skip restore in %s state", meta.Status)
+		return
+	}
+
+	a.notify(notify.RestoreEvent(meta), l)
+}
+
+// notifyPhysRestore sends the final state of the physical restore.
+// The database is unavailable by the end of the restore, so the meta
+// is read from the storage and the config must be taken in advance.
+// It blocks until the delivery is done since the agent is about to exit.
+func notifyPhysRestore(cfg pbm.Config, name string, l *log.Event) {
+	if cfg.Notifications == nil || len(cfg.Notifications.Webhooks) == 0 {
+		return
+	}
+
+	stg, err := pbm.Storage(cfg, l)
+	if err != nil {
+		l.Warning("notify: get storage: %v", err)
+		return
+	}
+	meta, err := pbm.GetPhysRestoreMeta(name, stg, l)
+	if err != nil {
+		l.Warning("notify: get restore meta: %v", err)
+		return
+	}
+	if !notify.IsTerminal(meta.Status) {
+		l.Debug("notify: skip restore in %s state", meta.Status)
+		return
+	}
+
+	notify.Send(cfg.Notifications.Webhooks, notify.RestoreEvent(meta), l)
+}
+
+// notifyDelete sends the result of the deletion. what is the kind of
+// deleted data and name is the backup name or the deletion time point.
+func (a *Agent) notifyDelete(what, name string, err error, l *log.Event) {
+	status := pbm.StatusDone
+	if err != nil {
+		status = pbm.StatusError
+	}
+
+	e := notify.NewEvent(notify.OpDelete, status, name)
+	e.Type = what
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	a.notify(e, l)
+}
+
+// notifyPITR sends the result of the oplog slicing. It's either an error
+// or done when the slicing has been disabled.
+func (a *Agent) notifyPITR(start int64, err error, l *log.Event) {
+	status := pbm.StatusDone
+	if err != nil {
+		status = pbm.StatusError
+	}
+
+	e := notify.NewEvent(notify.OpPITR, status, "")
+	e.StartTS = start
+	e.Duration = e.EndTS - start
+	rs := notify.Replset{
+		Name:   a.node.RS(),
+		Status: status,
+		Node:   a.node.Name(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+		rs.Error = e.Error
+	}
+	e.Replsets = []notify.Replset{rs}
+
+	a.notify(e, l)
+}
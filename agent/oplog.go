@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"time"
 
 	"github.com/pkg/errors"
@@ -59,16 +60,29 @@ func (a *Agent) OplogReplay(r *pbm.ReplayCmd, opID pbm.OPID, ep pbm.Epoch) {
 		}
 	}()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.setRst(&currentRestore{name: r.Name, cancel: cancel})
+	defer a.unsetRst()
+
 	l.Info("oplog replay started")
-	if err := restore.New(a.pbm, a.node, r.RSMap).ReplayOplog(r, opID, l); err != nil {
+	start := time.Now()
+	err = restore.New(a.pbm, a.node, r.RSMap).ReplayOplog(ctx, r, opID, l)
+	if nodeInfo.IsLeader() && !errors.Is(err, restore.ErrNoDataForShard) {
+		a.notifyRestore(r.Name, l)
+	}
+	if err != nil {
 		if errors.Is(err, restore.ErrNoDataForShard) {
 			l.Info("no oplog for the shard, skipping")
+		} else if errors.Is(err, restore.ErrCancelled) {
+			l.Info("oplog replay was canceled")
 		} else {
 			l.Error("oplog replay: %v", err.Error())
 		}
 		return
 	}
 	l.Info("oplog replay successfully finished")
+	mRestoreDuration.With(string(pbm.CmdReplay)).Set(time.Since(start).Seconds())
 
 	resetEpoch, err := a.pbm.ResetEpoch()
 	if err != nil {
@@ -5,10 +5,13 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/pitr"
 	"github.com/percona/percona-backup-mongodb/pbm/restore"
 )
@@ -164,11 +167,23 @@ func (a *Agent) pitr() (err error) {
 		return nil
 	}
 
-	stg, err := a.pbm.GetStorage(l)
+	stg, err := pbm.ThrottledStorage(cfg, pbm.ThrottlePITR, nil, l)
 	if err != nil {
 		return errors.Wrap(err, "unable to get storage configuration")
 	}
 
+	keys, err := crypt.NewKeyProvider(cfg.Encryption)
+	if err != nil {
+		return errors.Wrap(err, "init encryption")
+	}
+	if keys != nil {
+		key, err := keys.Key()
+		if err != nil {
+			return errors.Wrap(err, "get encryption key")
+		}
+		stg = crypt.Wrap(stg, key, keys)
+	}
+
 	epts := ep.TS()
 	lock := a.pbm.NewLock(pbm.LockHeader{
 		Replset: a.node.RS(),
@@ -211,6 +226,8 @@ func (a *Agent) pitr() (err error) {
 			w:      w,
 		})
 
+		start := time.Now().UTC().Unix()
+		from := ibcp.LastTS()
 		streamErr := ibcp.Stream(ctx, w, cfg.PITR.Compression, cfg.PITR.CompressionLevel)
 		if streamErr != nil {
 			switch streamErr.(type) {
@@ -220,6 +237,10 @@ func (a *Agent) pitr() (err error) {
 				l.Error("streaming oplog: %v", streamErr)
 			}
 		}
+		if ninf.IsLeader() {
+			progressed := primitive.CompareTimestamp(ibcp.LastTS(), from) != 0
+			a.notifyPITRChange(start, streamErr, progressed, l)
+		}
 
 		if err := lock.Release(); err != nil {
 			l.Error("release lock: %v", err)
@@ -238,6 +259,36 @@ func (a *Agent) pitr() (err error) {
 	return nil
 }
 
+// notifyPITRChange notifies about the slicing state changes only: the
+// slicing was disabled or has failed. Failed retries are the same state
+// unless the slicer made some progress in between.
+func (a *Agent) notifyPITRChange(start int64, streamErr error, progressed bool, l *log.Event) {
+	if progressed {
+		a.pitrFailed = false
+	}
+
+	if streamErr != nil {
+		if _, ok := streamErr.(pitr.ErrOpMoved); ok || a.pitrFailed {
+			return
+		}
+		a.pitrFailed = true
+		a.notifyPITR(start, streamErr, l)
+		return
+	}
+
+	cfg, err := a.pbm.GetConfig()
+	if err != nil {
+		l.Warning("notify: get config: %v", err)
+		return
+	}
+	// stopped for the other reason (e.g. oplogOnly change) and will be resumed
+	if cfg.PITR.Enabled {
+		return
+	}
+	a.pitrFailed = false
+	a.notifyPITR(start, nil, l)
+}
+
 func (a *Agent) pitrLockCheck() (moveOn bool, err error) {
 	ts, err := a.pbm.ClusterTime()
 	if err != nil {
@@ -270,6 +321,44 @@ func (a *Agent) PITRestore(r *pbm.PITRestoreCmd, opid pbm.OPID, ep pbm.Epoch) {
 
 	l.Info("to time: %s", time.Unix(r.TS, 0).UTC().Format(time.RFC3339))
 
+	if r.Bcp != "" {
+		bcp, err := a.pbm.GetBackupMeta(r.Bcp)
+		if errors.Is(err, pbm.ErrNotFound) {
+			cfg, err := a.pbm.GetConfig()
+			if err != nil {
+				l.Error("get config: %v", err)
+				return
+			}
+
+			bcp, err = restore.GetMetaFromStores(cfg, r.Bcp, l)
+			if err != nil {
+				l.Error("get backup metadata: %v", err)
+				return
+			}
+		} else if err != nil {
+			l.Error("get backup metadata: %v", err)
+			return
+		}
+
+		if bcp.Type == pbm.PhysicalBackup || bcp.Type == pbm.IncrementalBackup {
+			l.Info("base snapshot: %s (%s)", bcp.Name, bcp.Type)
+			err = a.restorePhysical(&pbm.RestoreCmd{
+				Name:       r.Name,
+				BackupName: r.Bcp,
+				RSMap:      r.RSMap,
+			}, primitive.Timestamp{T: uint32(r.TS), I: uint32(r.I)}, opid, ep, l)
+			if err != nil {
+				l.Error("%v", err)
+			}
+			return
+		}
+	}
+
+	// logical restore runs in the go-routine so it can be canceled
+	go a.pitRestoreLogical(r, opid, ep, l)
+}
+
+func (a *Agent) pitRestoreLogical(r *pbm.PITRestoreCmd, opid pbm.OPID, ep pbm.Epoch, l *log.Event) {
 	nodeInfo, err := a.node.GetInfo()
 	if err != nil {
 		l.Error("get node info: %v", err)
@@ -307,17 +396,29 @@ func (a *Agent) PITRestore(r *pbm.PITRestoreCmd, opid pbm.OPID, ep pbm.Epoch) {
 		}
 	}()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.setRst(&currentRestore{name: r.Name, cancel: cancel})
+	defer a.unsetRst()
+
 	l.Info("recovery started")
-	err = restore.New(a.pbm, a.node, r.RSMap).PITR(r, opid, l)
+	start := time.Now()
+	err = restore.New(a.pbm, a.node, r.RSMap).PITR(ctx, r, opid, l)
+	if nodeInfo.IsLeader() && !errors.Is(err, restore.ErrNoDataForShard) {
+		a.notifyRestore(r.Name, l)
+	}
 	if err != nil {
 		if errors.Is(err, restore.ErrNoDataForShard) {
 			l.Info("no data for the shard in backup, skipping")
+		} else if errors.Is(err, restore.ErrCancelled) {
+			l.Info("restore was canceled")
 		} else {
 			l.Error("restore: %v", err)
 		}
 		return
 	}
 	l.Info("recovery successfully finished")
+	mRestoreDuration.With(string(pbm.CmdPITR)).Set(time.Since(start).Seconds())
 
 	if nodeInfo.IsLeader() {
 		epch, err := a.pbm.ResetEpoch()
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+func (a *Agent) retentionInfo() (pbm.CleanupInfo, error) {
+	cfg, err := a.pbm.GetConfig()
+	if err != nil {
+		return pbm.CleanupInfo{}, errors.Wrap(err, "get config")
+	}
+	if !cfg.Retention.Enabled() {
+		return pbm.CleanupInfo{}, errors.New("retention policy isn't configured")
+	}
+
+	ct, err := a.pbm.ClusterTime()
+	if err != nil {
+		return pbm.CleanupInfo{}, errors.Wrap(err, "get cluster time")
+	}
+
+	return a.pbm.MakeRetentionInfo(cfg.Retention, time.Unix(int64(ct.T), 0))
+}
+
+// applyRetention requests the cleanup by the retention policy if it's
+// configured. It's called by the leader after a successful backup.
+func (a *Agent) applyRetention(l *log.Event) {
+	cfg, err := a.pbm.GetConfig()
+	if err != nil {
+		l.Error("retention: get config: %v", err)
+		return
+	}
+	if !cfg.Retention.Enabled() {
+		return
+	}
+
+	err = a.pbm.SendCmd(pbm.Cmd{
+		Cmd:     pbm.CmdCleanup,
+		Cleanup: &pbm.CleanupCmd{Retention: true},
+	})
+	if err != nil {
+		l.Error("retention: send cleanup command: %v", err)
+		return
+	}
+
+	l.Info("retention: cleanup requested")
+}
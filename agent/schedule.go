@@ -0,0 +1,273 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/cron"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+const (
+	scheduleCheckPeriod = time.Second * 20
+
+	// scheduleStartDeadline is how late a scheduled backup still can be
+	// started (e.g. after the leader agent was restarted). Later runs are
+	// recorded as missed.
+	scheduleStartDeadline = time.Minute * 10
+
+	// scheduleMissedMax limits the number of missed runs recorded at once
+	scheduleMissedMax = 10
+)
+
+// Schedule starts the backup scheduler routine. Only the cluster leader
+// agent (primary of the config server or of the non-sharded replicaset)
+// evaluates the schedule and sends backup commands.
+func (a *Agent) Schedule() {
+	a.log.Printf("starting backup scheduler")
+
+	tk := time.NewTicker(scheduleCheckPeriod)
+	defer tk.Stop()
+
+	for range tk.C {
+		err := a.schedule()
+		if err != nil {
+			ep, _ := a.pbm.GetEpoch()
+			a.log.Error(string(pbm.CmdSchedule), "", "", ep.TS(), "%v", err)
+		}
+	}
+}
+
+func (a *Agent) schedule() error {
+	// pausing for physical restore
+	if !a.HbIsRun() {
+		return nil
+	}
+
+	nodeInfo, err := a.node.GetInfo()
+	if err != nil {
+		return errors.Wrap(err, "get node info")
+	}
+	if !nodeInfo.IsClusterLeader() {
+		return nil
+	}
+
+	cfg, err := a.pbm.GetConfig()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return errors.Wrap(err, "get config")
+	}
+
+	names := make([]string, 0, len(cfg.Schedule))
+	for _, e := range cfg.Schedule {
+		names = append(names, e.Name)
+	}
+	err = a.pbm.DeleteScheduleStates(names)
+	if err != nil {
+		return errors.Wrap(err, "delete states of removed entries")
+	}
+
+	if len(cfg.Schedule) == 0 {
+		return nil
+	}
+
+	ct, err := a.pbm.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+	now := time.Unix(int64(ct.T), 0).UTC()
+
+	ep, err := a.pbm.GetEpoch()
+	if err != nil {
+		return errors.Wrap(err, "get epoch")
+	}
+
+	// only one backup can be started at a time
+	var started string
+	for _, e := range cfg.Schedule {
+		l := a.log.NewEvent(string(pbm.CmdSchedule), e.Name, "", ep.TS())
+
+		bcp, err := a.scheduleEntry(cfg, e, now, started, l)
+		if err != nil {
+			l.Error("%v", err)
+		}
+		if bcp != "" {
+			started = bcp
+		}
+	}
+
+	return nil
+}
+
+// scheduleEntry starts the entry's backup if it's time to. It returns
+// the name of the started backup. If started isn't empty, some backup has
+// already been started at this tick and the run is recorded as missed.
+func (a *Agent) scheduleEntry(
+	cfg pbm.Config,
+	e pbm.ScheduleConf,
+	now time.Time,
+	started string,
+	l *log.Event,
+) (string, error) {
+	spec, err := cron.Parse(e.Cron)
+	if err != nil {
+		return "", errors.Wrap(err, "parse cron")
+	}
+
+	st, err := a.pbm.GetScheduleState(e.Name)
+	if err != nil && !errors.Is(err, pbm.ErrNotFound) {
+		return "", errors.Wrap(err, "get state")
+	}
+	// new or changed entry. It starts from now on.
+	if st == nil || st.Cron != e.Cron {
+		l.Info("schedule %q, next run at %s", e.Cron, formatTime(spec.Next(now)))
+		return "", errors.Wrap(a.pbm.ResetScheduleState(e.Name, e.Cron, now.Unix()), "reset state")
+	}
+
+	run := spec.Next(time.Unix(st.LastRun, 0).UTC())
+	if run.IsZero() || run.After(now) {
+		return "", nil
+	}
+
+	// runs that passed while there was no leader agent
+	var missed []pbm.MissedRun
+	for next := spec.Next(run); !next.IsZero() && !next.After(now); next = spec.Next(next) {
+		missed = append(missed, pbm.MissedRun{
+			Time:   run.Unix(),
+			Reason: "no leader agent was running",
+		})
+		run = next
+	}
+
+	ok, err := a.pbm.ClaimScheduleRun(e.Name, st.LastRun, run.Unix())
+	if err != nil {
+		return "", errors.Wrap(err, "claim run")
+	}
+	if !ok {
+		l.Debug("run at %s is handled by another agent", formatTime(run))
+		return "", nil
+	}
+
+	if now.Sub(run) > scheduleStartDeadline {
+		missed = append(missed, pbm.MissedRun{
+			Time:   run.Unix(),
+			Reason: fmt.Sprintf("not started within %v", scheduleStartDeadline),
+		})
+		return "", a.scheduleMissed(e.Name, missed, l)
+	}
+
+	reason := ""
+	if started != "" {
+		reason = fmt.Sprintf("backup %s is started by another entry at the same time", started)
+	} else {
+		reason, err = a.scheduleBlocked()
+		if err != nil {
+			return "", errors.Wrap(err, "check running operations")
+		}
+	}
+	if reason != "" {
+		missed = append(missed, pbm.MissedRun{Time: run.Unix(), Reason: reason})
+		return "", a.scheduleMissed(e.Name, missed, l)
+	}
+
+	err = a.scheduleMissed(e.Name, missed, l)
+	if err != nil {
+		return "", err
+	}
+
+	compression := cfg.Backup.Compression
+	if e.Compression != "" {
+		compression = e.Compression
+	}
+	level := cfg.Backup.CompressionLevel
+	if e.CompressionLevel != nil {
+		level = e.CompressionLevel
+	}
+	typ := e.Type
+	if typ == "" {
+		typ = pbm.LogicalBackup
+	}
+
+	bcp := &pbm.BackupCmd{
+		Type:             typ,
+		IncrBase:         e.IncrBase,
+		Name:             now.Format(time.RFC3339),
+		Namespaces:       e.Namespaces,
+		Compression:      compression,
+		CompressionLevel: level,
+		Profile:          e.Profile,
+	}
+	err = a.pbm.SendCmd(pbm.Cmd{Cmd: pbm.CmdBackup, Backup: bcp})
+	if err != nil {
+		return "", errors.Wrap(err, "send backup command")
+	}
+	l.Info("run at %s: started backup %s <%s>, next run at %s",
+		formatTime(run), bcp.Name, bcp.Type, formatTime(spec.Next(run)))
+
+	return bcp.Name, errors.Wrap(a.pbm.SetScheduleLastBackup(e.Name, bcp.Name), "set last backup")
+}
+
+// scheduleBlocked returns the reason why a backup can't be started now.
+// Or an empty string if it can.
+func (a *Agent) scheduleBlocked() (string, error) {
+	locks, err := a.pbm.GetLocks(&pbm.LockHeader{})
+	if err != nil {
+		return "", errors.Wrap(err, "get locks")
+	}
+
+	ts, err := a.pbm.ClusterTime()
+	if err != nil {
+		return "", errors.Wrap(err, "read cluster time")
+	}
+
+	return blockedBy(locks, ts), nil
+}
+
+// blockedBy returns the reason why a backup can't be started at the cluster
+// time ts with the given locks. Stale locks are left for agents to deal with.
+// PITR slicing runs along with the backup start (agents resolve it).
+func blockedBy(locks []pbm.LockData, ts primitive.Timestamp) string {
+	for _, lk := range locks {
+		if lk.Type == pbm.CmdPITR {
+			continue
+		}
+		if lk.Heartbeat.T+pbm.StaleFrameSec >= ts.T {
+			return fmt.Sprintf("another operation is running: %s [opid: %s]", lk.Type, lk.OPID)
+		}
+	}
+
+	return ""
+}
+
+func (a *Agent) scheduleMissed(name string, runs []pbm.MissedRun, l *log.Event) error {
+	if len(runs) == 0 {
+		return nil
+	}
+
+	if len(runs) > scheduleMissedMax {
+		l.Warning("missed %d runs from %s to %s: %s", len(runs)-scheduleMissedMax,
+			formatTime(time.Unix(runs[0].Time, 0)),
+			formatTime(time.Unix(runs[len(runs)-scheduleMissedMax-1].Time, 0)),
+			runs[0].Reason)
+		runs = runs[len(runs)-scheduleMissedMax:]
+	}
+	for _, r := range runs {
+		l.Warning("missed run at %s: %s", formatTime(time.Unix(r.Time, 0)), r.Reason)
+	}
+
+	return errors.Wrap(a.pbm.AddScheduleMissed(name, runs...), "record missed runs")
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
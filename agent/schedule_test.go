@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestBlockedBy(t *testing.T) {
+	now := primitive.Timestamp{T: 1000}
+	lock := func(typ pbm.Command, hb uint32) pbm.LockData {
+		return pbm.LockData{
+			LockHeader: pbm.LockHeader{Type: typ, OPID: "op"},
+			Heartbeat:  primitive.Timestamp{T: hb},
+		}
+	}
+
+	cases := []struct {
+		name    string
+		locks   []pbm.LockData
+		blocked pbm.Command
+	}{
+		{"no locks", nil, ""},
+		{"pitr slicing", []pbm.LockData{lock(pbm.CmdPITR, 1000)}, ""},
+		{"stale backup", []pbm.LockData{lock(pbm.CmdBackup, 1000-pbm.StaleFrameSec-1)}, ""},
+		{"backup", []pbm.LockData{lock(pbm.CmdBackup, 1000)}, pbm.CmdBackup},
+		{"pitr and restore", []pbm.LockData{lock(pbm.CmdPITR, 1000), lock(pbm.CmdRestore, 990)}, pbm.CmdRestore},
+	}
+
+	for _, c := range cases {
+		got := blockedBy(c.locks, now)
+		if c.blocked == "" {
+			if got != "" {
+				t.Errorf("%s: expected not blocked, got %q", c.name, got)
+			}
+			continue
+		}
+		if want := fmt.Sprintf("another operation is running: %s [opid: op]", c.blocked); got != want {
+			t.Errorf("%s: expected blocked by %s, got %q", c.name, c.blocked, got)
+		}
+	}
+}
@@ -5,12 +5,12 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/restore"
-	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
 type currentBackup struct {
@@ -46,6 +46,40 @@ func (a *Agent) CancelBackup() {
 	a.bcp.cancel()
 }
 
+type currentRestore struct {
+	name   string
+	cancel context.CancelFunc
+}
+
+func (a *Agent) setRst(r *currentRestore) (changed bool) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if a.rst != nil {
+		return false
+	}
+
+	a.rst = r
+	return true
+}
+
+func (a *Agent) unsetRst() {
+	a.mx.Lock()
+	a.rst = nil
+	a.mx.Unlock()
+}
+
+// CancelRestore cancels current logical restore, PITR restore or oplog replay.
+// Physical restores can't be canceled.
+func (a *Agent) CancelRestore() {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if a.rst == nil {
+		return
+	}
+
+	a.rst.cancel()
+}
+
 // Backup starts backup
 func (a *Agent) Backup(cmd *pbm.BackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 	if cmd == nil {
@@ -198,9 +232,10 @@ func (a *Agent) Backup(cmd *pbm.BackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 		cancel: cancel,
 	})
 	l.Info("backup started")
-	err = bcp.Run(ctx, cmd, opid, l)
+	start := time.Now()
+	bcpErr := bcp.Run(ctx, cmd, opid, l)
 	a.unsetBcp()
-	if err != nil {
+	if err := bcpErr; err != nil {
 		if errors.Is(err, backup.ErrCancelled) {
 			l.Info("backup was canceled")
 		} else {
@@ -208,6 +243,7 @@ func (a *Agent) Backup(cmd *pbm.BackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 		}
 	} else {
 		l.Info("backup finished")
+		mBackupDuration.With(string(cmd.Type)).Set(time.Since(start).Seconds())
 	}
 
 	l.Debug("releasing lock")
@@ -215,6 +251,14 @@ func (a *Agent) Backup(cmd *pbm.BackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 	if err != nil {
 		l.Error("unable to release backup lock %v: %v", lock, err)
 	}
+
+	// the backup on the leader replset is done when the whole backup is
+	if nodeInfo.IsLeader() {
+		a.notifyBackup(cmd.Name, l)
+		if bcpErr == nil {
+			a.applyRetention(l)
+		}
+	}
 }
 
 const renominationFrame = 5 * time.Second
@@ -295,16 +339,16 @@ func (a *Agent) Restore(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch) {
 
 	l.Info("backup: %s", r.BackupName)
 
-	var stg storage.Storage
 	bcp, err := a.pbm.GetBackupMeta(r.BackupName)
 	if errors.Is(err, pbm.ErrNotFound) {
-		stg, err = a.pbm.GetStorage(l)
+		var cfg pbm.Config
+		cfg, err = a.pbm.GetConfig()
 		if err != nil {
-			l.Error("get storage: %v", err)
+			l.Error("get config: %v", err)
 			return
 		}
 
-		bcp, err = restore.GetMetaFromStore(stg, r.BackupName)
+		bcp, err = restore.GetMetaFromStores(cfg, r.BackupName, l)
 	}
 	if err != nil {
 		l.Error("get backup metadata: %v", err)
@@ -312,16 +356,20 @@ func (a *Agent) Restore(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch) {
 	}
 	switch bcp.Type {
 	case pbm.PhysicalBackup, pbm.IncrementalBackup:
-		err = a.restorePhysical(r, opid, ep, l)
+		err = a.restorePhysical(r, primitive.Timestamp{}, opid, ep, l)
+		if err != nil {
+			l.Error("%v", err)
+		}
 	case pbm.LogicalBackup:
 		fallthrough
 	default:
-		err = a.restoreLogical(r, opid, ep, l)
-	}
-
-	if err != nil {
-		l.Error("%v", err)
-		return
+		// logical restore runs in the go-routine so it can be canceled
+		go func() {
+			err := a.restoreLogical(r, opid, ep, l)
+			if err != nil {
+				l.Error("%v", err)
+			}
+		}()
 	}
 }
 
@@ -361,17 +409,31 @@ func (a *Agent) restoreLogical(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch, l
 		}
 	}()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.setRst(&currentRestore{name: r.Name, cancel: cancel})
+	defer a.unsetRst()
+
 	l.Info("restore started")
-	err = restore.New(a.pbm, a.node, r.RSMap).Snapshot(r, opid, l)
+	start := time.Now()
+	err = restore.New(a.pbm, a.node, r.RSMap).Snapshot(ctx, r, opid, l)
+	if nodeInfo.IsLeader() && !errors.Is(err, restore.ErrNoDataForShard) {
+		a.notifyRestore(r.Name, l)
+	}
 	if err != nil {
 		if errors.Is(err, restore.ErrNoDataForShard) {
 			l.Info("no data for the shard in backup, skipping")
 			return nil
 		}
+		if errors.Is(err, restore.ErrCancelled) {
+			l.Info("restore was canceled")
+			return nil
+		}
 
 		return err
 	}
 	l.Info("restore finished successfully")
+	mRestoreDuration.With(string(pbm.LogicalBackup)).Set(time.Since(start).Seconds())
 
 	if nodeInfo.IsLeader() {
 		epch, err := a.pbm.ResetEpoch()
@@ -385,8 +447,9 @@ func (a *Agent) restoreLogical(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch, l
 	return nil
 }
 
-// restoreLogical starts the restore
-func (a *Agent) restorePhysical(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch, l *log.Event) error {
+// restorePhysical starts the physical restore. If pitr isn't zero,
+// the oplog will be replayed up to it on top of the backup.
+func (a *Agent) restorePhysical(r *pbm.RestoreCmd, pitr primitive.Timestamp, opid pbm.OPID, ep pbm.Epoch, l *log.Event) error {
 	nodeInfo, err := a.node.GetInfo()
 	if err != nil {
 		return errors.Wrap(err, "get node info")
@@ -429,9 +492,22 @@ func (a *Agent) restorePhysical(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch,
 		lock.Release()
 	}
 
+	// the database is unavailable by the end of the restore
+	var cfg pbm.Config
+	if nodeInfo.IsClusterLeader() {
+		cfg, err = a.pbm.GetConfig()
+		if err != nil {
+			l.Warning("get config: %v", err)
+		}
+	}
+
 	l.Info("restore started")
-	err = rstr.Snapshot(r, opid, l, a.closeCMD, a.HbPause)
+	start := time.Now()
+	err = rstr.Snapshot(r, pitr, opid, l, a.closeCMD, a.HbPause)
 	l.Info("restore finished %v", err)
+	if nodeInfo.IsClusterLeader() {
+		notifyPhysRestore(cfg, r.Name, l)
+	}
 	if err != nil {
 		if errors.Is(err, restore.ErrNoDataForShard) {
 			l.Info("no data for the shard in backup, skipping")
@@ -441,6 +517,7 @@ func (a *Agent) restorePhysical(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch,
 		return err
 	}
 	l.Info("restore finished successfully")
+	mRestoreDuration.With(string(pbm.PhysicalBackup)).Set(time.Since(start).Seconds())
 
 	return nil
 }
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/client"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// BackupRequest is the body of POST /v1/backups
+type BackupRequest struct {
+	// Name is generated from the current time if empty
+	Name             string                   `json:"name,omitempty"`
+	Type             pbm.BackupType           `json:"type,omitempty"`
+	IncrBase         bool                     `json:"base,omitempty"`
+	Compression      compress.CompressionType `json:"compression,omitempty"`
+	CompressionLevel *int                     `json:"compression_level,omitempty"`
+	Namespaces       []string                 `json:"ns,omitempty"`
+	NSExclude        []string                 `json:"ns_exclude,omitempty"`
+	// NSQuery are the filters of the collections documents.
+	// Such a backup is partial.
+	NSQuery []sel.NSQuery `json:"ns_query,omitempty"`
+	// Throttle overrides the config limits of the storage traffic
+	Throttle *storage.Limits `json:"throttle,omitempty"`
+	// Profile is the storage profile to save the backup to
+	Profile string `json:"profile,omitempty"`
+}
+
+// backups handles GET (list) and POST (start backup) on /v1/backups
+func (s *Server) backups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.serve(w, r, s.listBackups, http.StatusOK)
+	case http.MethodPost:
+		s.serve(w, r, s.startBackup, http.StatusAccepted)
+	case http.MethodDelete:
+		s.serve(w, r, s.deleteBackups, http.StatusAccepted)
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, errors.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+// backup handles GET (describe) and DELETE on /v1/backups/{name}
+func (s *Server) backup(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.serve(w, r, s.getBackup, http.StatusOK)
+	case http.MethodDelete:
+		s.serve(w, r, s.deleteBackup, http.StatusAccepted)
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, errors.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+func (s *Server) listBackups(r *http.Request) (interface{}, error) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bcps, err := s.pbm.BackupsList(limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backups list")
+	}
+
+	return bcps, nil
+}
+
+func (s *Server) getBackup(r *http.Request) (interface{}, error) {
+	name, err := pathParam(r, "/v1/backups/")
+	if err != nil {
+		return nil, err
+	}
+
+	bcp, err := s.pbm.GetBackupMeta(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get backup %s", name)
+	}
+
+	return bcp, nil
+}
+
+func (s *Server) startBackup(r *http.Request) (interface{}, error) {
+	req := BackupRequest{}
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+
+	nss, err := sel.ParseNSOption(strings.Join(req.Namespaces, ","))
+	if err != nil {
+		return nil, badRequest(errors.WithMessage(err, "parse namespaces"))
+	}
+	if len(nss) != 0 && req.Type != "" && req.Type != pbm.LogicalBackup {
+		return nil, badRequest(errors.Errorf("namespaces are not allowed for %s backup", req.Type))
+	}
+	nsExclude, err := sel.ParseNSExcludeOption(strings.Join(req.NSExclude, ","))
+	if err != nil {
+		return nil, badRequest(errors.WithMessage(err, "parse excluded namespaces"))
+	}
+
+	b, err := s.c.StartBackup(r.Context(), client.BackupOptions{
+		Name:             req.Name,
+		Type:             req.Type,
+		IncrBase:         req.IncrBase,
+		Compression:      req.Compression,
+		CompressionLevel: req.CompressionLevel,
+		Namespaces:       nss,
+		NSExclude:        nsExclude,
+		NSQuery:          req.NSQuery,
+		Throttle:         req.Throttle,
+		Profile:          req.Profile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return OpResponse{OPID: b.OPID, Name: b.Name}, nil
+}
+
+func (s *Server) deleteBackup(r *http.Request) (interface{}, error) {
+	name, err := pathParam(r, "/v1/backups/")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.pbm.GetBackupMeta(name); err != nil {
+		return nil, errors.Wrapf(err, "get backup %s", name)
+	}
+
+	return s.sendDelete(r, client.DeleteOptions{Backup: name})
+}
+
+// deleteBackups deletes backups older than the
+// `older_than` query param (RFC3339)
+func (s *Server) deleteBackups(r *http.Request) (interface{}, error) {
+	v := r.URL.Query().Get("older_than")
+	if v == "" {
+		return nil, badRequest(errors.New("older_than should be set"))
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, badRequest(errors.Wrap(err, "parse older_than"))
+	}
+
+	return s.sendDelete(r, client.DeleteOptions{OlderThan: t})
+}
+
+func (s *Server) sendDelete(r *http.Request, o client.DeleteOptions) (interface{}, error) {
+	opid, err := s.c.Delete(r.Context(), o)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpResponse{OPID: opid.String(), Name: o.Backup}, nil
+}
+
+const defaultListLimit = 50
+
+func queryLimit(r *http.Request) (int64, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultListLimit, nil
+	}
+
+	l, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || l < 0 {
+		return 0, badRequest(errors.Errorf("invalid limit %q", v))
+	}
+
+	return l, nil
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+const (
+	eventsPollInterval = time.Second
+	eventsPingInterval = 15 * time.Second
+	eventsListLimit    = 100
+)
+
+// Event is a state transition of a backup or restore
+type Event struct {
+	Type   pbm.Command `json:"type"`
+	Name   string      `json:"name"`
+	OPID   string      `json:"opid"`
+	Status pbm.Status  `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	TS     int64       `json:"ts"`
+}
+
+func (e Event) key() string {
+	return string(e.Type) + "/" + e.Name
+}
+
+// events streams backups and restores state transitions
+// as server-sent events
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, errors.Errorf("method %s is not allowed", r.Method))
+		return
+	}
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		writeErr(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	// the current states are the baseline, only transitions are sent
+	states := make(map[string]pbm.Status)
+	cur, err := s.currentStates()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	diffStates(states, cur)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fl.Flush()
+
+	poll := time.NewTicker(eventsPollInterval)
+	defer poll.Stop()
+	ping := time.NewTicker(eventsPingInterval)
+	defer ping.Stop()
+
+	id := 0
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+			fl.Flush()
+		case <-poll.C:
+			cur, err := s.currentStates()
+			if err != nil {
+				fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+				fl.Flush()
+				continue
+			}
+
+			for _, e := range diffStates(states, cur) {
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				id++
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, e.Type, data)
+			}
+			fl.Flush()
+		}
+	}
+}
+
+func (s *Server) currentStates() ([]Event, error) {
+	bcps, err := s.pbm.BackupsList(eventsListLimit)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backups list")
+	}
+	rsts, err := s.pbm.RestoresList(eventsListLimit)
+	if err != nil {
+		return nil, errors.Wrap(err, "get restores list")
+	}
+
+	rv := make([]Event, 0, len(bcps)+len(rsts))
+	for _, b := range bcps {
+		rv = append(rv, Event{
+			Type:   pbm.CmdBackup,
+			Name:   b.Name,
+			OPID:   b.OPID,
+			Status: b.Status,
+			Error:  b.Err,
+			TS:     b.LastTransitionTS,
+		})
+	}
+	for _, r := range rsts {
+		rv = append(rv, Event{
+			Type:   pbm.CmdRestore,
+			Name:   r.Name,
+			OPID:   r.OPID,
+			Status: r.Status,
+			Error:  r.Error,
+			TS:     r.LastTransitionTS,
+		})
+	}
+
+	return rv, nil
+}
+
+// diffStates returns events which status differs from the known one
+// and updates the known states
+func diffStates(known map[string]pbm.Status, cur []Event) []Event {
+	var rv []Event
+	for _, e := range cur {
+		k := e.key()
+		if st, ok := known[k]; ok && st == e.Status {
+			continue
+		}
+
+		known[k] = e.Status
+		rv = append(rv, e)
+	}
+
+	return rv
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// StatusPending is the state of an operation not yet picked up by agents
+const StatusPending pbm.Status = "pending"
+
+// Operation is the state of the operation started by the command
+type Operation struct {
+	OPID   string      `json:"opid"`
+	Cmd    pbm.Command `json:"cmd"`
+	Name   string      `json:"name,omitempty"`
+	Status pbm.Status  `json:"status"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func (s *Server) operation(r *http.Request) (interface{}, error) {
+	id, err := pathParam(r, "/v1/operations/")
+	if err != nil {
+		return nil, err
+	}
+	opid, err := pbm.OPIDfromStr(id)
+	if err != nil {
+		return nil, badRequest(errors.Wrap(err, "parse opid"))
+	}
+
+	return s.getOperation(opid)
+}
+
+func (s *Server) getOperation(opid pbm.OPID) (*Operation, error) {
+	op := &Operation{OPID: opid.String()}
+
+	cmd, err := s.pbm.GetCmd(opid)
+	if err != nil {
+		if !errors.Is(err, pbm.ErrNotFound) {
+			return nil, errors.Wrap(err, "get command")
+		}
+
+		// the command could be already evicted from the capped
+		// collection, but the metadata is still there
+		if bcp, err := s.pbm.GetBackupByOPID(op.OPID); err == nil {
+			op.Cmd, op.Name, op.Status, op.Error = pbm.CmdBackup, bcp.Name, bcp.Status, bcp.Err
+			return op, nil
+		}
+		if rst, err := s.pbm.GetRestoreMetaByOPID(op.OPID); err == nil {
+			op.Cmd, op.Name, op.Status, op.Error = pbm.CmdRestore, rst.Name, rst.Status, rst.Error
+			return op, nil
+		}
+
+		return nil, errors.Wrapf(pbm.ErrNotFound, "operation %s", op.OPID)
+	}
+
+	op.Cmd = cmd.Cmd
+	switch cmd.Cmd {
+	case pbm.CmdBackup:
+		if cmd.Backup != nil {
+			op.Name = cmd.Backup.Name
+		}
+		bcp, err := s.pbm.GetBackupByOPID(op.OPID)
+		if err == nil {
+			op.Status, op.Error = bcp.Status, bcp.Err
+			return op, nil
+		}
+		if !errors.Is(err, pbm.ErrNotFound) {
+			return nil, errors.Wrap(err, "get backup meta")
+		}
+	case pbm.CmdRestore, pbm.CmdPITRestore, pbm.CmdReplay:
+		switch {
+		case cmd.Restore != nil:
+			op.Name = cmd.Restore.Name
+		case cmd.PITRestore != nil:
+			op.Name = cmd.PITRestore.Name
+		case cmd.Replay != nil:
+			op.Name = cmd.Replay.Name
+		}
+		rst, err := s.getRestoreMeta(op.Name)
+		if err == nil {
+			op.Status, op.Error = rst.Status, rst.Error
+			return op, nil
+		}
+		if !errors.Is(err, pbm.ErrNotFound) {
+			return nil, errors.Wrap(err, "get restore meta")
+		}
+	}
+
+	return op, s.opStateFromLogs(op)
+}
+
+// opStateFromLogs sets the state of the operation which has no metadata
+// (e.g. delete or resync) based on the locks and agents logs
+func (s *Server) opStateFromLogs(op *Operation) error {
+	ct, err := s.pbm.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "read cluster time")
+	}
+
+	for _, get := range []func(*pbm.LockHeader) ([]pbm.LockData, error){s.pbm.GetLocks, s.pbm.GetOpLocks} {
+		locks, err := get(&pbm.LockHeader{OPID: op.OPID})
+		if err != nil {
+			return errors.Wrap(err, "get locks")
+		}
+		for _, l := range locks {
+			if l.Heartbeat.T+pbm.StaleFrameSec >= ct.T {
+				op.Status = pbm.StatusRunning
+				return nil
+			}
+		}
+	}
+
+	errs, err := s.pbm.LogGet(&log.LogRequest{LogKeys: log.LogKeys{OPID: op.OPID, Severity: log.Error}}, 1)
+	if err != nil {
+		return errors.Wrap(err, "get logs")
+	}
+	if len(errs.Data) != 0 {
+		op.Status = pbm.StatusError
+		op.Error = errs.Data[0].Msg
+		return nil
+	}
+
+	entries, err := s.pbm.LogGet(&log.LogRequest{LogKeys: log.LogKeys{OPID: op.OPID, Severity: log.Debug}}, 1)
+	if err != nil {
+		return errors.Wrap(err, "get logs")
+	}
+	if len(entries.Data) != 0 {
+		op.Status = pbm.StatusDone
+		return nil
+	}
+
+	op.Status = StatusPending
+	return nil
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/client"
+	"github.com/percona/percona-backup-mongodb/pbm/mask"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// RestoreRequest is the body of POST /v1/restores. Either Backup
+// or Time has to be set. With Time, Backup is the base snapshot for
+// the point-in-time restore (PBM chooses one if it's empty).
+type RestoreRequest struct {
+	Backup string `json:"backup,omitempty"`
+	// Time is the point in time to restore to. Either in RFC3339
+	// or a cluster time "T,I" format.
+	Time       string   `json:"time,omitempty"`
+	Namespaces []string `json:"ns,omitempty"`
+	NSExclude  []string `json:"ns_exclude,omitempty"`
+	// NSFrom and NSTo rename the restored namespaces. The i-th
+	// pattern of NSFrom is renamed to the i-th one of NSTo.
+	NSFrom []string `json:"ns_from,omitempty"`
+	NSTo   []string `json:"ns_to,omitempty"`
+	// Mask is the masking of the restored data
+	Mask *mask.Spec `json:"mask,omitempty"`
+	// RSMap maps the cluster replset names to the names in the backup
+	RSMap map[string]string `json:"rs_map,omitempty"`
+	// Throttle overrides the config limits of the storage traffic
+	Throttle *storage.Limits `json:"throttle,omitempty"`
+	// AllowPartial allows the point-in-time restore
+	// on top of a partial backup
+	AllowPartial bool `json:"allow_partial,omitempty"`
+}
+
+// restores handles GET (list) and POST (start restore) on /v1/restores
+func (s *Server) restores(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.serve(w, r, s.listRestores, http.StatusOK)
+	case http.MethodPost:
+		s.serve(w, r, s.startRestore, http.StatusAccepted)
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, errors.Errorf("method %s is not allowed", r.Method))
+	}
+}
+
+func (s *Server) listRestores(r *http.Request) (interface{}, error) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := s.pbm.RestoresList(limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "get restores list")
+	}
+
+	return rs, nil
+}
+
+func (s *Server) restore(r *http.Request) (interface{}, error) {
+	name, err := pathParam(r, "/v1/restores/")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getRestoreMeta(name)
+}
+
+// getRestoreMeta returns restore meta from the db or, for the
+// physical restores, from the storage
+func (s *Server) getRestoreMeta(name string) (*pbm.RestoreMeta, error) {
+	m, err := s.pbm.GetRestoreMeta(name)
+	if err == nil || !errors.Is(err, pbm.ErrNotFound) {
+		return m, errors.Wrapf(err, "get restore %s", name)
+	}
+
+	ep, _ := s.pbm.GetEpoch()
+	l := s.pbm.Logger().NewEvent(string(pbm.CmdRestore), name, "", ep.TS())
+	stg, err := s.pbm.GetStorage(l)
+	if err != nil {
+		return nil, errors.Wrap(err, "get storage")
+	}
+
+	m, err = pbm.GetPhysRestoreMeta(name, stg, l)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get physical restore %s", name)
+	}
+	if m == nil || m.Status == "" {
+		return nil, errors.Wrapf(pbm.ErrNotFound, "get restore %s", name)
+	}
+
+	return m, nil
+}
+
+func (s *Server) startRestore(r *http.Request) (interface{}, error) {
+	req := RestoreRequest{}
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+
+	if req.Backup == "" && req.Time == "" {
+		return nil, badRequest(errors.New("either backup or time should be set"))
+	}
+
+	nss, err := sel.ParseNSOption(strings.Join(req.Namespaces, ","))
+	if err != nil {
+		return nil, badRequest(errors.WithMessage(err, "parse namespaces"))
+	}
+	nsExclude, err := sel.ParseNSExcludeOption(strings.Join(req.NSExclude, ","))
+	if err != nil {
+		return nil, badRequest(errors.WithMessage(err, "parse excluded namespaces"))
+	}
+	nsFrom, nsTo, err := sel.ParseNSRenameOption(strings.Join(req.NSFrom, ","), strings.Join(req.NSTo, ","))
+	if err != nil {
+		return nil, badRequest(errors.WithMessage(err, "parse namespaces renaming"))
+	}
+
+	// the command carries the backup name -> cluster name mapping
+	rsMap := make(map[string]string, len(req.RSMap))
+	for to, from := range req.RSMap {
+		if _, ok := rsMap[from]; ok {
+			return nil, badRequest(errors.Errorf("rs_map: source %v is duplicated", from))
+		}
+		rsMap[from] = to
+	}
+
+	var rst *client.RestoreStarted
+	if req.Time != "" {
+		ts, err := parseTS(req.Time)
+		if err != nil {
+			return nil, badRequest(err)
+		}
+
+		rst, err = s.c.PITRRestore(r.Context(), client.PITRRestoreOptions{
+			Time:         ts,
+			Base:         req.Backup,
+			Namespaces:   nss,
+			NSExclude:    nsExclude,
+			NSFrom:       nsFrom,
+			NSTo:         nsTo,
+			Mask:         req.Mask,
+			RSMap:        rsMap,
+			Throttle:     req.Throttle,
+			AllowPartial: req.AllowPartial,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rst, err = s.c.Restore(r.Context(), client.RestoreOptions{
+			Backup:     req.Backup,
+			Namespaces: nss,
+			NSExclude:  nsExclude,
+			NSFrom:     nsFrom,
+			NSTo:       nsTo,
+			Mask:       req.Mask,
+			RSMap:      rsMap,
+			Throttle:   req.Throttle,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return OpResponse{OPID: rst.OPID, Name: rst.Name}, nil
+}
+
+// parseTS parses either RFC3339 time or "T,I" cluster time
+func parseTS(t string) (primitive.Timestamp, error) {
+	if si := strings.SplitN(t, ",", 2); len(si) == 2 {
+		tt, err := strconv.ParseUint(si[0], 10, 32)
+		if err != nil {
+			return primitive.Timestamp{}, errors.Wrap(err, "parse clusterTime T")
+		}
+		ti, err := strconv.ParseUint(si[1], 10, 32)
+		if err != nil {
+			return primitive.Timestamp{}, errors.Wrap(err, "parse clusterTime I")
+		}
+
+		return primitive.Timestamp{T: uint32(tt), I: uint32(ti)}, nil
+	}
+
+	tm, err := time.Parse(time.RFC3339, t)
+	if err != nil {
+		return primitive.Timestamp{}, errors.Wrap(err, "parse time")
+	}
+
+	return primitive.Timestamp{T: uint32(tm.Unix())}, nil
+}
@@ -0,0 +1,146 @@
+// Package api implements the HTTP/JSON control API for PBM. It talks to
+// the agents the same way the pbm CLI does - via the commands stream and
+// the PBM metadata collections.
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/client"
+)
+
+// Server serves the control API.
+type Server struct {
+	pbm   *pbm.PBM
+	c     *client.Client
+	token string
+	mux   *http.ServeMux
+}
+
+// New creates the API server. If token isn't empty, every request
+// has to carry it as a bearer token.
+func New(cn *pbm.PBM, token string) *Server {
+	s := &Server{
+		pbm:   cn,
+		c:     client.New(cn),
+		token: token,
+		mux:   http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/v1/status", s.get(s.status))
+	s.mux.HandleFunc("/v1/backups", s.backups)
+	s.mux.HandleFunc("/v1/backups/", s.backup)
+	s.mux.HandleFunc("/v1/restores", s.restores)
+	s.mux.HandleFunc("/v1/restores/", s.get(s.restore))
+	s.mux.HandleFunc("/v1/operations/", s.get(s.operation))
+	s.mux.HandleFunc("/v1/logs", s.get(s.logs))
+	s.mux.HandleFunc("/v1/events", s.events)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		t := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(t), []byte(s.token)) != 1 {
+			writeErr(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// ErrorResponse is the body of all failed requests
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// OpResponse is the body of the requests which started an operation.
+// The operation state can be polled via /v1/operations/{opid}.
+type OpResponse struct {
+	OPID string `json:"opid"`
+	Name string `json:"name,omitempty"`
+}
+
+// errBadRequest marks errors caused by the request itself
+type errBadRequest struct {
+	error
+}
+
+func badRequest(err error) error {
+	return errBadRequest{err}
+}
+
+type handlerFunc func(r *http.Request) (interface{}, error)
+
+// get wraps the read-only handler
+func (s *Server) get(h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeErr(w, http.StatusMethodNotAllowed, errors.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+
+		s.serve(w, r, h, http.StatusOK)
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, h handlerFunc, code int) {
+	v, err := h(r)
+	if err != nil {
+		switch {
+		case errors.As(err, &errBadRequest{}),
+			errors.As(err, &client.InvalidOptionsError{}),
+			errors.Is(err, client.ErrNoStorage):
+			writeErr(w, http.StatusBadRequest, err)
+		case errors.Is(err, pbm.ErrNotFound):
+			writeErr(w, http.StatusNotFound, err)
+		case errors.As(err, &client.ConcurrentOpError{}):
+			writeErr(w, http.StatusConflict, err)
+		default:
+			writeErr(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	writeJSON(w, code, v)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeErr(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, ErrorResponse{Error: err.Error()})
+}
+
+// pathParam returns the last path element after the prefix
+func pathParam(r *http.Request, prefix string) (string, error) {
+	p := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if p == "" || strings.Contains(p, "/") {
+		return "", badRequest(errors.Errorf("invalid path %s", r.URL.Path))
+	}
+
+	return p, nil
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	// an empty body means all defaults
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return badRequest(errors.Wrap(err, "decode request body"))
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestServerRequests(t *testing.T) {
+	cases := []struct {
+		name   string
+		token  string
+		auth   string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"no token", "secret", "", http.MethodGet, "/v1/status", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer nope", http.MethodGet, "/v1/status", "", http.StatusUnauthorized},
+		{"wrong method", "secret", "Bearer secret", http.MethodPost, "/v1/status", "", http.StatusMethodNotAllowed},
+		{"unknown path", "", "", http.MethodGet, "/v1/nope", "", http.StatusNotFound},
+		{"empty restore", "", "", http.MethodPost, "/v1/restores", "{}", http.StatusBadRequest},
+		{"unknown field", "", "", http.MethodPost, "/v1/restores", `{"bcp":"x"}`, http.StatusBadRequest},
+		{"bad backup type", "", "", http.MethodPost, "/v1/backups", `{"type":"nope"}`, http.StatusBadRequest},
+		{"bad backup ns", "", "", http.MethodPost, "/v1/backups", `{"ns":["admin.*"]}`, http.StatusBadRequest},
+		{"bad opid", "", "", http.MethodGet, "/v1/operations/123", "", http.StatusBadRequest},
+		{"bad limit", "", "", http.MethodGet, "/v1/backups?limit=x", "", http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+			if c.auth != "" {
+				r.Header.Set("Authorization", c.auth)
+			}
+			w := httptest.NewRecorder()
+
+			New(nil, c.token).ServeHTTP(w, r)
+
+			if w.Code != c.code {
+				t.Errorf("expected %d, got %d: %s", c.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseTS(t *testing.T) {
+	ts, err := parseTS("1675000000,5")
+	if err != nil || ts != (primitive.Timestamp{T: 1675000000, I: 5}) {
+		t.Errorf("unexpected %v, %v", ts, err)
+	}
+
+	ts, err = parseTS("2023-01-29T13:46:40Z")
+	if err != nil || ts != (primitive.Timestamp{T: 1675000000}) {
+		t.Errorf("unexpected %v, %v", ts, err)
+	}
+
+	if _, err = parseTS("2023-01-29 13:46"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestDiffStates(t *testing.T) {
+	known := make(map[string]pbm.Status)
+	diffStates(known, []Event{
+		{Type: pbm.CmdBackup, Name: "b1", Status: pbm.StatusDone},
+		{Type: pbm.CmdBackup, Name: "b2", Status: pbm.StatusRunning},
+	})
+
+	got := diffStates(known, []Event{
+		{Type: pbm.CmdBackup, Name: "b1", Status: pbm.StatusDone},
+		{Type: pbm.CmdBackup, Name: "b2", Status: pbm.StatusDumpDone},
+		{Type: pbm.CmdRestore, Name: "b1", Status: pbm.StatusStarting},
+	})
+
+	want := []Event{
+		{Type: pbm.CmdBackup, Name: "b2", Status: pbm.StatusDumpDone},
+		{Type: pbm.CmdRestore, Name: "b1", Status: pbm.StatusStarting},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/client"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// StatusResponse is the body of GET /v1/status
+type StatusResponse = client.Status
+
+func (s *Server) status(r *http.Request) (interface{}, error) {
+	return s.c.Status(r.Context())
+}
+
+const defaultLogsTail = 100
+
+// logs returns log entries in chronological order. Query params:
+// node (rs[/node]), event (type[/name]), opid, severity (F, E, W, I, D)
+// and tail (the number of entries).
+func (s *Server) logs(r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	req := &log.LogRequest{}
+
+	if v := q.Get("node"); v != "" {
+		req.RS, req.Node, _ = strings.Cut(v, "/")
+	}
+	if v := q.Get("event"); v != "" {
+		req.Event, req.ObjName, _ = strings.Cut(v, "/")
+	}
+	req.OPID = q.Get("opid")
+
+	switch q.Get("severity") {
+	case "F":
+		req.Severity = log.Fatal
+	case "E":
+		req.Severity = log.Error
+	case "W":
+		req.Severity = log.Warning
+	case "I", "":
+		req.Severity = log.Info
+	case "D":
+		req.Severity = log.Debug
+	default:
+		return nil, badRequest(errors.Errorf("invalid severity %q", q.Get("severity")))
+	}
+
+	tail := int64(defaultLogsTail)
+	if v := q.Get("tail"); v != "" {
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || t < 0 {
+			return nil, badRequest(errors.Errorf("invalid tail %q", v))
+		}
+		tail = t
+	}
+
+	o, err := s.pbm.LogGet(req, tail)
+	if err != nil {
+		return nil, errors.Wrap(err, "get logs")
+	}
+
+	// reverse list
+	for i := len(o.Data)/2 - 1; i >= 0; i-- {
+		opp := len(o.Data) - 1 - i
+		o.Data[i], o.Data[opp] = o.Data[opp], o.Data[i]
+	}
+	if o.Data == nil {
+		o.Data = []log.Entry{}
+	}
+
+	return o.Data, nil
+}
@@ -4,18 +4,18 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/mod/semver"
 	"gopkg.in/yaml.v2"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/client"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
-	"github.com/percona/percona-backup-mongodb/version"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
 type backupOpts struct {
@@ -25,7 +25,11 @@ type backupOpts struct {
 	compression      string
 	compressionLevel []int
 	ns               string
+	nsExclude        string
+	nsQuery          []string
 	wait             bool
+	throttle         storage.Limits
+	profile          string
 }
 
 type backupOut struct {
@@ -41,159 +45,104 @@ type descBcp struct {
 	name string
 }
 
+type verifyBcpOpts struct {
+	name string
+}
+
+type verifyBcpResult struct {
+	Name   string     `json:"name"`
+	Status pbm.Status `json:"status"`
+	Error  string     `json:"error,omitempty"`
+}
+
+func (r verifyBcpResult) HasError() bool {
+	return r.Error != ""
+}
+
+func (r verifyBcpResult) String() string {
+	if r.Error != "" {
+		return fmt.Sprintf("Backup '%s' verification failed: %s", r.Name, r.Error)
+	}
+
+	return fmt.Sprintf("Backup '%s' verified", r.Name)
+}
+
 func runBackup(cn *pbm.PBM, b *backupOpts, outf outFormat) (fmt.Stringer, error) {
 	nss, err := parseCLINSOption(b.ns)
 	if err != nil {
 		return nil, errors.WithMessage(err, "parse --ns option")
 	}
-	if len(nss) > 1 {
-		return nil, errors.New("parse --ns option: multiple namespaces are not supported")
-	}
 	if len(nss) != 0 && b.typ == string(pbm.PhysicalBackup) {
 		return nil, errors.New("--ns flag is not allowed for physical backup")
 	}
-
-	if err := checkConcurrentOp(cn); err != nil {
-		// PITR slicing can be run along with the backup start - agents will resolve it.
-		op, ok := err.(concurentOpErr)
-		if !ok || op.op.Type != pbm.CmdPITR {
-			return nil, err
-		}
+	nsExclude, err := sel.ParseNSExcludeOption(b.nsExclude)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse --ns-exclude option")
 	}
-
-	cfg, err := cn.GetConfig()
+	nsQuery, err := sel.ParseNSQueryOption(b.nsQuery)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, errors.New("no store set. Set remote store with <pbm store set>")
-		}
-		return nil, errors.Wrap(err, "get remote-store")
+		return nil, errors.WithMessage(err, "parse --ns-query option")
 	}
 
-	compression := cfg.Backup.Compression
-	if b.compression != "" {
-		compression = compress.CompressionType(b.compression)
+	o := client.BackupOptions{
+		Name:        b.name,
+		Type:        pbm.BackupType(b.typ),
+		IncrBase:    b.base,
+		Compression: compress.CompressionType(b.compression),
+		Namespaces:  nss,
+		NSExclude:   nsExclude,
+		NSQuery:     nsQuery,
+		Throttle:    throttleOpt(b.throttle),
+		Profile:     b.profile,
 	}
-
-	level := cfg.Backup.CompressionLevel
 	if len(b.compressionLevel) != 0 {
-		level = &b.compressionLevel[0]
+		o.CompressionLevel = &b.compressionLevel[0]
 	}
 
-	err = cn.SendCmd(pbm.Cmd{
-		Cmd: pbm.CmdBackup,
-		Backup: &pbm.BackupCmd{
-			Type:             pbm.BackupType(b.typ),
-			IncrBase:         b.base,
-			Name:             b.name,
-			Namespaces:       nss,
-			Compression:      compression,
-			CompressionLevel: level,
-		},
-	})
+	c := client.New(cn)
+	started, err := c.StartBackup(context.Background(), o)
 	if err != nil {
-		return nil, errors.Wrap(err, "send command")
+		if errors.Is(err, client.ErrNoStorage) {
+			return nil, errors.New("no store set. Set remote store with <pbm store set>")
+		}
+		return nil, err
 	}
 
 	if outf != outText {
-		return backupOut{b.name, cfg.Storage.Path()}, nil
+		return backupOut{started.Name, started.Storage}, nil
 	}
 
-	fmt.Printf("Starting backup '%s'", b.name)
-	ctx, cancel := context.WithTimeout(context.Background(), pbm.WaitBackupStart)
-	defer cancel()
-	err = waitForBcpStatus(ctx, cn, b.name)
+	fmt.Printf("Starting backup '%s'", started.Name)
+	err = c.WaitBackupStart(context.Background(), started.Name, printDot)
 	if err != nil {
 		return nil, err
 	}
 
 	if b.wait {
-		return outMsg{}, waitBackup(context.Background(), cn, b.name)
+		return outMsg{}, waitBackup(context.Background(), c, started.Name)
 	}
 
 	fmt.Println()
-	return backupOut{b.name, cfg.Storage.Path()}, nil
+	return backupOut{started.Name, started.Storage}, nil
 }
 
-func waitBackup(ctx context.Context, cn *pbm.PBM, name string) error {
-	t := time.NewTicker(time.Second)
-	defer t.Stop()
-
+func waitBackup(ctx context.Context, c *client.Client, name string) error {
 	fmt.Printf("\nWaiting for '%s' backup...", name)
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-t.C:
-			bcp, err := cn.GetBackupMeta(name)
-			if err != nil {
-				return err
-			}
-
-			switch bcp.Status {
-			case pbm.StatusDone:
-				fmt.Println(" done")
-				return nil
-			case pbm.StatusCancelled:
-				fmt.Println(" canceled")
-				return nil
-			case pbm.StatusError:
-				fmt.Println(" failed")
-				return bcp.Error()
-			}
-		}
-
-		fmt.Print(".")
+	bcp, err := c.WaitBackup(ctx, name, printDot)
+	if bcp == nil {
+		return err
 	}
-}
-
-func waitForBcpStatus(ctx context.Context, cn *pbm.PBM, bcpName string) (err error) {
-	tk := time.NewTicker(time.Second)
-	defer tk.Stop()
-
-	var bmeta *pbm.BackupMeta
-	for {
-		select {
-		case <-tk.C:
-			fmt.Print(".")
-			bmeta, err = cn.GetBackupMeta(bcpName)
-			if errors.Is(err, pbm.ErrNotFound) {
-				continue
-			}
-			if err != nil {
-				return errors.Wrap(err, "get backup metadata")
-			}
-			switch bmeta.Status {
-			case pbm.StatusRunning, pbm.StatusDumpDone, pbm.StatusDone, pbm.StatusCancelled:
-				return nil
-			case pbm.StatusError:
-				rs := ""
-				for _, s := range bmeta.Replsets {
-					rs += fmt.Sprintf("\n- Backup on replicaset \"%s\" in state: %v", s.Name, s.Status)
-					if s.Error != "" {
-						rs += ": " + s.Error
-					}
-				}
-				return errors.New(bmeta.Error().Error() + rs)
-			}
-		case <-ctx.Done():
-			if bmeta == nil {
-				return errors.New("no progress from leader, backup metadata not found")
-			}
-			rs := ""
-			for _, s := range bmeta.Replsets {
-				rs += fmt.Sprintf("- Backup on replicaset \"%s\" in state: %v\n", s.Name, s.Status)
-				if s.Error != "" {
-					rs += ": " + s.Error
-				}
-			}
-			if rs == "" {
-				rs = "<no replset has started backup>\n"
-			}
 
-			return errors.New("no confirmation that backup has successfully started. Replsets status:\n" + rs)
-		}
+	switch bcp.Status {
+	case pbm.StatusDone:
+		fmt.Println(" done")
+	case pbm.StatusCancelled:
+		fmt.Println(" canceled")
+	case pbm.StatusError:
+		fmt.Println(" failed")
 	}
+	return err
 }
 
 type bcpDesc struct {
@@ -205,16 +154,28 @@ type bcpDesc struct {
 	LastWriteTime      string         `json:"last_write_time" yaml:"last_write_time"`
 	LastTransitionTime string         `json:"last_transition_time" yaml:"last_transition_time"`
 	Namespaces         []string       `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	NSExclude          []string       `json:"ns_exclude,omitempty" yaml:"ns_exclude,omitempty"`
+	NSQuery            []sel.NSQuery  `json:"ns_query,omitempty" yaml:"ns_query,omitempty"`
+	Partial            bool           `json:"partial,omitempty" yaml:"partial,omitempty"`
+	Profile            string         `json:"profile,omitempty" yaml:"profile,omitempty"`
 	MongoVersion       string         `json:"mongodb_version" yaml:"mongodb_version"`
 	FCV                string         `json:"fcv" yaml:"fcv"`
 	PBMVersion         string         `json:"pbm_version" yaml:"pbm_version"`
 	Status             pbm.Status     `json:"status" yaml:"status"`
 	Size               int64          `json:"size" yaml:"-"`
 	HSize              string         `json:"size_h" yaml:"size_h"`
+	KeyID              string         `json:"key_id,omitempty" yaml:"key_id,omitempty"`
+	Verification       *bcpVerifyDesc `json:"verification,omitempty" yaml:"verification,omitempty"`
 	Err                *string        `json:"error,omitempty" yaml:"error,omitempty"`
 	Replsets           []bcpReplDesc  `json:"replsets" yaml:"replsets"`
 }
 
+type bcpVerifyDesc struct {
+	Status pbm.Status `json:"status" yaml:"status"`
+	Time   string     `json:"time" yaml:"time"`
+	Error  string     `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
 type bcpReplDesc struct {
 	Name               string             `json:"name" yaml:"name"`
 	Status             pbm.Status         `json:"status" yaml:"status"`
@@ -225,6 +186,32 @@ type bcpReplDesc struct {
 	IsConfigSvr        *bool              `json:"configsvr,omitempty" yaml:"configsvr,omitempty"`
 	SecurityOpts       *pbm.MongodOptsSec `json:"security,omitempty" yaml:"security,omitempty"`
 	Error              *string            `json:"error,omitempty" yaml:"error,omitempty"`
+	Progress           *bcpProgressDesc   `json:"progress,omitempty" yaml:"progress,omitempty"`
+	Throttle           []bcpThrottleDesc  `json:"throttle,omitempty" yaml:"throttle,omitempty"`
+}
+
+type bcpThrottleDesc struct {
+	TS     int64  `json:"ts" yaml:"-"`
+	Time   string `json:"time" yaml:"time"`
+	Level  int    `json:"level" yaml:"level"`
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+type bcpProgressDesc struct {
+	BytesRead      int64  `json:"bytes_read" yaml:"-"`
+	HBytesRead     string `json:"bytes_read_h" yaml:"read"`
+	BytesUploaded  int64  `json:"bytes_uploaded" yaml:"-"`
+	HBytesUploaded string `json:"bytes_uploaded_h" yaml:"uploaded"`
+	BytesTotal     int64  `json:"bytes_total" yaml:"-"`
+	HBytesTotal    string `json:"bytes_total_h" yaml:"estimated_total"`
+	CollsDone      int    `json:"colls_done,omitempty" yaml:"collections_done,omitempty"`
+	CollsTotal     int    `json:"colls_total,omitempty" yaml:"collections_total,omitempty"`
+	FilesDone      int    `json:"files_done,omitempty" yaml:"files_done,omitempty"`
+	FilesTotal     int    `json:"files_total,omitempty" yaml:"files_total,omitempty"`
+	ETA            int64  `json:"eta,omitempty" yaml:"-"`
+	ETATime        string `json:"eta_time,omitempty" yaml:"eta,omitempty"`
+	UpdatedTS      int64  `json:"updated_ts" yaml:"-"`
+	UpdatedTime    string `json:"updated_time" yaml:"updated_time"`
 }
 
 func (b *bcpDesc) String() string {
@@ -263,6 +250,10 @@ func describeBackup(cn *pbm.PBM, b *descBcp) (fmt.Stringer, error) {
 		OPID:               bcp.OPID,
 		Type:               bcp.Type,
 		Namespaces:         bcp.Namespaces,
+		NSExclude:          bcp.NSExclude,
+		NSQuery:            bcp.NSQuery,
+		Partial:            bcp.IsPartial(),
+		Profile:            bcp.Store.Profile,
 		MongoVersion:       bcp.MongoVersion,
 		FCV:                bcp.FCV,
 		PBMVersion:         bcp.PBMVersion,
@@ -273,15 +264,23 @@ func describeBackup(cn *pbm.PBM, b *descBcp) (fmt.Stringer, error) {
 		Status:             bcp.Status,
 		Size:               bcp.Size,
 		HSize:              byteCountIEC(bcp.Size),
+		KeyID:              bcp.KeyID,
 	}
 	if bcp.Err != "" {
 		rv.Err = &bcp.Err
 	}
+	if c := bcp.LastVerification(); c != nil {
+		rv.Verification = &bcpVerifyDesc{
+			Status: c.Status,
+			Time:   time.Unix(c.Timestamp, 0).UTC().Format(time.RFC3339),
+			Error:  c.Error,
+		}
+	}
 
 	if bcp.Size == 0 {
 		switch bcp.Status {
 		case pbm.StatusDone, pbm.StatusCancelled, pbm.StatusError:
-			stg, err := cn.GetStorage(cn.Logger().NewEvent("", "", "", primitive.Timestamp{}))
+			stg, err := cn.GetBackupStorage(bcp, cn.Logger().NewEvent("", "", "", primitive.Timestamp{}))
 			if err != nil {
 				return nil, errors.WithMessage(err, "get storage")
 			}
@@ -311,143 +310,71 @@ func describeBackup(cn *pbm.PBM, b *descBcp) (fmt.Stringer, error) {
 		if r.MongodOpts != nil && r.MongodOpts.Security != nil {
 			rv.Replsets[i].SecurityOpts = r.MongodOpts.Security
 		}
-	}
-
-	return rv, err
-}
-
-// bcpsMatchCluster checks if given backups match shards in the cluster. Match means that
-// each replset in backup has a respective replset on the target cluster. It's ok if cluster
-// has more shards than there are currently in backup. But in the case of sharded cluster
-// backup has to have data for the current config server or for the sole RS in case of non-sharded rs.
-//
-// If some backup doesn't match cluster, the status of the backup meta in given `bcps` would be
-// changed to pbm.StatusError with respective error text emitted. It doesn't change meta on
-// storage nor in DB (backup is ok, it just doesn't cluster), it is just "in-flight" changes
-// in given `bcps`.
-func bcpsMatchCluster(bcps []pbm.BackupMeta, ver, fcv string, shards []pbm.Shard, confsrv string, rsMap map[string]string) {
-	sh := make(map[string]bool, len(shards))
-	for _, s := range shards {
-		sh[s.RS] = s.RS == confsrv
-	}
-
-	mapRS, mapRevRS := pbm.MakeRSMapFunc(rsMap), pbm.MakeReverseRSMapFunc(rsMap)
-	for i := 0; i < len(bcps); i++ {
-		bcp := &bcps[i]
-		if bcps[i].Type == pbm.PhysicalBackup && len(rsMap) != 0 {
-			bcp.SetRuntimeError(errRSMappingWithPhysBackup{})
-			continue
-		}
-
-		bcpMatchCluster(bcp, ver, fcv, sh, mapRS, mapRevRS)
-	}
-}
-
-func bcpMatchCluster(bcp *pbm.BackupMeta, ver, fcv string, shards map[string]bool, mapRS, mapRevRS pbm.RSMapFunc) {
-	if bcp.Status != pbm.StatusDone {
-		return
-	}
-	if !version.CompatibleWith(bcp.PBMVersion, pbm.BreakingChangesMap[bcp.Type]) {
-		bcp.SetRuntimeError(errIncompatibleVersion{bcp.PBMVersion})
-		return
-	}
-	if bcp.FCV != "" {
-		if bcp.FCV != fcv {
-			bcp.SetRuntimeError(errors.Errorf("backup FCV %q is incompatible with the running mongo FCV %q",
-				bcp.FCV, fcv))
-			return
-		}
-	} else if majmin(bcp.MongoVersion) != majmin(ver) {
-		bcp.SetRuntimeError(errors.Errorf("backup mongo version %q is incompatible with the running mongo version %q",
-			bcp.MongoVersion, ver))
-		return
-
-	}
-
-	var nomatch []string
-	hasconfsrv := false
-	for i := range bcp.Replsets {
-		name := mapRS(bcp.Replsets[i].Name)
-
-		isconfsrv, ok := shards[name]
-		if !ok {
-			nomatch = append(nomatch, name)
-		} else if mapRevRS(name) != bcp.Replsets[i].Name {
-			nomatch = append(nomatch, name)
+		if p := r.Progress; p != nil {
+			pd := &bcpProgressDesc{
+				BytesRead:      p.BytesRead,
+				HBytesRead:     byteCountIEC(p.BytesRead),
+				BytesUploaded:  p.BytesUploaded,
+				HBytesUploaded: byteCountIEC(p.BytesUploaded),
+				BytesTotal:     p.BytesTotal,
+				HBytesTotal:    byteCountIEC(p.BytesTotal),
+				CollsDone:      p.CollsDone,
+				CollsTotal:     p.CollsTotal,
+				FilesDone:      p.FilesDone,
+				FilesTotal:     p.FilesTotal,
+				UpdatedTS:      p.UpdatedTS,
+				UpdatedTime:    time.Unix(p.UpdatedTS, 0).UTC().Format(time.RFC3339),
+			}
+			// ETA makes sense only while the data is being copied
+			if p.ETA != 0 && r.Status == pbm.StatusRunning {
+				pd.ETA = p.ETA
+				pd.ETATime = time.Unix(p.ETA, 0).UTC().Format(time.RFC3339)
+			}
+			rv.Replsets[i].Progress = pd
 		}
-
-		if isconfsrv {
-			hasconfsrv = true
+		for _, e := range r.ThrottleEvents {
+			rv.Replsets[i].Throttle = append(rv.Replsets[i].Throttle, bcpThrottleDesc{
+				TS:     e.Timestamp,
+				Time:   time.Unix(e.Timestamp, 0).UTC().Format(time.RFC3339),
+				Level:  e.Level,
+				Reason: e.Reason,
+			})
 		}
 	}
 
-	if len(nomatch) != 0 || !hasconfsrv {
-		names := make([]string, len(nomatch))
-		copy(names, nomatch)
-		bcp.SetRuntimeError(errMissedReplsets{names: names, configsrv: !hasconfsrv})
-	}
+	return rv, err
 }
 
-func majmin(v string) string {
-	if len(v) == 0 {
-		return v
+// verifyBackup reads the whole backup from the storage to check if it
+// can be restored and records the result in the backup's metadata
+func verifyBackup(cn *pbm.PBM, b *verifyBcpOpts) (fmt.Stringer, error) {
+	bcp, err := cn.GetBackupMeta(b.name)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get backup meta")
 	}
 
-	if v[0] != 'v' {
-		v = "v" + v
+	cfg, err := cn.GetConfig()
+	if err != nil {
+		return nil, errors.WithMessage(err, "get config")
 	}
 
-	return semver.MajorMinor(v)
-}
-
-var errIncompatible = errors.New("incompatible")
-
-type errRSMappingWithPhysBackup struct{}
-
-func (errRSMappingWithPhysBackup) Error() string {
-	return "unsupported with replset remapping"
-}
-
-func (errRSMappingWithPhysBackup) Unwrap() error {
-	return errIncompatible
-}
-
-type errMissedReplsets struct {
-	names     []string
-	configsrv bool
-}
+	l := cn.Logger().NewEvent("verify", bcp.Name, "", primitive.Timestamp{})
+	verr := backup.Verify(cn.Context(), cfg, bcp, l)
 
-func (e errMissedReplsets) Error() string {
-	errString := ""
-	if len(e.names) != 0 {
-		errString = "Backup doesn't match current cluster topology - it has different replica set names. " +
-			"Extra shards in the backup will cause this, for a simple example. " +
-			"The extra/unknown replica set names found in the backup are: " + strings.Join(e.names, ", ")
+	rv := verifyBcpResult{Name: bcp.Name, Status: pbm.StatusVerified}
+	if verr != nil {
+		rv.Status = pbm.StatusVerifyFailed
+		rv.Error = verr.Error()
 	}
 
-	if e.configsrv {
-		if errString != "" {
-			errString += ". "
-		}
-		errString += "Backup has no data for the config server or sole replicaset"
+	err = cn.AddBackupCondition(bcp.Name, pbm.Condition{
+		Timestamp: time.Now().UTC().Unix(),
+		Status:    rv.Status,
+		Error:     rv.Error,
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "save verification result")
 	}
 
-	return errString
-}
-
-func (errMissedReplsets) Unwrap() error {
-	return errIncompatible
-}
-
-type errIncompatibleVersion struct {
-	bcpVer string
-}
-
-func (e errIncompatibleVersion) Unwrap() error {
-	return errIncompatible
-}
-
-func (e errIncompatibleVersion) Error() string {
-	return fmt.Sprintf("backup version (v%s) is not compatible with PBM v%s",
-		e.bcpVer, version.DefaultInfo.Version)
+	return rv, nil
 }
@@ -11,11 +11,12 @@ import (
 
 	"github.com/alecthomas/kingpin"
 	"github.com/pkg/errors"
-	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/client"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/version"
 )
 
@@ -92,8 +93,13 @@ func Main() {
 	backupCmd.Flag("base", "Is this a base for incremental backups").BoolVar(&backup.base)
 	backupCmd.Flag("compression-level", "Compression level (specific to the compression type)").
 		IntsVar(&backup.compressionLevel)
-	backupCmd.Flag("ns", `Namespaces to backup (e.g. "db.*", "db.collection"). If not set, backup all ("*.*")`).StringVar(&backup.ns)
+	backupCmd.Flag("ns", `Namespaces to backup (e.g. "db1.*,db2.collection2"). If not set, backup all ("*.*")`).StringVar(&backup.ns)
+	backupCmd.Flag("ns-exclude", `Namespaces to skip, "*" matches any part of the name (e.g. "logs.*,*.tmp_*")`).StringVar(&backup.nsExclude)
+	backupCmd.Flag("ns-query", `Backup only the documents of the collection matching the query in Extended JSON (e.g. 'events.clicks={"ts":{"$gte":{"$date":"2023-01-01T00:00:00Z"}}}'). Could be set multiple times`).
+		StringsVar(&backup.nsQuery)
 	backupCmd.Flag("wait", "Wait for the backup to finish").Short('w').BoolVar(&backup.wait)
+	backupCmd.Flag("profile", "Storage profile to save the backup to. If not set, the main storage is used").StringVar(&backup.profile)
+	throttleFlags(backupCmd, &backup.throttle)
 
 	cancelBcpCmd := pbmCmd.Command("cancel-backup", "Cancel backup")
 
@@ -101,22 +107,37 @@ func Main() {
 	descBcp := descBcp{}
 	descBcpCmd.Arg("backup_name", "Backup name").StringVar(&descBcp.name)
 
+	verifyBcpCmd := pbmCmd.Command("verify-backup", "Read the whole backup from the storage and check if it can be restored")
+	verifyBcp := verifyBcpOpts{}
+	verifyBcpCmd.Arg("backup_name", "Backup name").Required().StringVar(&verifyBcp.name)
+
 	restoreCmd := pbmCmd.Command("restore", "Restore backup")
 	restore := restoreOpts{}
 	restoreCmd.Arg("backup_name", "Backup name to restore").StringVar(&restore.bcp)
 	restoreCmd.Flag("time", fmt.Sprintf("Restore to the point-in-time. Set in format %s", datetimeFormat)).StringVar(&restore.pitr)
-	restoreCmd.Flag("base-snapshot", "Override setting: Name of older snapshot that PITR will be based on during restore.").StringVar(&restore.pitrBase)
+	restoreCmd.Flag("base-snapshot", "Override setting: Name of older snapshot that PITR will be based on during restore. Physical and incremental snapshots are allowed as well.").StringVar(&restore.pitrBase)
+	restoreCmd.Flag("allow-partial", "Allow the point-in-time restore on top of a partial backup (made with --ns-query)").BoolVar(&restore.allowPartial)
 	restoreCmd.Flag("ns", `Namespaces to restore (e.g. "db1.*,db2.collection2"). If not set, restore all ("*.*")`).StringVar(&restore.ns)
+	restoreCmd.Flag("ns-exclude", `Namespaces to skip, "*" matches any part of the name (e.g. "logs.*,*.tmp_*")`).StringVar(&restore.nsExclude)
+	restoreCmd.Flag("ns-from", `Namespaces to rename from (e.g. "orders.*,db2.coll"). Requires --ns-to`).StringVar(&restore.nsFrom)
+	restoreCmd.Flag("ns-to", `Namespaces to rename to (e.g. "orders_restored.*,db2.coll_restored"), one per each --ns-from namespace`).StringVar(&restore.nsTo)
+	restoreCmd.Flag("mask", "Path to the YAML file with the masking spec of the restored data. Logical restores only").StringVar(&restore.mask)
 	restoreCmd.Flag("wait", "Wait for the restore to finish.").Short('w').BoolVar(&restore.wait)
 	restoreCmd.Flag(RSMappingFlag, RSMappingDoc).Envar(RSMappingEnvVar).StringVar(&restore.rsMap)
+	throttleFlags(restoreCmd, &restore.throttle)
 
 	replayCmd := pbmCmd.Command("oplog-replay", "Replay oplog")
 	replayOpts := replayOptions{}
 	replayCmd.Flag("start", fmt.Sprintf("Replay oplog from the time. Set in format %s", datetimeFormat)).Required().StringVar(&replayOpts.start)
 	replayCmd.Flag("end", "Replay oplog to the time. Set in format %s").Required().StringVar(&replayOpts.end)
+	replayCmd.Flag("ns-from", `Namespaces to rename from (e.g. "orders.*,db2.coll"). Requires --ns-to`).StringVar(&replayOpts.nsFrom)
+	replayCmd.Flag("ns-to", `Namespaces to rename to (e.g. "orders_restored.*,db2.coll_restored"), one per each --ns-from namespace`).StringVar(&replayOpts.nsTo)
+	replayCmd.Flag("mask", "Path to the YAML file with the masking spec of the replayed data").StringVar(&replayOpts.mask)
 	replayCmd.Flag("wait", "Wait for the restore to finish.").Short('w').BoolVar(&replayOpts.wait)
 	replayCmd.Flag(RSMappingFlag, RSMappingDoc).Envar(RSMappingEnvVar).StringVar(&replayOpts.rsMap)
-	// todo(add oplog cancel)
+	throttleFlags(replayCmd, &replayOpts.throttle)
+
+	cancelRestoreCmd := pbmCmd.Command("cancel-restore", "Cancel logical restore, PITR restore or oplog replay")
 
 	listCmd := pbmCmd.Command("list", "Backup list")
 	list := listOpts{}
@@ -147,13 +168,19 @@ func Main() {
 	cleanupCmd.Flag("wait", "Wait for deletion done").Short('w').BoolVar(&cleanupOpts.wait)
 	cleanupCmd.Flag("dry-run", "Report but do not delete").BoolVar(&cleanupOpts.dryRun)
 
+	retentionCmd := pbmCmd.Command("retention", "Delete backups and PITR chunks according to the retention policy")
+	retentionOpts := cleanupOptions{}
+	retentionCmd.Flag("yes", "Don't ask confirmation").Short('y').BoolVar(&retentionOpts.yes)
+	retentionCmd.Flag("wait", "Wait for deletion done").Short('w').BoolVar(&retentionOpts.wait)
+	retentionCmd.Flag("dry-run", "Report but do not delete").BoolVar(&retentionOpts.dryRun)
+
 	logsCmd := pbmCmd.Command("logs", "PBM logs")
 	logs := logsOpts{}
 	logsCmd.Flag("follow", "Follow output").Short('f').Default("false").BoolVar(&logs.follow)
 	logsCmd.Flag("tail", "Show last N entries, 20 entries are shown by default, 0 for all logs").Short('t').Default("20").Int64Var(&logs.tail)
 	logsCmd.Flag("node", "Target node in format replset[/host:posrt]").Short('n').StringVar(&logs.node)
 	logsCmd.Flag("severity", "Severity level D, I, W, E or F, low to high. Choosing one includes higher levels too.").Short('s').Default("I").EnumVar(&logs.severity, "D", "I", "W", "E", "F")
-	logsCmd.Flag("event", "Event in format backup[/2020-10-06T11:45:14Z]. Events: backup, restore, cancelBackup, resync, pitr, pitrestore, delete").Short('e').StringVar(&logs.event)
+	logsCmd.Flag("event", "Event in format backup[/2020-10-06T11:45:14Z]. Events: backup, restore, cancelBackup, cancelRestore, resync, pitr, pitrestore, delete").Short('e').StringVar(&logs.event)
 	logsCmd.Flag("opid", "Operation ID").Short('i').StringVar(&logs.opid)
 	logsCmd.Flag("timezone", "Timezone of log output. `Local`, `UTC` or a location name corresponding to a file in the IANA Time Zone database, such as `America/New_York`").StringVar(&logs.location)
 	logsCmd.Flag("extra", "Show extra data in text format").Hidden().Short('x').BoolVar(&logs.extr)
@@ -161,14 +188,25 @@ func Main() {
 	statusOpts := statusOptions{}
 	statusCmd := pbmCmd.Command("status", "Show PBM status")
 	statusCmd.Flag(RSMappingFlag, RSMappingDoc).Envar(RSMappingEnvVar).StringVar(&statusOpts.rsMap)
-	statusCmd.Flag("sections", "Sections of status to display <cluster>/<pitr>/<running>/<backups>.").Short('s').
-		EnumsVar(&statusOpts.sections, "cluster", "pitr", "running", "backups")
+	statusCmd.Flag("sections", "Sections of status to display <cluster>/<pitr>/<schedule>/<running>/<backups>.").Short('s').
+		EnumsVar(&statusOpts.sections, "cluster", "pitr", "schedule", "running", "backups")
 
 	describeRestoreCmd := pbmCmd.Command("describe-restore", "Describe restore")
 	describeRestoreOpts := descrRestoreOpts{}
 	describeRestoreCmd.Arg("name", "Restore name").StringVar(&describeRestoreOpts.restore)
 	describeRestoreCmd.Flag("config", "Path to PBM config").Short('c').StringVar(&describeRestoreOpts.cfg)
 
+	extractCmd := pbmCmd.Command("extract", "Extract the documents of a logical backup from the storage into local files")
+	extract := extractOpts{}
+	extractCmd.Arg("backup_name", "Backup name").Required().StringVar(&extract.bcp)
+	extractCmd.Flag("ns", `Namespaces to extract (e.g. "db1.*,db2.collection2"). If not set, extract all ("*.*")`).StringVar(&extract.ns)
+	extractCmd.Flag("out-dir", "Directory to write the files to").Required().StringVar(&extract.outDir)
+	extractCmd.Flag("format", fmt.Sprintf("Format of the files: <%s>", strings.Join(extractFormats, ">/<"))).
+		Default(extractFormats[0]).
+		EnumVar(&extract.format, extractFormats...)
+	extractCmd.Flag("config", "Path to PBM config. If set, the backup is read from the storage without connecting to the cluster").
+		Short('c').StringVar(&extract.cfg)
+
 	cmd, err := pbmCmd.DefaultEnvars().Parse(os.Args[1:])
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error: parse command line parameters:", err)
@@ -190,7 +228,12 @@ func Main() {
 		return
 	}
 
-	if *mURL == "" {
+	// no pbm connection is needed for `pbm describe-restore -c ...`
+	// and `pbm extract -c ...`
+	noConn := (cmd == describeRestoreCmd.FullCommand() && describeRestoreOpts.cfg != "") ||
+		(cmd == extractCmd.FullCommand() && extract.cfg != "")
+
+	if *mURL == "" && !noConn {
 		fmt.Fprintln(os.Stderr, "Error: no mongodb connection URI supplied")
 		fmt.Fprintln(os.Stderr, "       Usual practice is the set it by the PBM_MONGODB_URI environment variable. It can also be set with commandline argument --mongodb-uri.")
 		pbmCmd.Usage(os.Args[1:])
@@ -201,8 +244,7 @@ func Main() {
 	defer cancel()
 
 	var pbmClient *pbm.PBM
-	// we don't need pbm connection if it is `pbm describe-restore -c ...`
-	if describeRestoreOpts.cfg == "" {
+	if !noConn {
 		pbmClient, err = pbm.New(ctx, *mURL, "pbm-ctl")
 		if err != nil {
 			exitErr(errors.Wrap(err, "connect to mongodb"), pbmOutF)
@@ -218,8 +260,12 @@ func Main() {
 		out, err = runBackup(pbmClient, &backup, pbmOutF)
 	case cancelBcpCmd.FullCommand():
 		out, err = cancelBcp(pbmClient)
+	case cancelRestoreCmd.FullCommand():
+		out, err = cancelRestore(pbmClient)
 	case descBcpCmd.FullCommand():
 		out, err = describeBackup(pbmClient, &descBcp)
+	case verifyBcpCmd.FullCommand():
+		out, err = verifyBackup(pbmClient, &verifyBcp)
 	case restoreCmd.FullCommand():
 		out, err = runRestore(pbmClient, &restore, pbmOutF)
 	case replayCmd.FullCommand():
@@ -232,12 +278,16 @@ func Main() {
 		out, err = deletePITR(pbmClient, &deletePitr, pbmOutF)
 	case cleanupCmd.FullCommand():
 		out, err = retentionCleanup(pbmClient, &cleanupOpts)
+	case retentionCmd.FullCommand():
+		out, err = applyRetention(pbmClient, &retentionOpts)
 	case logsCmd.FullCommand():
 		out, err = runLogs(pbmClient, &logs)
 	case statusCmd.FullCommand():
 		out, err = status(pbmClient, *mURL, statusOpts, pbmOutF == outJSONpretty)
 	case describeRestoreCmd.FullCommand():
 		out, err = describeRestore(pbmClient, describeRestoreOpts)
+	case extractCmd.FullCommand():
+		out, err = runExtract(pbmClient, &extract)
 	}
 
 	if err != nil {
@@ -365,7 +415,7 @@ func runLogs(cn *pbm.PBM, l *logsOpts) (fmt.Stringer, error) {
 }
 
 func followLogs(cn *pbm.PBM, r *log.LogRequest, showNode, expr bool) error {
-	outC, errC := log.Follow(cn.Context(), cn.Conn.Database(pbm.DB).Collection(pbm.LogCollection), r, false)
+	outC, errC := client.New(cn).FollowLogs(cn.Context(), r)
 
 	for {
 		select {
@@ -400,17 +450,11 @@ type snapshotStat struct {
 	PBMVersion string         `json:"pbmVersion"`
 	Type       pbm.BackupType `json:"type"`
 	SrcBackup  string         `json:"src"`
+	Profile    string         `json:"profile,omitempty"`
+	Partial    bool           `json:"partial,omitempty"`
 }
 
-type pitrRange struct {
-	Err            error        `json:"error,omitempty"`
-	Range          pbm.Timeline `json:"range"`
-	NoBaseSnapshot bool         `json:"noBaseSnapshot,omitempty"`
-}
-
-func (pr pitrRange) String() string {
-	return fmt.Sprintf("{ %s }", pr.Range)
-}
+type pitrRange = client.PITRRange
 
 func fmtTS(ts int64) string {
 	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
@@ -455,6 +499,16 @@ func cancelBcp(cn *pbm.PBM) (fmt.Stringer, error) {
 	return outMsg{"Backup cancellation has started"}, nil
 }
 
+func cancelRestore(cn *pbm.PBM) (fmt.Stringer, error) {
+	err := cn.SendCmd(pbm.Cmd{
+		Cmd: pbm.CmdCancelRestore,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "send restore canceling")
+	}
+	return outMsg{"Restore cancellation has started. The data restored so far is left as is"}, nil
+}
+
 var errInvalidFormat = errors.New("invalid format")
 
 func parseDateT(v string) (time.Time, error) {
@@ -468,144 +522,41 @@ func parseDateT(v string) (time.Time, error) {
 	return time.Time{}, errInvalidFormat
 }
 
-func findLock(cn *pbm.PBM, fn func(*pbm.LockHeader) ([]pbm.LockData, error)) (*pbm.LockData, error) {
-	locks, err := fn(&pbm.LockHeader{})
-	if err != nil {
-		return nil, errors.Wrap(err, "get locks")
-	}
-
-	ct, err := cn.ClusterTime()
-	if err != nil {
-		return nil, errors.Wrap(err, "get cluster time")
-	}
-
-	var lk *pbm.LockData
-	for _, l := range locks {
-		// We don't care about the PITR slicing here. It is a subject of other status sections
-		if l.Type == pbm.CmdPITR || l.Heartbeat.T+pbm.StaleFrameSec < ct.T {
-			continue
-		}
-
-		// Just check if all locks are for the same op
-		//
-		// It could happen that the healthy `lk` became stale by the time of this check
-		// or the op was finished and the new one was started. So the `l.Type != lk.Type`
-		// would be true but for the legit reason (no error).
-		// But chances for that are quite low and on the next run of `pbm status` everything
-		//  would be ok. So no reason to complicate code to avoid that.
-		if lk != nil && l.OPID != lk.OPID {
-			if err != nil {
-				return nil, errors.Errorf("conflicting ops running: [%s/%s::%s-%s] [%s/%s::%s-%s]. This conflict may naturally resolve after 10 seconds",
-					l.Replset, l.Node, l.Type, l.OPID,
-					lk.Replset, lk.Node, lk.Type, lk.OPID,
-				)
-			}
-		}
-
-		lk = &l
-	}
-
-	return lk, nil
-}
-
 var errTout = errors.Errorf("timeout reached")
 
 // waitOp waits up to waitFor duration until operations which acquires a given lock are finished
-func waitOp(pbmClient *pbm.PBM, lock *pbm.LockHeader, waitFor time.Duration) error {
-	// just to be sure the check hasn't started before the lock were created
-	time.Sleep(1 * time.Second)
-	fmt.Print(".")
+func waitOp(c *client.Client, lock *pbm.LockHeader, waitFor time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), waitFor)
+	defer cancel()
 
-	tmr := time.NewTimer(waitFor)
-	defer tmr.Stop()
-	tkr := time.NewTicker(1 * time.Second)
-	defer tkr.Stop()
-	for {
-		select {
-		case <-tmr.C:
-			return errTout
-		case <-tkr.C:
-			fmt.Print(".")
-			lock, err := pbmClient.GetLockData(lock)
-			if err != nil {
-				// No lock, so operation has finished
-				if err == mongo.ErrNoDocuments {
-					return nil
-				}
-				return errors.Wrap(err, "get lock data")
-			}
-			clusterTime, err := pbmClient.ClusterTime()
-			if err != nil {
-				return errors.Wrap(err, "read cluster time")
-			}
-			if lock.Heartbeat.T+pbm.StaleFrameSec < clusterTime.T {
-				return errors.Errorf("operation stale, last beat ts: %d", lock.Heartbeat.T)
-			}
-		}
+	err := c.WaitOp(ctx, lock, printDot)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errTout
 	}
+	return err
 }
 
-func isTTY() bool {
-	fi, err := os.Stdin.Stat()
-	return (fi.Mode()&os.ModeCharDevice) != 0 && err == nil
+// throttleFlags adds the flags overriding the config limits of the storage traffic
+func throttleFlags(cmd *kingpin.CmdClause, l *storage.Limits) {
+	cmd.Flag("max-mbps", "Limit the storage bandwidth (MB/s) of each agent. Overrides the config value").
+		Float64Var(&l.MaxMBps)
+	cmd.Flag("max-rps", "Limit the storage requests per second of each agent. Overrides the config value").
+		Float64Var(&l.MaxRPS)
 }
 
-func lastLogErr(cn *pbm.PBM, op pbm.Command, after int64) (string, error) {
-	l, err := cn.LogGet(
-		&log.LogRequest{
-			LogKeys: log.LogKeys{
-				Severity: log.Error,
-				Event:    string(op),
-			},
-		}, 1)
-	if err != nil {
-		return "", errors.Wrap(err, "get log records")
-	}
-	if len(l.Data) == 0 {
-		return "", nil
-	}
-
-	if l.Data[0].TS < after {
-		return "", nil
+// throttleOpt returns nil if no limits were set
+func throttleOpt(l storage.Limits) *storage.Limits {
+	if l == (storage.Limits{}) {
+		return nil
 	}
-
-	return l.Data[0].Msg, nil
-}
-
-type concurentOpErr struct {
-	op *pbm.LockHeader
+	return &l
 }
 
-func (e concurentOpErr) Error() string {
-	return fmt.Sprintf("another operation in progress, %s/%s [%s/%s]", e.op.Type, e.op.OPID, e.op.Replset, e.op.Node)
-}
-
-func (e concurentOpErr) MarshalJSON() ([]byte, error) {
-	s := make(map[string]interface{})
-	s["error"] = "another operation in progress"
-	s["operation"] = e.op
-	return json.Marshal(s)
+func printDot() {
+	fmt.Print(".")
 }
 
-func checkConcurrentOp(cn *pbm.PBM) error {
-	locks, err := cn.GetLocks(&pbm.LockHeader{})
-	if err != nil {
-		return errors.Wrap(err, "get locks")
-	}
-
-	ts, err := cn.ClusterTime()
-	if err != nil {
-		return errors.Wrap(err, "read cluster time")
-	}
-
-	// Stop if there is some live operation.
-	// But in case of stale lock just move on
-	// and leave it for agents to deal with.
-	for _, l := range locks {
-		if l.Heartbeat.T+pbm.StaleFrameSec >= ts.T {
-			return concurentOpErr{&l.LockHeader}
-		}
-	}
-
-	return nil
+func isTTY() bool {
+	fi, err := os.Stdin.Stat()
+	return (fi.Mode()&os.ModeCharDevice) != 0 && err == nil
 }
@@ -106,8 +106,14 @@ func runConfig(cn *pbm.PBM, c *configOpts) (fmt.Stringer, error) {
 
 		// provider value may differ as it set automatically after config parsing
 		cCfg.Storage.S3.Provider = cfg.Storage.S3.Provider
-		// resync storage only if Storage options have changed
-		if !reflect.DeepEqual(cfg.Storage, cCfg.Storage) {
+		if len(cfg.Profiles) == len(cCfg.Profiles) {
+			for i := range cfg.Profiles {
+				cCfg.Profiles[i].S3.Provider = cfg.Profiles[i].S3.Provider
+			}
+		}
+		// resync storage only if Storage or profiles options have changed
+		if !reflect.DeepEqual(cfg.Storage, cCfg.Storage) ||
+			!reflect.DeepEqual(cfg.Profiles, cCfg.Profiles) {
 			if err := rsync(cn); err != nil {
 				return nil, errors.WithMessage(err, "resync")
 			}
@@ -16,6 +16,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/client"
 )
 
 type deleteBcpOpts struct {
@@ -36,33 +37,26 @@ func deleteBackup(pbmClient *pbm.PBM, d *deleteBcpOpts, outf outFormat) (fmt.Str
 		}
 	}
 
-	cmd := pbm.Cmd{
-		Cmd:    pbm.CmdDeleteBackup,
-		Delete: &pbm.DeleteBackupCmd{},
-	}
+	o := client.DeleteOptions{Backup: d.name}
 	if len(d.olderThan) > 0 {
 		t, err := parseDateT(d.olderThan)
 		if err != nil {
 			return nil, errors.Wrap(err, "parse date")
 		}
-		cmd.Delete.OlderThan = t.UTC().Unix()
-	} else {
-		if len(d.name) == 0 {
-			return nil, errors.New("backup name should be specified")
-		}
-		cmd.Delete.Backup = d.name
+		o.OlderThan = t
 	}
+	c := client.New(pbmClient)
 	tsop := time.Now().UTC().Unix()
-	err := pbmClient.SendCmd(cmd)
+	_, err := c.Delete(context.Background(), o)
 	if err != nil {
-		return nil, errors.Wrap(err, "schedule delete")
+		return nil, err
 	}
 	if outf != outText {
 		return nil, nil
 	}
 
 	fmt.Print("Waiting for delete to be done ")
-	err = waitOp(pbmClient,
+	err = waitOp(c,
 		&pbm.LockHeader{
 			Type: pbm.CmdDeleteBackup,
 		},
@@ -71,7 +65,7 @@ func deleteBackup(pbmClient *pbm.PBM, d *deleteBcpOpts, outf outFormat) (fmt.Str
 		return nil, err
 	}
 
-	errl, err := lastLogErr(pbmClient, pbm.CmdDeleteBackup, tsop)
+	errl, err := c.LastLogErr(pbm.CmdDeleteBackup, tsop)
 	if err != nil {
 		return nil, errors.Wrap(err, "read agents log")
 	}
@@ -139,7 +133,8 @@ func deletePITR(pbmClient *pbm.PBM, d *deletePitrOpts, outf outFormat) (fmt.Stri
 	}
 
 	fmt.Print("Waiting for delete to be done ")
-	err = waitOp(pbmClient,
+	c := client.New(pbmClient)
+	err = waitOp(c,
 		&pbm.LockHeader{
 			Type: pbm.CmdDeletePITR,
 		},
@@ -148,7 +143,7 @@ func deletePITR(pbmClient *pbm.PBM, d *deletePitrOpts, outf outFormat) (fmt.Stri
 		return nil, err
 	}
 
-	errl, err := lastLogErr(pbmClient, pbm.CmdDeletePITR, tsop)
+	errl, err := c.LastLogErr(pbm.CmdDeletePITR, tsop)
 	if err != nil {
 		return nil, errors.Wrap(err, "read agents log")
 	}
@@ -185,6 +180,35 @@ func retentionCleanup(pbmClient *pbm.PBM, d *cleanupOptions) (fmt.Stringer, erro
 	if err != nil {
 		return nil, errors.WithMessage(err, "make cleanup report")
 	}
+
+	return runCleanup(pbmClient, info, &pbm.CleanupCmd{OlderThan: ts}, d)
+}
+
+// applyRetention deletes backups and PITR chunks according to
+// the retention policy from the config
+func applyRetention(pbmClient *pbm.PBM, d *cleanupOptions) (fmt.Stringer, error) {
+	cfg, err := pbmClient.GetConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "get config")
+	}
+	if !cfg.Retention.Enabled() {
+		return nil, errors.New("retention policy isn't configured. Set the `retention` section of the config")
+	}
+
+	ct, err := pbmClient.ClusterTime()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
+
+	info, err := pbmClient.MakeRetentionInfo(cfg.Retention, time.Unix(int64(ct.T), 0))
+	if err != nil {
+		return nil, errors.WithMessage(err, "make retention report")
+	}
+
+	return runCleanup(pbmClient, info, &pbm.CleanupCmd{Retention: true}, d)
+}
+
+func runCleanup(pbmClient *pbm.PBM, info pbm.CleanupInfo, cmd *pbm.CleanupCmd, d *cleanupOptions) (fmt.Stringer, error) {
 	if len(info.Backups) == 0 && len(info.Chunks) == 0 {
 		return outMsg{"nothing to delete"}, nil
 	}
@@ -206,9 +230,9 @@ func retentionCleanup(pbmClient *pbm.PBM, d *cleanupOptions) (fmt.Stringer, erro
 	}
 
 	tsop := time.Now().Unix()
-	err = pbmClient.SendCmd(pbm.Cmd{
+	err := pbmClient.SendCmd(pbm.Cmd{
 		Cmd:     pbm.CmdCleanup,
-		Cleanup: &pbm.CleanupCmd{OlderThan: ts},
+		Cleanup: cmd,
 	})
 	if err != nil {
 		return nil, errors.WithMessage(err, "send command")
@@ -218,7 +242,8 @@ func retentionCleanup(pbmClient *pbm.PBM, d *cleanupOptions) (fmt.Stringer, erro
 	}
 
 	fmt.Print("Waiting")
-	err = waitOp(pbmClient, &pbm.LockHeader{Type: pbm.CmdCleanup}, 10*time.Minute)
+	c := client.New(pbmClient)
+	err = waitOp(c, &pbm.LockHeader{Type: pbm.CmdCleanup}, 10*time.Minute)
 	fmt.Println()
 	if err != nil {
 		if errors.Is(err, errTout) {
@@ -227,7 +252,7 @@ func retentionCleanup(pbmClient *pbm.PBM, d *cleanupOptions) (fmt.Stringer, erro
 		return nil, err
 	}
 
-	errl, err := lastLogErr(pbmClient, pbm.CmdCleanup, tsop)
+	errl, err := c.LastLogErr(pbm.CmdCleanup, tsop)
 	if err != nil {
 		return nil, errors.WithMessage(err, "read agents log")
 	}
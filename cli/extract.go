@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v2"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	prestore "github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+)
+
+// extractFormats are the formats of the extracted files. The first is the default.
+var extractFormats = []string{string(backup.ExtractBSON), string(backup.ExtractJSONL)}
+
+type extractOpts struct {
+	bcp    string
+	ns     string
+	outDir string
+	format string
+	cfg    string
+}
+
+type extractOut struct {
+	Backup string               `json:"backup"`
+	Dir    string               `json:"dir"`
+	NSs    []backup.ExtractedNS `json:"namespaces"`
+}
+
+func (e extractOut) String() string {
+	s := fmt.Sprintf("Backup '%s' extracted to '%s':\n", e.Backup, e.Dir)
+	if len(e.NSs) == 0 {
+		return s + "  <no documents>\n"
+	}
+	for _, n := range e.NSs {
+		s += fmt.Sprintf("  %s: %d documents (%s) -> %s\n", n.NS, n.Docs, byteCountIEC(n.Size), n.File)
+	}
+
+	return s
+}
+
+// runExtract writes the backup documents into the local files. With the
+// config file (o.cfg) the backup meta is read from the storage, so the PBM
+// connection (cn) isn't needed.
+func runExtract(cn *pbm.PBM, o *extractOpts) (fmt.Stringer, error) {
+	nss, err := parseCLINSOption(o.ns)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse --ns option")
+	}
+	match := archive.DefaultNSFilter
+	if sel.IsSelective(nss) {
+		match = sel.MakeSelectedPred(nss)
+	}
+
+	l := log.New(nil, "cli", "").NewEvent("", "", "", primitive.Timestamp{})
+
+	var cfg pbm.Config
+	var bcp *pbm.BackupMeta
+	if o.cfg != "" {
+		cfg, err = readConfigFile(o.cfg)
+		if err != nil {
+			return nil, err
+		}
+		bcp, err = prestore.GetMetaFromStores(cfg, o.bcp, l)
+		if err != nil {
+			return nil, errors.Wrap(err, "get backup meta")
+		}
+	} else {
+		cfg, err = cn.GetConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "get config")
+		}
+		bcp, err = cn.GetBackupMeta(o.bcp)
+		if err != nil {
+			return nil, errors.Wrap(err, "get backup meta")
+		}
+	}
+
+	rv, err := backup.Extract(context.Background(), cfg, bcp, match, o.outDir, backup.ExtractFormat(o.format), l)
+	if err != nil {
+		return nil, errors.Wrap(err, "extract")
+	}
+
+	return extractOut{Backup: bcp.Name, Dir: o.outDir, NSs: rv}, nil
+}
+
+// readConfigFile reads PBM config from the YAML file
+func readConfigFile(file string) (pbm.Config, error) {
+	var cfg pbm.Config
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return cfg, errors.Wrap(err, "unable to read config file")
+	}
+
+	err = yaml.UnmarshalStrict(buf, &cfg)
+	if err != nil {
+		return cfg, errors.Wrap(err, "unable to unmarshal config file")
+	}
+
+	return cfg, nil
+}
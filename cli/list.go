@@ -1,15 +1,16 @@
 package cli
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sort"
 	"time"
 
 	"github.com/pkg/errors"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/client"
 )
 
 type listOpts struct {
@@ -95,7 +96,7 @@ func runList(cn *pbm.PBM, l *listOpts) (fmt.Stringer, error) {
 		return restoreList(cn, int64(l.size))
 	}
 	// show message and skip when resync is running
-	lk, err := findLock(cn, cn.GetLocks)
+	lk, err := client.New(cn).FindLock(cn.GetLocks)
 	if err == nil && lk != nil && lk.Type == pbm.CmdResync {
 		return outMsg{"Storage resync is running. Backups list will be available after sync finishes."}, nil
 	}
@@ -159,9 +160,15 @@ func (bl backupListOut) String() string {
 		if len(b.Namespaces) != 0 {
 			kind += ", selective"
 		}
+		if b.Partial {
+			kind += ", partial"
+		}
 		if b.Type == pbm.IncrementalBackup && b.SrcBackup == "" {
 			kind += ", base"
 		}
+		if b.Profile != "" {
+			kind += ", profile: " + b.Profile
+		}
 
 		s += fmt.Sprintf("  %s <%s> [restore_to_time: %s]\n", b.Name, kind, fmtTS(int64(b.RestoreTS)))
 	}
@@ -192,60 +199,22 @@ func (bl backupListOut) String() string {
 }
 
 func backupList(cn *pbm.PBM, size int, full, unbacked bool, rsMap map[string]string) (list backupListOut, err error) {
-	list.Snapshots, err = getSnapshotList(cn, size, rsMap)
-	if err != nil {
-		return list, errors.Wrap(err, "get snapshots")
-	}
-	list.PITR.Ranges, list.PITR.RsRanges, err = getPitrList(cn, size, full, unbacked, rsMap)
-	if err != nil {
-		return list, errors.Wrap(err, "get PITR ranges")
-	}
-
-	list.PITR.On, err = cn.IsPITR()
-	if err != nil {
-		return list, errors.Wrap(err, "check if PITR is on")
-	}
-
-	return list, nil
-}
-
-func getSnapshotList(cn *pbm.PBM, size int, rsMap map[string]string) (s []snapshotStat, err error) {
-	bcps, err := cn.BackupsList(int64(size))
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to get backups list")
-	}
-
-	shards, err := cn.ClusterMembers()
-	if err != nil {
-		return nil, errors.Wrap(err, "get cluster members")
-	}
-
-	inf, err := cn.GetNodeInfo()
-	if err != nil {
-		return nil, errors.Wrap(err, "define cluster state")
-	}
-
-	ver, err := pbm.GetMongoVersion(cn.Context(), cn.Conn)
-	if err != nil {
-		return nil, errors.WithMessage(err, "get mongo version")
-	}
-	fcv, err := cn.GetFeatureCompatibilityVersion()
+	bl, err := client.New(cn).ListBackups(context.Background(), client.ListOptions{
+		Limit:    size,
+		Full:     full,
+		Unbacked: unbacked,
+		RSMap:    rsMap,
+	})
 	if err != nil {
-		return nil, errors.WithMessage(err, "get featureCompatibilityVersion")
+		return list, err
 	}
 
-	// pbm.PBM is always connected either to config server or to the sole (hence main) RS
-	// which the `confsrv` param in `bcpMatchCluster` is all about
-	bcpsMatchCluster(bcps, ver.VersionString, fcv, shards, inf.SetName, rsMap)
-
-	for i := len(bcps) - 1; i >= 0; i-- {
-		b := bcps[i]
-
+	for _, b := range bl.Backups {
 		if b.Status != pbm.StatusDone {
 			continue
 		}
 
-		s = append(s, snapshotStat{
+		list.Snapshots = append(list.Snapshots, snapshotStat{
 			Name:       b.Name,
 			Namespaces: b.Namespaces,
 			Status:     b.Status,
@@ -253,137 +222,13 @@ func getSnapshotList(cn *pbm.PBM, size int, rsMap map[string]string) (s []snapsh
 			PBMVersion: b.PBMVersion,
 			Type:       b.Type,
 			SrcBackup:  b.SrcBackup,
+			Profile:    b.Store.Profile,
+			Partial:    b.IsPartial(),
 		})
 	}
+	list.PITR.On = bl.PITR.On
+	list.PITR.Ranges = bl.PITR.Ranges
+	list.PITR.RsRanges = bl.PITR.RsRanges
 
-	return s, nil
-}
-
-// getPitrList shows only chunks derived from `Done` and compatible version's backups
-func getPitrList(cn *pbm.PBM, size int, full, unbacked bool, rsMap map[string]string) (ranges []pitrRange, rsRanges map[string][]pitrRange, err error) {
-	inf, err := cn.GetNodeInfo()
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "define cluster state")
-	}
-
-	shards, err := cn.ClusterMembers()
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "get cluster members")
-	}
-
-	now, err := cn.ClusterTime()
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "get cluster time")
-	}
-
-	mapRevRS := pbm.MakeReverseRSMapFunc(rsMap)
-	rsRanges = make(map[string][]pitrRange)
-	var rstlines [][]pbm.Timeline
-	for _, s := range shards {
-		tlns, err := cn.PITRGetValidTimelines(mapRevRS(s.RS), now)
-		if err != nil {
-			return nil, nil, errors.Wrapf(err, "get PITR timelines for %s replset", s.RS)
-		}
-
-		if len(tlns) == 0 {
-			continue
-		}
-
-		if size > 0 && size < len(tlns) {
-			tlns = tlns[len(tlns)-size:]
-		}
-
-		if full {
-			var rsrng []pitrRange
-			for _, tln := range tlns {
-				rsrng = append(rsrng, pitrRange{Range: tln})
-			}
-			rsRanges[s.RS] = rsrng
-		}
-		rstlines = append(rstlines, tlns)
-	}
-
-	sh := make(map[string]bool, len(shards))
-	for _, s := range shards {
-		sh[s.RS] = s.RS == inf.SetName
-	}
-
-	for _, tl := range pbm.MergeTimelines(rstlines...) {
-		lastWrite, err := getBaseSnapshotLastWrite(cn, sh, rsMap, tl)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		rs := splitByBaseSnapshot(lastWrite, tl)
-		for i := range rs {
-			if !unbacked && rs[i].NoBaseSnapshot {
-				continue
-			}
-
-			ranges = append(ranges, rs[i])
-		}
-	}
-
-	return ranges, rsRanges, nil
-}
-
-func getBaseSnapshotLastWrite(cn *pbm.PBM, sh map[string]bool, rsMap map[string]string, tl pbm.Timeline) (*primitive.Timestamp, error) {
-	bcp, err := cn.GetFirstBackup(&primitive.Timestamp{T: tl.Start, I: 0})
-	if err != nil {
-		if !errors.Is(err, pbm.ErrNotFound) {
-			return nil, errors.Wrapf(err, "get backup for timeline: %s", tl)
-		}
-
-		return nil, nil
-	}
-	if bcp == nil {
-		return nil, nil
-	}
-
-	ver, err := pbm.GetMongoVersion(cn.Context(), cn.Conn)
-	if err != nil {
-		return nil, errors.WithMessage(err, "get mongo version")
-	}
-	fcv, err := cn.GetFeatureCompatibilityVersion()
-	if err != nil {
-		return nil, errors.WithMessage(err, "get featureCompatibilityVersion")
-	}
-
-	bcpMatchCluster(bcp, ver.VersionString, fcv, sh, pbm.MakeRSMapFunc(rsMap), pbm.MakeReverseRSMapFunc(rsMap))
-
-	if bcp.Status != pbm.StatusDone {
-		return nil, nil
-	}
-
-	return &bcp.LastWriteTS, nil
-}
-
-func splitByBaseSnapshot(lastWrite *primitive.Timestamp, tl pbm.Timeline) []pitrRange {
-	if lastWrite == nil || (lastWrite.T < tl.Start || lastWrite.T > tl.End) {
-		return []pitrRange{{Range: tl, NoBaseSnapshot: true}}
-	}
-
-	ranges := make([]pitrRange, 0, 1)
-
-	if lastWrite.T > tl.Start {
-		ranges = append(ranges, pitrRange{
-			Range: pbm.Timeline{
-				Start: tl.Start,
-				End:   lastWrite.T,
-			},
-			NoBaseSnapshot: true,
-		})
-	}
-
-	if lastWrite.T < tl.End {
-		ranges = append(ranges, pitrRange{
-			Range: pbm.Timeline{
-				Start: lastWrite.T + 1,
-				End:   tl.End,
-			},
-			NoBaseSnapshot: false,
-		})
-	}
-
-	return ranges
+	return list, nil
 }
@@ -4,6 +4,8 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
 )
 
 func parseRSNamesMapping(s string) (map[string]string, error) {
@@ -50,56 +52,12 @@ func makeStrSet() func(string) bool {
 }
 
 var (
-	ErrInvalidNamespace    = errors.New("invalid namespace")
-	ErrForbiddenDatabase   = errors.New(`"admin", "config", "local" databases are not allowed`)
-	ErrForbiddenCollection = errors.New(`"system.*" collections are not allowed`)
-	ErrAmbiguousNamespace  = errors.New("ambiguous namespace")
+	ErrInvalidNamespace    = sel.ErrInvalidNamespace
+	ErrForbiddenDatabase   = sel.ErrForbiddenDatabase
+	ErrForbiddenCollection = sel.ErrForbiddenCollection
+	ErrAmbiguousNamespace  = sel.ErrAmbiguousNamespace
 )
 
 func parseCLINSOption(s string) ([]string, error) {
-	s = strings.TrimSpace(s)
-	if s == "" || s == "*.*" {
-		return nil, nil
-	}
-
-	m := make(map[string]map[string]struct{})
-	for _, ns := range strings.Split(s, ",") {
-		db, coll, ok := strings.Cut(strings.TrimSpace(ns), ".")
-		if !ok {
-			return nil, errors.WithMessage(ErrInvalidNamespace, ns)
-		}
-		if db == "" || coll == "" || (db == "*" && coll != "*") {
-			return nil, errors.WithMessage(ErrInvalidNamespace, ns)
-		}
-		if db == "admin" || db == "config" || db == "local" {
-			return nil, ErrForbiddenDatabase
-		}
-		if strings.HasPrefix(coll, "system.") {
-			return nil, ErrForbiddenCollection
-		}
-
-		if _, ok := m[db]; !ok {
-			m[db] = make(map[string]struct{})
-		}
-		m[db][coll] = struct{}{}
-	}
-
-	if _, ok := m["*"]; ok && len(m) != 1 {
-		return nil, errors.WithMessage(ErrAmbiguousNamespace,
-			"cannot use * with other databases")
-	}
-
-	rv := []string{}
-	for db, colls := range m {
-		if _, ok := colls["*"]; ok && len(colls) != 1 {
-			return nil, errors.WithMessagef(ErrAmbiguousNamespace,
-				"cannot use * with other collections in %q database", db)
-		}
-
-		for coll := range colls {
-			rv = append(rv, db+"."+coll)
-		}
-	}
-
-	return rv, nil
+	return sel.ParseNSOption(s)
 }
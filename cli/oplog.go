@@ -3,18 +3,24 @@ package cli
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/client"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
 type replayOptions struct {
-	start string
-	end   string
-	wait  bool
-	rsMap string
+	start    string
+	end      string
+	wait     bool
+	nsFrom   string
+	nsTo     string
+	mask     string
+	rsMap    string
+	throttle storage.Limits
 }
 
 type oplogReplayResult struct {
@@ -42,6 +48,14 @@ func replayOplog(cn *pbm.PBM, o replayOptions, outf outFormat) (fmt.Stringer, er
 	if err != nil {
 		return nil, errors.WithMessage(err, "cannot parse replset mapping")
 	}
+	nsFrom, nsTo, err := sel.ParseNSRenameOption(o.nsFrom, o.nsTo)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse --ns-from/--ns-to options")
+	}
+	maskSpec, err := readMaskSpec(o.mask)
+	if err != nil {
+		return nil, err
+	}
 
 	startTS, err := parseTS(o.start)
 	if err != nil {
@@ -52,48 +66,40 @@ func replayOplog(cn *pbm.PBM, o replayOptions, outf outFormat) (fmt.Stringer, er
 		return nil, errors.Wrap(err, "parse end time")
 	}
 
-	err = checkConcurrentOp(cn)
+	c := client.New(cn)
+	r, err := c.ReplayOplog(context.Background(), client.ReplayOptions{
+		Start:    startTS,
+		End:      endTS,
+		NSFrom:   nsFrom,
+		NSTo:     nsTo,
+		Mask:     maskSpec,
+		RSMap:    rsMap,
+		Throttle: throttleOpt(o.throttle),
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	name := time.Now().UTC().Format(time.RFC3339Nano)
-	cmd := pbm.Cmd{
-		Cmd: pbm.CmdReplay,
-		Replay: &pbm.ReplayCmd{
-			Name:  name,
-			Start: startTS,
-			End:   endTS,
-			RSMap: rsMap,
-		},
-	}
-	if err := cn.SendCmd(cmd); err != nil {
-		return nil, errors.Wrap(err, "send command")
-	}
-
 	if outf != outText {
-		return oplogReplayResult{Name: name}, nil
+		return oplogReplayResult{Name: r.Name}, nil
 	}
 
 	fmt.Printf("Starting oplog replay '%s - %s'", o.start, o.end)
 
-	ctx, cancel := context.WithTimeout(context.Background(), pbm.WaitActionStart)
-	defer cancel()
-
-	m, err := waitForRestoreStatus(ctx, cn, name, cn.GetRestoreMeta)
+	m, err := c.WaitRestoreStart(context.Background(), r, printDot)
 	if err != nil {
 		return nil, err
 	}
 
 	if !o.wait || m == nil {
-		return oplogReplayResult{Name: name}, nil
+		return oplogReplayResult{Name: r.Name}, nil
 	}
 
 	fmt.Print("Started.\nWaiting to finish")
-	err = waitRestore(cn, m, 0)
+	err = c.WaitRestore(context.Background(), r, 0, printDot)
 	if err != nil {
 		return oplogReplayResult{err: err.Error()}, nil
 	}
 
-	return oplogReplayResult{Name: name, done: true}, nil
+	return oplogReplayResult{Name: r.Name, done: true}, nil
 }
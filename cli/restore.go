@@ -13,22 +13,33 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/client"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/mask"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
 type restoreOpts struct {
-	bcp      string
-	pitr     string
-	pitrBase string
-	wait     bool
-	ns       string
-	rsMap    string
+	bcp          string
+	pitr         string
+	pitrBase     string
+	allowPartial bool
+	wait         bool
+	ns           string
+	nsExclude    string
+	nsFrom       string
+	nsTo         string
+	mask         string
+	rsMap        string
+	throttle     storage.Limits
 }
 
 type restoreRet struct {
 	Name     string `json:"name,omitempty"`
 	Snapshot string `json:"snapshot,omitempty"`
 	PITR     string `json:"point-in-time,omitempty"`
+	Partial  bool   `json:"partial,omitempty"`
 	done     bool
 	physical bool
 	err      string
@@ -59,6 +70,14 @@ No other pbm command is available while the restore is running!
 		}
 		return fmt.Sprintf("Restore of the snapshot from '%s' has started", r.Snapshot)
 	case r.PITR != "":
+		if r.physical {
+			return fmt.Sprintf(`
+Restore to the point in time '%s' has started.
+Check restore status with: pbm describe-restore %s -c </path/to/pbm.conf.yaml>
+No other pbm command is available while the restore is running!
+`,
+				r.PITR, r.Name)
+		}
 		return fmt.Sprintf("Restore to the point in time '%s' has started", r.PITR)
 
 	default:
@@ -71,6 +90,18 @@ func runRestore(cn *pbm.PBM, o *restoreOpts, outf outFormat) (fmt.Stringer, erro
 	if err != nil {
 		return nil, errors.WithMessage(err, "parse --ns option")
 	}
+	nsExclude, err := sel.ParseNSExcludeOption(o.nsExclude)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse --ns-exclude option")
+	}
+	nsFrom, nsTo, err := sel.ParseNSRenameOption(o.nsFrom, o.nsTo)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse --ns-from/--ns-to options")
+	}
+	maskSpec, err := readMaskSpec(o.mask)
+	if err != nil {
+		return nil, err
+	}
 
 	rsMap, err := parseRSNamesMapping(o.rsMap)
 	if err != nil {
@@ -80,6 +111,9 @@ func runRestore(cn *pbm.PBM, o *restoreOpts, outf outFormat) (fmt.Stringer, erro
 	if o.pitr != "" && o.bcp != "" {
 		return nil, errors.New("either a backup name or point in time should be set, non both together!")
 	}
+	if o.allowPartial && o.pitr == "" {
+		return nil, errors.New("--allow-partial is allowed for the point-in-time restore only")
+	}
 
 	clusterTime, err := cn.ClusterTime()
 	if err != nil {
@@ -87,199 +121,125 @@ func runRestore(cn *pbm.PBM, o *restoreOpts, outf outFormat) (fmt.Stringer, erro
 	}
 	tdiff := time.Now().Unix() - int64(clusterTime.T)
 
+	c := client.New(cn)
 	switch {
 	case o.bcp != "":
-		m, err := restore(cn, o.bcp, nss, rsMap, outf)
+		r, m, err := restore(c, client.RestoreOptions{
+			Backup:     o.bcp,
+			Namespaces: nss,
+			NSExclude:  nsExclude,
+			NSFrom:     nsFrom,
+			NSTo:       nsTo,
+			Mask:       maskSpec,
+			RSMap:      rsMap,
+			Throttle:   throttleOpt(o.throttle),
+		}, outf)
 		if err != nil {
 			return nil, err
 		}
+		physical := r.Type == pbm.PhysicalBackup || r.Type == pbm.IncrementalBackup
 		if !o.wait {
 			return restoreRet{
-				Name:     m.Name,
+				Name:     r.Name,
 				Snapshot: o.bcp,
-				physical: m.Type == pbm.PhysicalBackup || m.Type == pbm.IncrementalBackup,
+				Partial:  r.Partial,
+				physical: physical,
 			}, nil
 		}
 
 		typ := " logical restore.\nWaiting to finish"
-		if m.Type == pbm.PhysicalBackup {
+		if physical {
 			typ = " physical restore.\nWaiting to finish"
 		}
 		fmt.Printf("Started%s", typ)
-		err = waitRestore(cn, m, tdiff)
+		err = c.WaitRestore(context.Background(), r, tdiff, printDot)
 		if err == nil {
 			return restoreRet{
 				done:     true,
-				physical: m.Type == pbm.PhysicalBackup || m.Type == pbm.IncrementalBackup,
+				physical: physical,
 			}, nil
 		}
 
-		if serr, ok := err.(errRestoreFailed); ok {
+		var serr client.RestoreFailedError
+		if errors.As(err, &serr) {
 			return restoreRet{err: serr.Error()}, nil
 		}
 		return restoreRet{err: fmt.Sprintf("%s.\n Try to check logs on node %s", err.Error(), m.Leader)}, nil
 	case o.pitr != "":
-		m, err := pitrestore(cn, o.pitr, o.pitrBase, nss, rsMap, outf)
+		r, err := pitrestore(c, o.pitr, client.PITRRestoreOptions{
+			Base:         o.pitrBase,
+			Namespaces:   nss,
+			NSExclude:    nsExclude,
+			NSFrom:       nsFrom,
+			NSTo:         nsTo,
+			Mask:         maskSpec,
+			RSMap:        rsMap,
+			Throttle:     throttleOpt(o.throttle),
+			AllowPartial: o.allowPartial,
+		}, outf)
 		if err != nil {
 			return nil, err
 		}
+		physical := r.Type == pbm.PhysicalBackup || r.Type == pbm.IncrementalBackup
 		if !o.wait {
-			return restoreRet{PITR: o.pitr, Name: m.Name}, nil
+			return restoreRet{PITR: o.pitr, Name: r.Name, Partial: r.Partial, physical: physical}, nil
 		}
 		fmt.Print("Started.\nWaiting to finish")
-		err = waitRestore(cn, m, tdiff)
+		err = c.WaitRestore(context.Background(), r, tdiff, printDot)
 		if err != nil {
 			return restoreRet{err: err.Error()}, nil
 		}
 		return restoreRet{
-			done: true,
-			PITR: o.pitr,
+			done:     true,
+			PITR:     o.pitr,
+			physical: physical,
 		}, nil
 	default:
 		return nil, errors.New("undefined restore state")
 	}
 }
 
-// We rely on heartbeats in error detection in case of all nodes failed,
-// comparing heartbeats with the current cluster time for logical restores.
-// But for physical ones, the cluster by this time is down. So we compare with
-// the wall time taking into account a time skew (wallTime - clusterTime) taken
-// when the cluster time was still available.
-func waitRestore(cn *pbm.PBM, m *pbm.RestoreMeta, tskew int64) error {
-	ep, _ := cn.GetEpoch()
-	l := cn.Logger().NewEvent(string(pbm.CmdRestore), m.Backup, m.OPID, ep.TS())
-	stg, err := cn.GetStorage(l)
-	if err != nil {
-		return errors.Wrap(err, "get storage")
-	}
-
-	tk := time.NewTicker(time.Second * 1)
-	defer tk.Stop()
-
-	var rmeta *pbm.RestoreMeta
-
-	getMeta := cn.GetRestoreMeta
-	if m.Type == pbm.PhysicalBackup || m.Type == pbm.IncrementalBackup {
-		getMeta = func(name string) (*pbm.RestoreMeta, error) {
-			return pbm.GetPhysRestoreMeta(name, stg, l)
-		}
+// readMaskSpec reads the masking spec from the YAML (or JSON) file.
+// It returns nil if the file isn't set.
+func readMaskSpec(file string) (*mask.Spec, error) {
+	if file == "" {
+		return nil, nil
 	}
 
-	var ctime uint32
-	frameSec := pbm.StaleFrameSec
-	if m.Type != pbm.LogicalBackup {
-		frameSec = 60 * 3
-	}
-	for range tk.C {
-		fmt.Print(".")
-		rmeta, err = getMeta(m.Name)
-		if errors.Is(err, pbm.ErrNotFound) {
-			continue
-		}
-		if err != nil {
-			return errors.Wrap(err, "get restore metadata")
-		}
-
-		switch rmeta.Status {
-		case pbm.StatusDone, pbm.StatusPartlyDone:
-			return nil
-		case pbm.StatusError:
-			return errRestoreFailed{fmt.Sprintf("operation failed with: %s", rmeta.Error)}
-		}
-
-		if m.Type == pbm.LogicalBackup {
-			clusterTime, err := cn.ClusterTime()
-			if err != nil {
-				return errors.Wrap(err, "read cluster time")
-			}
-			ctime = clusterTime.T
-		} else {
-			ctime = uint32(time.Now().Unix() + tskew)
-		}
-
-		if rmeta.Hb.T+frameSec < ctime {
-			return errors.Errorf("operation staled, last heartbeat: %v", rmeta.Hb.T)
-		}
-	}
-
-	return nil
-}
-
-type errRestoreFailed struct {
-	string
-}
-
-func (e errRestoreFailed) Error() string {
-	return e.string
-}
-
-func restore(cn *pbm.PBM, bcpName string, nss []string, rsMapping map[string]string, outf outFormat) (*pbm.RestoreMeta, error) {
-	bcp, err := cn.GetBackupMeta(bcpName)
-	if errors.Is(err, pbm.ErrNotFound) {
-		return nil, errors.Errorf("backup '%s' not found", bcpName)
-	}
+	buf, err := ioutil.ReadFile(file)
 	if err != nil {
-		return nil, errors.Wrap(err, "get backup data")
-	}
-	if bcp.Status != pbm.StatusDone {
-		return nil, errors.Errorf("backup '%s' didn't finish successfully", bcpName)
+		return nil, errors.Wrap(err, "read masking spec file")
 	}
 
-	err = checkConcurrentOp(cn)
+	spec := &mask.Spec{}
+	err = yaml.UnmarshalStrict(buf, spec)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "unmarshal masking spec file")
 	}
 
-	name := time.Now().UTC().Format(time.RFC3339Nano)
-	err = cn.SendCmd(pbm.Cmd{
-		Cmd: pbm.CmdRestore,
-		Restore: &pbm.RestoreCmd{
-			Name:       name,
-			BackupName: bcpName,
-			Namespaces: nss,
-			RSMap:      rsMapping,
-		},
-	})
+	return spec, nil
+}
+
+// restore starts the restore from the backup and, in the case of text output,
+// waits for it to start. The returned meta is empty if it doesn't wait.
+func restore(c *client.Client, o client.RestoreOptions, outf outFormat) (*client.RestoreStarted, *pbm.RestoreMeta, error) {
+	r, err := c.Restore(context.Background(), o)
 	if err != nil {
-		return nil, errors.Wrap(err, "send command")
+		return nil, nil, err
 	}
 
 	if outf != outText {
-		return &pbm.RestoreMeta{
-			Name:   name,
-			Backup: bcpName,
-			Type:   bcp.Type,
-		}, nil
+		return r, &pbm.RestoreMeta{}, nil
 	}
 
-	fmt.Printf("Starting restore %s from '%s'", name, bcpName)
-
-	var (
-		fn     getRestoreMetaFn
-		ctx    context.Context
-		cancel context.CancelFunc
-	)
-
-	// physical restore may take more time to start
-	const waitPhysRestoreStart = time.Second * 120
-	if bcp.Type == pbm.PhysicalBackup || bcp.Type == pbm.IncrementalBackup {
-		ep, _ := cn.GetEpoch()
-		stg, err := cn.GetStorage(cn.Logger().NewEvent(string(pbm.CmdRestore), bcpName, "", ep.TS()))
-		if err != nil {
-			return nil, errors.Wrap(err, "get storage")
-		}
-
-		fn = func(name string) (*pbm.RestoreMeta, error) {
-			return pbm.GetPhysRestoreMeta(name, stg, cn.Logger().NewEvent(string(pbm.CmdRestore), bcpName, "", ep.TS()))
-		}
-		ctx, cancel = context.WithTimeout(context.Background(), waitPhysRestoreStart)
-	} else {
-		fn = cn.GetRestoreMeta
-		ctx, cancel = context.WithTimeout(context.Background(), pbm.WaitActionStart)
+	printPartial(r)
+	fmt.Printf("Starting restore %s from '%s'", r.Name, o.Backup)
+	m, err := c.WaitRestoreStart(context.Background(), r, printDot)
+	if err != nil {
+		return nil, nil, err
 	}
-	defer cancel()
 
-	return waitForRestoreStatus(ctx, cn, name, fn)
+	return r, m, nil
 }
 
 func parseTS(t string) (ts primitive.Timestamp, err error) {
@@ -304,96 +264,41 @@ func parseTS(t string) (ts primitive.Timestamp, err error) {
 	return primitive.Timestamp{T: uint32(tsto.Unix()), I: 0}, nil
 }
 
-func pitrestore(cn *pbm.PBM, t, base string, nss []string, rsMap map[string]string, outf outFormat) (rmeta *pbm.RestoreMeta, err error) {
+// pitrestore starts the restore to the point in time t. o.Time is set from t.
+func pitrestore(c *client.Client, t string, o client.PITRRestoreOptions, outf outFormat) (*client.RestoreStarted, error) {
 	ts, err := parseTS(t)
 	if err != nil {
 		return nil, err
 	}
+	o.Time = ts
 
-	err = checkConcurrentOp(cn)
+	r, err := c.PITRRestore(context.Background(), o)
 	if err != nil {
 		return nil, err
 	}
 
-	name := time.Now().UTC().Format(time.RFC3339Nano)
-	err = cn.SendCmd(pbm.Cmd{
-		Cmd: pbm.CmdPITRestore,
-		PITRestore: &pbm.PITRestoreCmd{
-			Name:       name,
-			TS:         int64(ts.T),
-			I:          int64(ts.I),
-			Bcp:        base,
-			Namespaces: nss,
-			RSMap:      rsMap,
-		},
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "send command")
-	}
-
 	if outf != outText {
-		return &pbm.RestoreMeta{Name: name}, nil
+		return r, nil
 	}
 
+	printPartial(r)
 	fmt.Printf("Starting restore to the point in time '%s'", t)
+	_, err = c.WaitRestoreStart(context.Background(), r, printDot)
+	if err != nil {
+		return nil, err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), pbm.WaitActionStart)
-	defer cancel()
-
-	return waitForRestoreStatus(ctx, cn, name, cn.GetRestoreMeta)
+	return r, nil
 }
 
-type getRestoreMetaFn func(name string) (*pbm.RestoreMeta, error)
-
-func waitForRestoreStatus(ctx context.Context, cn *pbm.PBM, name string, getfn getRestoreMetaFn) (*pbm.RestoreMeta, error) {
-	tk := time.NewTicker(time.Second * 1)
-	defer tk.Stop()
-	var err error
-	meta := new(pbm.RestoreMeta)
-	for {
-		select {
-		case <-tk.C:
-			fmt.Print(".")
-			meta, err = getfn(name)
-			if errors.Is(err, pbm.ErrNotFound) {
-				continue
-			}
-			if err != nil {
-				return nil, errors.Wrap(err, "get metadata")
-			}
-			if meta == nil {
-				continue
-			}
-			switch meta.Status {
-			case pbm.StatusRunning, pbm.StatusDumpDone, pbm.StatusDone:
-				return meta, nil
-			case pbm.StatusError:
-				rs := ""
-				for _, s := range meta.Replsets {
-					rs += fmt.Sprintf("\n- Restore on replicaset \"%s\" in state: %v", s.Name, s.Status)
-					if s.Error != "" {
-						rs += ": " + s.Error
-					}
-				}
-				return nil, errors.New(meta.Error + rs)
-			}
-		case <-ctx.Done():
-			rs := ""
-			if meta != nil {
-				for _, s := range meta.Replsets {
-					rs += fmt.Sprintf("- Restore on replicaset \"%s\" in state: %v\n", s.Name, s.Status)
-					if s.Error != "" {
-						rs += ": " + s.Error
-					}
-				}
-			}
-			if rs == "" {
-				rs = "<no replset has started restore>\n"
-			}
-
-			return nil, errors.New("no confirmation that restore has successfully started. Replsets status:\n" + rs)
-		}
+// printPartial warns that the restored backup is partial
+func printPartial(r *client.RestoreStarted) {
+	if !r.Partial {
+		return
 	}
+
+	fmt.Printf("Backup '%s' is partial: only the documents matching the query are restored "+
+		"for some collections (see `pbm describe-backup %s`)\n", r.Backup, r.Backup)
 }
 
 type descrRestoreOpts struct {
@@ -424,9 +329,78 @@ type RestoreReplset struct {
 	Error              *string       `json:"error,omitempty" yaml:"error,omitempty"`
 	LastTransitionTS   int64         `json:"last_transition_ts" yaml:"-"`
 	LastTransitionTime string        `json:"last_transition_time" yaml:"last_transition_time"`
+	LastAppliedTS      *int64        `json:"last_applied_ts,omitempty" yaml:"-"`
+	LastAppliedTime    *string       `json:"last_applied_time,omitempty" yaml:"last_applied_time,omitempty"`
+	Progress           *rstProgress  `json:"progress,omitempty" yaml:"progress,omitempty"`
 	Nodes              []RestoreNode `json:"nodes,omitempty" yaml:"nodes,omitempty"`
 }
 
+type rstProgress struct {
+	Stage            string `json:"stage" yaml:"stage"`
+	Percent          *int   `json:"percent,omitempty" yaml:"percent,omitempty"`
+	BytesDownloaded  int64  `json:"bytes_downloaded" yaml:"-"`
+	HBytesDownloaded string `json:"bytes_downloaded_h" yaml:"downloaded"`
+	BytesTotal       int64  `json:"bytes_total" yaml:"-"`
+	HBytesTotal      string `json:"bytes_total_h" yaml:"total"`
+	NSDone           int    `json:"ns_done,omitempty" yaml:"namespaces_done,omitempty"`
+	NSTotal          int    `json:"ns_total,omitempty" yaml:"namespaces_total,omitempty"`
+	DocsInserted     int64  `json:"docs_inserted,omitempty" yaml:"docs_inserted,omitempty"`
+	FilesDone        int    `json:"files_done,omitempty" yaml:"files_done,omitempty"`
+	FilesTotal       int    `json:"files_total,omitempty" yaml:"files_total,omitempty"`
+	OplogApplied     int64  `json:"oplog_applied,omitempty" yaml:"oplog_applied,omitempty"`
+	OplogLastTS      int64  `json:"oplog_last_ts,omitempty" yaml:"-"`
+	OplogLastTime    string `json:"oplog_last_time,omitempty" yaml:"oplog_last_time,omitempty"`
+	OplogTargetTS    int64  `json:"oplog_target_ts,omitempty" yaml:"-"`
+	OplogTargetTime  string `json:"oplog_target_time,omitempty" yaml:"oplog_target_time,omitempty"`
+	ETA              int64  `json:"eta,omitempty" yaml:"-"`
+	ETATime          string `json:"eta_time,omitempty" yaml:"eta,omitempty"`
+	UpdatedTS        int64  `json:"updated_ts" yaml:"-"`
+	UpdatedTime      string `json:"updated_time" yaml:"updated_time"`
+}
+
+func restoreProgress(p *pbm.RestoreProgress, status pbm.Status) *rstProgress {
+	rv := &rstProgress{
+		Stage:            p.Stage,
+		BytesDownloaded:  p.BytesDownloaded,
+		HBytesDownloaded: byteCountIEC(p.BytesDownloaded),
+		BytesTotal:       p.BytesTotal,
+		HBytesTotal:      byteCountIEC(p.BytesTotal),
+		NSDone:           p.NSDone,
+		NSTotal:          p.NSTotal,
+		DocsInserted:     p.DocsInserted,
+		FilesDone:        p.FilesDone,
+		FilesTotal:       p.FilesTotal,
+		OplogApplied:     p.OplogApplied,
+		UpdatedTS:        p.UpdatedTS,
+		UpdatedTime:      time.Unix(p.UpdatedTS, 0).UTC().Format(time.RFC3339),
+	}
+	if p.OplogLastTS.T != 0 {
+		rv.OplogLastTS = int64(p.OplogLastTS.T)
+		rv.OplogLastTime = time.Unix(rv.OplogLastTS, 0).UTC().Format(time.RFC3339)
+	}
+	if p.OplogTargetTS.T != 0 {
+		rv.OplogTargetTS = int64(p.OplogTargetTS.T)
+		rv.OplogTargetTime = time.Unix(rv.OplogTargetTS, 0).UTC().Format(time.RFC3339)
+	}
+
+	switch status {
+	case pbm.StatusDone:
+		v := 100
+		rv.Percent = &v
+	case pbm.StatusRunning, pbm.StatusDumpDone:
+		if v := p.Percent(); v >= 0 {
+			rv.Percent = &v
+		}
+		// ETA makes sense only while the restore is in progress
+		if p.ETA != 0 {
+			rv.ETA = p.ETA
+			rv.ETATime = time.Unix(p.ETA, 0).UTC().Format(time.RFC3339)
+		}
+	}
+
+	return rv
+}
+
 type RestoreNode struct {
 	Name               string     `json:"name" yaml:"name"`
 	Status             pbm.Status `json:"status" yaml:"status"`
@@ -448,15 +422,9 @@ func describeRestore(cn *pbm.PBM, o descrRestoreOpts) (fmt.Stringer, error) {
 	var res describeRestoreResult
 	var meta *pbm.RestoreMeta
 	if o.cfg != "" {
-		buf, err := ioutil.ReadFile(o.cfg)
+		cfg, err := readConfigFile(o.cfg)
 		if err != nil {
-			return nil, errors.Wrap(err, "unable to read config file")
-		}
-
-		var cfg pbm.Config
-		err = yaml.UnmarshalStrict(buf, &cfg)
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to  unmarshal config file")
+			return nil, err
 		}
 
 		l := log.New(nil, "cli", "").NewEvent("", "", "", primitive.Timestamp{})
@@ -513,6 +481,15 @@ func describeRestore(cn *pbm.PBM, o descrRestoreOpts) (fmt.Stringer, error) {
 		if rs.Status == pbm.StatusError {
 			mrs.Error = &rs.Error
 		}
+		if !rs.LastAppliedTS.IsZero() {
+			ts := int64(rs.LastAppliedTS.T)
+			mrs.LastAppliedTS = &ts
+			s := time.Unix(ts, 0).UTC().Format(time.RFC3339)
+			mrs.LastAppliedTime = &s
+		}
+		if rs.Progress != nil {
+			mrs.Progress = restoreProgress(rs.Progress, rs.Status)
+		}
 		for _, node := range rs.Nodes {
 			mnode := RestoreNode{
 				Name:               node.Name,
@@ -18,6 +18,8 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/client"
+	"github.com/percona/percona-backup-mongodb/pbm/cron"
 	plog "github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/pitr"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
@@ -103,6 +105,7 @@ func status(cn *pbm.PBM, curi string, opts statusOptions, pretty bool) (fmt.Stri
 				},
 			},
 			{"pitr", "PITR incremental backup", nil, getPitrStatus},
+			{"schedule", "Backup schedule", nil, getScheduleStatus},
 			{"running", "Currently running", nil, getCurrOps},
 			{"backups", "Backups", nil, storageStatFn},
 		},
@@ -360,6 +363,91 @@ func getPitrStatus(cn *pbm.PBM) (fmt.Stringer, error) {
 	return p, errors.Wrap(err, "check for errors")
 }
 
+type scheduleEntry struct {
+	Name       string `json:"name"`
+	Cron       string `json:"cron"`
+	Type       string `json:"type"`
+	NextRun    string `json:"nextRun,omitempty"`
+	LastBackup string `json:"lastBackup,omitempty"`
+	LastMissed string `json:"lastMissed,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+type scheduleStat []scheduleEntry
+
+func (s scheduleStat) String() string {
+	var o string
+	for _, e := range s {
+		o += fmt.Sprintf("%s [%s] <%s>", e.Name, e.Cron, e.Type)
+		if e.NextRun != "" {
+			o += fmt.Sprintf(" next run: %s", e.NextRun)
+		}
+		if e.LastBackup != "" {
+			o += fmt.Sprintf(", last backup: %s", e.LastBackup)
+		}
+		o += "\n"
+		if e.LastMissed != "" {
+			o += fmt.Sprintf("  ! missed run at %s: %s\n", e.LastMissed, e.Reason)
+		}
+	}
+
+	return strings.TrimSuffix(o, "\n")
+}
+
+// getScheduleStatus returns nil if there is no backup schedule
+func getScheduleStatus(cn *pbm.PBM) (fmt.Stringer, error) {
+	cfg, err := cn.GetConfig()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get config")
+	}
+	if len(cfg.Schedule) == 0 {
+		return nil, nil
+	}
+
+	states, err := cn.GetScheduleStates()
+	if err != nil {
+		return nil, errors.Wrap(err, "get schedule state")
+	}
+	stm := make(map[string]pbm.ScheduleState, len(states))
+	for _, st := range states {
+		stm[st.Name] = st
+	}
+
+	s := scheduleStat{}
+	for _, e := range cfg.Schedule {
+		se := scheduleEntry{
+			Name: e.Name,
+			Cron: e.Cron,
+			Type: string(e.Type),
+		}
+		if se.Type == "" {
+			se.Type = string(pbm.LogicalBackup)
+		}
+
+		st, ok := stm[e.Name]
+		if ok && st.Cron == e.Cron {
+			se.LastBackup = st.LastBackup
+			if len(st.Missed) != 0 {
+				m := st.Missed[len(st.Missed)-1]
+				se.LastMissed = fmtTS(m.Time)
+				se.Reason = m.Reason
+			}
+			if spec, err := cron.Parse(e.Cron); err == nil {
+				if next := spec.Next(time.Unix(st.LastRun, 0).UTC()); !next.IsZero() {
+					se.NextRun = next.Format(time.RFC3339)
+				}
+			}
+		}
+
+		s = append(s, se)
+	}
+
+	return s, nil
+}
+
 func getPitrErr(cn *pbm.PBM) (string, error) {
 	epch, err := cn.GetEpoch()
 	if err != nil {
@@ -418,13 +506,7 @@ LOOP:
 	return strings.Join(errs, "; "), nil
 }
 
-type currOp struct {
-	Type    pbm.Command `json:"type,omitempty"`
-	Name    string      `json:"name,omitempty"`
-	StartTS int64       `json:"startTS,omitempty"`
-	Status  string      `json:"status,omitempty"`
-	OPID    string      `json:"opID,omitempty"`
-}
+type currOp client.CurrentOp
 
 func (c currOp) String() string {
 	if c.Type == pbm.CmdUndefined {
@@ -435,73 +517,46 @@ func (c currOp) String() string {
 	default:
 		return fmt.Sprintf("%s [op id: %s]", c.Type, c.OPID)
 	case pbm.CmdBackup, pbm.CmdRestore, pbm.CmdPITRestore:
-		return fmt.Sprintf("%s \"%s\", started at %s. Status: %s. [op id: %s]",
+		s := fmt.Sprintf("%s \"%s\", started at %s. Status: %s. [op id: %s]",
 			c.Type, c.Name, time.Unix((c.StartTS), 0).UTC().Format("2006-01-02T15:04:05Z"),
 			c.Status, c.OPID,
 		)
-	}
-}
 
-func getCurrOps(cn *pbm.PBM) (fmt.Stringer, error) {
-	var r currOp
+		rss := make([]string, 0, len(c.Progress))
+		for rs := range c.Progress {
+			rss = append(rss, rs)
+		}
+		sort.Strings(rss)
+		for _, rs := range rss {
+			s += fmt.Sprintf("\n  %s: %s", rs, fmtBcpProgress(c.Progress[rs]))
+		}
 
-	// check for ops
-	lk, err := findLock(cn, cn.GetLocks)
-	if err != nil {
-		return r, errors.Wrap(err, "get ops")
+		return s
 	}
+}
 
-	if lk == nil {
-		// check for delete ops
-		lk, err = findLock(cn, cn.GetOpLocks)
-		if err != nil {
-			return r, errors.Wrap(err, "get delete ops")
-		}
+func fmtBcpProgress(p *pbm.BackupProgress) string {
+	s := fmt.Sprintf("%s of ~%s read", fmtSize(p.BytesRead), fmtSize(p.BytesTotal))
+	if v := p.Percent(); v >= 0 {
+		s += fmt.Sprintf(" (%d%%)", v)
 	}
-
-	if lk == nil {
-		return r, nil
+	s += fmt.Sprintf(", %s uploaded", fmtSize(p.BytesUploaded))
+	if p.CollsTotal != 0 {
+		s += fmt.Sprintf(", collections: %d/%d", p.CollsDone, p.CollsTotal)
 	}
-
-	r = currOp{
-		Type: lk.Type,
-		OPID: lk.OPID,
+	if p.FilesTotal != 0 {
+		s += fmt.Sprintf(", files: %d/%d", p.FilesDone, p.FilesTotal)
 	}
-
-	// reaching here means no conflict operation, hence all locks are the same,
-	// hence any lock in `lk` contais info on the current op
-	switch r.Type {
-	case pbm.CmdBackup:
-		bcp, err := cn.GetBackupByOPID(r.OPID)
-		if err != nil {
-			return r, errors.Wrap(err, "get backup info")
-		}
-		r.Name = bcp.Name
-		r.StartTS = bcp.StartTS
-		r.Status = string(bcp.Status)
-		switch bcp.Status {
-		case pbm.StatusRunning:
-			r.Status = "snapshot backup"
-		case pbm.StatusDumpDone:
-			r.Status = "oplog backup"
-		}
-	case pbm.CmdRestore, pbm.CmdPITRestore:
-		rst, err := cn.GetRestoreMetaByOPID(r.OPID)
-		if err != nil {
-			return r, errors.Wrap(err, "get restore info")
-		}
-		r.Name = rst.Backup
-		r.StartTS = rst.StartTS
-		r.Status = string(rst.Status)
-		switch rst.Status {
-		case pbm.StatusRunning:
-			r.Status = "snapshot restore"
-		case pbm.StatusDumpDone:
-			r.Status = "oplog restore"
-		}
+	if p.ETA != 0 {
+		s += fmt.Sprintf(", ETA: %s", time.Unix(p.ETA, 0).UTC().Format("2006-01-02T15:04:05Z"))
 	}
 
-	return r, nil
+	return s
+}
+
+func getCurrOps(cn *pbm.PBM) (fmt.Stringer, error) {
+	op, err := client.New(cn).CurrentOp()
+	return currOp(op), err
 }
 
 type storageStat struct {
@@ -538,7 +593,7 @@ func (s storageStat) String() string {
 		case pbm.StatusCancelled:
 			status = fmt.Sprintf("[!canceled: %s]", fmtTS(sn.RestoreTS))
 		case pbm.StatusError:
-			if errors.Is(sn.Err, errIncompatible) {
+			if errors.Is(sn.Err, client.ErrIncompatible) {
 				status = fmt.Sprintf("[incompatible: %s] [%s]", sn.Err.Error(), fmtTS(sn.RestoreTS))
 			} else {
 				status = fmt.Sprintf("[ERROR: %s] [%s]", sn.Err.Error(), fmtTS(sn.RestoreTS))
@@ -551,9 +606,15 @@ func (s storageStat) String() string {
 		if len(sn.Namespaces) != 0 {
 			kind += ", selective"
 		}
+		if sn.Partial {
+			kind += ", partial"
+		}
 		if sn.Type == pbm.IncrementalBackup && sn.SrcBackup == "" {
 			kind += ", base"
 		}
+		if sn.Profile != "" {
+			kind += ", profile: " + sn.Profile
+		}
 
 		ret += fmt.Sprintf("    %s %s <%s> %s\n",
 			sn.Name, fmtSize(sn.Size), kind, status)
@@ -605,29 +666,13 @@ func getStorageStat(cn *pbm.PBM, rsMap map[string]string) (fmt.Stringer, error)
 		return s, errors.Wrap(err, "get backups list")
 	}
 
-	inf, err := cn.GetNodeInfo()
-	if err != nil {
-		return s, errors.Wrap(err, "define cluster state")
-	}
-	ver, err := pbm.GetMongoVersion(cn.Context(), cn.Conn)
+	err = client.New(cn).MatchCluster(bcps, rsMap)
 	if err != nil {
-		return nil, errors.WithMessage(err, "get mongo version")
-	}
-	fcv, err := cn.GetFeatureCompatibilityVersion()
-	if err != nil {
-		return nil, errors.WithMessage(err, "get featureCompatibilityVersion")
-	}
-
-	shards, err := cn.ClusterMembers()
-	if err != nil {
-		return s, errors.Wrap(err, "get cluster members")
+		return s, err
 	}
 
-	// pbm.PBM is always connected either to config server or to the sole (hence main) RS
-	// which the `confsrv` param in `bcpMatchCluster` is all about
-	bcpsMatchCluster(bcps, ver.VersionString, fcv, shards, inf.SetName, rsMap)
-
-	stg, err := cn.GetStorage(cn.Logger().NewEvent("", "", "", primitive.Timestamp{}))
+	stgs := pbm.NewStorages(cfg, cn.Logger().NewEvent("", "", "", primitive.Timestamp{}))
+	stg, err := stgs.Get("")
 	if err != nil {
 		return s, errors.Wrap(err, "get storage")
 	}
@@ -646,6 +691,8 @@ func getStorageStat(cn *pbm.PBM, rsMap map[string]string) (fmt.Stringer, error)
 			PBMVersion: bcp.PBMVersion,
 			Type:       bcp.Type,
 			SrcBackup:  bcp.SrcBackup,
+			Profile:    bcp.Store.Profile,
+			Partial:    bcp.IsPartial(),
 		}
 		if err := bcp.Error(); err != nil {
 			snpsht.Err = err
@@ -654,7 +701,7 @@ func getStorageStat(cn *pbm.PBM, rsMap map[string]string) (fmt.Stringer, error)
 
 		switch bcp.Status {
 		case pbm.StatusError:
-			if !errors.Is(snpsht.Err, errIncompatible) {
+			if !errors.Is(snpsht.Err, client.ErrIncompatible) {
 				break
 			}
 			fallthrough
@@ -671,7 +718,10 @@ func getStorageStat(cn *pbm.PBM, rsMap map[string]string) (fmt.Stringer, error)
 			}
 		}
 
-		snpsht.Size, err = getBackupSize(&bcp, stg)
+		bstg, err := stgs.Backup(&bcp)
+		if err == nil {
+			snpsht.Size, err = getBackupSize(&bcp, bstg)
+		}
 		if err != nil {
 			snpsht.Err = err
 			snpsht.ErrString = err.Error()
@@ -740,7 +790,7 @@ func getPITRranges(cn *pbm.PBM, stg storage.Storage, bcps []pbm.BackupMeta, rsMa
 			break
 		}
 
-		pr = append(pr, splitByBaseSnapshot(bcplastWrite, tl)...)
+		pr = append(pr, client.SplitByBaseSnapshot(bcplastWrite, tl)...)
 	}
 
 	return &pitrRanges{Ranges: pr, Size: size}, nil
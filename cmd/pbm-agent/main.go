@@ -26,6 +26,7 @@ func main() {
 
 		mURI      = pbmAgentCmd.Flag(mongoConnFlag, "MongoDB connection string").Envar("PBM_MONGODB_URI").Required().String()
 		dumpConns = pbmAgentCmd.Flag("dump-parallel-collections", "Number of collections to dump in parallel").Envar("PBM_DUMP_PARALLEL_COLLECTIONS").Default(strconv.Itoa(runtime.NumCPU() / 2)).Int()
+		mAddr     = pbmAgentCmd.Flag("metrics-addr", "Address to expose Prometheus metrics on (e.g. :9216). Disabled if empty").Envar("PBM_METRICS_ADDR").String()
 
 		versionCmd    = pbmCmd.Command("version", "PBM version info")
 		versionShort  = versionCmd.Flag("short", "Only version info").Default("false").Bool()
@@ -56,14 +57,14 @@ func main() {
 
 	hidecreds()
 
-	err = runAgent(url, *dumpConns)
+	err = runAgent(url, *dumpConns, *mAddr)
 	log.Println("Exit:", err)
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
-func runAgent(mongoURI string, dumpConns int) error {
+func runAgent(mongoURI string, dumpConns int, metricsAddr string) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -85,7 +86,15 @@ func runAgent(mongoURI string, dumpConns int) error {
 	}
 
 	go agnt.PITR()
+	go agnt.Schedule()
 	go agnt.HbStatus()
+	if metricsAddr != "" {
+		go func() {
+			if err := agnt.ServeMetrics(metricsAddr); err != nil {
+				log.Println("Error:", err)
+			}
+		}()
+	}
 
 	return errors.Wrap(agnt.Start(), "listen the commands stream")
 }
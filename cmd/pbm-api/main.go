@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/api"
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/version"
+)
+
+func main() {
+	var (
+		pbmCmd = kingpin.New("pbm-api", "Percona Backup for MongoDB control API")
+		runCmd = pbmCmd.Command("run", "Run API server").Default().Hidden()
+
+		mURI   = runCmd.Flag("mongodb-uri", "MongoDB connection string").Envar("PBM_MONGODB_URI").Required().String()
+		listen = runCmd.Flag("listen", "Address to listen on").Envar("PBM_API_LISTEN").Default("127.0.0.1:8080").String()
+		token  = runCmd.Flag("token", "Bearer token required from the clients. Disabled if empty").Envar("PBM_API_TOKEN").String()
+
+		versionCmd = pbmCmd.Command("version", "PBM version info")
+	)
+
+	cmd, err := pbmCmd.DefaultEnvars().Parse(os.Args[1:])
+	if err != nil {
+		log.Println("Error: Parse command line parameters:", err)
+		os.Exit(1)
+	}
+
+	if cmd == versionCmd.FullCommand() {
+		fmt.Println(version.DefaultInfo.All(""))
+		return
+	}
+
+	url := "mongodb://" + strings.Replace(*mURI, "mongodb://", "", 1)
+	err = run(url, *listen, *token)
+	log.Println("Exit:", err)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func run(mongoURI, addr, token string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cn, err := pbm.New(ctx, mongoURI, "pbm-api")
+	if err != nil {
+		return errors.Wrap(err, "connect to PBM")
+	}
+	cn.InitLogger("", "")
+
+	log.Printf("pbm-api listening on %s", addr)
+	return errors.Wrap(http.ListenAndServe(addr, api.New(cn, token)), "serve")
+}
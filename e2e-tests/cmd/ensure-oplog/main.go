@@ -279,7 +279,7 @@ func ensureReplsetOplog(ctx context.Context, uri string, from, till primitive.Ti
 		o := oplog.NewOplogBackup(m)
 		o.SetTailingSpan(t.from, t.till)
 
-		n, err := backup.Upload(ctx, o, stg, compression, cfg.PITR.CompressionLevel, filename, -1)
+		n, sum, err := backup.Upload(ctx, o, stg, compression, cfg.PITR.CompressionLevel, filename, -1)
 		if err != nil {
 			return errors.WithMessagef(err, "failed to upload %s - %s chunk",
 				formatTimestamp(t.from), formatTimestamp(t.till))
@@ -294,6 +294,7 @@ func ensureReplsetOplog(ctx context.Context, uri string, from, till primitive.Ti
 			Compression: compression,
 			StartTS:     t.from,
 			EndTS:       t.till,
+			Checksum:    sum,
 		}
 
 		if err := pbmC.PITRAddChunk(meta); err != nil {
@@ -1,6 +1,8 @@
 package archive
 
 import (
+	"hash"
+	"hash/crc64"
 	"io"
 	"strings"
 	"sync"
@@ -11,6 +13,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
 	"golang.org/x/sync/errgroup"
+
+	"github.com/percona/percona-backup-mongodb/pbm/checksum"
 )
 
 type (
@@ -26,8 +30,9 @@ type archiveMeta struct {
 type Namespace struct {
 	*archive.CollectionMetadata `bson:",inline"`
 
-	CRC  int64 `bson:"crc"`
-	Size int64 `bson:"size"`
+	CRC      int64  `bson:"crc"`
+	Size     int64  `bson:"size"`
+	Checksum string `bson:"checksum,omitempty"`
 }
 
 const MetaFile = "metadata.json"
@@ -39,6 +44,9 @@ var terminatorBytes = []byte{0xFF, 0xFF, 0xFF, 0xFF}
 type (
 	NSFilterFn  func(ns string) bool
 	DocFilterFn func(ns string, d bson.Raw) bool
+	// DocTransformFn returns the document to write instead of d.
+	// ns of the time-series collections is "db.system.buckets.coll".
+	DocTransformFn func(ns string, d bson.Raw) (bson.Raw, error)
 )
 
 func DefaultNSFilter(string) bool { return true }
@@ -73,6 +81,9 @@ func Decompose(r io.Reader, newWriter NewWriter, nsFilter NSFilterFn, docFilter
 
 		n.CRC = c.crc[ns]
 		n.Size = c.size[ns]
+		if h := c.sum[ns]; h != nil {
+			n.Checksum = checksum.Sum(h)
+		}
 		nss = append(nss, n)
 	}
 	meta.Namespaces = nss
@@ -81,7 +92,9 @@ func Decompose(r io.Reader, newWriter NewWriter, nsFilter NSFilterFn, docFilter
 	return errors.WithMessage(err, "metadata")
 }
 
-func Compose(w io.Writer, nsFilter NSFilterFn, newReader NewReader) error {
+// Compose writes the archive of the namespaces selected by nsFilter.
+// docTransform (if not nil) is applied to each document.
+func Compose(w io.Writer, nsFilter NSFilterFn, docTransform DocTransformFn, newReader NewReader) error {
 	meta, err := readMetadata(newReader)
 	if err != nil {
 		return errors.WithMessage(err, "metadata")
@@ -102,7 +115,8 @@ func Compose(w io.Writer, nsFilter NSFilterFn, newReader NewReader) error {
 
 	err = writeAllNamespaces(w, newReader,
 		int(meta.Header.ConcurrentCollections),
-		meta.Namespaces)
+		meta.Namespaces,
+		docTransform)
 	return errors.WithMessage(err, "write namespaces")
 }
 
@@ -133,7 +147,7 @@ func writePrelude(w io.Writer, m *archiveMeta) error {
 	return errors.WithMessage(err, "write")
 }
 
-func writeAllNamespaces(w io.Writer, newReader NewReader, lim int, nss []*Namespace) error {
+func writeAllNamespaces(w io.Writer, newReader NewReader, lim int, nss []*Namespace, docTransform DocTransformFn) error {
 	mu := sync.Mutex{}
 	eg := errgroup.Group{}
 	eg.SetLimit(lim)
@@ -155,7 +169,28 @@ func writeAllNamespaces(w io.Writer, newReader NewReader, lim int, nss []*Namesp
 			}
 			defer r.Close()
 
-			err = splitChunks(r, MaxBSONSize*2, func(b []byte) error {
+			var transform func([]byte) ([]byte, error)
+			var crc hash.Hash64
+			if docTransform != nil {
+				tns := nss
+				if ns.Type == "timeseries" {
+					tns = ns.Database + ".system.buckets." + ns.Collection
+				}
+				transform = func(b []byte) ([]byte, error) {
+					return docTransform(tns, b)
+				}
+				// the transformed data doesn't match the CRC of the dump
+				crc = crc64.New(crc64.MakeTable(crc64.ECMA))
+			}
+
+			// The checksum mismatch is reported at the end of the namespace
+			// data. So the documents are already sent to mongorestore by then
+			// and the restore fails with the namespace partially restored.
+			err = splitChunks(checksum.NewReader(r, ns.Checksum), MaxBSONSize*2, transform, func(b []byte) error {
+				if crc != nil {
+					crc.Write(b)
+				}
+
 				mu.Lock()
 				defer mu.Unlock()
 				return errors.WithMessage(writeChunk(w, ns, b), "write chunk")
@@ -163,6 +198,9 @@ func writeAllNamespaces(w io.Writer, newReader NewReader, lim int, nss []*Namesp
 			if err != nil {
 				return errors.WithMessage(err, "split")
 			}
+			if crc != nil {
+				ns.CRC = int64(crc.Sum64())
+			}
 
 			mu.Lock()
 			defer mu.Unlock()
@@ -173,18 +211,28 @@ func writeAllNamespaces(w io.Writer, newReader NewReader, lim int, nss []*Namesp
 	return eg.Wait()
 }
 
-func splitChunks(r io.Reader, size int, write func([]byte) error) error {
+// splitChunks reads the documents and writes them by chunks of the size.
+// transform (if not nil) is applied to each document.
+func splitChunks(r io.Reader, size int, transform func([]byte) ([]byte, error), write func([]byte) error) error {
 	chunk := make([]byte, 0, size)
 	buf, err := ReadBSONBuffer(r, nil)
 	for err == nil {
-		if len(chunk)+len(buf) > size {
+		doc := buf
+		if transform != nil {
+			doc, err = transform(buf)
+			if err != nil {
+				return errors.WithMessage(err, "transform")
+			}
+		}
+
+		if len(chunk)+len(doc) > size {
 			if err := write(chunk); err != nil {
 				return err
 			}
 			chunk = chunk[:0]
 		}
 
-		chunk = append(chunk, buf...)
+		chunk = append(chunk, doc...)
 		buf, err = ReadBSONBuffer(r, buf[:cap(buf)])
 	}
 
@@ -287,6 +335,10 @@ func writeMetadata(meta *archiveMeta, newWriter NewWriter) error {
 	}
 	defer w.Close()
 
+	return encodeMetadata(w, meta)
+}
+
+func encodeMetadata(w io.Writer, meta *archiveMeta) error {
 	data, err := bson.MarshalExtJSONIndent(meta, true, true, "", "\t")
 	if err != nil {
 		return errors.WithMessage(err, "marshal")
@@ -295,6 +347,30 @@ func writeMetadata(meta *archiveMeta, newWriter NewWriter) error {
 	return SecureWrite(w, data)
 }
 
+// MergeMetadata writes the metadata of the several dumps of distinct
+// namespaces as the metadata of the one dump. The header of the first
+// one is kept.
+func MergeMetadata(w io.Writer, metas ...io.Reader) error {
+	var meta *archiveMeta
+	for i, r := range metas {
+		m, err := ReadMetadata(r)
+		if err != nil {
+			return errors.WithMessagef(err, "read %d", i)
+		}
+
+		if meta == nil {
+			meta = m
+			continue
+		}
+		meta.Namespaces = append(meta.Namespaces, m.Namespaces...)
+	}
+	if meta == nil {
+		return errors.New("no metadata")
+	}
+
+	return encodeMetadata(w, meta)
+}
+
 func readMetadata(newReader NewReader) (*archiveMeta, error) {
 	r, err := newReader(MetaFile)
 	if err != nil {
@@ -323,6 +399,7 @@ type consumer struct {
 	nss       map[string]io.WriteCloser
 	crc       map[string]int64
 	size      map[string]int64
+	sum       map[string]hash.Hash
 	curr      string
 }
 
@@ -334,6 +411,7 @@ func newConsumer(newWriter NewWriter, nsFilter NSFilterFn, docFilter DocFilterFn
 		nss:       make(map[string]io.WriteCloser),
 		crc:       make(map[string]int64),
 		size:      make(map[string]int64),
+		sum:       make(map[string]hash.Hash),
 	}
 }
 
@@ -386,9 +464,11 @@ func (c *consumer) BodyBSON(data []byte) error {
 		}
 
 		c.nss[ns] = w
+		c.sum[ns] = checksum.New()
 	}
 
 	c.size[ns] += int64(len(data))
+	c.sum[ns].Write(data)
 	return errors.WithMessagef(SecureWrite(w, data), "%q", ns)
 }
 
@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	plog "github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+const (
+	throttleCheckInterval = time.Second * 5
+	maxThrottleLevel      = 5
+	// throttleBaseDelay is the delay of the dump writes on the first
+	// throttling level. Each next level doubles it.
+	throttleBaseDelay = time.Millisecond * 10
+	// throttleRelease is the share of the thresholds the load has to
+	// drop below to lower the throttling level. So the level doesn't
+	// flap around the thresholds.
+	throttleRelease = 0.8
+)
+
+// nodeLoad is the load of the backup source node
+type nodeLoad struct {
+	replLag    int
+	cacheUsed  float64
+	cacheDirty float64
+}
+
+// adaptiveThrottle paces the logical backup depending on the load of
+// the source node. Each time the load exceeds any of the thresholds the
+// throttling level goes up: fewer collections are read concurrently and
+// the dump writes are delayed longer. When the load drops well below
+// all the thresholds the level goes down.
+type adaptiveThrottle struct {
+	cfg   pbm.AdaptiveThrottleConf
+	conns int
+	level int32
+
+	load   func() (nodeLoad, error)
+	record func(pbm.ThrottleEvent) error
+	l      *plog.Event
+}
+
+func newAdaptiveThrottle(cfg pbm.AdaptiveThrottleConf, node *pbm.Node, record func(pbm.ThrottleEvent) error, l *plog.Event) *adaptiveThrottle {
+	return &adaptiveThrottle{
+		cfg:    cfg,
+		conns:  node.DumpConns(),
+		load:   nodeLoadFn(cfg, node),
+		record: record,
+		l:      l,
+	}
+}
+
+// nodeLoadFn returns func that fetches the load data needed by the cfg checks
+func nodeLoadFn(cfg pbm.AdaptiveThrottleConf, node *pbm.Node) func() (nodeLoad, error) {
+	return func() (nodeLoad, error) {
+		var ld nodeLoad
+		var err error
+
+		if cfg.MaxReplLag > 0 {
+			ld.replLag, err = node.ReplicationLag()
+			if err != nil {
+				return ld, err
+			}
+		}
+		if cfg.MaxCacheUsed > 0 || cfg.MaxCacheDirty > 0 {
+			ld.cacheUsed, ld.cacheDirty, err = node.WTCacheUsage()
+			if err != nil {
+				return ld, err
+			}
+		}
+
+		return ld, nil
+	}
+}
+
+// Pace implements snapshot.Pacer
+func (t *adaptiveThrottle) Pace() (int, time.Duration) {
+	return pace(int(atomic.LoadInt32(&t.level)), t.conns)
+}
+
+// pace returns the number of concurrent collection readers and the
+// writes delay for the throttling level
+func pace(level, conns int) (int, time.Duration) {
+	if level <= 0 {
+		return 0, 0
+	}
+
+	n := conns >> level
+	if n < 1 {
+		n = 1
+	}
+	return n, throttleBaseDelay << (level - 1)
+}
+
+// run checks the node load every throttleCheckInterval until the
+// returned func is called
+func (t *adaptiveThrottle) run() func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		tk := time.NewTicker(throttleCheckInterval)
+		defer tk.Stop()
+
+		for {
+			select {
+			case <-tk.C:
+				t.check()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+func (t *adaptiveThrottle) check() {
+	ld, err := t.load()
+	if err != nil {
+		t.l.Warning("adaptive throttle: get node load: %v", err)
+		return
+	}
+
+	lvl := int(atomic.LoadInt32(&t.level))
+	next, reason := nextThrottleLevel(lvl, t.cfg, ld)
+	if next == lvl {
+		return
+	}
+	atomic.StoreInt32(&t.level, int32(next))
+
+	readers, delay := pace(next, t.conns)
+	t.l.Info("adaptive throttle: level %d -> %d (readers: %d, write delay: %v): %s",
+		lvl, next, readers, delay, reason)
+
+	err = t.record(pbm.ThrottleEvent{
+		Timestamp: time.Now().Unix(),
+		Level:     next,
+		Reason:    reason,
+	})
+	if err != nil {
+		t.l.Warning("adaptive throttle: save event: %v", err)
+	}
+}
+
+// nextThrottleLevel returns the throttling level for the node load and
+// the reason of the change
+func nextThrottleLevel(level int, cfg pbm.AdaptiveThrottleConf, ld nodeLoad) (int, string) {
+	var over []string
+	calm := true
+
+	if cfg.MaxReplLag > 0 {
+		if ld.replLag > cfg.MaxReplLag {
+			over = append(over, fmt.Sprintf("replication lag %ds > %ds", ld.replLag, cfg.MaxReplLag))
+		}
+		if float64(ld.replLag) > float64(cfg.MaxReplLag)*throttleRelease {
+			calm = false
+		}
+	}
+	if cfg.MaxCacheUsed > 0 {
+		if ld.cacheUsed > cfg.MaxCacheUsed {
+			over = append(over, fmt.Sprintf("cache used %.1f%% > %.1f%%", ld.cacheUsed, cfg.MaxCacheUsed))
+		}
+		if ld.cacheUsed > cfg.MaxCacheUsed*throttleRelease {
+			calm = false
+		}
+	}
+	if cfg.MaxCacheDirty > 0 {
+		if ld.cacheDirty > cfg.MaxCacheDirty {
+			over = append(over, fmt.Sprintf("cache dirty %.1f%% > %.1f%%", ld.cacheDirty, cfg.MaxCacheDirty))
+		}
+		if ld.cacheDirty > cfg.MaxCacheDirty*throttleRelease {
+			calm = false
+		}
+	}
+
+	switch {
+	case len(over) != 0 && level < maxThrottleLevel:
+		return level + 1, strings.Join(over, ", ")
+	case calm && level > 0:
+		return level - 1, "node load is back to normal"
+	}
+
+	return level, ""
+}
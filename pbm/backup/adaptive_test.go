@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestNextThrottleLevel(t *testing.T) {
+	cfg := pbm.AdaptiveThrottleConf{MaxReplLag: 10, MaxCacheDirty: 20}
+
+	cases := []struct {
+		name  string
+		level int
+		load  nodeLoad
+		want  int
+	}{
+		{"calm", 0, nodeLoad{replLag: 1, cacheDirty: 5}, 0},
+		{"lag", 0, nodeLoad{replLag: 11}, 1},
+		{"dirty", 2, nodeLoad{cacheDirty: 21}, 3},
+		{"max", maxThrottleLevel, nodeLoad{replLag: 100}, maxThrottleLevel},
+		{"hysteresis", 2, nodeLoad{replLag: 9}, 2},
+		{"release", 2, nodeLoad{replLag: 7, cacheDirty: 15}, 1},
+		{"unchecked", 0, nodeLoad{cacheUsed: 99}, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, reason := nextThrottleLevel(c.level, cfg, c.load)
+			if got != c.want {
+				t.Errorf("level: got %d, want %d", got, c.want)
+			}
+			if (got != c.level) != (reason != "") {
+				t.Errorf("unexpected reason %q for level %d -> %d", reason, c.level, got)
+			}
+		})
+	}
+}
+
+func TestPace(t *testing.T) {
+	cases := []struct {
+		level   int
+		readers int
+		delay   time.Duration
+	}{
+		{0, 0, 0},
+		{1, 4, throttleBaseDelay},
+		{2, 2, throttleBaseDelay * 2},
+		{5, 1, throttleBaseDelay * 16},
+	}
+
+	for _, c := range cases {
+		n, d := pace(c.level, 8)
+		if n != c.readers || d != c.delay {
+			t.Errorf("level %d: got (%d, %v), want (%d, %v)", c.level, n, d, c.readers, c.delay)
+		}
+	}
+}
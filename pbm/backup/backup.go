@@ -13,7 +13,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/checksum"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
 	plog "github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/version"
@@ -32,6 +34,8 @@ type Backup struct {
 	node     *pbm.Node
 	typ      pbm.BackupType
 	incrBase bool
+	// key is used to encrypt the backup data. nil means no encryption.
+	key *crypt.Key
 }
 
 func New(cn *pbm.PBM, node *pbm.Node) *Backup {
@@ -70,6 +74,8 @@ func (b *Backup) Init(bcp *pbm.BackupCmd, opid pbm.OPID, balancer pbm.BalancerMo
 		OPID:           opid.String(),
 		Name:           bcp.Name,
 		Namespaces:     bcp.Namespaces,
+		NSExclude:      bcp.NSExclude,
+		NSQuery:        bcp.NSQuery,
 		Compression:    bcp.Compression,
 		StartTS:        time.Now().Unix(),
 		Status:         pbm.StatusStarting,
@@ -88,7 +94,26 @@ func (b *Backup) Init(bcp *pbm.BackupCmd, opid pbm.OPID, balancer pbm.BalancerMo
 	} else if err != nil {
 		return errors.Wrap(err, "unable to get PBM config settings")
 	}
-	meta.Store = cfg.Storage
+	stgCfg, err := cfg.Profile(bcp.Profile)
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+	meta.Store = pbm.BackupStore{
+		Profile:     bcp.Profile,
+		StorageConf: stgCfg,
+	}
+
+	keys, err := crypt.NewKeyProvider(cfg.Encryption)
+	if err != nil {
+		return errors.Wrap(err, "init encryption")
+	}
+	if keys != nil {
+		key, err := keys.Key()
+		if err != nil {
+			return errors.Wrap(err, "get encryption key")
+		}
+		meta.KeyID = key.ID
+	}
 
 	ver, err := b.node.GetMongoVersion()
 	if err != nil {
@@ -125,14 +150,30 @@ func (b *Backup) Run(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, l *
 		rsMeta.IsConfigSvr = &v
 	}
 
-	stg, err := b.cn.GetStorage(l)
+	bcpm, err := b.cn.GetBackupMeta(bcp.Name)
 	if err != nil {
-		return errors.Wrap(err, "unable to get PBM storage configuration settings")
+		return errors.Wrap(err, "balancer status, get backup meta")
 	}
 
-	bcpm, err := b.cn.GetBackupMeta(bcp.Name)
+	cfg, err := b.cn.GetConfig()
 	if err != nil {
-		return errors.Wrap(err, "balancer status, get backup meta")
+		return errors.Wrap(err, "unable to get PBM config settings")
+	}
+	cfg, err = cfg.WithProfile(bcpm.Store.Profile)
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+	stg, err := pbm.ThrottledStorage(cfg, pbm.ThrottleBackup, bcp.Throttle, l)
+	if err != nil {
+		return errors.Wrap(err, "unable to get PBM storage configuration settings")
+	}
+
+	// all agents should encrypt data with the key chosen on init
+	if bcpm.KeyID != "" {
+		b.key, err = getKey(b.cn, bcpm.KeyID)
+		if err != nil {
+			return errors.Wrap(err, "get encryption key")
+		}
 	}
 
 	// on any error the RS' and the backup' (in case this is the backup leader) meta will be marked appropriately
@@ -221,11 +262,13 @@ func (b *Backup) Run(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, l *
 		}
 	}()
 
+	// the data is encrypted while the metadata stays readable for resync
+	dst := crypt.Wrap(stg, b.key, nil)
 	switch b.typ {
 	case pbm.LogicalBackup:
-		err = b.doLogical(ctx, bcp, opid, &rsMeta, inf, stg, l)
+		err = b.doLogical(ctx, bcp, opid, &rsMeta, inf, dst, l)
 	case pbm.PhysicalBackup, pbm.IncrementalBackup:
-		err = b.doPhysical(ctx, bcp, opid, &rsMeta, inf, stg, l)
+		err = b.doPhysical(ctx, bcp, opid, &rsMeta, inf, dst, l)
 	default:
 		return errors.New("undefined backup type")
 	}
@@ -298,6 +341,18 @@ Loop:
 	return bs.Mode
 }
 
+func getKey(cn *pbm.PBM, id string) (*crypt.Key, error) {
+	keys, err := cn.GetKeyProvider()
+	if err != nil {
+		return nil, errors.Wrap(err, "init encryption")
+	}
+	if keys == nil {
+		return nil, errors.Errorf("the backup is encrypted with key %q but encryption isn't configured", id)
+	}
+
+	return keys.KeyByID(id)
+}
+
 const maxReplicationLagTimeSec = 21
 
 // NodeSuits checks if node can perform backup
@@ -355,18 +410,20 @@ type Canceller interface {
 var ErrCancelled = errors.New("backup canceled")
 
 // Upload writes data to dst from given src and returns an amount of written bytes
-func Upload(ctx context.Context, src Source, dst storage.Storage, compression compress.CompressionType, compressLevel *int, fname string, sizeb int64) (int64, error) {
+// and the checksum of the data (see the checksum package)
+func Upload(ctx context.Context, src Source, dst storage.Storage, compression compress.CompressionType, compressLevel *int, fname string, sizeb int64) (int64, string, error) {
 	r, pw := io.Pipe()
 
 	w, err := compress.Compress(pw, compression, compressLevel)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 
+	h := checksum.New()
 	var rwErr rwErr
 	var n int64
 	go func() {
-		n, rwErr.read = src.WriteTo(w)
+		n, rwErr.read = src.WriteTo(io.MultiWriter(w, h))
 		rwErr.compress = w.Close()
 		pw.Close()
 	}()
@@ -385,19 +442,19 @@ func Upload(ctx context.Context, src Source, dst storage.Storage, compression co
 
 		err := r.Close()
 		if err != nil {
-			return 0, errors.Wrap(err, "cancel backup: close reader")
+			return 0, "", errors.Wrap(err, "cancel backup: close reader")
 		}
-		return 0, ErrCancelled
+		return 0, "", ErrCancelled
 	case <-saveDone:
 	}
 
 	r.Close()
 
 	if !rwErr.nil() {
-		return 0, rwErr
+		return 0, "", rwErr
 	}
 
-	return n, nil
+	return n, checksum.Sum(h), nil
 }
 
 func (b *Backup) reconcileStatus(bcpName, opid string, status pbm.Status, timeout *time.Duration) error {
@@ -0,0 +1,320 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
+	plog "github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/version"
+)
+
+// ExtractFormat is the format of the extracted documents
+type ExtractFormat string
+
+const (
+	// ExtractBSON writes the documents as is. Along with the collection
+	// metadata it makes the mongodump's directory layout.
+	ExtractBSON ExtractFormat = "bson"
+	// ExtractJSONL writes a document per line in relaxed Extended JSON
+	ExtractJSONL ExtractFormat = "jsonl"
+)
+
+// ExtractedNS describes the extracted collection
+type ExtractedNS struct {
+	NS   string `json:"ns"`
+	File string `json:"file"`
+	Docs int64  `json:"docs"`
+	Size int64  `json:"size"`
+}
+
+// Extract writes the documents of the logical backup namespaces selected
+// by match into the files of the dir: <dir>/<db>/<collection>.<format>
+// (collection names are escaped the way mongodump does).
+// The data of all replsets (shards) is merged. It reads the backup from
+// the storage only, the cluster isn't involved.
+func Extract(
+	ctx context.Context,
+	cfg pbm.Config,
+	bcp *pbm.BackupMeta,
+	match archive.NSFilterFn,
+	dir string,
+	format ExtractFormat,
+	l *plog.Event,
+) ([]ExtractedNS, error) {
+	if bcp.Status != pbm.StatusDone {
+		return nil, errors.Errorf("backup isn't finished: status: %s", bcp.Status)
+	}
+	if bcp.Type != pbm.LogicalBackup {
+		return nil, errors.Errorf("%s backup can't be extracted, logical only", bcp.Type)
+	}
+	switch format {
+	case ExtractBSON, ExtractJSONL:
+	default:
+		return nil, errors.Errorf("unknown format %q", format)
+	}
+
+	keys, err := crypt.NewKeyProvider(cfg.Encryption)
+	if err != nil {
+		return nil, errors.Wrap(err, "init encryption")
+	}
+	if bcp.KeyID != "" {
+		if keys == nil {
+			return nil, errors.Errorf("backup is encrypted with key %q but encryption isn't configured", bcp.KeyID)
+		}
+		_, err = keys.KeyByID(bcp.KeyID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get encryption key %q", bcp.KeyID)
+		}
+	}
+
+	cfg, err = cfg.WithProfile(bcp.Store.Profile)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup storage")
+	}
+
+	// a new storage for each concurrent reader, see Restore.RunSnapshot
+	newStorage := func() (storage.Storage, error) {
+		stg, err := pbm.Storage(cfg, l)
+		if err != nil {
+			return nil, errors.Wrap(err, "get storage")
+		}
+		return crypt.WrapRead(stg, keys, bcp.KeyID), nil
+	}
+
+	x := &extractor{
+		dir:    dir,
+		format: format,
+		nss:    make(map[string]*ExtractedNS),
+	}
+	for i := range bcp.Replsets {
+		rs := &bcp.Replsets[i]
+		l.Info("extract replset %s", rs.Name)
+
+		err = x.extractReplset(ctx, newStorage, bcp, rs, match)
+		if err != nil {
+			return nil, errors.Wrapf(err, "replset %s", rs.Name)
+		}
+	}
+
+	rv := make([]ExtractedNS, 0, len(x.nss))
+	for _, n := range x.nss {
+		rv = append(rv, *n)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].NS < rv[j].NS })
+
+	return rv, nil
+}
+
+type extractor struct {
+	dir    string
+	format ExtractFormat
+	// nss are the extracted namespaces. The files of the namespaces
+	// extracted from the previous replsets are appended.
+	nss map[string]*ExtractedNS
+	// colls are the file names of the current replset namespaces
+	colls map[string]string
+}
+
+func (x *extractor) extractReplset(
+	ctx context.Context,
+	newStorage func() (storage.Storage, error),
+	bcp *pbm.BackupMeta,
+	rs *pbm.BackupReplset,
+	match archive.NSFilterFn,
+) error {
+	stg, err := newStorage()
+	if err != nil {
+		return err
+	}
+
+	x.colls = make(map[string]string)
+	var rdr io.ReadCloser
+	if version.IsLegacyArchive(bcp.PBMVersion) {
+		sr, err := stg.SourceReader(rs.DumpName)
+		if err != nil {
+			return errors.Wrapf(err, "get object %s", rs.DumpName)
+		}
+		defer sr.Close()
+
+		rdr, err = compress.Decompress(sr, bcp.Compression)
+		if err != nil {
+			return errors.Wrapf(err, "decompress object %s", rs.DumpName)
+		}
+	} else {
+		err = x.readMetadata(stg, path.Join(bcp.Name, rs.Name, archive.MetaFile), match)
+		if err != nil {
+			return err
+		}
+
+		rdr, err = snapshot.DownloadDump(
+			func(ns string) (io.ReadCloser, error) {
+				stg, err := newStorage()
+				if err != nil {
+					return nil, err
+				}
+				return stg.SourceReader(path.Join(bcp.Name, rs.Name, ns))
+			},
+			bcp.Compression,
+			match,
+			nil,
+			nil)
+		if err != nil {
+			return errors.Wrap(err, "download snapshot")
+		}
+	}
+	defer rdr.Close()
+
+	err = archive.Decompose(rdr,
+		func(ns string) (io.WriteCloser, error) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if ns == archive.MetaFile {
+				return nopWriteCloser{io.Discard}, nil
+			}
+			return x.open(ns)
+		}, match, nil)
+	return errors.Wrap(err, "snapshot")
+}
+
+// readMetadata reads the dump metadata of the replset. The collection
+// metadata is saved along with the documents in the bson format.
+func (x *extractor) readMetadata(stg storage.Storage, name string, match archive.NSFilterFn) error {
+	r, err := stg.SourceReader(name)
+	if err != nil {
+		return errors.Wrapf(err, "get object %s", name)
+	}
+	defer r.Close()
+
+	meta, err := archive.ReadMetadata(r)
+	if err != nil {
+		return errors.Wrapf(err, "read metadata %s", name)
+	}
+
+	for _, n := range meta.Namespaces {
+		ns := archive.NSify(n.Database, n.Collection)
+		if !match(ns) {
+			continue
+		}
+
+		coll := n.Collection
+		if n.Type == "timeseries" {
+			coll = "system.buckets." + coll
+		}
+		x.colls[ns] = coll
+
+		if x.format != ExtractBSON || n.Metadata == "" {
+			continue
+		}
+		file, err := x.path(n.Database, n.Collection, ".metadata.json")
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(file), 0o755)
+		if err != nil {
+			return errors.Wrapf(err, "create dir for %s", ns)
+		}
+		err = os.WriteFile(file, []byte(n.Metadata), 0o644)
+		if err != nil {
+			return errors.Wrapf(err, "write metadata of %s", ns)
+		}
+	}
+
+	return nil
+}
+
+// open opens the file of the ns documents. The file is truncated
+// unless it was written by the previous replsets.
+func (x *extractor) open(ns string) (io.WriteCloser, error) {
+	db, coll, _ := strings.Cut(ns, ".")
+	if c, ok := x.colls[ns]; ok {
+		coll = c
+	}
+
+	n := x.nss[ns]
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if n == nil {
+		file, err := x.path(db, coll, "."+string(x.format))
+		if err != nil {
+			return nil, err
+		}
+		n = &ExtractedNS{
+			NS:   ns,
+			File: file,
+		}
+		x.nss[ns] = n
+		flag |= os.O_TRUNC
+	}
+
+	err := os.MkdirAll(filepath.Dir(n.File), 0o755)
+	if err != nil {
+		return nil, errors.Wrap(err, "create dir")
+	}
+	f, err := os.OpenFile(n.File, flag, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open file")
+	}
+
+	return &docWriter{f: f, ns: n, jsonl: x.format == ExtractJSONL}, nil
+}
+
+// path returns the path of the collection file with the ext. Collection
+// names are escaped the way mongodump does ("/" is percent-encoded). The
+// path out of the dir is an error.
+func (x *extractor) path(db, coll, ext string) (string, error) {
+	p := filepath.Join(x.dir, db, util.EscapeCollectionName(coll)+ext)
+
+	rel, err := filepath.Rel(filepath.Clean(x.dir), p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("namespace %s.%s is out of the dir", db, coll)
+	}
+
+	return p, nil
+}
+
+// docWriter writes the documents into the file.
+// archive.Decompose writes documents one by one.
+type docWriter struct {
+	f     *os.File
+	ns    *ExtractedNS
+	jsonl bool
+}
+
+func (w *docWriter) Write(p []byte) (int, error) {
+	w.ns.Docs++
+	w.ns.Size += int64(len(p))
+
+	if !w.jsonl {
+		return w.f.Write(p)
+	}
+
+	b, err := bson.MarshalExtJSON(bson.Raw(p), false, false)
+	if err != nil {
+		return 0, errors.Wrapf(err, "%s: document #%d", w.ns.NS, w.ns.Docs)
+	}
+	_, err = w.f.Write(append(b, '\n'))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *docWriter) Close() error {
+	return w.f.Close()
+}
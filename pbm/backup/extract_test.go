@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+const testCollMeta = `{"indexes":[{"v":2,"key":{"_id":1},"name":"_id_"}],"collectionName":"users","type":"collection"}`
+
+// writeTestDump writes the dump files of the replset: docs
+// are the documents of the "app" db collections
+func writeTestDump(t *testing.T, dir, bcp, rs string, docs map[string][]bson.D) {
+	t.Helper()
+
+	rsDir := filepath.Join(dir, bcp, rs)
+	if err := os.MkdirAll(rsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	nss := bson.A{}
+	for coll, dd := range docs {
+		data := []byte{}
+		for _, d := range dd {
+			b, err := bson.Marshal(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(data, b...)
+		}
+		if err := os.WriteFile(filepath.Join(rsDir, "app."+coll), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		nss = append(nss, bson.D{
+			{"db", "app"},
+			{"collection", coll},
+			{"metadata", testCollMeta},
+			{"type", "collection"},
+			{"crc", int64(0)},
+			{"size", int64(len(data))},
+		})
+	}
+
+	meta, err := bson.MarshalExtJSON(bson.D{
+		{"concurrent_collections", int32(1)},
+		{"version", "0.1"},
+		{"server_version", "6.0.0"},
+		{"tool_version", "100.7.0"},
+		{"namespaces", nss},
+	}, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rsDir, "metadata.json"), meta, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	stgDir := t.TempDir()
+	writeTestDump(t, stgDir, "bcp", "rs0", map[string][]bson.D{
+		"users": {{{"_id", 1}, {"name", "a"}}, {{"_id", 2}, {"name", "b"}}},
+		"logs":  {{{"_id", 1}}},
+	})
+	writeTestDump(t, stgDir, "bcp", "rs1", map[string][]bson.D{
+		"users": {{{"_id", 3}, {"name", "c"}}},
+	})
+
+	cfg := pbm.Config{
+		Storage: pbm.StorageConf{
+			Type:       storage.Filesystem,
+			Filesystem: fs.Conf{Path: stgDir},
+		},
+	}
+	bcp := &pbm.BackupMeta{
+		Name:        "bcp",
+		Type:        pbm.LogicalBackup,
+		Status:      pbm.StatusDone,
+		Compression: compress.CompressionTypeNone,
+		PBMVersion:  "2.0.4",
+		Replsets:    []pbm.BackupReplset{{Name: "rs0"}, {Name: "rs1"}},
+	}
+	l := log.New(nil, "", "").NewEvent("", "", "", primitive.Timestamp{})
+	match := sel.MakeSelectedPred([]string{"app.users"})
+
+	out := t.TempDir()
+	got, err := Extract(context.Background(), cfg, bcp, match, out, ExtractBSON, l)
+	if err != nil {
+		t.Fatalf("extract bson: %v", err)
+	}
+	file := filepath.Join(out, "app", "users.bson")
+	if len(got) != 1 || got[0].NS != "app.users" || got[0].Docs != 3 || got[0].File != file {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int32
+	r := bytes.NewReader(data)
+	for {
+		doc, err := archive.ReadBSONBuffer(r, nil)
+		if err != nil {
+			break
+		}
+		ids = append(ids, bson.Raw(doc).Lookup("_id").Int32())
+	}
+	if !reflect.DeepEqual(ids, []int32{1, 2, 3}) {
+		t.Errorf("unexpected documents: %v", ids)
+	}
+	meta, err := os.ReadFile(filepath.Join(out, "app", "users.metadata.json"))
+	if err != nil || string(meta) != testCollMeta {
+		t.Errorf("unexpected collection metadata %q: %v", meta, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "app", "logs.bson")); !os.IsNotExist(err) {
+		t.Errorf("not selected collection is extracted: %v", err)
+	}
+
+	got, err = Extract(context.Background(), cfg, bcp, match, out, ExtractJSONL, l)
+	if err != nil {
+		t.Fatalf("extract jsonl: %v", err)
+	}
+	if len(got) != 1 || got[0].Docs != 3 {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+
+	f, err := os.Open(filepath.Join(out, "app", "users.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines [][]byte
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, append([]byte{}, s.Bytes()...))
+	}
+	want := [][]byte{
+		[]byte(`{"_id":1,"name":"a"}`),
+		[]byte(`{"_id":2,"name":"b"}`),
+		[]byte(`{"_id":3,"name":"c"}`),
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("unexpected lines:\n%s", bytes.Join(lines, []byte("\n")))
+	}
+
+	bcp.Type = pbm.PhysicalBackup
+	if _, err := Extract(context.Background(), cfg, bcp, match, out, ExtractBSON, l); err == nil {
+		t.Error("expected error for physical backup")
+	}
+}
+
+func TestExtractPath(t *testing.T) {
+	dir := t.TempDir()
+	x := &extractor{dir: dir, format: ExtractBSON, nss: make(map[string]*ExtractedNS)}
+
+	w, err := x.open("app.a/../../../../home/u/.bashrc")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	w.Close()
+
+	want := filepath.Join(dir, "app", "a%2F..%2F..%2F..%2F..%2Fhome%2Fu%2F.bashrc.bson")
+	if got := x.nss["app.a/../../../../home/u/.bashrc"].File; got != want {
+		t.Errorf("expected file %s, got %s", want, got)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("file is not created: %v", err)
+	}
+
+	if _, err := x.path("..", "users", ".bson"); err == nil {
+		t.Error("expected error for the path out of the dir")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm"
 	"github.com/percona/percona-backup-mongodb/pbm/archive"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
 	plog "github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/sel"
@@ -24,23 +26,26 @@ import (
 
 func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, rsMeta *pbm.BackupReplset, inf *pbm.NodeInfo, stg storage.Storage, l *plog.Event) error {
 	var db, coll string
+	nsFilter := archive.DefaultNSFilter
+	docFilter := archive.DefaultDocFilter
 	if sel.IsSelective(bcp.Namespaces) {
 		// for selective backup, configsvr does not hold any data.
 		// only some collections from config db is required to restore cluster state
 		if inf.IsConfigSrv() {
 			db = "config"
 		} else {
-			db, coll = parseNS(bcp.Namespaces[0])
+			db, coll = dumpScope(bcp.Namespaces)
+			nsFilter = sel.MakeSelectedPred(bcp.Namespaces)
 		}
 	}
-
-	nssSize, err := getNamespacesSize(ctx, b.node.Session(), db, coll)
-	if err != nil {
-		return errors.WithMessage(err, "get namespaces size")
-	}
-	if bcp.Compression == compress.CompressionTypeNone {
-		for n := range nssSize {
-			nssSize[n] *= 4
+	if len(bcp.NSExclude) != 0 {
+		notExcluded, err := sel.MakeNSExcludePred(bcp.NSExclude)
+		if err != nil {
+			return errors.WithMessage(err, "namespaces exclusion")
+		}
+		selected := nsFilter
+		nsFilter = func(ns string) bool {
+			return selected(ns) && notExcluded(ns)
 		}
 	}
 
@@ -107,13 +112,41 @@ func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPI
 		}
 	}
 
-	var dump io.WriterTo
-	if len(nssSize) == 0 {
-		dump = snapshot.DummyBackup{}
-	} else {
-		dump, err = snapshot.NewBackup(b.node.ConnURI(), b.node.DumpConns(), db, coll)
+	// the namespaces (and databases to dump) are listed after the cluster
+	// first write. So the ones created in between are covered by the oplog.
+	nssSize, nssDataSize, err := getNamespacesSize(ctx, b.node.Session(), db, coll)
+	if err != nil {
+		return errors.WithMessage(err, "get namespaces size")
+	}
+
+	nss := make([]string, 0, len(nssSize))
+	for ns := range nssSize {
+		nss = append(nss, ns)
+		if !nsFilter(ns) {
+			delete(nssSize, ns)
+			delete(nssDataSize, ns)
+		}
+	}
+	sort.Strings(nss)
+	// the documents of the queried collections are dumped separately
+	queries := make(map[string]string)
+	for _, q := range bcp.NSQuery {
+		if _, ok := nssSize[q.NS]; ok {
+			queries[q.NS] = q.Query
+		}
+	}
+
+	var dbs []string
+	if db == "" {
+		dbs, err = dumpDBs(ctx, b.node.Session(), bcp.Namespaces)
 		if err != nil {
-			return errors.Wrap(err, "init mongodump options")
+			return errors.WithMessage(err, "list databases")
+		}
+	}
+	parts := dumpParts(db, coll, dbs, nss, nsFilter, queries, !sel.IsSelective(bcp.Namespaces))
+	if bcp.Compression == compress.CompressionTypeNone {
+		for n := range nssSize {
+			nssSize[n] *= 4
 		}
 	}
 
@@ -121,9 +154,37 @@ func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPI
 	if err != nil {
 		return errors.WithMessage(err, "get config")
 	}
+	cfg, err = cfg.WithProfile(bcp.Profile)
+	if err != nil {
+		return errors.WithMessage(err, "get backup storage")
+	}
+
+	var dumps []io.WriterTo
+	stopThrottle := func() {}
+	if len(nssSize) == 0 {
+		dumps = []io.WriterTo{snapshot.DummyBackup{}}
+	} else {
+		var pacer snapshot.Pacer
+		if cfg.Backup.AdaptiveThrottle.Enabled() {
+			at := newAdaptiveThrottle(*cfg.Backup.AdaptiveThrottle, b.node,
+				func(e pbm.ThrottleEvent) error {
+					return b.cn.AddRSThrottleEvent(bcp.Name, rsMeta.Name, e)
+				}, l)
+			stopThrottle = at.run()
+			defer stopThrottle()
+			pacer = at
+		}
+
+		for _, o := range parts {
+			dump, err := snapshot.NewBackup(b.node.ConnURI(), b.node.DumpConns(), o, pacer)
+			if err != nil {
+				return errors.Wrapf(err, "init mongodump options for %q.%q", o.DB, o.Collection)
+			}
+
+			dumps = append(dumps, dump)
+		}
+	}
 
-	nsFilter := archive.DefaultNSFilter
-	docFilter := archive.DefaultDocFilter
 	if inf.IsConfigSrv() && sel.IsSelective(bcp.Namespaces) {
 		chunkSelector, err := createBackupChunkSelector(ctx, b.cn.Conn, bcp.Namespaces)
 		if err != nil {
@@ -134,25 +195,36 @@ func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPI
 		docFilter = makeConfigsvrDocFilter(bcp.Namespaces, chunkSelector)
 	}
 
-	snapshotSize, err := snapshot.UploadDump(dump,
+	prg := newProgress()
+	prg.collsTotal = len(nssDataSize)
+	for _, n := range nssDataSize {
+		prg.bytesTotal += n
+	}
+	stopProgress := b.reportProgress(bcp.Name, rsMeta.Name, prg, l)
+	defer stopProgress()
+
+	snapshotSize, err := snapshot.UploadDumpParts(dumps,
 		func(ns, ext string, r io.Reader) error {
-			stg, err := pbm.Storage(cfg, l)
+			stg, err := pbm.ThrottledStorage(cfg, pbm.ThrottleBackup, bcp.Throttle, l)
 			if err != nil {
 				return errors.WithMessage(err, "get storage")
 			}
 
 			filepath := path.Join(bcp.Name, rsMeta.Name, ns+ext)
-			return stg.Save(filepath, r, nssSize[ns])
+			return crypt.Wrap(stg, b.key, nil).Save(filepath, r, nssSize[ns])
 		},
 		snapshot.UploadDumpOptions{
 			Compression:      bcp.Compression,
 			CompressionLevel: bcp.CompressionLevel,
 			NSFilter:         nsFilter,
 			DocFilter:        docFilter,
+			Progress:         prg,
 		})
 	if err != nil {
 		return errors.Wrap(err, "mongodump")
 	}
+	stopThrottle()
+	stopProgress()
 	l.Info("mongodump finished, waiting for the oplog")
 
 	err = b.cn.ChangeRSState(bcp.Name, rsMeta.Name, pbm.StatusDumpDone, "")
@@ -195,11 +267,16 @@ func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPI
 	l.Debug("set oplog span to %v / %v", fwTS, lwTS)
 	oplog.SetTailingSpan(fwTS, lwTS)
 	// size -1 - we're assuming oplog never exceed 97Gb (see comments in s3.Save method)
-	oplogSize, err := Upload(ctx, oplog, stg, bcp.Compression, bcp.CompressionLevel, rsMeta.OplogName, -1)
+	oplogSize, oplogSum, err := Upload(ctx, oplog, stg, bcp.Compression, bcp.CompressionLevel, rsMeta.OplogName, -1)
 	if err != nil {
 		return errors.Wrap(err, "oplog")
 	}
 
+	err = b.cn.SetRSOplogChecksum(bcp.Name, rsMeta.Name, oplogSum)
+	if err != nil {
+		return errors.Wrap(err, "set oplog checksum")
+	}
+
 	err = b.cn.IncBackupSize(ctx, bcp.Name, snapshotSize+oplogSize)
 	if err != nil {
 		return errors.Wrap(err, "inc backup size")
@@ -278,8 +355,11 @@ func makeConfigsvrDocFilter(nss []string, selector sel.ChunkSelector) archive.Do
 	}
 }
 
-func getNamespacesSize(ctx context.Context, m *mongo.Client, db, coll string) (map[string]int64, error) {
+// getNamespacesSize returns the storage size and the (uncompressed)
+// data size of each namespace
+func getNamespacesSize(ctx context.Context, m *mongo.Client, db, coll string) (map[string]int64, map[string]int64, error) {
 	rv := make(map[string]int64)
+	data := make(map[string]int64)
 
 	q := bson.D{}
 	if db != "" {
@@ -287,10 +367,10 @@ func getNamespacesSize(ctx context.Context, m *mongo.Client, db, coll string) (m
 	}
 	dbs, err := m.ListDatabaseNames(ctx, q)
 	if err != nil {
-		return nil, errors.WithMessage(err, "list databases")
+		return nil, nil, errors.WithMessage(err, "list databases")
 	}
 	if len(dbs) == 0 {
-		return rv, nil
+		return rv, data, nil
 	}
 
 	mu := sync.Mutex{}
@@ -330,6 +410,7 @@ func getNamespacesSize(ctx context.Context, m *mongo.Client, db, coll string) (m
 
 					var doc struct {
 						StorageSize int64 `bson:"storageSize"`
+						Size        int64 `bson:"size"`
 					}
 
 					if err := res.Decode(&doc); err != nil {
@@ -338,6 +419,7 @@ func getNamespacesSize(ctx context.Context, m *mongo.Client, db, coll string) (m
 
 					mu.Lock()
 					rv[ns] = doc.StorageSize
+					data[ns] = doc.Size
 					mu.Unlock()
 
 					return nil
@@ -349,7 +431,107 @@ func getNamespacesSize(ctx context.Context, m *mongo.Client, db, coll string) (m
 	}
 
 	err = eg.Wait()
-	return rv, err
+	return rv, data, err
+}
+
+// dumpDBs returns the databases to dump one by one: all but local
+// (mongodump skips it too) or the selected ones
+func dumpDBs(ctx context.Context, m *mongo.Client, selected []string) ([]string, error) {
+	dbs, err := m.ListDatabaseNames(ctx, bson.D{{"name", bson.D{{"$ne", "local"}}}})
+	if err != nil {
+		return nil, err
+	}
+	if !sel.IsSelective(selected) {
+		return dbs, nil
+	}
+
+	seldbs := make(map[string]bool)
+	for _, ns := range selected {
+		d, _ := parseNS(ns)
+		seldbs[d] = true
+	}
+	rv := []string{}
+	for _, d := range dbs {
+		if seldbs[d] {
+			rv = append(rv, d)
+		}
+	}
+
+	return rv, nil
+}
+
+// dumpParts returns the mongodump runs of the backup. mongodump can dump
+// the whole instance, a db or a collection only, and skip collections of
+// the db. So the whole instance (or several dbs) is dumped db by db, in
+// order not to read the collections out of the nsFilter at all. Queried
+// collections are dumped separately with the query. nss are all the
+// namespaces in the scope of db and coll. dbs are the databases to dump if
+// db is empty. The users and roles are dumped with the admin db if
+// usersAndRoles is set.
+func dumpParts(
+	db, coll string,
+	dbs, nss []string,
+	nsFilter archive.NSFilterFn,
+	queries map[string]string,
+	usersAndRoles bool,
+) []snapshot.DumpOptions {
+	if db != "" {
+		dbs = []string{db}
+	}
+
+	rv := []snapshot.DumpOptions{}
+	for _, d := range dbs {
+		o := snapshot.DumpOptions{DB: d, Collection: coll, UsersAndRoles: usersAndRoles}
+		had, left := 0, 0
+		for _, ns := range nss {
+			nd, c, _ := strings.Cut(ns, ".")
+			if nd != d {
+				continue
+			}
+
+			had++
+			if _, ok := queries[ns]; ok || !nsFilter(ns) {
+				if coll == "" {
+					o.ExcludeColls = append(o.ExcludeColls, c)
+				}
+				continue
+			}
+			left++
+		}
+		// everything of the db is either dumped with the query or skipped
+		if had != 0 && left == 0 {
+			continue
+		}
+
+		rv = append(rv, o)
+	}
+
+	qnss := make([]string, 0, len(queries))
+	for ns := range queries {
+		qnss = append(qnss, ns)
+	}
+	sort.Strings(qnss)
+	for _, ns := range qnss {
+		d, c, _ := strings.Cut(ns, ".")
+		rv = append(rv, snapshot.DumpOptions{DB: d, Collection: c, Query: queries[ns]})
+	}
+
+	return rv
+}
+
+// dumpScope returns the narrowest db and collection for mongodump
+// covering all nss. Empty db means several dbs or the whole instance.
+func dumpScope(nss []string) (string, string) {
+	db, coll := parseNS(nss[0])
+	for _, ns := range nss[1:] {
+		d, _ := parseNS(ns)
+		if d != db {
+			return "", ""
+		}
+		coll = ""
+	}
+
+	return db, coll
 }
 
 func parseNS(ns string) (string, string) {
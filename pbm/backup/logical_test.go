@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+)
+
+func TestDumpParts(t *testing.T) {
+	dbs := []string{"admin", "app", "config", "shop"}
+	nss := []string{
+		"admin.pbmRUsers",
+		"admin.system.version",
+		"app.logs",
+		"app.users",
+		"config.settings",
+		"shop.orders",
+	}
+	queries := map[string]string{"shop.orders": `{"status":"open"}`}
+	notExcluded, err := sel.MakeNSExcludePred([]string{"app.logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name          string
+		db, coll      string
+		dbs           []string
+		nss           []string
+		queries       map[string]string
+		filter        archive.NSFilterFn
+		usersAndRoles bool
+		want          []snapshot.DumpOptions
+	}{
+		{
+			name:          "whole instance",
+			dbs:           dbs,
+			nss:           nss,
+			queries:       queries,
+			filter:        notExcluded,
+			usersAndRoles: true,
+			want: []snapshot.DumpOptions{
+				{DB: "admin", UsersAndRoles: true},
+				{DB: "app", ExcludeColls: []string{"logs"}, UsersAndRoles: true},
+				{DB: "config", UsersAndRoles: true},
+				{DB: "shop", Collection: "orders", Query: `{"status":"open"}`},
+			},
+		},
+		{
+			name:    "several dbs",
+			dbs:     []string{"app", "shop"},
+			nss:     nss,
+			queries: queries,
+			filter:  sel.MakeSelectedPred([]string{"app.users", "shop.*"}),
+			want: []snapshot.DumpOptions{
+				{DB: "app", ExcludeColls: []string{"logs"}},
+				{DB: "shop", Collection: "orders", Query: `{"status":"open"}`},
+			},
+		},
+		{
+			name:    "db",
+			db:      "app",
+			nss:     []string{"app.logs", "app.users", "app.visits"},
+			queries: map[string]string{"app.users": `{"age":1}`},
+			filter:  notExcluded,
+			want: []snapshot.DumpOptions{
+				{DB: "app", ExcludeColls: []string{"logs", "users"}},
+				{DB: "app", Collection: "users", Query: `{"age":1}`},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		got := dumpParts(c.db, c.coll, c.dbs, c.nss, c.filter, c.queries, c.usersAndRoles)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.want, got)
+		}
+
+		// neither the excluded namespace is dumped
+		// nor the queried one is dumped in full
+		for _, o := range got {
+			if o.DB == "" {
+				t.Errorf("%s: whole instance is dumped: %+v", c.name, o)
+			}
+			if o.DB == "app" && (o.Collection == "logs" || o.Collection == "" && !contains(o.ExcludeColls, "logs")) {
+				t.Errorf("%s: excluded app.logs is dumped: %+v", c.name, o)
+			}
+			for ns := range c.queries {
+				d, coll, _ := strings.Cut(ns, ".")
+				if o.DB == d && o.Query == "" &&
+					(o.Collection == coll || o.Collection == "" && !contains(o.ExcludeColls, coll)) {
+					t.Errorf("%s: queried %s is dumped in full: %+v", c.name, ns, o)
+				}
+			}
+		}
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
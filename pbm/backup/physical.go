@@ -173,6 +173,10 @@ func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OP
 			if src == nil {
 				return errors.Wrap(err, "nil source backup")
 			}
+			if src.Store.Profile != bcp.Profile {
+				return errors.Errorf("source backup %s is stored in the %q profile while this one goes to %q. "+
+					"Make a new base backup for the profile", src.Name, src.Store.Profile, bcp.Profile)
+			}
 
 			// ? should be done during Init()?
 			if inf.IsLeader() {
@@ -285,9 +289,18 @@ func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OP
 		data = append(data, *stgb)
 	}
 
+	prg := newProgress()
+	dn, dsize := uploadSize(data, b.typ == pbm.IncrementalBackup)
+	jn, jsize := uploadSize(jrnls, false)
+	prg.filesTotal = dn + jn
+	prg.bytesTotal = dsize + jsize
+	stopProgress := b.reportProgress(bcp.Name, rsMeta.Name, prg, l)
+	defer stopProgress()
+	mstg := storage.NewMetered(stg, prg.uploaded, func(float64) {})
+
 	l.Info("uploading data")
 	rsMeta.Files, err = uploadFiles(ctx, data, bcp.Name+"/"+rsMeta.Name, bcur.Meta.DBpath,
-		b.typ == pbm.IncrementalBackup, stg, bcp.Compression, bcp.CompressionLevel, l)
+		b.typ == pbm.IncrementalBackup, mstg, bcp.Compression, bcp.CompressionLevel, prg, l)
 	if err != nil {
 		return err
 	}
@@ -295,11 +308,12 @@ func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OP
 
 	l.Info("uploading journals")
 	ju, err := uploadFiles(ctx, jrnls, bcp.Name+"/"+rsMeta.Name, bcur.Meta.DBpath,
-		false, stg, bcp.Compression, bcp.CompressionLevel, l)
+		false, mstg, bcp.Compression, bcp.CompressionLevel, prg, l)
 	if err != nil {
 		return err
 	}
 	l.Info("uploading journals done")
+	stopProgress()
 	rsMeta.Files = append(rsMeta.Files, ju...)
 
 	err = b.cn.RSSetPhyFiles(bcp.Name, rsMeta.Name, rsMeta)
@@ -376,7 +390,7 @@ func (id *UUID) IsZero() bool {
 // unchanged files (Len == 0) but add them to the meta as we need know
 // what files shouldn't be restored (those which isn't in the target backup).
 func uploadFiles(ctx context.Context, files []pbm.File, subdir, trimPrefix string, incr bool,
-	stg storage.Storage, comprT compress.CompressionType, comprL *int, l *plog.Event) (data []pbm.File, err error) {
+	stg storage.Storage, comprT compress.CompressionType, comprL *int, prg *progress, l *plog.Event) (data []pbm.File, err error) {
 	if len(files) == 0 {
 		return data, err
 	}
@@ -416,7 +430,7 @@ func uploadFiles(ctx context.Context, files []pbm.File, subdir, trimPrefix strin
 			continue
 		}
 
-		fw, err := writeFile(ctx, wfile, path.Join(subdir, trim(wfile.Name)), stg, comprT, comprL, l)
+		fw, err := writeFile(ctx, wfile, path.Join(subdir, trim(wfile.Name)), stg, comprT, comprL, prg, l)
 		if err != nil {
 			return data, errors.Wrapf(err, "upload file `%s`", wfile.Name)
 		}
@@ -431,7 +445,7 @@ func uploadFiles(ctx context.Context, files []pbm.File, subdir, trimPrefix strin
 		return data, nil
 	}
 
-	f, err := writeFile(ctx, wfile, path.Join(subdir, trim(wfile.Name)), stg, comprT, comprL, l)
+	f, err := writeFile(ctx, wfile, path.Join(subdir, trim(wfile.Name)), stg, comprT, comprL, prg, l)
 	if err != nil {
 		return data, errors.Wrapf(err, "upload file `%s`", wfile.Name)
 	}
@@ -442,7 +456,7 @@ func uploadFiles(ctx context.Context, files []pbm.File, subdir, trimPrefix strin
 	return data, nil
 }
 
-func writeFile(ctx context.Context, src pbm.File, dst string, stg storage.Storage, compression compress.CompressionType, compressLevel *int, l *plog.Event) (*pbm.File, error) {
+func writeFile(ctx context.Context, src pbm.File, dst string, stg storage.Storage, compression compress.CompressionType, compressLevel *int, prg *progress, l *plog.Event) (*pbm.File, error) {
 	fstat, err := os.Stat(src.Name)
 	if err != nil {
 		return nil, errors.Wrap(err, "get file stat")
@@ -461,10 +475,11 @@ func writeFile(ctx context.Context, src pbm.File, dst string, stg storage.Storag
 	}
 	l.Debug("uploading: %s %s", src, fmtSize(sz))
 
-	_, err = Upload(ctx, &src, stg, compression, compressLevel, dst, sz)
+	_, sum, err := Upload(ctx, readCountSource{&src, prg.Read}, stg, compression, compressLevel, dst, sz)
 	if err != nil {
 		return nil, errors.Wrap(err, "upload file")
 	}
+	prg.fileDone()
 
 	finf, err := stg.FileStat(dst)
 	if err != nil {
@@ -472,12 +487,13 @@ func writeFile(ctx context.Context, src pbm.File, dst string, stg storage.Storag
 	}
 
 	return &pbm.File{
-		Name:    src.Name,
-		Size:    fstat.Size(),
-		Fmode:   fstat.Mode(),
-		StgSize: finf.Size,
-		Off:     src.Off,
-		Len:     src.Len,
+		Name:     src.Name,
+		Size:     fstat.Size(),
+		Fmode:    fstat.Mode(),
+		StgSize:  finf.Size,
+		Off:      src.Off,
+		Len:      src.Len,
+		Checksum: sum,
 	}, nil
 }
 
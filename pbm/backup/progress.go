@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	plog "github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+const progressReportInterval = time.Second * 10
+
+// progress tracks the copying of the replset data.
+// Counters are updated concurrently.
+type progress struct {
+	bytesRead     int64
+	bytesUploaded int64
+	collsDone     int64
+	filesDone     int64
+
+	bytesTotal int64
+	collsTotal int
+	filesTotal int
+	start      int64
+}
+
+func newProgress() *progress {
+	return &progress{start: time.Now().Unix()}
+}
+
+func (p *progress) Read(n int64) {
+	atomic.AddInt64(&p.bytesRead, n)
+}
+
+func (p *progress) Uploaded(n int64) {
+	atomic.AddInt64(&p.bytesUploaded, n)
+}
+
+func (p *progress) NSDone(string) {
+	atomic.AddInt64(&p.collsDone, 1)
+}
+
+func (p *progress) fileDone() {
+	atomic.AddInt64(&p.filesDone, 1)
+}
+
+// uploaded is a storage upload meter
+func (p *progress) uploaded(n float64) {
+	p.Uploaded(int64(n))
+}
+
+func (p *progress) get(now int64) *pbm.BackupProgress {
+	rv := &pbm.BackupProgress{
+		BytesRead:     atomic.LoadInt64(&p.bytesRead),
+		BytesUploaded: atomic.LoadInt64(&p.bytesUploaded),
+		BytesTotal:    p.bytesTotal,
+		CollsDone:     int(atomic.LoadInt64(&p.collsDone)),
+		CollsTotal:    p.collsTotal,
+		FilesDone:     int(atomic.LoadInt64(&p.filesDone)),
+		FilesTotal:    p.filesTotal,
+		UpdatedTS:     now,
+	}
+	rv.EstimateETA(p.start, now)
+
+	return rv
+}
+
+// reportProgress saves the progress to the replset's backup meta every
+// progressReportInterval until the returned func is called. The latter
+// saves the final state and is safe to call more than once.
+func (b *Backup) reportProgress(bcpName, rsName string, p *progress, l *plog.Event) func() {
+	save := func() {
+		err := b.cn.SetRSProgress(bcpName, rsName, p.get(time.Now().Unix()))
+		if err != nil {
+			l.Warning("save progress: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		tk := time.NewTicker(progressReportInterval)
+		defer tk.Stop()
+
+		for {
+			select {
+			case <-tk.C:
+				save()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			save()
+		})
+	}
+}
+
+// readCountSource calls fn with the amount of data written by the source
+type readCountSource struct {
+	src Source
+	fn  func(int64)
+}
+
+func (s readCountSource) WriteTo(w io.Writer) (int64, error) {
+	return s.src.WriteTo(&writeCounter{w: w, fn: s.fn})
+}
+
+type writeCounter struct {
+	w  io.Writer
+	fn func(int64)
+}
+
+func (c *writeCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if n > 0 {
+		c.fn(int64(n))
+	}
+	return n, err
+}
+
+// uploadSize returns the number of files (chunks) uploadFiles would
+// upload for the given files and the amount of data to read
+func uploadSize(files []pbm.File, incr bool) (n int, size int64) {
+	if len(files) == 0 {
+		return 0, 0
+	}
+
+	fsize := func(f pbm.File) int64 {
+		if f.Len == 0 {
+			return f.Size
+		}
+		if f.Off+f.Len > f.Size {
+			return f.Size - f.Off
+		}
+		return f.Len
+	}
+
+	wfile := files[0]
+	for _, file := range files[1:] {
+		if incr && (file.Len == 0 || file.Off >= file.Size) {
+			continue
+		}
+
+		if wfile.Name == file.Name &&
+			wfile.Off+wfile.Len == file.Off {
+			wfile.Len += file.Len
+			wfile.Size = file.Size
+			continue
+		}
+
+		n++
+		size += fsize(wfile)
+		wfile = file
+	}
+
+	if incr && wfile.Off == 0 && wfile.Len == 0 {
+		return n, size
+	}
+
+	return n + 1, size + fsize(wfile)
+}
@@ -0,0 +1,289 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/checksum"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
+	plog "github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/version"
+)
+
+// Verify reads every object of the backup from the storage and checks
+// if it can be restored. It means objects decrypt (if encrypted),
+// decompress and match their checksums (if any). Besides:
+//   - documents of logical snapshots are valid BSON
+//   - oplog is a valid BSON and its records are ordered within the backup's time span
+//   - physical files (chunks) have sizes recorded in the metadata
+//
+// It doesn't change the backup's metadata.
+func Verify(ctx context.Context, cfg pbm.Config, bcp *pbm.BackupMeta, l *plog.Event) error {
+	if bcp.Status != pbm.StatusDone {
+		return errors.Errorf("backup isn't finished: status: %s", bcp.Status)
+	}
+
+	keys, err := crypt.NewKeyProvider(cfg.Encryption)
+	if err != nil {
+		return errors.Wrap(err, "init encryption")
+	}
+	if bcp.KeyID != "" {
+		if keys == nil {
+			return errors.Errorf("backup is encrypted with key %q but encryption isn't configured", bcp.KeyID)
+		}
+		_, err = keys.KeyByID(bcp.KeyID)
+		if err != nil {
+			return errors.Wrapf(err, "get encryption key %q", bcp.KeyID)
+		}
+	}
+
+	cfg, err = cfg.WithProfile(bcp.Store.Profile)
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+
+	// a new storage for each concurrent reader, see Restore.RunSnapshot
+	newStorage := func() (storage.Storage, error) {
+		stg, err := pbm.Storage(cfg, l)
+		if err != nil {
+			return nil, errors.Wrap(err, "get storage")
+		}
+		return crypt.WrapRead(stg, keys, bcp.KeyID), nil
+	}
+
+	for i := range bcp.Replsets {
+		rs := &bcp.Replsets[i]
+		l.Info("verify replset %s", rs.Name)
+
+		switch bcp.Type {
+		case pbm.LogicalBackup:
+			err = verifyLogical(ctx, newStorage, bcp, rs)
+		case pbm.PhysicalBackup, pbm.IncrementalBackup:
+			err = verifyPhysical(ctx, newStorage, bcp, rs, l)
+		default:
+			err = errors.Errorf("unknown backup type %s", bcp.Type)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "replset %s", rs.Name)
+		}
+	}
+
+	return nil
+}
+
+func verifyLogical(ctx context.Context, newStorage func() (storage.Storage, error), bcp *pbm.BackupMeta, rs *pbm.BackupReplset) error {
+	stg, err := newStorage()
+	if err != nil {
+		return err
+	}
+
+	var rdr io.ReadCloser
+	if version.IsLegacyArchive(bcp.PBMVersion) {
+		sr, err := stg.SourceReader(rs.DumpName)
+		if err != nil {
+			return errors.Wrapf(err, "get object %s", rs.DumpName)
+		}
+		defer sr.Close()
+
+		rdr, err = compress.Decompress(sr, bcp.Compression)
+		if err != nil {
+			return errors.Wrapf(err, "decompress object %s", rs.DumpName)
+		}
+	} else {
+		rdr, err = snapshot.DownloadDump(
+			func(ns string) (io.ReadCloser, error) {
+				stg, err := newStorage()
+				if err != nil {
+					return nil, err
+				}
+				return stg.SourceReader(path.Join(bcp.Name, rs.Name, ns))
+			},
+			bcp.Compression,
+			archive.DefaultNSFilter,
+			nil,
+			nil)
+		if err != nil {
+			return errors.Wrap(err, "download snapshot")
+		}
+	}
+	defer rdr.Close()
+
+	err = archive.Decompose(rdr,
+		func(ns string) (io.WriteCloser, error) {
+			if ns == archive.MetaFile {
+				return nopWriteCloser{io.Discard}, nil
+			}
+			return &bsonValidator{ctx: ctx, ns: ns}, nil
+		}, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "snapshot")
+	}
+
+	return verifyOplog(ctx, stg, pbm.OplogChunk{
+		RS:          rs.Name,
+		FName:       rs.OplogName,
+		Compression: bcp.Compression,
+		StartTS:     bcp.FirstWriteTS,
+		EndTS:       bcp.LastWriteTS,
+		Checksum:    rs.OplogChecksum,
+	})
+}
+
+// verifyOplog checks if the chunk holds valid oplog records which are
+// ordered and belong to the chunk's time span
+func verifyOplog(ctx context.Context, stg storage.Storage, chnk pbm.OplogChunk) error {
+	sr, err := stg.SourceReader(chnk.FName)
+	if err != nil {
+		return errors.Wrapf(err, "get object %s", chnk.FName)
+	}
+	defer sr.Close()
+
+	r, err := compress.Decompress(sr, chnk.Compression)
+	if err != nil {
+		return errors.Wrapf(err, "decompress object %s", chnk.FName)
+	}
+	defer r.Close()
+
+	src := checksum.NewReader(r, chnk.Checksum)
+	var prev primitive.Timestamp
+	var buf []byte
+	for i := 0; ; i++ {
+		if i%1000 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		buf, err = archive.ReadBSONBuffer(src, buf[:cap(buf)])
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return errors.Wrapf(err, "oplog %s: read record #%d", chnk.FName, i)
+		}
+
+		doc := bson.Raw(buf)
+		err = doc.Validate()
+		if err != nil {
+			return errors.Wrapf(err, "oplog %s: record #%d", chnk.FName, i)
+		}
+
+		var ts primitive.Timestamp
+		var ok bool
+		ts.T, ts.I, ok = doc.Lookup("ts").TimestampOK()
+		if !ok {
+			return errors.Errorf("oplog %s: record #%d has no timestamp", chnk.FName, i)
+		}
+		if primitive.CompareTimestamp(ts, chnk.StartTS) == -1 ||
+			primitive.CompareTimestamp(ts, chnk.EndTS) == 1 {
+			return errors.Errorf("oplog %s: record #%d %v is out of the range %v - %v",
+				chnk.FName, i, ts, chnk.StartTS, chnk.EndTS)
+		}
+		if primitive.CompareTimestamp(ts, prev) != 1 {
+			return errors.Errorf("oplog %s: record #%d %v is out of order, previous %v",
+				chnk.FName, i, ts, prev)
+		}
+		prev = ts
+	}
+}
+
+func verifyPhysical(ctx context.Context, newStorage func() (storage.Storage, error), bcp *pbm.BackupMeta, rs *pbm.BackupReplset, l *plog.Event) error {
+	stg, err := newStorage()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range rs.Files {
+		// the file is stored in one of the previous (incremental) backups
+		if f.Off < 0 || f.Len < 0 {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		name := path.Join(bcp.Name, rs.Name, f.Name+bcp.Compression.Suffix())
+		size := f.Size
+		if f.Len != 0 {
+			name += fmt.Sprintf(".%d-%d", f.Off, f.Len)
+			size = f.Len
+			if f.Off+f.Len > f.Size {
+				size = f.Size - f.Off
+			}
+		}
+		l.Debug("verify %s", name)
+
+		n, err := readObject(stg, name, bcp.Compression, f.Checksum)
+		if err != nil {
+			return err
+		}
+
+		// The whole file might grow a bit while it is being uploaded.
+		// Restore truncates it to the recorded size anyway.
+		if n < size || (f.Len != 0 && n != size) {
+			return errors.Errorf("object %s: size %d doesn't match the expected %d", name, n, size)
+		}
+	}
+
+	return nil
+}
+
+// readObject reads the whole object and returns the size of decompressed data
+func readObject(stg storage.Storage, name string, c compress.CompressionType, sum string) (int64, error) {
+	sr, err := stg.SourceReader(name)
+	if err != nil {
+		return 0, errors.Wrapf(err, "get object %s", name)
+	}
+	defer sr.Close()
+
+	r, err := compress.Decompress(sr, c)
+	if err != nil {
+		return 0, errors.Wrapf(err, "decompress object %s", name)
+	}
+	defer r.Close()
+
+	n, err := io.Copy(io.Discard, checksum.NewReader(r, sum))
+	return n, errors.Wrapf(err, "read object %s", name)
+}
+
+// bsonValidator checks every written document is a valid BSON.
+// archive.Decompose writes documents one by one.
+type bsonValidator struct {
+	ctx context.Context
+	ns  string
+	n   int
+}
+
+func (v *bsonValidator) Write(p []byte) (int, error) {
+	v.n++
+	if v.n%1000 == 0 && v.ctx.Err() != nil {
+		return 0, v.ctx.Err()
+	}
+
+	err := bson.Raw(p).Validate()
+	if err != nil {
+		return 0, errors.Wrapf(err, "%s: document #%d", v.ns, v.n)
+	}
+
+	return len(p), nil
+}
+
+func (v *bsonValidator) Close() error {
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
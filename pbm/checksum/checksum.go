@@ -0,0 +1,65 @@
+// Package checksum computes and verifies checksums of the backup objects.
+//
+// The checksum is calculated over the object's data before compression
+// and encryption. So it is verified on the decompressed stream during
+// restore and doesn't depend on the storage or compression settings.
+//
+// PITR chunks are verified before any op is applied. Snapshot namespaces
+// are verified as they are restored, so a mismatch fails the restore at
+// the end of the namespace data, after its documents were inserted.
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrMismatch means the object's data doesn't match its checksum
+var ErrMismatch = errors.New("checksum mismatch")
+
+// New returns a new hash.Hash computing the checksum
+func New() hash.Hash {
+	return sha256.New()
+}
+
+// Sum returns the hex encoded checksum of the data written to h
+func Sum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type reader struct {
+	r      io.Reader
+	h      hash.Hash
+	expect string
+}
+
+// NewReader returns a reader that computes the checksum of data read
+// from r and checks it against expected one when r hits io.EOF. It returns
+// an error wrapping ErrMismatch instead of io.EOF if checksums don't match.
+//
+// Objects saved by older versions have no checksum. So if expected is
+// empty, r is returned as is.
+func NewReader(r io.Reader, expected string) io.Reader {
+	if expected == "" {
+		return r
+	}
+
+	return &reader{r: r, h: New(), expect: expected}
+}
+
+func (c *reader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+
+	if errors.Is(err, io.EOF) {
+		if sum := Sum(c.h); sum != c.expect {
+			return n, errors.Wrapf(ErrMismatch, "expected %s, got %s", c.expect, sum)
+		}
+	}
+
+	return n, err
+}
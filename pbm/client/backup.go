@@ -0,0 +1,431 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/mod/semver"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/version"
+)
+
+// BackupOptions are the options of the backup to start
+type BackupOptions struct {
+	// Name is generated from the current time if empty
+	Name     string
+	Type     pbm.BackupType
+	IncrBase bool
+	// Compression and CompressionLevel override the config values if set
+	Compression      compress.CompressionType
+	CompressionLevel *int
+	// Namespaces of the selective backup (e.g. "db.*", "db.coll").
+	// Empty means the whole cluster.
+	Namespaces []string
+	// NSExclude are the patterns of the namespaces to skip
+	// (e.g. "logs.*", "*.tmp_*"). Logical backups only.
+	NSExclude []string
+	// NSQuery are the filters of the collections documents. Only the
+	// matching documents get into the (partial) backup. Logical backups only.
+	NSQuery []sel.NSQuery
+	// Throttle overrides the config limits of the storage traffic
+	Throttle *storage.Limits
+	// Profile is the storage profile to save the backup to.
+	// Empty means the main storage.
+	Profile string
+}
+
+// BackupStarted describes the started backup
+type BackupStarted struct {
+	OPID    string `json:"opid"`
+	Name    string `json:"name"`
+	Storage string `json:"storage"`
+}
+
+// StartBackup sends the backup command. It doesn't wait for the backup
+// to start (see WaitBackupStart).
+func (c *Client) StartBackup(ctx context.Context, o BackupOptions) (*BackupStarted, error) {
+	if o.Type == "" {
+		o.Type = pbm.LogicalBackup
+	}
+	switch o.Type {
+	case pbm.LogicalBackup, pbm.PhysicalBackup, pbm.IncrementalBackup:
+	default:
+		return nil, invalidOptions(errors.Errorf("unknown backup type %q", o.Type))
+	}
+	if len(o.Namespaces) != 0 && o.Type == pbm.PhysicalBackup {
+		return nil, invalidOptions(errors.New("namespaces are not allowed for physical backup"))
+	}
+	if len(o.NSExclude) != 0 {
+		if o.Type != pbm.LogicalBackup {
+			return nil, invalidOptions(errors.Errorf("namespaces exclusion is not allowed for %s backup", o.Type))
+		}
+		if err := sel.ValidateNSExclude(o.NSExclude); err != nil {
+			return nil, invalidOptions(err)
+		}
+	}
+	if err := checkNSQuery(o); err != nil {
+		return nil, err
+	}
+	if err := pbm.ValidateLimits(o.Throttle); err != nil {
+		return nil, invalidOptions(err)
+	}
+	if o.Name == "" {
+		o.Name = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	if err := c.CheckConcurrentOp(); err != nil {
+		// PITR slicing can be run along with the backup start - agents will resolve it.
+		var op ConcurrentOpError
+		if !errors.As(err, &op) || op.Op.Type != pbm.CmdPITR {
+			return nil, err
+		}
+	}
+
+	cfg, err := c.pbm.GetConfig()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNoStorage
+		}
+		return nil, errors.Wrap(err, "get remote-store")
+	}
+
+	stg, err := cfg.Profile(o.Profile)
+	if err != nil {
+		return nil, invalidOptions(err)
+	}
+
+	compression := cfg.Backup.Compression
+	if o.Compression != "" {
+		compression = o.Compression
+	}
+
+	level := cfg.Backup.CompressionLevel
+	if o.CompressionLevel != nil {
+		level = o.CompressionLevel
+	}
+
+	opid, err := c.pbm.SendCmdOp(pbm.Cmd{
+		Cmd: pbm.CmdBackup,
+		Backup: &pbm.BackupCmd{
+			Type:             o.Type,
+			IncrBase:         o.IncrBase,
+			Name:             o.Name,
+			Namespaces:       o.Namespaces,
+			NSExclude:        o.NSExclude,
+			NSQuery:          o.NSQuery,
+			Compression:      compression,
+			CompressionLevel: level,
+			Throttle:         o.Throttle,
+			Profile:          o.Profile,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "send command")
+	}
+
+	return &BackupStarted{
+		OPID:    opid.String(),
+		Name:    o.Name,
+		Storage: stg.Path(),
+	}, nil
+}
+
+// checkNSQuery validates the queries. The queried collections have to be
+// in the backup.
+func checkNSQuery(o BackupOptions) error {
+	if len(o.NSQuery) == 0 {
+		return nil
+	}
+	if o.Type != pbm.LogicalBackup {
+		return invalidOptions(errors.Errorf("namespaces query is not allowed for %s backup", o.Type))
+	}
+	if err := sel.ValidateNSQuery(o.NSQuery); err != nil {
+		return invalidOptions(err)
+	}
+
+	selected := archive.DefaultNSFilter
+	if sel.IsSelective(o.Namespaces) {
+		selected = sel.MakeSelectedPred(o.Namespaces)
+	}
+	notExcluded, err := sel.MakeNSExcludePred(o.NSExclude)
+	if err != nil {
+		return invalidOptions(err)
+	}
+	for _, q := range o.NSQuery {
+		if !selected(q.NS) || !notExcluded(q.NS) {
+			return invalidOptions(errors.Errorf("%s: the queried namespace is not in the backup", q.NS))
+		}
+	}
+
+	return nil
+}
+
+// WaitBackupStart waits up to pbm.WaitBackupStart until the backup
+// has started (or already finished). tick (if not nil) is called on each poll.
+func (c *Client) WaitBackupStart(ctx context.Context, name string, tick func()) error {
+	ctx, cancel := context.WithTimeout(ctx, pbm.WaitBackupStart)
+	defer cancel()
+
+	tk := time.NewTicker(time.Second)
+	defer tk.Stop()
+
+	var bmeta *pbm.BackupMeta
+	var err error
+	for {
+		select {
+		case <-tk.C:
+			callTick(tick)
+			bmeta, err = c.pbm.GetBackupMeta(name)
+			if errors.Is(err, pbm.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return errors.Wrap(err, "get backup metadata")
+			}
+			switch bmeta.Status {
+			case pbm.StatusRunning, pbm.StatusDumpDone, pbm.StatusDone, pbm.StatusCancelled:
+				return nil
+			case pbm.StatusError:
+				rs := ""
+				for _, s := range bmeta.Replsets {
+					rs += fmt.Sprintf("\n- Backup on replicaset \"%s\" in state: %v", s.Name, s.Status)
+					if s.Error != "" {
+						rs += ": " + s.Error
+					}
+				}
+				return errors.New(bmeta.Error().Error() + rs)
+			}
+		case <-ctx.Done():
+			if bmeta == nil {
+				return errors.New("no progress from leader, backup metadata not found")
+			}
+			rs := ""
+			for _, s := range bmeta.Replsets {
+				rs += fmt.Sprintf("- Backup on replicaset \"%s\" in state: %v\n", s.Name, s.Status)
+				if s.Error != "" {
+					rs += ": " + s.Error
+				}
+			}
+			if rs == "" {
+				rs = "<no replset has started backup>\n"
+			}
+
+			return errors.New("no confirmation that backup has successfully started. Replsets status:\n" + rs)
+		}
+	}
+}
+
+// WaitBackup waits until the backup is finished. It returns the backup
+// metadata and, if the backup has failed, its error.
+// tick (if not nil) is called on each poll.
+func (c *Client) WaitBackup(ctx context.Context, name string, tick func()) (*pbm.BackupMeta, error) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+			bcp, err := c.pbm.GetBackupMeta(name)
+			if err != nil {
+				return nil, err
+			}
+
+			switch bcp.Status {
+			case pbm.StatusDone, pbm.StatusCancelled:
+				return bcp, nil
+			case pbm.StatusError:
+				return bcp, bcp.Error()
+			}
+		}
+
+		callTick(tick)
+	}
+}
+
+// DeleteOptions select backups to delete. Either Backup
+// or OlderThan has to be set.
+type DeleteOptions struct {
+	Backup    string
+	OlderThan time.Time
+}
+
+// Delete sends the command to delete backups. The operation can be waited
+// via WaitOp with the pbm.CmdDeleteBackup lock.
+func (c *Client) Delete(ctx context.Context, o DeleteOptions) (pbm.OPID, error) {
+	d := &pbm.DeleteBackupCmd{}
+	switch {
+	case !o.OlderThan.IsZero():
+		d.OlderThan = o.OlderThan.UTC().Unix()
+	case o.Backup != "":
+		d.Backup = o.Backup
+	default:
+		return pbm.OPID{}, invalidOptions(errors.New("backup name should be specified"))
+	}
+
+	opid, err := c.pbm.SendCmdOp(pbm.Cmd{
+		Cmd:    pbm.CmdDeleteBackup,
+		Delete: d,
+	})
+	return opid, errors.Wrap(err, "schedule delete")
+}
+
+// MatchCluster checks if the backups can be restored on the current cluster
+// (see BackupsMatchCluster). rsMap maps backup replset names to the cluster ones.
+func (c *Client) MatchCluster(bcps []pbm.BackupMeta, rsMap map[string]string) error {
+	shards, err := c.pbm.ClusterMembers()
+	if err != nil {
+		return errors.Wrap(err, "get cluster members")
+	}
+
+	inf, err := c.pbm.GetNodeInfo()
+	if err != nil {
+		return errors.Wrap(err, "define cluster state")
+	}
+
+	ver, err := pbm.GetMongoVersion(c.pbm.Context(), c.pbm.Conn)
+	if err != nil {
+		return errors.WithMessage(err, "get mongo version")
+	}
+	fcv, err := c.pbm.GetFeatureCompatibilityVersion()
+	if err != nil {
+		return errors.WithMessage(err, "get featureCompatibilityVersion")
+	}
+
+	// pbm.PBM is always connected either to config server or to the sole (hence main) RS
+	// which the `confsrv` param in `bcpMatchCluster` is all about
+	BackupsMatchCluster(bcps, ver.VersionString, fcv, shards, inf.SetName, rsMap)
+	return nil
+}
+
+// BackupsMatchCluster checks if given backups match shards in the cluster. Match means that
+// each replset in backup has a respective replset on the target cluster. It's ok if cluster
+// has more shards than there are currently in backup. But in the case of sharded cluster
+// backup has to have data for the current config server or for the sole RS in case of non-sharded rs.
+//
+// If some backup doesn't match cluster, the status of the backup meta in given `bcps` would be
+// changed to pbm.StatusError with respective error text emitted. It doesn't change meta on
+// storage nor in DB (backup is ok, it just doesn't cluster), it is just "in-flight" changes
+// in given `bcps`.
+func BackupsMatchCluster(bcps []pbm.BackupMeta, ver, fcv string, shards []pbm.Shard, confsrv string, rsMap map[string]string) {
+	sh := make(map[string]bool, len(shards))
+	for _, s := range shards {
+		sh[s.RS] = s.RS == confsrv
+	}
+
+	mapRS, mapRevRS := pbm.MakeRSMapFunc(rsMap), pbm.MakeReverseRSMapFunc(rsMap)
+	for i := 0; i < len(bcps); i++ {
+		bcpMatchCluster(&bcps[i], ver, fcv, sh, mapRS, mapRevRS)
+	}
+}
+
+func bcpMatchCluster(bcp *pbm.BackupMeta, ver, fcv string, shards map[string]bool, mapRS, mapRevRS pbm.RSMapFunc) {
+	if bcp.Status != pbm.StatusDone {
+		return
+	}
+	if !version.CompatibleWith(bcp.PBMVersion, pbm.BreakingChangesMap[bcp.Type]) {
+		bcp.SetRuntimeError(IncompatibleVersionError{bcp.PBMVersion})
+		return
+	}
+	if bcp.FCV != "" {
+		if bcp.FCV != fcv {
+			bcp.SetRuntimeError(errors.Errorf("backup FCV %q is incompatible with the running mongo FCV %q",
+				bcp.FCV, fcv))
+			return
+		}
+	} else if majmin(bcp.MongoVersion) != majmin(ver) {
+		bcp.SetRuntimeError(errors.Errorf("backup mongo version %q is incompatible with the running mongo version %q",
+			bcp.MongoVersion, ver))
+		return
+
+	}
+
+	var nomatch []string
+	hasconfsrv := false
+	for i := range bcp.Replsets {
+		name := mapRS(bcp.Replsets[i].Name)
+
+		isconfsrv, ok := shards[name]
+		if !ok {
+			nomatch = append(nomatch, name)
+		} else if mapRevRS(name) != bcp.Replsets[i].Name {
+			nomatch = append(nomatch, name)
+		}
+
+		if isconfsrv {
+			hasconfsrv = true
+		}
+	}
+
+	if len(nomatch) != 0 || !hasconfsrv {
+		names := make([]string, len(nomatch))
+		copy(names, nomatch)
+		bcp.SetRuntimeError(MissedReplsetsError{Names: names, ConfigSrv: !hasconfsrv})
+	}
+}
+
+func majmin(v string) string {
+	if len(v) == 0 {
+		return v
+	}
+
+	if v[0] != 'v' {
+		v = "v" + v
+	}
+
+	return semver.MajorMinor(v)
+}
+
+// MissedReplsetsError means the backup doesn't match the cluster topology
+type MissedReplsetsError struct {
+	// Names are the backup replsets which have no match in the cluster
+	Names []string
+	// ConfigSrv is true when the backup has no data for the config server
+	ConfigSrv bool
+}
+
+func (e MissedReplsetsError) Error() string {
+	errString := ""
+	if len(e.Names) != 0 {
+		errString = "Backup doesn't match current cluster topology - it has different replica set names. " +
+			"Extra shards in the backup will cause this, for a simple example. " +
+			"The extra/unknown replica set names found in the backup are: " + strings.Join(e.Names, ", ")
+	}
+
+	if e.ConfigSrv {
+		if errString != "" {
+			errString += ". "
+		}
+		errString += "Backup has no data for the config server or sole replicaset"
+	}
+
+	return errString
+}
+
+func (MissedReplsetsError) Unwrap() error {
+	return ErrIncompatible
+}
+
+// IncompatibleVersionError means the backup was made by incompatible PBM version
+type IncompatibleVersionError struct {
+	BcpVer string
+}
+
+func (e IncompatibleVersionError) Unwrap() error {
+	return ErrIncompatible
+}
+
+func (e IncompatibleVersionError) Error() string {
+	return fmt.Sprintf("backup version (v%s) is not compatible with PBM v%s",
+		e.BcpVer, version.DefaultInfo.Version)
+}
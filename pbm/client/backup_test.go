@@ -1,4 +1,4 @@
-package cli
+package client
 
 import (
 	"errors"
@@ -168,7 +168,7 @@ func TestBcpMatchCluster(t *testing.T) {
 				b.meta.Status = pbm.StatusDone
 				m = append(m, b.meta)
 			}
-			bcpsMatchCluster(m, "", "", c.shards, c.confsrv, nil)
+			BackupsMatchCluster(m, "", "", c.shards, c.confsrv, nil)
 			for i := 0; i < len(c.bcps); i++ {
 				if c.bcps[i].expect != m[i].Status {
 					t.Errorf("wrong status for %s, expect %s, got %s", m[i].Name, c.bcps[i].expect, m[i].Status)
@@ -230,8 +230,8 @@ func TestBcpMatchRemappedCluster(t *testing.T) {
 				},
 			},
 			rsMap: map[string]string{},
-			expected: errMissedReplsets{
-				names: []string{"rs2"},
+			expected: MissedReplsetsError{
+				Names: []string{"rs2"},
 			},
 		},
 		{
@@ -244,8 +244,8 @@ func TestBcpMatchRemappedCluster(t *testing.T) {
 			rsMap: map[string]string{
 				"rs1": "rs0",
 			},
-			expected: errMissedReplsets{
-				names: []string{"rs0"},
+			expected: MissedReplsetsError{
+				Names: []string{"rs0"},
 			},
 		},
 		{
@@ -275,20 +275,27 @@ func TestBcpMatchRemappedCluster(t *testing.T) {
 				"rs2": "rs1",
 				"rs4": "rs3",
 			},
-			expected: errMissedReplsets{
-				names: []string{"rs3", "rs5"},
+			expected: MissedReplsetsError{
+				Names: []string{"rs3", "rs5"},
 			},
 		},
 		{
 			bcp:      pbm.BackupMeta{},
-			expected: errMissedReplsets{configsrv: true},
+			expected: MissedReplsetsError{ConfigSrv: true},
 		},
 		{
 			bcp: pbm.BackupMeta{
 				Type: pbm.PhysicalBackup,
+				Replsets: []pbm.BackupReplset{
+					{Name: "cfg"},
+					{Name: "shard0"},
+				},
+			},
+			rsMap: map[string]string{
+				"cfg":    "rs0",
+				"shard0": "rs1",
 			},
-			rsMap:    map[string]string{"": ""},
-			expected: errRSMappingWithPhysBackup{},
+			expected: nil,
 		},
 	}
 
@@ -300,16 +307,12 @@ func TestBcpMatchRemappedCluster(t *testing.T) {
 		}
 
 		c.bcp.Status = pbm.StatusDone
-		if c.bcp.Type == pbm.PhysicalBackup && len(c.rsMap) != 0 {
-			c.bcp.SetRuntimeError(errRSMappingWithPhysBackup{})
-		} else {
-			bcpMatchCluster(&c.bcp,
-				"",
-				"",
-				topology,
-				pbm.MakeRSMapFunc(c.rsMap),
-				pbm.MakeReverseRSMapFunc(c.rsMap))
-		}
+		bcpMatchCluster(&c.bcp,
+			"",
+			"",
+			topology,
+			pbm.MakeRSMapFunc(c.rsMap),
+			pbm.MakeReverseRSMapFunc(c.rsMap))
 
 		if msg := checkBcpMatchClusterError(c.bcp.Error(), c.expected); msg != "" {
 			t.Errorf("case #%d failed: %s", i, msg)
@@ -324,44 +327,38 @@ func checkBcpMatchClusterError(err error, target error) string {
 		return ""
 	}
 
-	if !errors.Is(err, errIncompatible) {
+	if !errors.Is(err, ErrIncompatible) {
 		return fmt.Sprintf("unknown error: %T", err)
 	}
 
 	switch err := err.(type) {
-	case errMissedReplsets:
-		target, ok := target.(errMissedReplsets)
+	case MissedReplsetsError:
+		target, ok := target.(MissedReplsetsError)
 		if !ok {
-			return fmt.Sprintf("expect errMissedReplsets, got %T", err)
+			return fmt.Sprintf("expect MissedReplsetsError, got %T", err)
 		}
 
 		var msg string
 
-		if err.configsrv != target.configsrv {
+		if err.ConfigSrv != target.ConfigSrv {
 			msg = fmt.Sprintf("expect replsets to be %v, got %v",
-				target.configsrv, err.configsrv)
+				target.ConfigSrv, err.ConfigSrv)
 		}
 
-		sort.Strings(err.names)
-		sort.Strings(target.names)
+		sort.Strings(err.Names)
+		sort.Strings(target.Names)
 
-		a := strings.Join(err.names, ", ")
-		b := strings.Join(target.names, ", ")
+		a := strings.Join(err.Names, ", ")
+		b := strings.Join(target.Names, ", ")
 
 		if a != b {
 			msg = fmt.Sprintf("expect replsets to be %v, got %v", a, b)
 		}
 
 		return msg
-	case errRSMappingWithPhysBackup:
-		if _, ok := target.(errRSMappingWithPhysBackup); !ok {
-			return fmt.Sprintf("expect errRSMappingWithPhysBackup, got %T", err)
-		}
-	default:
-		return fmt.Sprintf("unknown errIncompatible error: %T", err)
 	}
 
-	return ""
+	return fmt.Sprintf("unknown ErrIncompatible error: %T", err)
 }
 
 func BenchmarkBcpMatchCluster3x10(b *testing.B) {
@@ -386,7 +383,7 @@ func BenchmarkBcpMatchCluster3x10(b *testing.B) {
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		bcpsMatchCluster(bcps, "", "", shards, "config", nil)
+		BackupsMatchCluster(bcps, "", "", shards, "config", nil)
 	}
 }
 
@@ -412,7 +409,7 @@ func BenchmarkBcpMatchCluster3x100(b *testing.B) {
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		bcpsMatchCluster(bcps, "", "", shards, "config", nil)
+		BackupsMatchCluster(bcps, "", "", shards, "config", nil)
 	}
 }
 
@@ -466,7 +463,7 @@ func BenchmarkBcpMatchCluster17x100(b *testing.B) {
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		bcpsMatchCluster(bcps, "", "", shards, "config", nil)
+		BackupsMatchCluster(bcps, "", "", shards, "config", nil)
 	}
 }
 
@@ -492,7 +489,7 @@ func BenchmarkBcpMatchCluster3x1000(b *testing.B) {
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		bcpsMatchCluster(bcps, "", "", shards, "config", nil)
+		BackupsMatchCluster(bcps, "", "", shards, "config", nil)
 	}
 }
 
@@ -515,7 +512,7 @@ func BenchmarkBcpMatchCluster1000x1000(b *testing.B) {
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		bcpsMatchCluster(bcps, "", "", shards, "config", nil)
+		BackupsMatchCluster(bcps, "", "", shards, "config", nil)
 	}
 }
 
@@ -555,7 +552,7 @@ func BenchmarkBcpMatchCluster3x10Err(b *testing.B) {
 		})
 	}
 	for i := 0; i < b.N; i++ {
-		bcpsMatchCluster(bcps, "", "", shards, "config", nil)
+		BackupsMatchCluster(bcps, "", "", shards, "config", nil)
 	}
 }
 
@@ -579,6 +576,6 @@ func BenchmarkBcpMatchCluster1000x1000Err(b *testing.B) {
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		bcpsMatchCluster(bcps, "", "", shards, "config", nil)
+		BackupsMatchCluster(bcps, "", "", shards, "config", nil)
 	}
 }
@@ -0,0 +1,210 @@
+// Package client drives PBM programmatically. It talks to the agents
+// the same way the pbm CLI does - via the commands stream and the PBM
+// metadata collections - and returns structured results and typed errors.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// Client is a PBM client
+type Client struct {
+	pbm *pbm.PBM
+}
+
+// New creates a client on top of the PBM connection
+func New(cn *pbm.PBM) *Client {
+	return &Client{pbm: cn}
+}
+
+var (
+	// ErrIncompatible is the cause of errors for backups that can't be
+	// restored on the current cluster (see MatchCluster)
+	ErrIncompatible = errors.New("incompatible")
+	// ErrNoStorage is returned when the remote storage isn't configured
+	ErrNoStorage = errors.New("no store set")
+)
+
+// InvalidOptionsError is returned when the given options are not valid
+type InvalidOptionsError struct {
+	Err error
+}
+
+func (e InvalidOptionsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e InvalidOptionsError) Unwrap() error {
+	return e.Err
+}
+
+func invalidOptions(err error) error {
+	return InvalidOptionsError{err}
+}
+
+// ConcurrentOpError is returned when the operation can't be started
+// because another one is running
+type ConcurrentOpError struct {
+	Op *pbm.LockHeader
+}
+
+func (e ConcurrentOpError) Error() string {
+	return fmt.Sprintf("another operation in progress, %s/%s [%s/%s]", e.Op.Type, e.Op.OPID, e.Op.Replset, e.Op.Node)
+}
+
+func (e ConcurrentOpError) MarshalJSON() ([]byte, error) {
+	s := make(map[string]interface{})
+	s["error"] = "another operation in progress"
+	s["operation"] = e.Op
+	return json.Marshal(s)
+}
+
+// CheckConcurrentOp returns ConcurrentOpError if there is some live
+// operation. Stale locks are left for agents to deal with.
+func (c *Client) CheckConcurrentOp() error {
+	locks, err := c.pbm.GetLocks(&pbm.LockHeader{})
+	if err != nil {
+		return errors.Wrap(err, "get locks")
+	}
+
+	ts, err := c.pbm.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "read cluster time")
+	}
+
+	for _, l := range locks {
+		if l.Heartbeat.T+pbm.StaleFrameSec >= ts.T {
+			return ConcurrentOpError{&l.LockHeader}
+		}
+	}
+
+	return nil
+}
+
+// FindLock returns the lock of the running operation (other than PITR
+// slicing) or nil if there is none. fn is either pbm.GetLocks or
+// pbm.GetOpLocks.
+func (c *Client) FindLock(fn func(*pbm.LockHeader) ([]pbm.LockData, error)) (*pbm.LockData, error) {
+	locks, err := fn(&pbm.LockHeader{})
+	if err != nil {
+		return nil, errors.Wrap(err, "get locks")
+	}
+
+	ct, err := c.pbm.ClusterTime()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
+
+	var lk *pbm.LockData
+	for _, l := range locks {
+		// We don't care about the PITR slicing here. It is a subject of other status sections
+		if l.Type == pbm.CmdPITR || l.Heartbeat.T+pbm.StaleFrameSec < ct.T {
+			continue
+		}
+
+		// Just check if all locks are for the same op
+		//
+		// It could happen that the healthy `lk` became stale by the time of this check
+		// or the op was finished and the new one was started. So the `l.Type != lk.Type`
+		// would be true but for the legit reason (no error).
+		// But chances for that are quite low and on the next run of `pbm status` everything
+		//  would be ok. So no reason to complicate code to avoid that.
+		if lk != nil && l.OPID != lk.OPID {
+			if err != nil {
+				return nil, errors.Errorf("conflicting ops running: [%s/%s::%s-%s] [%s/%s::%s-%s]. This conflict may naturally resolve after 10 seconds",
+					l.Replset, l.Node, l.Type, l.OPID,
+					lk.Replset, lk.Node, lk.Type, lk.OPID,
+				)
+			}
+		}
+
+		l := l
+		lk = &l
+	}
+
+	return lk, nil
+}
+
+// WaitOp waits until operations which acquire a given lock are finished.
+// It returns ctx.Err() if the context is done first. tick (if not nil)
+// is called on each poll.
+func (c *Client) WaitOp(ctx context.Context, lock *pbm.LockHeader, tick func()) error {
+	// just to be sure the check hasn't started before the lock were created
+	select {
+	case <-time.After(time.Second):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	callTick(tick)
+
+	tkr := time.NewTicker(time.Second)
+	defer tkr.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tkr.C:
+			callTick(tick)
+			lock, err := c.pbm.GetLockData(lock)
+			if err != nil {
+				// No lock, so operation has finished
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return nil
+				}
+				return errors.Wrap(err, "get lock data")
+			}
+			clusterTime, err := c.pbm.ClusterTime()
+			if err != nil {
+				return errors.Wrap(err, "read cluster time")
+			}
+			if lock.Heartbeat.T+pbm.StaleFrameSec < clusterTime.T {
+				return errors.Errorf("operation stale, last beat ts: %d", lock.Heartbeat.T)
+			}
+		}
+	}
+}
+
+// LastLogErr returns the last error logged by agents for the operation
+// type after the given unix time or an empty string if there is none
+func (c *Client) LastLogErr(op pbm.Command, after int64) (string, error) {
+	l, err := c.pbm.LogGet(
+		&log.LogRequest{
+			LogKeys: log.LogKeys{
+				Severity: log.Error,
+				Event:    string(op),
+			},
+		}, 1)
+	if err != nil {
+		return "", errors.Wrap(err, "get log records")
+	}
+	if len(l.Data) == 0 {
+		return "", nil
+	}
+
+	if l.Data[0].TS < after {
+		return "", nil
+	}
+
+	return l.Data[0].Msg, nil
+}
+
+// FollowLogs streams new log entries matching the request
+// until ctx is done
+func (c *Client) FollowLogs(ctx context.Context, r *log.LogRequest) (<-chan *log.Entry, <-chan error) {
+	return log.Follow(ctx, c.pbm.Conn.Database(pbm.DB).Collection(pbm.LogCollection), r, false)
+}
+
+func callTick(tick func()) {
+	if tick != nil {
+		tick()
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// ListOptions are the options of ListBackups
+type ListOptions struct {
+	// Limit is the max number of backups (and timelines per replset)
+	// to return. 0 means no limit.
+	Limit int
+	// Full adds oplog ranges of each replset
+	Full bool
+	// Unbacked adds oplog ranges with no base snapshot
+	Unbacked bool
+	// RSMap maps backup replset names to the cluster ones
+	RSMap map[string]string
+}
+
+// BackupList is the list of backups and PITR ranges
+type BackupList struct {
+	// Backups are in ascending order. Backups that can't be restored on
+	// the cluster have the StatusError status with the ErrIncompatible
+	// cause (see MatchCluster).
+	Backups []pbm.BackupMeta `json:"backups"`
+	PITR    struct {
+		On       bool                   `json:"on"`
+		Ranges   []PITRRange            `json:"ranges"`
+		RsRanges map[string][]PITRRange `json:"rsRanges,omitempty"`
+	} `json:"pitr"`
+}
+
+// ListBackups returns backups and PITR ranges
+func (c *Client) ListBackups(ctx context.Context, o ListOptions) (*BackupList, error) {
+	bcps, err := c.pbm.BackupsList(int64(o.Limit))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get backups list")
+	}
+	if err := c.MatchCluster(bcps, o.RSMap); err != nil {
+		return nil, err
+	}
+
+	list := &BackupList{}
+	for i := len(bcps) - 1; i >= 0; i-- {
+		list.Backups = append(list.Backups, bcps[i])
+	}
+
+	list.PITR.Ranges, list.PITR.RsRanges, err = c.PITRRanges(o.Limit, o.Full, o.Unbacked, o.RSMap)
+	if err != nil {
+		return nil, errors.Wrap(err, "get PITR ranges")
+	}
+
+	list.PITR.On, err = c.pbm.IsPITR()
+	if err != nil {
+		return nil, errors.Wrap(err, "check if PITR is on")
+	}
+
+	return list, nil
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// PITRRange is a range of the oplog available for the point-in-time restore
+type PITRRange struct {
+	Err            error        `json:"error,omitempty"`
+	Range          pbm.Timeline `json:"range"`
+	NoBaseSnapshot bool         `json:"noBaseSnapshot,omitempty"`
+}
+
+func (pr PITRRange) String() string {
+	return fmt.Sprintf("{ %s }", pr.Range)
+}
+
+// PITRRanges returns oplog ranges across the cluster and, if full is set,
+// ranges of each replset. Only ranges derived from `Done` and compatible
+// version's backups are returned unless unbacked is set.
+// size limits the number of timelines per replset if > 0.
+func (c *Client) PITRRanges(size int, full, unbacked bool, rsMap map[string]string) (ranges []PITRRange, rsRanges map[string][]PITRRange, err error) {
+	inf, err := c.pbm.GetNodeInfo()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "define cluster state")
+	}
+
+	shards, err := c.pbm.ClusterMembers()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get cluster members")
+	}
+
+	now, err := c.pbm.ClusterTime()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get cluster time")
+	}
+
+	mapRevRS := pbm.MakeReverseRSMapFunc(rsMap)
+	rsRanges = make(map[string][]PITRRange)
+	var rstlines [][]pbm.Timeline
+	for _, s := range shards {
+		tlns, err := c.pbm.PITRGetValidTimelines(mapRevRS(s.RS), now)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "get PITR timelines for %s replset", s.RS)
+		}
+
+		if len(tlns) == 0 {
+			continue
+		}
+
+		if size > 0 && size < len(tlns) {
+			tlns = tlns[len(tlns)-size:]
+		}
+
+		if full {
+			var rsrng []PITRRange
+			for _, tln := range tlns {
+				rsrng = append(rsrng, PITRRange{Range: tln})
+			}
+			rsRanges[s.RS] = rsrng
+		}
+		rstlines = append(rstlines, tlns)
+	}
+
+	sh := make(map[string]bool, len(shards))
+	for _, s := range shards {
+		sh[s.RS] = s.RS == inf.SetName
+	}
+
+	for _, tl := range pbm.MergeTimelines(rstlines...) {
+		lastWrite, err := c.baseSnapshotLastWrite(sh, rsMap, tl)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rs := SplitByBaseSnapshot(lastWrite, tl)
+		for i := range rs {
+			if !unbacked && rs[i].NoBaseSnapshot {
+				continue
+			}
+
+			ranges = append(ranges, rs[i])
+		}
+	}
+
+	return ranges, rsRanges, nil
+}
+
+func (c *Client) baseSnapshotLastWrite(sh map[string]bool, rsMap map[string]string, tl pbm.Timeline) (*primitive.Timestamp, error) {
+	bcp, err := c.pbm.GetFirstBackup(&primitive.Timestamp{T: tl.Start, I: 0})
+	if err != nil {
+		if !errors.Is(err, pbm.ErrNotFound) {
+			return nil, errors.Wrapf(err, "get backup for timeline: %s", tl)
+		}
+
+		return nil, nil
+	}
+	if bcp == nil {
+		return nil, nil
+	}
+
+	ver, err := pbm.GetMongoVersion(c.pbm.Context(), c.pbm.Conn)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get mongo version")
+	}
+	fcv, err := c.pbm.GetFeatureCompatibilityVersion()
+	if err != nil {
+		return nil, errors.WithMessage(err, "get featureCompatibilityVersion")
+	}
+
+	bcpMatchCluster(bcp, ver.VersionString, fcv, sh, pbm.MakeRSMapFunc(rsMap), pbm.MakeReverseRSMapFunc(rsMap))
+
+	if bcp.Status != pbm.StatusDone {
+		return nil, nil
+	}
+
+	return &bcp.LastWriteTS, nil
+}
+
+// SplitByBaseSnapshot splits the timeline into the part covered by
+// the base snapshot (which last write is lastWrite) and the rest
+func SplitByBaseSnapshot(lastWrite *primitive.Timestamp, tl pbm.Timeline) []PITRRange {
+	if lastWrite == nil || (lastWrite.T < tl.Start || lastWrite.T > tl.End) {
+		return []PITRRange{{Range: tl, NoBaseSnapshot: true}}
+	}
+
+	ranges := make([]PITRRange, 0, 1)
+
+	if lastWrite.T > tl.Start {
+		ranges = append(ranges, PITRRange{
+			Range: pbm.Timeline{
+				Start: tl.Start,
+				End:   lastWrite.T,
+			},
+			NoBaseSnapshot: true,
+		})
+	}
+
+	if lastWrite.T < tl.End {
+		ranges = append(ranges, PITRRange{
+			Range: pbm.Timeline{
+				Start: lastWrite.T + 1,
+				End:   tl.End,
+			},
+			NoBaseSnapshot: false,
+		})
+	}
+
+	return ranges
+}
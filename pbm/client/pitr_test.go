@@ -1,4 +1,4 @@
-package cli
+package client
 
 import (
 	"reflect"
@@ -12,9 +12,9 @@ func Test_splitByBaseSnapshot(t *testing.T) {
 	tl := pbm.Timeline{Start: 3, End: 7}
 
 	t.Run("lastWrite is nil", func(t *testing.T) {
-		got := splitByBaseSnapshot(nil, tl)
+		got := SplitByBaseSnapshot(nil, tl)
 
-		want := []pitrRange{
+		want := []PITRRange{
 			{Range: tl, NoBaseSnapshot: true},
 		}
 
@@ -23,9 +23,9 @@ func Test_splitByBaseSnapshot(t *testing.T) {
 
 	t.Run("lastWrite > tl.End", func(t *testing.T) {
 		lastWrite := &primitive.Timestamp{T: tl.End + 1}
-		got := splitByBaseSnapshot(lastWrite, tl)
+		got := SplitByBaseSnapshot(lastWrite, tl)
 
-		want := []pitrRange{
+		want := []PITRRange{
 			{Range: tl, NoBaseSnapshot: true},
 		}
 
@@ -34,9 +34,9 @@ func Test_splitByBaseSnapshot(t *testing.T) {
 
 	t.Run("lastWrite = tl.End", func(t *testing.T) {
 		lastWrite := &primitive.Timestamp{T: tl.End}
-		got := splitByBaseSnapshot(lastWrite, tl)
+		got := SplitByBaseSnapshot(lastWrite, tl)
 
-		want := []pitrRange{
+		want := []PITRRange{
 			{Range: tl, NoBaseSnapshot: true},
 		}
 
@@ -45,9 +45,9 @@ func Test_splitByBaseSnapshot(t *testing.T) {
 
 	t.Run("lastWrite < tl.Start", func(t *testing.T) {
 		lastWrite := &primitive.Timestamp{T: tl.Start - 1}
-		got := splitByBaseSnapshot(lastWrite, tl)
+		got := SplitByBaseSnapshot(lastWrite, tl)
 
-		want := []pitrRange{
+		want := []PITRRange{
 			{Range: tl, NoBaseSnapshot: true},
 		}
 
@@ -56,9 +56,9 @@ func Test_splitByBaseSnapshot(t *testing.T) {
 
 	t.Run("lastWrite = tl.Start", func(t *testing.T) {
 		lastWrite := &primitive.Timestamp{T: tl.Start}
-		got := splitByBaseSnapshot(lastWrite, tl)
+		got := SplitByBaseSnapshot(lastWrite, tl)
 
-		want := []pitrRange{
+		want := []PITRRange{
 			{
 				Range: pbm.Timeline{
 					Start: lastWrite.T + 1,
@@ -73,9 +73,9 @@ func Test_splitByBaseSnapshot(t *testing.T) {
 
 	t.Run("tl.Start < lastWrite < tl.End", func(t *testing.T) {
 		lastWrite := &primitive.Timestamp{T: 5}
-		got := splitByBaseSnapshot(lastWrite, tl)
+		got := SplitByBaseSnapshot(lastWrite, tl)
 
-		want := []pitrRange{
+		want := []PITRRange{
 			{
 				Range: pbm.Timeline{
 					Start: tl.Start,
@@ -96,7 +96,7 @@ func Test_splitByBaseSnapshot(t *testing.T) {
 	})
 }
 
-func check(t *testing.T, got, want []pitrRange) {
+func check(t *testing.T, got, want []PITRRange) {
 	t.Helper()
 
 	if !reflect.DeepEqual(got, want) {
@@ -0,0 +1,525 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/mask"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// WaitPhysRestoreStart is the time to wait for a physical restore to start.
+// Physical restore may take more time to start than the logical one.
+const WaitPhysRestoreStart = time.Second * 120
+
+// RestoreOptions are the options of the restore from a backup
+type RestoreOptions struct {
+	Backup string
+	// Namespaces to restore (e.g. "db.*", "db.coll").
+	// Empty means everything in the backup.
+	Namespaces []string
+	// NSExclude are the patterns of the namespaces to skip
+	// (e.g. "logs.*", "*.tmp_*"). Only for logical restores.
+	NSExclude []string
+	// NSFrom and NSTo are the namespaces renaming patterns
+	// (e.g. "db.*" -> "db_restored.*"). Only for logical restores.
+	NSFrom []string
+	NSTo   []string
+	// Mask is the masking of the restored data. Only for logical restores.
+	Mask *mask.Spec
+	// RSMap maps backup replset names to the cluster ones
+	RSMap map[string]string
+	// Throttle overrides the config limits of the storage traffic
+	Throttle *storage.Limits
+}
+
+// PITRRestoreOptions are the options of the point-in-time restore
+type PITRRestoreOptions struct {
+	Time primitive.Timestamp
+	// Base is the base snapshot. If empty, PBM will choose one.
+	Base       string
+	Namespaces []string
+	NSExclude  []string
+	NSFrom     []string
+	NSTo       []string
+	Mask       *mask.Spec
+	RSMap      map[string]string
+	Throttle   *storage.Limits
+	// AllowPartial allows to restore on top of a partial backup
+	// (see pbm.BackupMeta.IsPartial)
+	AllowPartial bool
+}
+
+// ReplayOptions are the options of the oplog replay
+type ReplayOptions struct {
+	Start    primitive.Timestamp
+	End      primitive.Timestamp
+	NSFrom   []string
+	NSTo     []string
+	Mask     *mask.Spec
+	RSMap    map[string]string
+	Throttle *storage.Limits
+}
+
+// RestoreStarted describes the started restore
+type RestoreStarted struct {
+	OPID   string         `json:"opid"`
+	Name   string         `json:"name"`
+	Backup string         `json:"backup,omitempty"`
+	Type   pbm.BackupType `json:"type"`
+	// Partial is true if the backup is partial
+	// (see pbm.BackupMeta.IsPartial)
+	Partial bool `json:"partial,omitempty"`
+}
+
+func (r *RestoreStarted) physical() bool {
+	return r.Type == pbm.PhysicalBackup || r.Type == pbm.IncrementalBackup
+}
+
+// RestoreFailedError is returned when the restore has failed or was canceled
+type RestoreFailedError struct {
+	Msg string
+}
+
+func (e RestoreFailedError) Error() string {
+	return e.Msg
+}
+
+// BackupNotFoundError is returned when the backup to restore doesn't exist.
+// It unwraps to pbm.ErrNotFound.
+type BackupNotFoundError struct {
+	Name string
+}
+
+func (e BackupNotFoundError) Error() string {
+	return fmt.Sprintf("backup '%s' not found", e.Name)
+}
+
+func (BackupNotFoundError) Unwrap() error {
+	return pbm.ErrNotFound
+}
+
+// Restore sends the command to restore a backup.
+// It doesn't wait for the restore to start (see WaitRestoreStart).
+func (c *Client) Restore(ctx context.Context, o RestoreOptions) (*RestoreStarted, error) {
+	if err := pbm.ValidateLimits(o.Throttle); err != nil {
+		return nil, invalidOptions(err)
+	}
+
+	bcp, err := c.doneBackup(o.Backup)
+	if err != nil {
+		return nil, err
+	}
+	if len(o.Namespaces) != 0 && bcp.Type != pbm.LogicalBackup {
+		return nil, invalidOptions(errors.Errorf("namespaces are not allowed for %s restore", bcp.Type))
+	}
+	err = checkNSExclude(o.NSExclude, bcp.Type)
+	if err != nil {
+		return nil, err
+	}
+	err = c.checkNSRename(o.NSFrom, o.NSTo, bcp.Type)
+	if err != nil {
+		return nil, err
+	}
+	err = checkMask(o.Mask, bcp.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.CheckConcurrentOp()
+	if err != nil {
+		return nil, err
+	}
+
+	name := time.Now().UTC().Format(time.RFC3339Nano)
+	opid, err := c.pbm.SendCmdOp(pbm.Cmd{
+		Cmd: pbm.CmdRestore,
+		Restore: &pbm.RestoreCmd{
+			Name:       name,
+			BackupName: o.Backup,
+			Namespaces: o.Namespaces,
+			NSExclude:  o.NSExclude,
+			NSFrom:     o.NSFrom,
+			NSTo:       o.NSTo,
+			Mask:       o.Mask,
+			RSMap:      o.RSMap,
+			Throttle:   o.Throttle,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "send command")
+	}
+
+	return &RestoreStarted{
+		OPID:    opid.String(),
+		Name:    name,
+		Backup:  o.Backup,
+		Type:    bcp.Type,
+		Partial: bcp.IsPartial(),
+	}, nil
+}
+
+// PITRRestore sends the command to restore the cluster to the point in time.
+// It doesn't wait for the restore to start (see WaitRestoreStart).
+func (c *Client) PITRRestore(ctx context.Context, o PITRRestoreOptions) (*RestoreStarted, error) {
+	if err := pbm.ValidateLimits(o.Throttle); err != nil {
+		return nil, invalidOptions(err)
+	}
+
+	bcpType := pbm.LogicalBackup
+	partial := false
+	if o.Base != "" {
+		bcp, err := c.doneBackup(o.Base)
+		if err != nil {
+			return nil, err
+		}
+		if primitive.CompareTimestamp(bcp.LastWriteTS, o.Time) >= 0 {
+			return nil, invalidOptions(errors.New("snapshot's last write is later than the target time. Try to set an earlier snapshot. Or leave the snapshot empty so PBM will choose one."))
+		}
+
+		bcpType = bcp.Type
+		if len(o.Namespaces) != 0 && bcpType != pbm.LogicalBackup {
+			return nil, invalidOptions(errors.Errorf("namespaces are not allowed for %s restore", bcpType))
+		}
+		partial = bcp.IsPartial()
+		if partial && !o.AllowPartial {
+			return nil, invalidOptions(errors.Errorf("backup '%s' is partial (some collections are filtered by query). "+
+				"The oplog may update the documents missing in the backup", o.Base))
+		}
+	} else {
+		// the same base snapshot the agents would choose.
+		// partial ones are chosen only if they're allowed
+		var bcp *pbm.BackupMeta
+		var err error
+		if o.AllowPartial {
+			bcp, err = c.pbm.GetLastBackupWithPartial(&o.Time)
+		} else {
+			bcp, err = c.pbm.GetLastBackup(&o.Time)
+		}
+		if err != nil && !errors.Is(err, pbm.ErrNotFound) {
+			return nil, errors.Wrap(err, "define last backup")
+		}
+		// if there is none, the agents report it
+		if bcp != nil {
+			partial = bcp.IsPartial()
+		}
+	}
+	err := c.checkNSRename(o.NSFrom, o.NSTo, bcpType)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNSExclude(o.NSExclude, bcpType)
+	if err != nil {
+		return nil, err
+	}
+	err = checkMask(o.Mask, bcpType)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.CheckConcurrentOp()
+	if err != nil {
+		return nil, err
+	}
+
+	name := time.Now().UTC().Format(time.RFC3339Nano)
+	opid, err := c.pbm.SendCmdOp(pbm.Cmd{
+		Cmd: pbm.CmdPITRestore,
+		PITRestore: &pbm.PITRestoreCmd{
+			Name:         name,
+			TS:           int64(o.Time.T),
+			I:            int64(o.Time.I),
+			Bcp:          o.Base,
+			Namespaces:   o.Namespaces,
+			NSExclude:    o.NSExclude,
+			NSFrom:       o.NSFrom,
+			NSTo:         o.NSTo,
+			Mask:         o.Mask,
+			RSMap:        o.RSMap,
+			Throttle:     o.Throttle,
+			AllowPartial: o.AllowPartial,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "send command")
+	}
+
+	return &RestoreStarted{
+		OPID:    opid.String(),
+		Name:    name,
+		Backup:  o.Base,
+		Type:    bcpType,
+		Partial: partial,
+	}, nil
+}
+
+// ReplayOplog sends the command to replay the oplog between
+// o.Start and o.End. It doesn't wait for the replay to start
+// (see WaitRestoreStart).
+func (c *Client) ReplayOplog(ctx context.Context, o ReplayOptions) (*RestoreStarted, error) {
+	if err := pbm.ValidateLimits(o.Throttle); err != nil {
+		return nil, invalidOptions(err)
+	}
+	err := c.checkNSRename(o.NSFrom, o.NSTo, pbm.LogicalBackup)
+	if err != nil {
+		return nil, err
+	}
+	err = checkMask(o.Mask, pbm.LogicalBackup)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.CheckConcurrentOp()
+	if err != nil {
+		return nil, err
+	}
+
+	name := time.Now().UTC().Format(time.RFC3339Nano)
+	opid, err := c.pbm.SendCmdOp(pbm.Cmd{
+		Cmd: pbm.CmdReplay,
+		Replay: &pbm.ReplayCmd{
+			Name:     name,
+			Start:    o.Start,
+			End:      o.End,
+			NSFrom:   o.NSFrom,
+			NSTo:     o.NSTo,
+			Mask:     o.Mask,
+			RSMap:    o.RSMap,
+			Throttle: o.Throttle,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "send command")
+	}
+
+	return &RestoreStarted{
+		OPID: opid.String(),
+		Name: name,
+		Type: pbm.LogicalBackup,
+	}, nil
+}
+
+// checkNSRename validates the namespaces renaming. It's supported only
+// by logical restores of non-sharded clusters.
+func (c *Client) checkNSRename(from, to []string, typ pbm.BackupType) error {
+	if len(from) == 0 && len(to) == 0 {
+		return nil
+	}
+	if typ != pbm.LogicalBackup {
+		return invalidOptions(errors.Errorf("namespaces renaming is not allowed for %s restore", typ))
+	}
+	if err := sel.ValidateNSRename(from, to); err != nil {
+		return invalidOptions(err)
+	}
+
+	inf, err := c.pbm.GetNodeInfo()
+	if err != nil {
+		return errors.Wrap(err, "get node info")
+	}
+	if inf.IsSharded() {
+		return invalidOptions(errors.New("namespaces renaming is not supported for sharded clusters"))
+	}
+
+	return nil
+}
+
+// checkNSExclude validates the patterns of the excluded namespaces
+func checkNSExclude(nss []string, typ pbm.BackupType) error {
+	if len(nss) == 0 {
+		return nil
+	}
+	if typ != pbm.LogicalBackup {
+		return invalidOptions(errors.Errorf("namespaces exclusion is not allowed for %s restore", typ))
+	}
+	if err := sel.ValidateNSExclude(nss); err != nil {
+		return invalidOptions(err)
+	}
+
+	return nil
+}
+
+// checkMask validates the masking spec. The data of physical
+// backups can't be masked.
+func checkMask(spec *mask.Spec, typ pbm.BackupType) error {
+	if spec == nil {
+		return nil
+	}
+	if typ != pbm.LogicalBackup {
+		return invalidOptions(errors.Errorf("masking is not allowed for %s restore", typ))
+	}
+	if err := spec.Validate(); err != nil {
+		return invalidOptions(errors.WithMessage(err, "masking"))
+	}
+
+	return nil
+}
+
+func (c *Client) doneBackup(name string) (*pbm.BackupMeta, error) {
+	bcp, err := c.pbm.GetBackupMeta(name)
+	if errors.Is(err, pbm.ErrNotFound) {
+		return nil, BackupNotFoundError{name}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup data")
+	}
+	if bcp.Status != pbm.StatusDone {
+		return nil, invalidOptions(errors.Errorf("backup '%s' didn't finish successfully", name))
+	}
+
+	return bcp, nil
+}
+
+func (c *Client) restoreMetaFn(r *RestoreStarted) (func(name string) (*pbm.RestoreMeta, error), error) {
+	if !r.physical() {
+		return c.pbm.GetRestoreMeta, nil
+	}
+
+	ep, _ := c.pbm.GetEpoch()
+	l := c.pbm.Logger().NewEvent(string(pbm.CmdRestore), r.Backup, r.OPID, ep.TS())
+	stg, err := c.pbm.GetStorage(l)
+	if err != nil {
+		return nil, errors.Wrap(err, "get storage")
+	}
+
+	return func(name string) (*pbm.RestoreMeta, error) {
+		return pbm.GetPhysRestoreMeta(name, stg, l)
+	}, nil
+}
+
+// WaitRestoreStart waits until the restore has started. It waits up to
+// pbm.WaitActionStart for logical restores and WaitPhysRestoreStart for
+// physical ones. tick (if not nil) is called on each poll.
+func (c *Client) WaitRestoreStart(ctx context.Context, r *RestoreStarted, tick func()) (*pbm.RestoreMeta, error) {
+	getfn, err := c.restoreMetaFn(r)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := pbm.WaitActionStart
+	if r.physical() {
+		timeout = WaitPhysRestoreStart
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	tk := time.NewTicker(time.Second * 1)
+	defer tk.Stop()
+
+	meta := new(pbm.RestoreMeta)
+	for {
+		select {
+		case <-tk.C:
+			callTick(tick)
+			meta, err = getfn(r.Name)
+			if errors.Is(err, pbm.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "get metadata")
+			}
+			if meta == nil {
+				continue
+			}
+			switch meta.Status {
+			case pbm.StatusRunning, pbm.StatusDumpDone, pbm.StatusDone:
+				return meta, nil
+			case pbm.StatusError:
+				rs := ""
+				for _, s := range meta.Replsets {
+					rs += fmt.Sprintf("\n- Restore on replicaset \"%s\" in state: %v", s.Name, s.Status)
+					if s.Error != "" {
+						rs += ": " + s.Error
+					}
+				}
+				return nil, errors.New(meta.Error + rs)
+			case pbm.StatusCancelled:
+				return nil, errors.New("restore was canceled")
+			}
+		case <-ctx.Done():
+			rs := ""
+			if meta != nil {
+				for _, s := range meta.Replsets {
+					rs += fmt.Sprintf("- Restore on replicaset \"%s\" in state: %v\n", s.Name, s.Status)
+					if s.Error != "" {
+						rs += ": " + s.Error
+					}
+				}
+			}
+			if rs == "" {
+				rs = "<no replset has started restore>\n"
+			}
+
+			return nil, errors.New("no confirmation that restore has successfully started. Replsets status:\n" + rs)
+		}
+	}
+}
+
+// WaitRestore waits until the restore is finished. It returns
+// RestoreFailedError if the restore has failed or was canceled.
+// tick (if not nil) is called on each poll.
+//
+// We rely on heartbeats in error detection in case of all nodes failed,
+// comparing heartbeats with the current cluster time for logical restores.
+// But for physical ones, the cluster by this time is down. So we compare with
+// the wall time taking into account a time skew tskew (wallTime - clusterTime)
+// taken when the cluster time was still available.
+func (c *Client) WaitRestore(ctx context.Context, r *RestoreStarted, tskew int64, tick func()) error {
+	getMeta, err := c.restoreMetaFn(r)
+	if err != nil {
+		return err
+	}
+
+	tk := time.NewTicker(time.Second * 1)
+	defer tk.Stop()
+
+	var ctime uint32
+	frameSec := pbm.StaleFrameSec
+	if r.Type != pbm.LogicalBackup {
+		frameSec = 60 * 3
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tk.C:
+		}
+
+		callTick(tick)
+		rmeta, err := getMeta(r.Name)
+		if errors.Is(err, pbm.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "get restore metadata")
+		}
+
+		switch rmeta.Status {
+		case pbm.StatusDone, pbm.StatusPartlyDone:
+			return nil
+		case pbm.StatusError:
+			return RestoreFailedError{fmt.Sprintf("operation failed with: %s", rmeta.Error)}
+		case pbm.StatusCancelled:
+			return RestoreFailedError{"operation was canceled"}
+		}
+
+		if r.Type == pbm.LogicalBackup {
+			clusterTime, err := c.pbm.ClusterTime()
+			if err != nil {
+				return errors.Wrap(err, "read cluster time")
+			}
+			ctime = clusterTime.T
+		} else {
+			ctime = uint32(time.Now().Unix() + tskew)
+		}
+
+		if rmeta.Hb.T+frameSec < ctime {
+			return errors.Errorf("operation staled, last heartbeat: %v", rmeta.Hb.T)
+		}
+	}
+}
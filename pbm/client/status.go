@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// Status is the state of the cluster from the PBM point of view
+type Status struct {
+	Agents []AgentStatus `json:"agents"`
+	// Running are the locks of live operations
+	Running    []pbm.LockHeader `json:"running"`
+	PITR       PITRStatus       `json:"pitr"`
+	LastBackup *pbm.BackupMeta  `json:"last_backup,omitempty"`
+}
+
+// AgentStatus is the state of pbm-agent
+type AgentStatus struct {
+	RS     string   `json:"rs"`
+	Node   string   `json:"node"`
+	Ver    string   `json:"ver"`
+	OK     bool     `json:"ok"`
+	Stale  bool     `json:"stale"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// PITRStatus is the state of the oplog slicing
+type PITRStatus struct {
+	Enabled bool `json:"enabled"`
+	Running bool `json:"running"`
+}
+
+// Status returns the state of agents, running operations, PITR
+// and the last successful backup
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	ct, err := c.pbm.ClusterTime()
+	if err != nil {
+		return nil, errors.Wrap(err, "read cluster time")
+	}
+
+	rv := &Status{
+		Agents:  []AgentStatus{},
+		Running: []pbm.LockHeader{},
+	}
+
+	agents, err := c.pbm.AgentsStatus()
+	if err != nil {
+		return nil, errors.Wrap(err, "get agents status")
+	}
+	for _, a := range agents {
+		ok, errs := a.OK()
+		rv.Agents = append(rv.Agents, AgentStatus{
+			RS:     a.RS,
+			Node:   a.Node,
+			Ver:    a.Ver,
+			OK:     ok,
+			Stale:  a.Heartbeat.T+pbm.StaleFrameSec < ct.T,
+			Errors: errs,
+		})
+	}
+
+	for _, get := range []func(*pbm.LockHeader) ([]pbm.LockData, error){c.pbm.GetLocks, c.pbm.GetOpLocks} {
+		locks, err := get(&pbm.LockHeader{})
+		if err != nil {
+			return nil, errors.Wrap(err, "get locks")
+		}
+		for _, l := range locks {
+			if l.Heartbeat.T+pbm.StaleFrameSec >= ct.T {
+				rv.Running = append(rv.Running, l.LockHeader)
+			}
+		}
+	}
+
+	rv.PITR.Enabled, err = c.pbm.IsPITR()
+	if err != nil {
+		return nil, errors.Wrap(err, "check pitr")
+	}
+	rv.PITR.Running, err = c.pbm.PITRrun()
+	if err != nil {
+		return nil, errors.Wrap(err, "check pitr is running")
+	}
+
+	rv.LastBackup, err = c.pbm.LastSuccessfulBackup()
+	if err != nil && !errors.Is(err, pbm.ErrNotFound) {
+		return nil, errors.Wrap(err, "get last backup")
+	}
+
+	return rv, nil
+}
+
+// CurrentOp is the running operation
+type CurrentOp struct {
+	Type    pbm.Command `json:"type,omitempty"`
+	Name    string      `json:"name,omitempty"`
+	StartTS int64       `json:"startTS,omitempty"`
+	Status  string      `json:"status,omitempty"`
+	OPID    string      `json:"opID,omitempty"`
+	// Progress of the backup data copying by replset
+	Progress map[string]*pbm.BackupProgress `json:"progress,omitempty"`
+}
+
+// CurrentOp returns the running operation (other than PITR slicing).
+// The Type of the returned op is pbm.CmdUndefined if there is none.
+func (c *Client) CurrentOp() (CurrentOp, error) {
+	var r CurrentOp
+
+	// check for ops
+	lk, err := c.FindLock(c.pbm.GetLocks)
+	if err != nil {
+		return r, errors.Wrap(err, "get ops")
+	}
+
+	if lk == nil {
+		// check for delete ops
+		lk, err = c.FindLock(c.pbm.GetOpLocks)
+		if err != nil {
+			return r, errors.Wrap(err, "get delete ops")
+		}
+	}
+
+	if lk == nil {
+		return r, nil
+	}
+
+	r = CurrentOp{
+		Type: lk.Type,
+		OPID: lk.OPID,
+	}
+
+	// reaching here means no conflict operation, hence all locks are the same,
+	// hence any lock in `lk` contais info on the current op
+	switch r.Type {
+	case pbm.CmdBackup:
+		bcp, err := c.pbm.GetBackupByOPID(r.OPID)
+		if err != nil {
+			return r, errors.Wrap(err, "get backup info")
+		}
+		r.Name = bcp.Name
+		r.StartTS = bcp.StartTS
+		r.Status = string(bcp.Status)
+		switch bcp.Status {
+		case pbm.StatusRunning:
+			r.Status = "snapshot backup"
+		case pbm.StatusDumpDone:
+			r.Status = "oplog backup"
+		}
+		for _, rs := range bcp.Replsets {
+			// the data copying is over for other states
+			if rs.Progress == nil || rs.Status != pbm.StatusRunning {
+				continue
+			}
+			if r.Progress == nil {
+				r.Progress = make(map[string]*pbm.BackupProgress)
+			}
+			r.Progress[rs.Name] = rs.Progress
+		}
+	case pbm.CmdRestore, pbm.CmdPITRestore:
+		rst, err := c.pbm.GetRestoreMetaByOPID(r.OPID)
+		if err != nil {
+			return r, errors.Wrap(err, "get restore info")
+		}
+		r.Name = rst.Backup
+		r.StartTS = rst.StartTS
+		r.Status = string(rst.Status)
+		switch rst.Status {
+		case pbm.StatusRunning:
+			r.Status = "snapshot restore"
+		case pbm.StatusDumpDone:
+			r.Status = "oplog restore"
+		}
+	}
+
+	return r, nil
+}
@@ -1,11 +1,15 @@
 package pbm
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ErrorCursor struct {
@@ -16,6 +20,32 @@ func (c ErrorCursor) Error() string {
 	return fmt.Sprintln("cursor was closed with:", c.cerr)
 }
 
+const (
+	// cmdStreamResumeAttempts is how many times the listener tries to
+	// reopen the change stream (e.g. during the primary failover)
+	// before falling back to polling
+	cmdStreamResumeAttempts = 10
+	// cmdPollInterval is the interval between the cmd stream polls
+	cmdPollInterval = time.Second
+	// cmdWatchRetryInterval is how long the listener polls
+	// before the next attempt to open the change stream
+	cmdWatchRetryInterval = time.Minute
+	// cmdSeenWindow is how long (in seconds of cmd.TS) the delivered commands
+	// are remembered to skip duplicates. It covers redeliveries after
+	// the stream resume and clock skew between the clients.
+	cmdSeenWindow = 300
+)
+
+// errChangeStreamHistoryLost is returned by mongod when the resume token
+// is no longer in the oplog
+const errChangeStreamHistoryLost = 286
+
+// ListenCmd returns the channel of the commands sent to the agents.
+// It watches the cmd stream via the change stream and resumes after
+// the last received event in case of failures (e.g. primary failover).
+// If the change stream isn't available or can't be resumed, it falls back
+// to polling the cmd collection and retries the change stream every
+// cmdWatchRetryInterval. Each command is delivered only once.
 func (p *PBM) ListenCmd(cl <-chan struct{}) (<-chan Cmd, <-chan error) {
 	cmd := make(chan Cmd)
 	errc := make(chan error)
@@ -24,64 +54,299 @@ func (p *PBM) ListenCmd(cl <-chan struct{}) (<-chan Cmd, <-chan error) {
 		defer close(cmd)
 		defer close(errc)
 
-		ts := time.Now().UTC().Unix()
-		var lastTs int64
-		var lastCmd Command
-		for {
+		ctx, cancel := context.WithCancel(p.ctx)
+		defer cancel()
+		go func() {
 			select {
 			case <-cl:
-				return
-			default:
-			}
-			cur, err := p.Conn.Database(DB).Collection(CmdStreamCollection).Find(
-				p.ctx,
-				bson.M{"ts": bson.M{"$gte": ts}},
-			)
-			if err != nil {
-				errc <- errors.Wrap(err, "watch the cmd stream")
-				continue
+				cancel()
+			case <-ctx.Done():
 			}
+		}()
+
+		l := &cmdListener{
+			p:    p,
+			cmd:  cmd,
+			errc: errc,
+			ts:   time.Now().UTC().Unix(),
+			seen: make(map[OPID]int64),
+		}
+		l.setStartAt(ctx)
 
-			for cur.Next(p.ctx) {
-				c := Cmd{}
-				err := cur.Decode(&c)
-				if err != nil {
-					errc <- errors.Wrap(err, "message decode")
-					continue
-				}
-
-				if c.Cmd == lastCmd && c.TS == lastTs {
-					continue
-				}
-
-				opid, ok := cur.Current.Lookup("_id").ObjectIDOK()
-				if !ok {
-					errc <- errors.New("unable to get operation ID")
-					continue
-				}
-
-				c.OPID = OPID(opid)
-
-				lastCmd = c.Cmd
-				lastTs = c.TS
-				cmd <- c
-				ts = time.Now().UTC().Unix()
+		for {
+			err := l.watch(ctx)
+			if err == nil || ctx.Err() != nil {
+				return
 			}
-			if cur.Err() != nil {
-				errc <- ErrorCursor{cerr: cur.Err()}
-				cur.Close(p.ctx)
+
+			l.sendErr(ctx, errors.WithMessage(err, "watch the cmd stream, fallback to polling"))
+			if !l.poll(ctx, cmdWatchRetryInterval) {
 				return
 			}
-			cur.Close(p.ctx)
-			time.Sleep(time.Second * 1)
 		}
 	}()
 
 	return cmd, errc
 }
 
+type cmdListener struct {
+	p    *PBM
+	cmd  chan<- Cmd
+	errc chan<- error
+
+	// ts is the lower bound (cmd.TS) of the commands yet to be delivered
+	ts int64
+	// startAt is the cluster time the change stream starts at
+	// unless it's resumed after the received event
+	startAt primitive.Timestamp
+	// seen is the set of already delivered commands
+	// with cmd.TS >= ts-cmdSeenWindow
+	seen map[OPID]int64
+}
+
+// deliver sends the command to the listener unless it was already delivered.
+// It returns false if the context was canceled.
+func (l *cmdListener) deliver(ctx context.Context, c Cmd) bool {
+	if _, ok := l.seen[c.OPID]; ok {
+		return true
+	}
+
+	select {
+	case l.cmd <- c:
+	case <-ctx.Done():
+		return false
+	}
+
+	l.seen[c.OPID] = c.TS
+	if c.TS > l.ts {
+		l.ts = c.TS
+		for id, ts := range l.seen {
+			if ts < l.ts-cmdSeenWindow {
+				delete(l.seen, id)
+			}
+		}
+	}
+
+	return true
+}
+
+// setStartAt sets the start of the change stream to the current cluster
+// time. So the commands sent after this point aren't missed. It keeps the
+// previous value on error. That may bring duplicates only.
+func (l *cmdListener) setStartAt(ctx context.Context) {
+	ts, err := l.p.ClusterTime()
+	if err != nil {
+		l.sendErr(ctx, errors.WithMessage(err, "get cluster time"))
+		return
+	}
+
+	l.startAt = ts
+}
+
+func (l *cmdListener) sendErr(ctx context.Context, err error) {
+	select {
+	case l.errc <- err:
+	case <-ctx.Done():
+	}
+}
+
+// watch delivers commands via the change stream. It returns nil if the
+// context was canceled or an error if the stream can't be (re)opened.
+func (l *cmdListener) watch(ctx context.Context) error {
+	coll := l.p.Conn.Database(DB).Collection(CmdStreamCollection)
+	pipeline := mongo.Pipeline{{{"$match", bson.D{{"operationType", "insert"}}}}}
+
+	var token bson.Raw
+	attempts := 0
+	for {
+		opts := options.ChangeStream()
+		if token != nil {
+			opts.SetResumeAfter(token)
+		} else if !l.startAt.IsZero() {
+			opts.SetStartAtOperationTime(&l.startAt)
+		}
+
+		cs, err := coll.Watch(ctx, pipeline, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if token == nil || attempts >= cmdStreamResumeAttempts || isHistoryLost(err) {
+				return errors.Wrap(err, "open change stream")
+			}
+
+			attempts++
+			select {
+			case <-time.After(time.Second * time.Duration(attempts)):
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+		attempts = 0
+		if t := cs.ResumeToken(); t != nil {
+			token = t
+		}
+
+		for cs.Next(ctx) {
+			token = cs.ResumeToken()
+
+			e := struct {
+				Doc bson.Raw `bson:"fullDocument"`
+			}{}
+			if err := cs.Decode(&e); err != nil {
+				l.sendErr(ctx, errors.Wrap(err, "event decode"))
+				continue
+			}
+
+			c, err := decodeCmd(e.Doc)
+			if err != nil {
+				l.sendErr(ctx, err)
+				continue
+			}
+
+			if !l.deliver(ctx, c) {
+				break
+			}
+		}
+
+		if t := cs.ResumeToken(); t != nil {
+			token = t
+		}
+		err = cs.Err()
+		cs.Close(context.Background())
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			err = errors.New("change stream was closed")
+		}
+		if token == nil || isHistoryLost(err) {
+			return err
+		}
+
+		l.sendErr(ctx, errors.WithMessage(err, "change stream was interrupted, resuming"))
+	}
+}
+
+// poll delivers commands by re-reading the cmd stream collection
+// every cmdPollInterval for the duration d. The change stream opened
+// after that starts at the last poll. It returns false if the context
+// was canceled or the cmd stream can't be read.
+func (l *cmdListener) poll(ctx context.Context, d time.Duration) bool {
+	tk := time.NewTicker(cmdPollInterval)
+	defer tk.Stop()
+	until := time.After(d)
+
+	for {
+		if !l.pollOnce(ctx) {
+			return false
+		}
+
+		select {
+		case <-tk.C:
+		case <-until:
+			l.setStartAt(ctx)
+			return l.pollOnce(ctx)
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (l *cmdListener) pollOnce(ctx context.Context) bool {
+	coll := l.p.Conn.Database(DB).Collection(CmdStreamCollection)
+
+	cur, err := coll.Find(ctx, bson.M{"ts": bson.M{"$gte": l.ts}})
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		l.sendErr(ctx, errors.Wrap(err, "watch the cmd stream"))
+		return true
+	}
+
+	for cur.Next(ctx) {
+		c, err := decodeCmd(cur.Current)
+		if err != nil {
+			l.sendErr(ctx, err)
+			continue
+		}
+
+		if !l.deliver(ctx, c) {
+			break
+		}
+	}
+
+	err = cur.Err()
+	cur.Close(context.Background())
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		l.sendErr(ctx, ErrorCursor{cerr: err})
+		return false
+	}
+
+	return true
+}
+
+func decodeCmd(raw bson.Raw) (Cmd, error) {
+	c := Cmd{}
+	if err := bson.Unmarshal(raw, &c); err != nil {
+		return c, errors.Wrap(err, "message decode")
+	}
+
+	opid, ok := raw.Lookup("_id").ObjectIDOK()
+	if !ok {
+		return c, errors.New("unable to get operation ID")
+	}
+	c.OPID = OPID(opid)
+
+	return c, nil
+}
+
+func isHistoryLost(err error) bool {
+	var se mongo.ServerError
+	return errors.As(err, &se) && se.HasErrorCode(errChangeStreamHistoryLost)
+}
+
 func (p *PBM) SendCmd(cmd Cmd) error {
-	cmd.TS = time.Now().UTC().Unix()
-	_, err := p.Conn.Database(DB).Collection(CmdStreamCollection).InsertOne(p.ctx, cmd)
+	_, err := p.SendCmdOp(cmd)
 	return err
 }
+
+// SendCmdOp sends the command to the agents and returns
+// the ID of the operation it starts
+func (p *PBM) SendCmdOp(cmd Cmd) (OPID, error) {
+	cmd.TS = time.Now().UTC().Unix()
+	res, err := p.Conn.Database(DB).Collection(CmdStreamCollection).InsertOne(p.ctx, cmd)
+	if err != nil {
+		return OPID(primitive.NilObjectID), err
+	}
+
+	id, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return OPID(primitive.NilObjectID), errors.New("unable to get operation ID")
+	}
+
+	return OPID(id), nil
+}
+
+// GetCmd returns the command which started the operation or ErrNotFound
+// if there is no such command (the cmd stream is a capped collection)
+func (p *PBM) GetCmd(opid OPID) (*Cmd, error) {
+	raw, err := p.Conn.Database(DB).Collection(CmdStreamCollection).FindOne(
+		p.ctx,
+		bson.D{{"_id", opid.Obj()}},
+	).DecodeBytes()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "get")
+	}
+
+	c, err := decodeCmd(raw)
+	return &c, err
+}
@@ -0,0 +1,58 @@
+package pbm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCmdListenerDeliver(t *testing.T) {
+	cmdc := make(chan Cmd, 10)
+	l := &cmdListener{cmd: cmdc, ts: 100, seen: make(map[OPID]int64)}
+
+	bcp := Cmd{Cmd: CmdBackup, TS: 100, OPID: OPID(primitive.NewObjectID())}
+	bcp2 := Cmd{Cmd: CmdBackup, TS: 100, OPID: OPID(primitive.NewObjectID())}
+	del := Cmd{Cmd: CmdDeleteBackup, TS: 101, OPID: OPID(primitive.NewObjectID())}
+	cln := Cmd{Cmd: CmdCleanup, TS: 101 + cmdSeenWindow, OPID: OPID(primitive.NewObjectID())}
+
+	// the same command may come from both the change stream and the polling
+	for _, c := range []Cmd{bcp, bcp2, bcp, del, bcp2, del, cln} {
+		if !l.deliver(context.Background(), c) {
+			t.Fatal("unexpected cancel")
+		}
+	}
+	close(cmdc)
+
+	var got []OPID
+	for c := range cmdc {
+		got = append(got, c.OPID)
+	}
+	want := []OPID{bcp.OPID, bcp2.OPID, del.OPID, cln.OPID}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if l.ts != cln.TS {
+		t.Errorf("expected ts %d, got %d", cln.TS, l.ts)
+	}
+	// the backups are out of the window now
+	if len(l.seen) != 2 {
+		t.Errorf("expected seen to be pruned to 2 cmds, got %d", len(l.seen))
+	}
+}
+
+func TestCmdListenerDeliverCanceled(t *testing.T) {
+	l := &cmdListener{cmd: make(chan Cmd), seen: make(map[OPID]int64)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if l.deliver(ctx, Cmd{Cmd: CmdBackup, OPID: OPID(primitive.NewObjectID())}) {
+		t.Error("expected deliver to be canceled")
+	}
+	if len(l.seen) != 0 {
+		t.Error("canceled cmd shouldn't be marked as delivered")
+	}
+}
@@ -3,7 +3,9 @@ package pbm
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"reflect"
 	"strconv"
 	"strings"
@@ -17,46 +19,126 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/cron"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/metrics"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/azure"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/blackhole"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/gcs"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
 )
 
 // Config is a pbm config
 type Config struct {
-	PITR    PITRConf            `bson:"pitr" json:"pitr" yaml:"pitr"`
-	Storage StorageConf         `bson:"storage" json:"storage" yaml:"storage"`
-	Restore RestoreConf         `bson:"restore" json:"restore,omitempty" yaml:"restore,omitempty"`
-	Backup  BackupConf          `bson:"backup" json:"backup,omitempty" yaml:"backup,omitempty"`
-	Epoch   primitive.Timestamp `bson:"epoch" json:"-" yaml:"-"`
+	PITR    PITRConf    `bson:"pitr" json:"pitr" yaml:"pitr"`
+	Storage StorageConf `bson:"storage" json:"storage" yaml:"storage"`
+	// Profiles are additional named storages backups can be saved to.
+	// PITR chunks are always saved to the main storage.
+	Profiles []StorageProfile `bson:"profiles,omitempty" json:"profiles,omitempty" yaml:"profiles,omitempty"`
+	Restore  RestoreConf      `bson:"restore" json:"restore,omitempty" yaml:"restore,omitempty"`
+	Backup   BackupConf       `bson:"backup" json:"backup,omitempty" yaml:"backup,omitempty"`
+	// Encryption is the client-side encryption of the backup data
+	Encryption *crypt.Conf `bson:"encryption,omitempty" json:"encryption,omitempty" yaml:"encryption,omitempty"`
+	// Schedule is a list of backups the cluster leader agent starts periodically
+	Schedule []ScheduleConf `bson:"schedule,omitempty" json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	// Retention is applied by agents after each successful backup
+	Retention *RetentionConf `bson:"retention,omitempty" json:"retention,omitempty" yaml:"retention,omitempty"`
+	// Notifications are sent by agents when operations finish
+	Notifications *NotificationsConf  `bson:"notifications,omitempty" json:"notifications,omitempty" yaml:"notifications,omitempty"`
+	Epoch         primitive.Timestamp `bson:"epoch" json:"-" yaml:"-"`
 }
 
-func (c Config) String() string {
-	if c.Storage.S3.Credentials.AccessKeyID != "" {
-		c.Storage.S3.Credentials.AccessKeyID = "***"
+// redactEncryption returns a copy of the encryption config with secrets hidden
+func redactEncryption(e *crypt.Conf) *crypt.Conf {
+	if e == nil || e.Vault == nil {
+		return e
+	}
+
+	c := *e
+	v := *e.Vault
+	if v.Token != "" {
+		v.Token = "***"
+	}
+	c.Vault = &v
+	return &c
+}
+
+// redactNotifications returns a copy of the notifications config with
+// secrets and headers values hidden
+func redactNotifications(n *NotificationsConf) *NotificationsConf {
+	if n == nil {
+		return n
+	}
+
+	c := *n
+	c.Webhooks = make([]WebhookConf, len(n.Webhooks))
+	for i, h := range n.Webhooks {
+		if h.Secret != "" {
+			h.Secret = "***"
+		}
+		if len(h.Headers) != 0 {
+			hdr := make(map[string]string, len(h.Headers))
+			for k := range h.Headers {
+				hdr[k] = "***"
+			}
+			h.Headers = hdr
+		}
+		c.Webhooks[i] = h
+	}
+	return &c
+}
+
+// redactStorage hides secrets of the storage config
+func redactStorage(s *StorageConf) {
+	if s.S3.Credentials.AccessKeyID != "" {
+		s.S3.Credentials.AccessKeyID = "***"
+	}
+	if s.S3.Credentials.SecretAccessKey != "" {
+		s.S3.Credentials.SecretAccessKey = "***"
 	}
-	if c.Storage.S3.Credentials.SecretAccessKey != "" {
-		c.Storage.S3.Credentials.SecretAccessKey = "***"
+	if s.S3.Credentials.SessionToken != "" {
+		s.S3.Credentials.SessionToken = "***"
 	}
-	if c.Storage.S3.Credentials.SessionToken != "" {
-		c.Storage.S3.Credentials.SessionToken = "***"
+	if s.S3.Credentials.Vault.Secret != "" {
+		s.S3.Credentials.Vault.Secret = "***"
 	}
-	if c.Storage.S3.Credentials.Vault.Secret != "" {
-		c.Storage.S3.Credentials.Vault.Secret = "***"
+	if s.S3.Credentials.Vault.Token != "" {
+		s.S3.Credentials.Vault.Token = "***"
 	}
-	if c.Storage.S3.Credentials.Vault.Token != "" {
-		c.Storage.S3.Credentials.Vault.Token = "***"
+	if s.S3.ServerSideEncryption != nil &&
+		s.S3.ServerSideEncryption.SseCustomerKey != "" {
+		sse := *s.S3.ServerSideEncryption
+		sse.SseCustomerKey = "***"
+		s.S3.ServerSideEncryption = &sse
 	}
-	if c.Storage.S3.ServerSideEncryption != nil &&
-		c.Storage.S3.ServerSideEncryption.SseCustomerKey != "" {
-		c.Storage.S3.ServerSideEncryption.SseCustomerKey = "***"
+	if s.Azure.Credentials.Key != "" {
+		s.Azure.Credentials.Key = "***"
 	}
-	if c.Storage.Azure.Credentials.Key != "" {
-		c.Storage.Azure.Credentials.Key = "***"
+	if s.GCS.Credentials.PrivateKey != "" {
+		s.GCS.Credentials.PrivateKey = "***"
+	}
+}
+
+// redact hides secrets of the config
+func (c *Config) redact() {
+	redactStorage(&c.Storage)
+	if len(c.Profiles) != 0 {
+		profiles := make([]StorageProfile, len(c.Profiles))
+		for i, p := range c.Profiles {
+			redactStorage(&p.StorageConf)
+			profiles[i] = p
+		}
+		c.Profiles = profiles
 	}
+	c.Encryption = redactEncryption(c.Encryption)
+	c.Notifications = redactNotifications(c.Notifications)
+}
+
+func (c Config) String() string {
+	c.redact()
 
 	b, err := yaml.Marshal(c)
 	if err != nil {
@@ -73,6 +155,8 @@ type PITRConf struct {
 	OplogOnly        bool                     `bson:"oplogOnly,omitempty" json:"oplogOnly,omitempty" yaml:"oplogOnly,omitempty"`
 	Compression      compress.CompressionType `bson:"compression,omitempty" json:"compression,omitempty" yaml:"compression,omitempty"`
 	CompressionLevel *int                     `bson:"compressionLevel,omitempty" json:"compressionLevel,omitempty" yaml:"compressionLevel,omitempty"`
+	// Throttle limits the storage traffic of oplog slicing
+	Throttle *storage.Limits `bson:"throttle,omitempty" json:"throttle,omitempty" yaml:"throttle,omitempty"`
 }
 
 // StorageConf is a configuration of the backup storage
@@ -80,6 +164,7 @@ type StorageConf struct {
 	Type       storage.Type `bson:"type" json:"type" yaml:"type"`
 	S3         s3.Conf      `bson:"s3,omitempty" json:"s3,omitempty" yaml:"s3,omitempty"`
 	Azure      azure.Conf   `bson:"azure,omitempty" json:"azure,omitempty" yaml:"azure,omitempty"`
+	GCS        gcs.Conf     `bson:"gcs,omitempty" json:"gcs,omitempty" yaml:"gcs,omitempty"`
 	Filesystem fs.Conf      `bson:"filesystem,omitempty" json:"filesystem,omitempty" yaml:"filesystem,omitempty"`
 }
 
@@ -89,6 +174,8 @@ func (s *StorageConf) Typ() string {
 		return "S3"
 	case storage.Azure:
 		return "Azure"
+	case storage.GCS:
+		return "GCS"
 	case storage.Filesystem:
 		return "FS"
 	case storage.BlackHole:
@@ -115,6 +202,11 @@ func (s *StorageConf) Path() string {
 		if s.Azure.Prefix != "" {
 			path += "/" + s.Azure.Prefix
 		}
+	case storage.GCS:
+		path = "gs://" + s.GCS.Bucket
+		if s.GCS.Prefix != "" {
+			path += "/" + s.GCS.Prefix
+		}
 	case storage.Filesystem:
 		path = s.Filesystem.Path
 	case storage.BlackHole:
@@ -124,6 +216,80 @@ func (s *StorageConf) Path() string {
 	return path
 }
 
+// StorageProfile is a named storage backups can be saved to
+// instead of the main one
+type StorageProfile struct {
+	Name        string `bson:"name" json:"name" yaml:"name"`
+	StorageConf `bson:",inline" json:",inline" yaml:",inline"`
+}
+
+func validateProfiles(profiles []StorageProfile) error {
+	names := make(map[string]struct{}, len(profiles))
+	for i := range profiles {
+		p := &profiles[i]
+		if p.Name == "" {
+			return errors.New("name should be set")
+		}
+		if strings.ContainsAny(p.Name, "./$ ") {
+			return errors.Errorf("%s: name should not contain '.', '/', '$' or spaces", p.Name)
+		}
+		if _, ok := names[p.Name]; ok {
+			return errors.Errorf("%s: duplicate name", p.Name)
+		}
+		names[p.Name] = struct{}{}
+
+		switch p.Type {
+		case storage.S3:
+			err := p.S3.Cast()
+			if err != nil {
+				return errors.Wrapf(err, "%s: cast storage", p.Name)
+			}
+		case storage.Filesystem:
+			err := p.Filesystem.Cast()
+			if err != nil {
+				return errors.Wrapf(err, "%s: check config", p.Name)
+			}
+		case storage.Azure, storage.GCS, storage.BlackHole:
+		default:
+			return errors.Errorf("%s: unknown storage type %q", p.Name, p.Type)
+		}
+	}
+
+	return nil
+}
+
+// ErrProfileNotFound is returned for an unknown storage profile
+var ErrProfileNotFound = errors.New("storage profile not found")
+
+// Profile returns the storage config of the named profile.
+// An empty name stands for the main storage.
+func (c Config) Profile(name string) (StorageConf, error) {
+	if name == "" {
+		return c.Storage, nil
+	}
+
+	for _, p := range c.Profiles {
+		if p.Name == name {
+			return p.StorageConf, nil
+		}
+	}
+
+	return StorageConf{}, errors.Wrap(ErrProfileNotFound, name)
+}
+
+// WithProfile returns the config with the named profile as the storage.
+// So the storages created from it (Storage, ThrottledStorage)
+// point to the profile.
+func (c Config) WithProfile(name string) (Config, error) {
+	stg, err := c.Profile(name)
+	if err != nil {
+		return c, err
+	}
+
+	c.Storage = stg
+	return c, nil
+}
+
 // RestoreConf is config options for the restore
 type RestoreConf struct {
 	// Logical restore
@@ -144,12 +310,183 @@ type RestoreConf struct {
 	// physical restore. Will try $PATH/mongod if not set.
 	MongodLocation    string            `bson:"mongodLocation" json:"mongodLocation,omitempty" yaml:"mongodLocation,omitempty"`
 	MongodLocationMap map[string]string `bson:"mongodLocationMap" json:"mongodLocationMap,omitempty" yaml:"mongodLocationMap,omitempty"`
+
+	// Throttle limits the storage traffic of restores
+	Throttle *storage.Limits `bson:"throttle,omitempty" json:"throttle,omitempty" yaml:"throttle,omitempty"`
 }
 
 type BackupConf struct {
 	Priority         map[string]float64       `bson:"priority,omitempty" json:"priority,omitempty" yaml:"priority,omitempty"`
 	Compression      compress.CompressionType `bson:"compression,omitempty" json:"compression,omitempty" yaml:"compression,omitempty"`
 	CompressionLevel *int                     `bson:"compressionLevel,omitempty" json:"compressionLevel,omitempty" yaml:"compressionLevel,omitempty"`
+	// Throttle limits the storage traffic of backups
+	Throttle *storage.Limits `bson:"throttle,omitempty" json:"throttle,omitempty" yaml:"throttle,omitempty"`
+	// AdaptiveThrottle slows logical backups down when the source node
+	// is under pressure
+	AdaptiveThrottle *AdaptiveThrottleConf `bson:"adaptiveThrottle,omitempty" json:"adaptiveThrottle,omitempty" yaml:"adaptiveThrottle,omitempty"`
+}
+
+// AdaptiveThrottleConf sets the thresholds of the source node load which
+// make a logical backup slow down the reading of collections. Zero value
+// disables the respective check.
+type AdaptiveThrottleConf struct {
+	// MaxReplLag is the replication lag of the node in seconds
+	MaxReplLag int `bson:"maxReplLag,omitempty" json:"maxReplLag,omitempty" yaml:"maxReplLag,omitempty"`
+	// MaxCacheUsed is the WiredTiger cache fill ratio in percents
+	MaxCacheUsed float64 `bson:"maxCacheUsed,omitempty" json:"maxCacheUsed,omitempty" yaml:"maxCacheUsed,omitempty"`
+	// MaxCacheDirty is the WiredTiger cache dirty data ratio in percents
+	MaxCacheDirty float64 `bson:"maxCacheDirty,omitempty" json:"maxCacheDirty,omitempty" yaml:"maxCacheDirty,omitempty"`
+}
+
+// Enabled returns true if any of the thresholds is set
+func (c *AdaptiveThrottleConf) Enabled() bool {
+	return c != nil && (c.MaxReplLag > 0 || c.MaxCacheUsed > 0 || c.MaxCacheDirty > 0)
+}
+
+func validateAdaptiveThrottle(c *AdaptiveThrottleConf) error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxReplLag < 0 {
+		return errors.New("maxReplLag can't be negative")
+	}
+	if c.MaxCacheUsed < 0 || c.MaxCacheUsed > 100 {
+		return errors.New("maxCacheUsed should be in the range [0, 100]")
+	}
+	if c.MaxCacheDirty < 0 || c.MaxCacheDirty > 100 {
+		return errors.New("maxCacheDirty should be in the range [0, 100]")
+	}
+
+	return nil
+}
+
+// NotificationsConf configures the notifications about operations
+// reaching a terminal state (done, error or canceled)
+type NotificationsConf struct {
+	Webhooks []WebhookConf `bson:"webhooks,omitempty" json:"webhooks,omitempty" yaml:"webhooks,omitempty"`
+}
+
+// WebhookConf is an HTTP endpoint the notifications are POSTed to
+type WebhookConf struct {
+	URL     string            `bson:"url" json:"url" yaml:"url"`
+	Headers map[string]string `bson:"headers,omitempty" json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Secret (if set) is the key of the HMAC-SHA256 signature
+	// of the payload sent in the X-PBM-Signature header
+	Secret string `bson:"secret,omitempty" json:"secret,omitempty" yaml:"secret,omitempty"`
+	// Events filters the notifications to send. Entries are operations
+	// (e.g. "backup") or "<operation>.<status>" patterns (e.g. "*.error").
+	// Empty means all.
+	Events []string `bson:"events,omitempty" json:"events,omitempty" yaml:"events,omitempty"`
+}
+
+func validateNotifications(n *NotificationsConf) error {
+	if n == nil {
+		return nil
+	}
+
+	for i, h := range n.Webhooks {
+		u, err := url.Parse(h.URL)
+		if err != nil {
+			return errors.Wrapf(err, "webhook %d: parse url", i)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return errors.Errorf("webhook %d: url should be http(s)://host[/path]", i)
+		}
+		for _, e := range h.Events {
+			if _, err := path.Match(e, ""); err != nil {
+				return errors.Errorf("webhook %d: invalid event pattern %q", i, e)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ScheduleConf is an entry of the backup schedule
+type ScheduleConf struct {
+	// Name identifies the entry. It should be unique.
+	Name string `bson:"name" json:"name" yaml:"name"`
+	// Cron is a cron expression (in UTC) of the backup start times
+	Cron             string                   `bson:"cron" json:"cron" yaml:"cron"`
+	Type             BackupType               `bson:"type,omitempty" json:"type,omitempty" yaml:"type,omitempty"`
+	IncrBase         bool                     `bson:"incrBase,omitempty" json:"incrBase,omitempty" yaml:"incrBase,omitempty"`
+	Compression      compress.CompressionType `bson:"compression,omitempty" json:"compression,omitempty" yaml:"compression,omitempty"`
+	CompressionLevel *int                     `bson:"compressionLevel,omitempty" json:"compressionLevel,omitempty" yaml:"compressionLevel,omitempty"`
+	Namespaces       []string                 `bson:"namespaces,omitempty" json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	// Profile is the storage profile to save the backups to.
+	// Empty means the main storage.
+	Profile string `bson:"profile,omitempty" json:"profile,omitempty" yaml:"profile,omitempty"`
+}
+
+func validateSchedule(sch []ScheduleConf, profiles []StorageProfile) error {
+	names := make(map[string]struct{}, len(sch))
+	for _, e := range sch {
+		if e.Name == "" {
+			return errors.New("name should be set")
+		}
+		if _, ok := names[e.Name]; ok {
+			return errors.Errorf("%s: duplicate name", e.Name)
+		}
+		names[e.Name] = struct{}{}
+
+		if _, err := cron.Parse(e.Cron); err != nil {
+			return errors.Wrapf(err, "%s: parse cron", e.Name)
+		}
+
+		switch e.Type {
+		case "", LogicalBackup:
+		case PhysicalBackup, IncrementalBackup:
+			if len(e.Namespaces) != 0 {
+				return errors.Errorf("%s: namespaces are not allowed for %s backup", e.Name, e.Type)
+			}
+		default:
+			return errors.Errorf("%s: unknown backup type %q", e.Name, e.Type)
+		}
+		if e.IncrBase && e.Type != IncrementalBackup {
+			return errors.Errorf("%s: incrBase is allowed for incremental backup only", e.Name)
+		}
+
+		if c := string(e.Compression); c != "" && !compress.IsValidCompressionType(c) {
+			return errors.Errorf("%s: unsupported compression type: %q", e.Name, c)
+		}
+		if _, err := (Config{Profiles: profiles}).Profile(e.Profile); err != nil {
+			return errors.Wrapf(err, "%s: profile", e.Name)
+		}
+	}
+
+	return nil
+}
+
+// RetentionConf is a retention policy of backups and PITR chunks.
+// The most recent backup of each of the last Daily days, Weekly weeks and
+// Monthly months is kept. And PITR chunks (along with the base snapshot)
+// that allow restoring to any point within the last PITRDays days.
+type RetentionConf struct {
+	Daily    int `bson:"daily,omitempty" json:"daily,omitempty" yaml:"daily,omitempty"`
+	Weekly   int `bson:"weekly,omitempty" json:"weekly,omitempty" yaml:"weekly,omitempty"`
+	Monthly  int `bson:"monthly,omitempty" json:"monthly,omitempty" yaml:"monthly,omitempty"`
+	PITRDays int `bson:"pitrDays,omitempty" json:"pitrDays,omitempty" yaml:"pitrDays,omitempty"`
+}
+
+// Enabled returns true if any of the policy's rules is set
+func (r *RetentionConf) Enabled() bool {
+	return r != nil && (r.GFS() || r.PITRDays > 0)
+}
+
+// GFS returns true if any of the backups rules (daily, weekly, monthly) is set
+func (r *RetentionConf) GFS() bool {
+	return r.Daily > 0 || r.Weekly > 0 || r.Monthly > 0
+}
+
+func validateRetention(r *RetentionConf) error {
+	if r == nil {
+		return nil
+	}
+	if r.Daily < 0 || r.Weekly < 0 || r.Monthly < 0 || r.PITRDays < 0 {
+		return errors.New("values can't be negative")
+	}
+
+	return nil
 }
 
 type confMap map[string]reflect.Kind
@@ -213,6 +550,31 @@ func (p *PBM) SetConfig(cfg Config) error {
 		return errors.Errorf("unsupported compression type: %q", c)
 	}
 
+	if err := validateProfiles(cfg.Profiles); err != nil {
+		return errors.Wrap(err, "check profiles")
+	}
+	if err := validateSchedule(cfg.Schedule, cfg.Profiles); err != nil {
+		return errors.Wrap(err, "check schedule")
+	}
+	if err := validateRetention(cfg.Retention); err != nil {
+		return errors.Wrap(err, "check retention")
+	}
+	for k, t := range map[string]*storage.Limits{
+		"backup.throttle":  cfg.Backup.Throttle,
+		"pitr.throttle":    cfg.PITR.Throttle,
+		"restore.throttle": cfg.Restore.Throttle,
+	} {
+		if err := ValidateLimits(t); err != nil {
+			return errors.WithMessage(err, k)
+		}
+	}
+	if err := validateAdaptiveThrottle(cfg.Backup.AdaptiveThrottle); err != nil {
+		return errors.WithMessage(err, "backup.adaptiveThrottle")
+	}
+	if err := validateNotifications(cfg.Notifications); err != nil {
+		return errors.WithMessage(err, "check notifications")
+	}
+
 	ct, err := p.ClusterTime()
 	if err != nil {
 		return errors.Wrap(err, "get cluster time")
@@ -276,6 +638,24 @@ func (p *PBM) SetConfigVar(key, val string) error {
 		}
 	case "storage.s3.debugLogLevels":
 		s3.SDKLogLevel(v.(string), os.Stderr)
+	case "retention.daily", "retention.weekly", "retention.monthly", "retention.pitrDays":
+		if v.(int64) < 0 {
+			return errors.Errorf("%s can't be negative", key)
+		}
+	case "backup.throttle.maxMBps", "backup.throttle.maxRPS",
+		"pitr.throttle.maxMBps", "pitr.throttle.maxRPS",
+		"restore.throttle.maxMBps", "restore.throttle.maxRPS":
+		if v.(float64) < 0 {
+			return errors.Errorf("%s can't be negative", key)
+		}
+	case "backup.adaptiveThrottle.maxReplLag":
+		if v.(int64) < 0 {
+			return errors.Errorf("%s can't be negative", key)
+		}
+	case "backup.adaptiveThrottle.maxCacheUsed", "backup.adaptiveThrottle.maxCacheDirty":
+		if f := v.(float64); f < 0 || f > 100 {
+			return errors.Errorf("%s should be in the range [0, 100]", key)
+		}
 	}
 
 	_, err = p.Conn.Database(DB).Collection(ConfigCollection).UpdateOne(
@@ -366,24 +746,7 @@ func (p *PBM) GetConfigYaml(fieldRedaction bool) ([]byte, error) {
 	}
 
 	if fieldRedaction {
-		if c.Storage.S3.Credentials.AccessKeyID != "" {
-			c.Storage.S3.Credentials.AccessKeyID = "***"
-		}
-		if c.Storage.S3.Credentials.SecretAccessKey != "" {
-			c.Storage.S3.Credentials.SecretAccessKey = "***"
-		}
-		if c.Storage.S3.Credentials.SessionToken != "" {
-			c.Storage.S3.Credentials.SessionToken = "***"
-		}
-		if c.Storage.S3.Credentials.Vault.Secret != "" {
-			c.Storage.S3.Credentials.Vault.Secret = "***"
-		}
-		if c.Storage.S3.Credentials.Vault.Token != "" {
-			c.Storage.S3.Credentials.Vault.Token = "***"
-		}
-		if c.Storage.Azure.Credentials.Key != "" {
-			c.Storage.Azure.Credentials.Key = "***"
-		}
+		c.redact()
 	}
 
 	b, err := yaml.Marshal(c)
@@ -432,13 +795,154 @@ func (p *PBM) GetStorage(l *log.Event) (storage.Storage, error) {
 	return Storage(c, l)
 }
 
-// Storage creates and returns a storage object based on a given config
+// GetBackupStorage creates the storage the backup is kept on
+func (p *PBM) GetBackupStorage(bcp *BackupMeta, l *log.Event) (storage.Storage, error) {
+	c, err := p.GetConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "get config")
+	}
+
+	return BackupStorage(c, bcp, l)
+}
+
+// BackupStorage creates the storage of the backup's profile
+func BackupStorage(c Config, bcp *BackupMeta, l *log.Event) (storage.Storage, error) {
+	c, err := c.WithProfile(bcp.Store.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return Storage(c, l)
+}
+
+// Storages creates the storages of the config profiles on demand
+// and reuses them. It isn't safe for concurrent use.
+type Storages struct {
+	c Config
+	l *log.Event
+	m map[string]storage.Storage
+}
+
+func NewStorages(c Config, l *log.Event) *Storages {
+	return &Storages{c: c, l: l, m: make(map[string]storage.Storage)}
+}
+
+// Get returns the storage of the profile. Empty name is the main storage.
+func (s *Storages) Get(profile string) (storage.Storage, error) {
+	if stg, ok := s.m[profile]; ok {
+		return stg, nil
+	}
+
+	c, err := s.c.WithProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	stg, err := Storage(c, s.l)
+	if err != nil {
+		return nil, err
+	}
+
+	s.m[profile] = stg
+	return stg, nil
+}
+
+// Backup returns the storage the backup is kept on
+func (s *Storages) Backup(bcp *BackupMeta) (storage.Storage, error) {
+	return s.Get(bcp.Store.Profile)
+}
+
+// GetKeyProvider returns the encryption key provider for the current config.
+// It returns nil if encryption isn't configured.
+func (p *PBM) GetKeyProvider() (crypt.KeyProvider, error) {
+	c, err := p.GetConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "get config")
+	}
+
+	return crypt.NewKeyProvider(c.Encryption)
+}
+
+// Storage creates and returns a storage object based on a given config.
+// Transferred bytes are counted in the storage metrics.
 func Storage(c Config, l *log.Event) (storage.Storage, error) {
+	stg, err := newStorage(c, l)
+	if err != nil {
+		return nil, err
+	}
+
+	t := string(stg.Type())
+	return storage.NewMetered(stg,
+		metrics.StorageUploadedBytes.With(t).Add,
+		metrics.StorageDownloadedBytes.With(t).Add), nil
+}
+
+// Kinds of the storage traffic limited separately
+const (
+	ThrottleBackup  = "backup"
+	ThrottlePITR    = "pitr"
+	ThrottleRestore = "restore"
+)
+
+// ThrottledStorage creates the storage the same way Storage does and
+// limits its traffic by the config limits of the given kind (operation
+// type). Non-zero limits set by the command (if any) take precedence.
+// The limits are shared by all storages of the kind created in this
+// process, hence they are enforced per agent.
+func ThrottledStorage(c Config, kind string, cmd *storage.Limits, l *log.Event) (storage.Storage, error) {
+	stg, err := Storage(c, l)
+	if err != nil {
+		return nil, err
+	}
+
+	var conf *storage.Limits
+	switch kind {
+	case ThrottleBackup:
+		conf = c.Backup.Throttle
+	case ThrottlePITR:
+		conf = c.PITR.Throttle
+	case ThrottleRestore:
+		conf = c.Restore.Throttle
+	default:
+		return nil, errors.Errorf("unknown throttle kind %q", kind)
+	}
+
+	lim := storage.Limits{}.With(conf).With(cmd)
+	return storage.NewThrottled(stg, storage.SharedLimiter(kind, lim)), nil
+}
+
+// GetThrottledStorage is ThrottledStorage for the current config
+func (p *PBM) GetThrottledStorage(kind string, cmd *storage.Limits, l *log.Event) (storage.Storage, error) {
+	c, err := p.GetConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "get config")
+	}
+
+	return ThrottledStorage(c, kind, cmd, l)
+}
+
+// ValidateLimits checks the storage traffic limits
+func ValidateLimits(l *storage.Limits) error {
+	if l == nil {
+		return nil
+	}
+	if l.MaxMBps < 0 {
+		return errors.New("maxMBps can't be negative")
+	}
+	if l.MaxRPS < 0 {
+		return errors.New("maxRPS can't be negative")
+	}
+
+	return nil
+}
+
+func newStorage(c Config, l *log.Event) (storage.Storage, error) {
 	switch c.Storage.Type {
 	case storage.S3:
 		return s3.New(c.Storage.S3, l)
 	case storage.Azure:
 		return azure.New(c.Storage.Azure, l)
+	case storage.GCS:
+		return gcs.New(c.Storage.GCS, l)
 	case storage.Filesystem:
 		return fs.New(c.Storage.Filesystem), nil
 	case storage.BlackHole:
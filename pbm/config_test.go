@@ -0,0 +1,81 @@
+package pbm
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestConfigProfile(t *testing.T) {
+	c := Config{
+		Storage: StorageConf{
+			Type:       storage.Filesystem,
+			Filesystem: fs.Conf{Path: "/main"},
+		},
+		Profiles: []StorageProfile{
+			{
+				Name: "archive",
+				StorageConf: StorageConf{
+					Type:       storage.Filesystem,
+					Filesystem: fs.Conf{Path: "/archive"},
+				},
+			},
+		},
+	}
+
+	for name, want := range map[string]string{
+		"":        "/main",
+		"archive": "/archive",
+	} {
+		pc, err := c.WithProfile(name)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", name, err)
+		}
+		if p := pc.Storage.Filesystem.Path; p != want {
+			t.Errorf("%q: got storage %q, want %q", name, p, want)
+		}
+	}
+	if c.Storage.Filesystem.Path != "/main" {
+		t.Errorf("main storage is changed: %q", c.Storage.Filesystem.Path)
+	}
+
+	_, err := c.WithProfile("unknown")
+	if !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("unknown profile: got %v, want %v", err, ErrProfileNotFound)
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	prof := func(name string) StorageProfile {
+		return StorageProfile{
+			Name: name,
+			StorageConf: StorageConf{
+				Type:       storage.Filesystem,
+				Filesystem: fs.Conf{Path: "/" + name},
+			},
+		}
+	}
+
+	cases := []struct {
+		name     string
+		profiles []StorageProfile
+		ok       bool
+	}{
+		{"none", nil, true},
+		{"valid", []StorageProfile{prof("a"), prof("b")}, true},
+		{"empty name", []StorageProfile{prof("")}, false},
+		{"dot", []StorageProfile{prof("a.b")}, false},
+		{"duplicate", []StorageProfile{prof("a"), prof("a")}, false},
+		{"no type", []StorageProfile{{Name: "a"}}, false},
+	}
+
+	for _, c := range cases {
+		err := validateProfiles(c.profiles)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected result: %v", c.name, err)
+		}
+	}
+}
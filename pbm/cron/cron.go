@@ -0,0 +1,206 @@
+// Package cron parses cron expressions and calculates their activation times.
+//
+// The expression has the standard five fields:
+//
+//	┌───────────── minute (0-59)
+//	│ ┌───────────── hour (0-23)
+//	│ │ ┌───────────── day of the month (1-31)
+//	│ │ │ ┌───────────── month (1-12 or jan-dec)
+//	│ │ │ │ ┌───────────── day of the week (0-7 or sun-sat, both 0 and 7 are Sunday)
+//	│ │ │ │ │
+//	* * * * *
+//
+// Each field is `*`, a value, a range `a-b` or a list of them separated by
+// commas. Any of `*` and ranges may have a step, e.g. `*/15` or `1-30/2`.
+// If both day fields are restricted, the spec matches when either of
+// them matches. Macros @yearly (@annually), @monthly, @weekly,
+// @daily (@midnight) and @hourly are accepted as well.
+package cron
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Spec is a parsed cron expression
+type Spec struct {
+	minute, hour, dom, month, dow uint64
+
+	// whether day fields are restricted (not `*`)
+	domSet, dowSet bool
+}
+
+type bounds struct {
+	name     string
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	minutes = bounds{"minute", 0, 59, nil}
+	hours   = bounds{"hour", 0, 23, nil}
+	doms    = bounds{"day of month", 1, 31, nil}
+	months  = bounds{"month", 1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dows = bounds{"day of week", 0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses the cron expression
+func Parse(spec string) (*Spec, error) {
+	spec = strings.TrimSpace(spec)
+	if m, ok := macros[strings.ToLower(spec)]; ok {
+		spec = m
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	s := &Spec{}
+	var err error
+	if s.minute, err = parseField(fields[0], minutes); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[1], hours); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[2], doms); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[3], months); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[4], dows); err != nil {
+		return nil, err
+	}
+
+	// 7 is Sunday as well
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	s.domSet = !strings.HasPrefix(fields[2], "*")
+	s.dowSet = !strings.HasPrefix(fields[4], "*")
+
+	return s, nil
+}
+
+// parseField returns the bitset of values matching the field
+func parseField(f string, b bounds) (uint64, error) {
+	var set uint64
+	for _, expr := range strings.Split(f, ",") {
+		rng, step := expr, uint(1)
+		if i := strings.IndexByte(expr, '/'); i != -1 {
+			rng = expr[:i]
+			n, err := strconv.ParseUint(expr[i+1:], 10, 8)
+			if err != nil || n == 0 {
+				return 0, errors.Errorf("%s: invalid step in %q", b.name, expr)
+			}
+			step = uint(n)
+		}
+
+		lo, hi := b.min, b.max
+		switch i := strings.IndexByte(rng, '-'); {
+		case rng == "*":
+		case i != -1:
+			var err error
+			if lo, err = b.value(rng[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = b.value(rng[i+1:]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, errors.Errorf("%s: invalid range %q", b.name, rng)
+			}
+		default:
+			v, err := b.value(rng)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			// `5/10` means `5-max/10`
+			if step == 1 {
+				hi = v
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+func (b bounds) value(s string) (uint, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || uint(v) < b.min || uint(v) > b.max {
+		return 0, errors.Errorf("%s: invalid value %q, should be in range %d-%d", b.name, s, b.min, b.max)
+	}
+
+	return uint(v), nil
+}
+
+// Next returns the first activation time after t. Times are calculated
+// in t's location with a minute precision. It returns zero time if there
+// is no activation within next five years (e.g. Feb 30).
+func (s *Spec) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+func (s *Spec) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domSet && s.dowSet {
+		return dom || dow
+	}
+	return dom && dow
+}
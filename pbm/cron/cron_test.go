@@ -0,0 +1,74 @@
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/cron"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2023, time.March, 15, 10, 30, 20, 0, time.UTC) // Wednesday
+
+	cases := []struct {
+		spec string
+		next string
+	}{
+		{"* * * * *", "2023-03-15T10:31:00Z"},
+		{"*/15 * * * *", "2023-03-15T10:45:00Z"},
+		{"30 10 * * *", "2023-03-16T10:30:00Z"},
+		{"0 2 * * *", "2023-03-16T02:00:00Z"},
+		{"@hourly", "2023-03-15T11:00:00Z"},
+		{"@daily", "2023-03-16T00:00:00Z"},
+		{"@weekly", "2023-03-19T00:00:00Z"},
+		{"@monthly", "2023-04-01T00:00:00Z"},
+		{"@yearly", "2024-01-01T00:00:00Z"},
+		{"0 0 * * 7", "2023-03-19T00:00:00Z"},
+		{"0 3 * * mon-fri", "2023-03-16T03:00:00Z"},
+		{"0 3 * * sat,sun", "2023-03-18T03:00:00Z"},
+		{"0 0 29 feb *", "2024-02-29T00:00:00Z"},
+		{"15-20/5 10 * * *", "2023-03-16T10:15:00Z"},
+		{"5/20 * * * *", "2023-03-15T10:45:00Z"},
+		// either day field matches if both are restricted
+		{"0 0 1 * fri", "2023-03-17T00:00:00Z"},
+		{"0 0 31 apr *", ""},
+	}
+
+	for _, c := range cases {
+		s, err := cron.Parse(c.spec)
+		if err != nil {
+			t.Errorf("%q: parse: %v", c.spec, err)
+			continue
+		}
+
+		next := s.Next(from)
+		got := ""
+		if !next.IsZero() {
+			got = next.Format(time.RFC3339)
+		}
+		if got != c.next {
+			t.Errorf("%q: expected %q, got %q", c.spec, c.next, got)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"a * * * *",
+		"@every 5m",
+	} {
+		if _, err := cron.Parse(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package crypt implements client-side encryption of the backup data.
+package crypt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Conf is the encryption config. Only one of the key sources
+// should be set.
+type Conf struct {
+	// KeyFile is the path to the file with a 256-bit key. The key
+	// could be stored either as raw 32 bytes or base64 encoded.
+	KeyFile string     `bson:"keyFile,omitempty" json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	Vault   *VaultConf `bson:"vault,omitempty" json:"vault,omitempty" yaml:"vault,omitempty"`
+}
+
+// Enabled returns true if any key source is configured
+func (c *Conf) Enabled() bool {
+	return c != nil && (c.KeyFile != "" || c.Vault != nil)
+}
+
+// Key is the encryption key and its id. The id is stored along with
+// the encrypted data so the right key can be picked up on restore.
+type Key struct {
+	ID   string
+	Data []byte
+}
+
+// KeyProvider gives the keys for encryption and decryption
+type KeyProvider interface {
+	// Key returns the current key. It should be used to encrypt new data.
+	Key() (*Key, error)
+	// KeyByID returns the key with the given id. It returns an error
+	// if there is no such key.
+	KeyByID(id string) (*Key, error)
+}
+
+// NewKeyProvider returns the key provider for the given config.
+// It returns nil if encryption isn't configured.
+func NewKeyProvider(c *Conf) (KeyProvider, error) {
+	switch {
+	case !c.Enabled():
+		return nil, nil
+	case c.KeyFile != "" && c.Vault != nil:
+		return nil, errors.New("either keyFile or vault should be set")
+	case c.KeyFile != "":
+		return newFileKey(c.KeyFile)
+	default:
+		return newVault(c.Vault)
+	}
+}
+
+type fileKey struct {
+	key *Key
+}
+
+func newFileKey(path string) (*fileKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read key file")
+	}
+
+	data, err := parseKey(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "key file %s", path)
+	}
+
+	return &fileKey{key: &Key{ID: "file:" + fingerprint(data), Data: data}}, nil
+}
+
+func (f *fileKey) Key() (*Key, error) {
+	return f.key, nil
+}
+
+func (f *fileKey) KeyByID(id string) (*Key, error) {
+	if id != f.key.ID {
+		return nil, errors.Errorf("key id mismatch: the key file has %q", f.key.ID)
+	}
+
+	return f.key, nil
+}
+
+// parseKey accepts raw 32-byte key or base64 encoded one
+func parseKey(b []byte) ([]byte, error) {
+	if len(b) == 32 {
+		return b, nil
+	}
+
+	s := strings.TrimSpace(string(b))
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("key should be either 32 bytes or base64 encoded 32 bytes")
+	}
+	if len(data) != 32 {
+		return nil, errors.Errorf("invalid key length %d, expected 32 bytes", len(data))
+	}
+
+	return data, nil
+}
+
+// fingerprint identifies the key without revealing it
+func fingerprint(key []byte) string {
+	h := sha256.Sum256(key)
+	return hex.EncodeToString(h[:8])
+}
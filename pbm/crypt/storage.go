@@ -0,0 +1,108 @@
+package crypt
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// Storage encrypts data on Save with the given key and transparently
+// decrypts encrypted objects on read. Not encrypted objects are read as is
+// unless the storage is made by WrapRead for encrypted objects.
+type Storage struct {
+	storage.Storage
+	key       *Key
+	keys      KeyProvider
+	encrypted bool
+}
+
+// Wrap returns the storage which encrypts saved data with key (if not nil)
+// and decrypts read data with keys from the provider. If both key and keys
+// are nil, stg is returned as is.
+func Wrap(stg storage.Storage, key *Key, keys KeyProvider) storage.Storage {
+	if key == nil && keys == nil {
+		return stg
+	}
+
+	return &Storage{
+		Storage: stg,
+		key:     key,
+		keys:    keys,
+	}
+}
+
+// WrapRead returns the storage to read objects encrypted with the key keyID
+// as recorded in their metadata. Such objects without the encryption header
+// are rejected with ErrNotEncrypted. Empty keyID means the objects were never
+// encrypted and they are read as with Wrap(stg, nil, keys). If stg is already
+// wrapped, its underlying storage is used.
+func WrapRead(stg storage.Storage, keys KeyProvider, keyID string) storage.Storage {
+	if s, ok := stg.(*Storage); ok {
+		stg = s.Storage
+	}
+	if keyID == "" {
+		return Wrap(stg, nil, keys)
+	}
+
+	return &Storage{
+		Storage:   stg,
+		keys:      keys,
+		encrypted: true,
+	}
+}
+
+// KeyID returns the id of the key stg encrypts saved data with or
+// an empty string if it doesn't encrypt.
+func KeyID(stg storage.Storage) string {
+	s, ok := stg.(*Storage)
+	if !ok || s.key == nil {
+		return ""
+	}
+
+	return s.key.ID
+}
+
+func (s *Storage) Save(name string, data io.Reader, size int64) error {
+	if s.key == nil {
+		return s.Storage.Save(name, data, size)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := NewWriter(pw, s.key)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		_, err = io.Copy(w, data)
+		if err != nil {
+			pw.CloseWithError(errors.Wrap(err, "encrypt"))
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+
+	err := s.Storage.Save(name, pr, EncryptedSize(s.key, size))
+	pr.CloseWithError(err)
+	return err
+}
+
+func (s *Storage) SourceReader(name string) (io.ReadCloser, error) {
+	rc, err := s.Storage.SourceReader(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newReader(rc, s.keys, s.encrypted)
+	if err != nil {
+		rc.Close()
+		return nil, errors.Wrapf(err, "decrypt %s", name)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, rc}, nil
+}
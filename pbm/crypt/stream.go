@@ -0,0 +1,300 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Encrypted stream format:
+//
+//	header:  magic (8 bytes) | key id length (2 bytes, BE) | key id | salt (16 bytes)
+//	segment: length (4 bytes, BE, the highest bit marks the last segment) | AES-GCM sealed data
+//
+// Each stream is encrypted with its own subkey derived from the key and the
+// random salt. Segment nonces are built from the segment number and the
+// "last segment" flag. So segments can't be reordered, and the truncated
+// stream will fail to decrypt.
+var magic = []byte{'P', 'B', 'M', 'E', 'N', 'C', 0x00, 0x01}
+
+const (
+	saltSize    = 16
+	segmentSize = 64 << 10
+	lastFlag    = 1 << 31
+	maxKeyIDLen = 1<<16 - 1
+)
+
+// ErrNoKeys means an object is encrypted but no key provider is configured
+var ErrNoKeys = errors.New("object is encrypted but encryption is not configured")
+
+// ErrNotEncrypted means an object is recorded as encrypted but has no
+// encryption header
+var ErrNotEncrypted = errors.New("object is recorded as encrypted but it is not")
+
+func newAEAD(key *Key, salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key.Data)
+	mac.Write([]byte("pbm stream key"))
+	mac.Write(salt)
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, errors.Wrap(err, "create cipher")
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func nonce(aead cipher.AEAD, n uint64, last bool) []byte {
+	b := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(b[len(b)-9:], n)
+	if last {
+		b[len(b)-1] = 1
+	}
+	return b
+}
+
+// EncryptedSize returns the size of the encrypted stream for the data of
+// the given size. It returns -1 for the unknown (negative) size.
+func EncryptedSize(key *Key, size int64) int64 {
+	if size < 0 {
+		return -1
+	}
+
+	// the last segment is always written, even if it's empty
+	segs := (size + segmentSize - 1) / segmentSize
+	if segs == 0 {
+		segs = 1
+	}
+	return int64(len(magic)+2+len(key.ID)+saltSize) + size + segs*(4+16)
+}
+
+type writer struct {
+	w    io.Writer
+	aead cipher.AEAD
+	buf  []byte
+	n    uint64
+}
+
+// NewWriter returns io.WriteCloser which encrypts data with the given key
+// and writes it to w. Close has to be called to write the final segment.
+// It doesn't close the underlying writer.
+func NewWriter(w io.Writer, key *Key) (io.WriteCloser, error) {
+	if len(key.ID) > maxKeyIDLen {
+		return nil, errors.New("key id is too long")
+	}
+
+	salt := make([]byte, saltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate salt")
+	}
+
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	h := make([]byte, 0, len(magic)+2+len(key.ID)+saltSize)
+	h = append(h, magic...)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(key.ID)))
+	h = append(h, key.ID...)
+	h = append(h, salt...)
+	_, err = w.Write(h)
+	if err != nil {
+		return nil, errors.Wrap(err, "write header")
+	}
+
+	return &writer{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, segmentSize),
+	}, nil
+}
+
+func (e *writer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// keep the full segment in the buffer until there is more data,
+		// as we don't know yet if it's the last one
+		if len(e.buf) == segmentSize {
+			err := e.seal(false)
+			if err != nil {
+				return n - len(p), err
+			}
+		}
+
+		c := copy(e.buf[len(e.buf):segmentSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+	}
+
+	return n, nil
+}
+
+func (e *writer) Close() error {
+	return e.seal(true)
+}
+
+func (e *writer) seal(last bool) error {
+	out := e.aead.Seal(make([]byte, 4, 4+len(e.buf)+e.aead.Overhead()),
+		nonce(e.aead, e.n, last), e.buf, nil)
+
+	l := uint32(len(out) - 4)
+	if last {
+		l |= lastFlag
+	}
+	binary.BigEndian.PutUint32(out, l)
+
+	_, err := e.w.Write(out)
+	if err != nil {
+		return errors.Wrap(err, "write segment")
+	}
+
+	e.n++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+type reader struct {
+	r    *bufio.Reader
+	aead cipher.AEAD
+	buf  []byte
+	data []byte
+	n    uint64
+	done bool
+}
+
+// NewReader returns a reader which decrypts data from r if it is encrypted.
+// Not encrypted data is passed as is, so it should be used only for objects
+// which were never encrypted. The key is chosen from keys by the key id
+// stored in the stream header.
+func NewReader(r io.Reader, keys KeyProvider) (io.Reader, error) {
+	return newReader(r, keys, false)
+}
+
+// NewEncryptedReader is like NewReader but fails with ErrNotEncrypted
+// if data from r isn't encrypted. It is used for objects recorded as
+// encrypted, so the plaintext substituted for them isn't accepted.
+func NewEncryptedReader(r io.Reader, keys KeyProvider) (io.Reader, error) {
+	return newReader(r, keys, true)
+}
+
+func newReader(r io.Reader, keys KeyProvider, encrypted bool) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	h, err := br.Peek(len(magic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrap(err, "read header")
+	}
+	if !bytes.Equal(h, magic) {
+		if encrypted {
+			return nil, ErrNotEncrypted
+		}
+		return br, nil
+	}
+
+	if keys == nil {
+		return nil, ErrNoKeys
+	}
+
+	_, err = br.Discard(len(magic))
+	if err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+
+	var l uint16
+	err = binary.Read(br, binary.BigEndian, &l)
+	if err != nil {
+		return nil, errors.Wrap(err, "read key id")
+	}
+	id := make([]byte, l)
+	_, err = io.ReadFull(br, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "read key id")
+	}
+	salt := make([]byte, saltSize)
+	_, err = io.ReadFull(br, salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "read salt")
+	}
+
+	key, err := keys.KeyByID(string(id))
+	if err != nil {
+		return nil, errors.Wrapf(err, "get key %q", id)
+	}
+
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{r: br, aead: aead}, nil
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	for len(d.data) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+
+		err := d.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.data)
+	d.data = d.data[n:]
+	return n, nil
+}
+
+func (d *reader) open() error {
+	var l uint32
+	err := binary.Read(d.r, binary.BigEndian, &l)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return errors.Wrap(err, "read segment length")
+	}
+
+	last := l&lastFlag != 0
+	l &^= lastFlag
+	if l > uint32(segmentSize+d.aead.Overhead()) {
+		return errors.Errorf("invalid segment length %d", l)
+	}
+
+	if cap(d.buf) < int(l) {
+		d.buf = make([]byte, l)
+	}
+	d.buf = d.buf[:l]
+	_, err = io.ReadFull(d.r, d.buf)
+	if err != nil {
+		return errors.Wrap(err, "read segment")
+	}
+
+	d.data, err = d.aead.Open(d.buf[:0], nonce(d.aead, d.n, last), d.buf, nil)
+	if err != nil {
+		return errors.Wrapf(err, "decrypt segment %d", d.n)
+	}
+
+	d.n++
+	d.done = last
+	if last {
+		_, err = d.r.Peek(1)
+		if err == nil {
+			return errors.New("unexpected data after the last segment")
+		}
+		if !errors.Is(err, io.EOF) {
+			return errors.Wrap(err, "read after the last segment")
+		}
+	}
+	return nil
+}
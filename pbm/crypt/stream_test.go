@@ -0,0 +1,152 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type testKeys struct {
+	key *Key
+}
+
+func (t testKeys) Key() (*Key, error) { return t.key, nil }
+
+func (t testKeys) KeyByID(id string) (*Key, error) {
+	if id != t.key.ID {
+		return nil, errors.Errorf("no key %q", id)
+	}
+	return t.key, nil
+}
+
+func newTestKey(t *testing.T, id string) *Key {
+	t.Helper()
+
+	k := &Key{ID: id, Data: make([]byte, 32)}
+	_, err := rand.Read(k.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func encrypt(t *testing.T, key *Key, data []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(data []byte, keys KeyProvider) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), keys)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundtrip(t *testing.T) {
+	key := newTestKey(t, "test")
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 17} {
+		data := make([]byte, size)
+		rand.Read(data) //nolint:errcheck
+
+		enc := encrypt(t, key, data)
+		if int64(len(enc)) != EncryptedSize(key, int64(size)) {
+			t.Errorf("size %d: encrypted size %d, expected %d", size, len(enc), EncryptedSize(key, int64(size)))
+		}
+
+		dec, err := decrypt(enc, testKeys{key})
+		if err != nil {
+			t.Fatalf("size %d: decrypt: %v", size, err)
+		}
+		if !bytes.Equal(dec, data) {
+			t.Errorf("size %d: decrypted data mismatch", size)
+		}
+	}
+}
+
+func TestPlainPassthrough(t *testing.T) {
+	data := []byte("not encrypted data")
+
+	dec, err := decrypt(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec, data) {
+		t.Errorf("got %q, expected %q", dec, data)
+	}
+}
+
+func TestPlainRejected(t *testing.T) {
+	key := newTestKey(t, "test")
+	data := []byte("not encrypted data")
+
+	for name, d := range map[string][]byte{"plain": data, "empty": {}} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewEncryptedReader(bytes.NewReader(d), testKeys{key})
+			if !errors.Is(err, ErrNotEncrypted) {
+				t.Errorf("got %v, expected %v", err, ErrNotEncrypted)
+			}
+		})
+	}
+
+	r, err := NewEncryptedReader(bytes.NewReader(encrypt(t, key, data)), testKeys{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec, data) {
+		t.Errorf("got %q, expected %q", dec, data)
+	}
+}
+
+func TestCorrupted(t *testing.T) {
+	key := newTestKey(t, "test")
+	data := make([]byte, 2*segmentSize+100)
+	enc := encrypt(t, key, data)
+
+	tampered := append([]byte{}, enc...)
+	tampered[len(tampered)-1] ^= 1
+
+	cases := map[string]struct {
+		data []byte
+		keys KeyProvider
+	}{
+		"no keys":   {enc, nil},
+		"wrong key": {enc, testKeys{newTestKey(t, "other")}},
+		"same id":   {enc, testKeys{newTestKey(t, "test")}},
+		"tampered":  {tampered, testKeys{key}},
+		"truncated": {enc[:len(enc)-segmentSize], testKeys{key}},
+		"cut":       {enc[:len(enc)-10], testKeys{key}},
+		"trailing":  {append(append([]byte{}, enc...), 0), testKeys{key}},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := decrypt(c.data, c.keys)
+			if err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package crypt
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// VaultConf describes the key stored in the HashiCorp Vault
+// KV secrets engine (version 2).
+type VaultConf struct {
+	Server string `bson:"server" json:"server" yaml:"server"`
+	Token  string `bson:"token" json:"token" yaml:"token"`
+	// Secret is the path to the secret, e.g. "secret/data/pbm"
+	Secret string `bson:"secret" json:"secret" yaml:"secret"`
+	// Field is the secret's field with the base64 encoded key.
+	// Default is "key".
+	Field                 string `bson:"field,omitempty" json:"field,omitempty" yaml:"field,omitempty"`
+	InsecureSkipTLSVerify bool   `bson:"insecureSkipTLSVerify,omitempty" json:"insecureSkipTLSVerify,omitempty" yaml:"insecureSkipTLSVerify,omitempty"`
+}
+
+const defaultVaultField = "key"
+
+type vault struct {
+	conf *VaultConf
+	cl   *http.Client
+
+	mu   sync.Mutex
+	keys map[string]*Key
+}
+
+func newVault(c *VaultConf) (*vault, error) {
+	if c.Server == "" || c.Secret == "" {
+		return nil, errors.New("vault server and secret should be set")
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if c.InsecureSkipTLSVerify {
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+
+	return &vault{
+		conf: c,
+		cl:   &http.Client{Transport: tr, Timeout: 30 * time.Second},
+		keys: make(map[string]*Key),
+	}, nil
+}
+
+// Key returns the latest version of the secret
+func (v *vault) Key() (*Key, error) {
+	return v.get(0)
+}
+
+func (v *vault) KeyByID(id string) (*Key, error) {
+	v.mu.Lock()
+	k, ok := v.keys[id]
+	v.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	prefix := "vault:" + v.conf.Secret + "@"
+	if !strings.HasPrefix(id, prefix) {
+		return nil, errors.Errorf("key %q doesn't belong to the vault secret %s", id, v.conf.Secret)
+	}
+	ver, err := strconv.Atoi(strings.TrimPrefix(id, prefix))
+	if err != nil || ver <= 0 {
+		return nil, errors.Errorf("invalid vault key id %q", id)
+	}
+
+	return v.get(ver)
+}
+
+type vaultResp struct {
+	Data struct {
+		Data     map[string]string `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// get fetches the given version of the secret. 0 means the latest one.
+func (v *vault) get(ver int) (*Key, error) {
+	u := strings.TrimSuffix(v.conf.Server, "/") + "/v1/" + strings.TrimPrefix(v.conf.Secret, "/")
+	if ver > 0 {
+		u += "?" + url.Values{"version": {strconv.Itoa(ver)}}.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	req.Header.Set("X-Vault-Token", v.conf.Token)
+
+	resp, err := v.cl.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "vault request")
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, errors.Wrap(err, "read vault response")
+	}
+
+	var r vaultResp
+	err = json.Unmarshal(body, &r)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return nil, errors.Wrap(err, "decode vault response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("vault responded with %s: %s", resp.Status, strings.Join(r.Errors, "; "))
+	}
+
+	field := v.conf.Field
+	if field == "" {
+		field = defaultVaultField
+	}
+	val, ok := r.Data.Data[field]
+	if !ok {
+		return nil, errors.Errorf("no field %q in the vault secret %s", field, v.conf.Secret)
+	}
+	data, err := parseKey([]byte(val))
+	if err != nil {
+		return nil, errors.Wrapf(err, "vault secret %s", v.conf.Secret)
+	}
+
+	k := &Key{
+		ID:   fmt.Sprintf("vault:%s@%d", v.conf.Secret, r.Data.Metadata.Version),
+		Data: data,
+	}
+
+	v.mu.Lock()
+	v.keys[k.ID] = k
+	v.mu.Unlock()
+
+	return k, nil
+}
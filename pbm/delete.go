@@ -32,7 +32,7 @@ func (p *PBM) DeleteBackup(name string, l *log.Event) error {
 		return err
 	}
 
-	stg, err := p.GetStorage(l)
+	stg, err := p.GetBackupStorage(meta, l)
 	if err != nil {
 		return errors.Wrap(err, "get storage")
 	}
@@ -196,10 +196,11 @@ func (p *PBM) deleteLegacyLogicalBackupFiles(meta *BackupMeta, stg storage.Stora
 
 // DeleteOlderThan deletes backups which older than given Time
 func (p *PBM) DeleteOlderThan(t time.Time, l *log.Event) error {
-	stg, err := p.GetStorage(l)
+	cfg, err := p.GetConfig()
 	if err != nil {
-		return errors.Wrap(err, "get storage")
+		return errors.Wrap(err, "get config")
 	}
+	stgs := NewStorages(cfg, l)
 
 	tlns, err := p.PITRTimelines()
 	if err != nil {
@@ -229,6 +230,10 @@ func (p *PBM) DeleteOlderThan(t time.Time, l *log.Event) error {
 			continue
 		}
 
+		stg, err := stgs.Backup(m)
+		if err != nil {
+			return errors.Wrapf(err, "get storage of backup %s", m.Name)
+		}
 		err = p.DeleteBackupFiles(m, stg)
 		if err != nil {
 			return errors.Wrap(err, "delete backup files from storage")
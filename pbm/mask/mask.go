@@ -0,0 +1,581 @@
+// Package mask transforms the documents of logical restores and oplog
+// replays so the masked fields never land on the target cluster.
+package mask
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Strategy defines how the masked value is replaced
+type Strategy string
+
+const (
+	// StrategyNull replaces the value with null
+	StrategyNull Strategy = "null"
+	// StrategyConstant replaces the value with the Field.Value
+	StrategyConstant Strategy = "constant"
+	// StrategyHash replaces the value with the salted SHA-256 hex string
+	// of it. The same values are masked to the same hashes, so the
+	// masked fields can still be used for joins and lookups.
+	StrategyHash Strategy = "hash"
+	// StrategyRandom replaces the value with a random one of the same
+	// type. Strings keep their shape: letters are replaced by random
+	// letters of the same case, digits by random digits and the rest
+	// (e.g. "@", ".", "-") are kept.
+	StrategyRandom Strategy = "random"
+)
+
+// ErrTimeseries is returned on the attempt to mask the documents of
+// a time-series collection. Its buckets don't have the fields of the
+// measurements, so they can't be masked by the field paths.
+var ErrTimeseries = errors.New("masking of time-series collections is not supported")
+
+// Spec is the masking specification of a restore
+type Spec struct {
+	// Salt is mixed into the hashed values. It's strongly recommended
+	// to set it, otherwise low-entropy values (e.g. phones) can be
+	// recovered from the hashes by brute force.
+	Salt       string    `bson:"salt,omitempty" json:"salt,omitempty" yaml:"salt,omitempty"`
+	Namespaces []NSRules `bson:"namespaces" json:"namespaces" yaml:"namespaces"`
+}
+
+// NSRules are the masked fields of the namespace
+type NSRules struct {
+	// NS is either "db.coll", "db.*" or "*.*"
+	NS     string  `bson:"ns" json:"ns" yaml:"ns"`
+	Fields []Field `bson:"fields" json:"fields" yaml:"fields"`
+}
+
+// Field is the masking of the field
+type Field struct {
+	// Path is the dot-separated path of the field (e.g. "contacts.email").
+	// Arrays along the path are traversed. The numeric parts address
+	// the array elements (e.g. "phones.0").
+	Path     string   `bson:"path" json:"path" yaml:"path"`
+	Strategy Strategy `bson:"strategy" json:"strategy" yaml:"strategy"`
+	// Value is the replacement of the StrategyConstant
+	Value interface{} `bson:"value,omitempty" json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// Validate checks the spec
+func (s *Spec) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if len(s.Namespaces) == 0 {
+		return errors.New("no namespaces to mask")
+	}
+
+	seen := make(map[string]struct{})
+	for _, n := range s.Namespaces {
+		if err := validateNS(n.NS); err != nil {
+			return err
+		}
+		if _, ok := seen[n.NS]; ok {
+			return errors.Errorf("namespace %q is duplicated", n.NS)
+		}
+		seen[n.NS] = struct{}{}
+
+		if len(n.Fields) == 0 {
+			return errors.Errorf("%s: no fields to mask", n.NS)
+		}
+		for _, f := range n.Fields {
+			if err := f.validate(); err != nil {
+				return errors.WithMessagef(err, "%s: field %q", n.NS, f.Path)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateNS(ns string) error {
+	d, c, ok := strings.Cut(ns, ".")
+	if !ok || d == "" || c == "" {
+		return errors.Errorf("invalid namespace %q", ns)
+	}
+	switch d {
+	case "admin", "config", "local":
+		return errors.Errorf("%s: %q database can't be masked", ns, d)
+	case "*":
+		if c != "*" {
+			return errors.Errorf("invalid namespace %q: expected \"*.*\"", ns)
+		}
+		return nil
+	}
+	if strings.Contains(d, "*") || (c != "*" && strings.Contains(c, "*")) {
+		return errors.Errorf("invalid namespace %q: only the whole collection name could be *", ns)
+	}
+	if strings.HasPrefix(c, "system.") {
+		return errors.Errorf("%s: system collections can't be masked", ns)
+	}
+
+	return nil
+}
+
+func (f *Field) validate() error {
+	parts := strings.Split(f.Path, ".")
+	for _, p := range parts {
+		if p == "" {
+			return errors.New("empty path element")
+		}
+		if strings.HasPrefix(p, "$") {
+			return errors.New("path element can't start with '$'")
+		}
+	}
+	if parts[0] == "_id" {
+		return errors.New("_id can't be masked")
+	}
+
+	switch f.Strategy {
+	case StrategyNull, StrategyHash, StrategyRandom:
+	case StrategyConstant:
+		if f.Value == nil {
+			return errors.New("constant value is not set")
+		}
+		if _, _, err := bson.MarshalValue(f.Value); err != nil {
+			return errors.Wrap(err, "invalid constant value")
+		}
+	default:
+		return errors.Errorf("unknown strategy %q", f.Strategy)
+	}
+
+	return nil
+}
+
+type maskFn func(interface{}) interface{}
+
+type rule struct {
+	parts []string
+	fn    maskFn
+}
+
+// Masker masks the documents and the oplog entries according to the spec.
+// It's safe for concurrent use.
+type Masker struct {
+	salt  []byte
+	rules map[string][]rule
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// New creates the masker of the spec. It returns nil if the spec is nil.
+func New(s *Spec) (*Masker, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	m := &Masker{
+		salt:  []byte(s.Salt),
+		rules: make(map[string][]rule),
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, n := range s.Namespaces {
+		for _, f := range n.Fields {
+			m.rules[n.NS] = append(m.rules[n.NS], rule{
+				parts: strings.Split(f.Path, "."),
+				fn:    m.maskFn(f),
+			})
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Masker) maskFn(f Field) maskFn {
+	switch f.Strategy {
+	case StrategyConstant:
+		v := f.Value
+		return func(interface{}) interface{} { return v }
+	case StrategyHash:
+		return m.hash
+	case StrategyRandom:
+		return m.random
+	}
+
+	return func(interface{}) interface{} { return nil }
+}
+
+// nsRules returns the rules of the namespace. The rules of the
+// "db.*" and "*.*" are applied as well.
+func (m *Masker) nsRules(ns string) ([]rule, error) {
+	d, c, _ := strings.Cut(ns, ".")
+	if strings.HasPrefix(c, "system.buckets.") {
+		base := d + "." + strings.TrimPrefix(c, "system.buckets.")
+		if len(m.rules[base])+len(m.rules[d+".*"])+len(m.rules["*.*"]) != 0 {
+			return nil, errors.WithMessage(ErrTimeseries, base)
+		}
+		return nil, nil
+	}
+	if strings.HasPrefix(c, "system.") || d == "admin" || d == "config" || d == "local" {
+		return nil, nil
+	}
+
+	var rv []rule
+	rv = append(rv, m.rules[ns]...)
+	rv = append(rv, m.rules[d+".*"]...)
+	rv = append(rv, m.rules["*.*"]...)
+	return rv, nil
+}
+
+// Doc returns the masked document of the namespace. The namespace of
+// time-series collection buckets is "db.system.buckets.coll".
+func (m *Masker) Doc(ns string, d bson.Raw) (bson.Raw, error) {
+	rules, err := m.nsRules(ns)
+	if err != nil || len(rules) == 0 {
+		return d, err
+	}
+
+	doc := bson.D{}
+	err = bson.Unmarshal(d, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal")
+	}
+
+	var v interface{} = doc
+	for _, r := range rules {
+		v = maskPath(v, r.parts, r.fn)
+	}
+
+	b, err := bson.Marshal(v)
+	return b, errors.Wrap(err, "marshal")
+}
+
+// Op returns the masked oplog entry. It masks the inserted documents and
+// the values set by the updates. The nested applyOps entries aren't
+// handled here and have to be masked one by one.
+func (m *Masker) Op(op db.Oplog) (db.Oplog, error) {
+	if op.Operation != "i" && op.Operation != "u" {
+		return op, nil
+	}
+
+	rules, err := m.nsRules(op.Namespace)
+	if err != nil || len(rules) == 0 {
+		return op, err
+	}
+
+	if op.Operation == "i" || len(op.Object) == 0 || !strings.HasPrefix(op.Object[0].Key, "$") {
+		// insert or replacement document
+		var v interface{} = op.Object
+		for _, r := range rules {
+			v = maskPath(v, r.parts, r.fn)
+		}
+		op.Object = v.(bson.D)
+		return op, nil
+	}
+
+	obj := make(bson.D, len(op.Object))
+	copy(obj, op.Object)
+	for i, e := range obj {
+		switch e.Key {
+		case "diff":
+			// $v: 2 update
+			if diff, ok := e.Value.(bson.D); ok {
+				obj[i].Value = maskDiff(rules, diff, nil)
+			}
+		case "$set":
+			set, ok := e.Value.(bson.D)
+			if !ok {
+				continue
+			}
+			nset := make(bson.D, len(set))
+			for j, s := range set {
+				nset[j] = bson.E{s.Key, maskSet(rules, strings.Split(s.Key, "."), s.Value)}
+			}
+			obj[i].Value = nset
+		}
+	}
+	op.Object = obj
+
+	return op, nil
+}
+
+// maskDiff masks the $v: 2 update diff of the field at the prefix path.
+// The "u" (update) and "i" (insert) sections hold the new values of
+// the fields, the "s<field>" are the diffs of the subdocuments (or
+// of array elements) and "u<index>" are new values of array elements.
+func maskDiff(rules []rule, diff bson.D, prefix []string) bson.D {
+	rv := make(bson.D, len(diff))
+	for i, e := range diff {
+		rv[i] = e
+		switch {
+		case e.Key == "u" || e.Key == "i":
+			fields, ok := e.Value.(bson.D)
+			if !ok {
+				continue
+			}
+			nf := make(bson.D, len(fields))
+			for j, f := range fields {
+				nf[j] = bson.E{f.Key, maskSet(rules, appendPath(prefix, f.Key), f.Value)}
+			}
+			rv[i].Value = nf
+		case len(e.Key) > 1 && e.Key[0] == 's':
+			if sub, ok := e.Value.(bson.D); ok {
+				rv[i].Value = maskDiff(rules, sub, appendPath(prefix, e.Key[1:]))
+			}
+		case len(e.Key) > 1 && e.Key[0] == 'u':
+			if _, ok := index(e.Key[1:]); ok {
+				rv[i].Value = maskSet(rules, appendPath(prefix, e.Key[1:]), e.Value)
+			}
+		}
+	}
+
+	return rv
+}
+
+func appendPath(prefix []string, p string) []string {
+	rv := make([]string, len(prefix), len(prefix)+1)
+	copy(rv, prefix)
+	return append(rv, p)
+}
+
+// maskSet masks the value set to the field at the path
+func maskSet(rules []rule, path []string, v interface{}) interface{} {
+	for _, r := range rules {
+		i, j, ok := matchPath(path, r.parts)
+		if !ok {
+			continue
+		}
+
+		if i == len(path) && j < len(r.parts) {
+			// the subdocument with the masked field is set
+			v = maskPath(v, r.parts[j:], r.fn)
+		} else {
+			// the masked field or its part is set
+			v = maskLeaf(v, r.fn)
+		}
+	}
+
+	return v
+}
+
+// matchPath matches the updated field path against the masked one.
+// The array indexes in the path that aren't in the masked one are
+// skipped. It returns how many elements of both are matched.
+func matchPath(path, masked []string) (int, int, bool) {
+	i, j := 0, 0
+	for i < len(path) && j < len(masked) {
+		switch {
+		case path[i] == masked[j]:
+			i++
+			j++
+		case isIndex(path[i]):
+			i++
+		default:
+			return 0, 0, false
+		}
+	}
+
+	return i, j, true
+}
+
+// maskPath masks the field at the path of the value
+func maskPath(v interface{}, parts []string, fn maskFn) interface{} {
+	if len(parts) == 0 {
+		return maskLeaf(v, fn)
+	}
+
+	switch t := v.(type) {
+	case bson.D:
+		d := make(bson.D, len(t))
+		copy(d, t)
+		for i := range d {
+			if d[i].Key == parts[0] {
+				d[i].Value = maskPath(d[i].Value, parts[1:], fn)
+			}
+		}
+		return d
+	case bson.A:
+		a := make(bson.A, len(t))
+		idx, ok := index(parts[0])
+		for i := range t {
+			switch {
+			case ok && i == idx:
+				a[i] = maskPath(t[i], parts[1:], fn)
+			case ok:
+				a[i] = t[i]
+			default:
+				a[i] = maskPath(t[i], parts, fn)
+			}
+		}
+		return a
+	}
+
+	return v
+}
+
+// maskLeaf masks the value. The array elements are masked one by one.
+func maskLeaf(v interface{}, fn maskFn) interface{} {
+	a, ok := v.(bson.A)
+	if !ok {
+		return fn(v)
+	}
+
+	rv := make(bson.A, len(a))
+	for i := range a {
+		rv[i] = maskLeaf(a[i], fn)
+	}
+	return rv
+}
+
+func index(s string) (int, bool) {
+	if !isIndex(s) {
+		return 0, false
+	}
+	i, err := strconv.Atoi(s)
+	return i, err == nil
+}
+
+func isIndex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Masker) hash(v interface{}) interface{} {
+	t, b, err := bson.MarshalValue(v)
+	if err != nil {
+		return nil
+	}
+
+	h := sha256.New()
+	h.Write(m.salt)
+	h.Write([]byte{byte(t)})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (m *Masker) random(v interface{}) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return randomValue(m.rnd, v)
+}
+
+func randomValue(rnd *rand.Rand, v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return randomString(rnd, t)
+	case int32:
+		return int32(randomInt(rnd, int64(t), math.MaxInt32))
+	case int64:
+		return randomInt(rnd, t, math.MaxInt64)
+	case float64:
+		return randomFloat(rnd, t)
+	case bool:
+		return rnd.Intn(2) == 1
+	case primitive.DateTime:
+		return primitive.DateTime(rnd.Int63n(time.Now().UnixMilli()))
+	case primitive.ObjectID:
+		return primitive.NewObjectID()
+	case primitive.Binary:
+		b := make([]byte, len(t.Data))
+		rnd.Read(b)
+		return primitive.Binary{Subtype: t.Subtype, Data: b}
+	case bson.D:
+		d := make(bson.D, len(t))
+		for i := range t {
+			d[i] = bson.E{t[i].Key, randomValue(rnd, t[i].Value)}
+		}
+		return d
+	case bson.A:
+		a := make(bson.A, len(t))
+		for i := range t {
+			a[i] = randomValue(rnd, t[i])
+		}
+		return a
+	}
+
+	return nil
+}
+
+const (
+	lower  = "abcdefghijklmnopqrstuvwxyz"
+	upper  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digits = "0123456789"
+)
+
+// randomString returns the random string of the same shape
+func randomString(rnd *rand.Rand, s string) string {
+	b := strings.Builder{}
+	b.Grow(len(s))
+	for _, c := range s {
+		switch {
+		case unicode.IsDigit(c):
+			b.WriteByte(digits[rnd.Intn(len(digits))])
+		case unicode.IsUpper(c):
+			b.WriteByte(upper[rnd.Intn(len(upper))])
+		case unicode.IsLetter(c):
+			b.WriteByte(lower[rnd.Intn(len(lower))])
+		default:
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// randomInt returns the random number with the same sign
+// and number of digits but not greater than max by absolute value
+func randomInt(rnd *rand.Rand, n, max int64) int64 {
+	if n == 0 {
+		return 0
+	}
+
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	lo := int64(1)
+	for lo <= n/10 {
+		lo *= 10
+	}
+	hi := lo * 10
+	if hi/10 != lo || hi < 0 || hi > max {
+		hi = max
+	}
+
+	rv := lo + rnd.Int63n(hi-lo)
+	if neg {
+		return -rv
+	}
+	return rv
+}
+
+// randomFloat returns the random number with the same sign
+// and order of magnitude
+func randomFloat(rnd *rand.Rand, f float64) float64 {
+	if f == 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return f
+	}
+
+	e := math.Floor(math.Log10(math.Abs(f)))
+	rv := (1 + rnd.Float64()*9) * math.Pow(10, e)
+	if f < 0 {
+		return -rv
+	}
+	return rv
+}
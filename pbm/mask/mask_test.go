@@ -0,0 +1,241 @@
+package mask
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func testMasker(t *testing.T) *Masker {
+	t.Helper()
+
+	m, err := New(&Spec{
+		Salt: "salt",
+		Namespaces: []NSRules{
+			{
+				NS: "app.users",
+				Fields: []Field{
+					{Path: "ssn", Strategy: StrategyNull},
+					{Path: "email", Strategy: StrategyHash},
+					{Path: "contacts.phone", Strategy: StrategyRandom},
+					{Path: "note", Strategy: StrategyConstant, Value: "redacted"},
+				},
+			},
+			{
+				NS:     "app.*",
+				Fields: []Field{{Path: "card", Strategy: StrategyNull}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("new masker: %v", err)
+	}
+
+	return m
+}
+
+func TestValidate(t *testing.T) {
+	field := []Field{{Path: "a", Strategy: StrategyNull}}
+	cases := []struct {
+		name string
+		spec Spec
+		ok   bool
+	}{
+		{"valid", Spec{Namespaces: []NSRules{{NS: "db.c", Fields: field}, {NS: "*.*", Fields: field}}}, true},
+		{"no namespaces", Spec{}, false},
+		{"no fields", Spec{Namespaces: []NSRules{{NS: "db.c"}}}, false},
+		{"invalid ns", Spec{Namespaces: []NSRules{{NS: "db", Fields: field}}}, false},
+		{"system db", Spec{Namespaces: []NSRules{{NS: "admin.c", Fields: field}}}, false},
+		{"wildcard", Spec{Namespaces: []NSRules{{NS: "db.c*", Fields: field}}}, false},
+		{"duplicate", Spec{Namespaces: []NSRules{{NS: "db.c", Fields: field}, {NS: "db.c", Fields: field}}}, false},
+		{"_id", Spec{Namespaces: []NSRules{{NS: "db.c", Fields: []Field{{Path: "_id.a", Strategy: StrategyNull}}}}}, false},
+		{"empty path", Spec{Namespaces: []NSRules{{NS: "db.c", Fields: []Field{{Path: "a..b", Strategy: StrategyNull}}}}}, false},
+		{"strategy", Spec{Namespaces: []NSRules{{NS: "db.c", Fields: []Field{{Path: "a", Strategy: "faker"}}}}}, false},
+		{"no constant", Spec{Namespaces: []NSRules{{NS: "db.c", Fields: []Field{{Path: "a", Strategy: StrategyConstant}}}}}, false},
+		{
+			"invalid constant",
+			Spec{Namespaces: []NSRules{{NS: "db.c", Fields: []Field{{
+				Path: "a", Strategy: StrategyConstant, Value: map[interface{}]interface{}{1: 1},
+			}}}}},
+			false,
+		},
+	}
+
+	for _, c := range cases {
+		err := c.spec.Validate()
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected result: %v", c.name, err)
+		}
+	}
+}
+
+func TestDoc(t *testing.T) {
+	m := testMasker(t)
+
+	src := bson.D{
+		{"_id", 1},
+		{"name", "John"},
+		{"ssn", "123-45-6789"},
+		{"email", "john@example.com"},
+		{"contacts", bson.A{
+			bson.D{{"type", "home"}, {"phone", "+1 (555) 123-4567"}},
+			bson.D{{"type", "work"}, {"phone", "+1 (555) 765-4321"}},
+		}},
+		{"note", bson.D{{"text", "secret"}}},
+		{"card", "4111111111111111"},
+	}
+	raw, err := bson.Marshal(src)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	b, err := m.Doc("app.users", raw)
+	if err != nil {
+		t.Fatalf("mask: %v", err)
+	}
+	got := bson.D{}
+	if err := bson.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	doc := got.Map()
+
+	if doc["_id"] != int32(1) || doc["name"] != "John" {
+		t.Errorf("unmasked fields are changed: %v", got)
+	}
+	if doc["ssn"] != nil || doc["card"] != nil {
+		t.Errorf("expected nulls, got ssn: %v, card: %v", doc["ssn"], doc["card"])
+	}
+	if doc["email"] != m.hash("john@example.com") || doc["email"] == "john@example.com" {
+		t.Errorf("unexpected email hash: %v", doc["email"])
+	}
+	if doc["note"] != "redacted" {
+		t.Errorf("unexpected note: %v", doc["note"])
+	}
+
+	phone := regexp.MustCompile(`^\+\d \(\d{3}\) \d{3}-\d{4}$`)
+	for i, c := range doc["contacts"].(bson.A) {
+		p := c.(bson.D).Map()["phone"].(string)
+		if !phone.MatchString(p) || p == src[4].Value.(bson.A)[i].(bson.D)[1].Value {
+			t.Errorf("unexpected phone %q", p)
+		}
+	}
+
+	// the other collection of the db gets the db rules only
+	b, err = m.Doc("app.orders", raw)
+	if err != nil {
+		t.Fatalf("mask: %v", err)
+	}
+	got = bson.D{}
+	if err := bson.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if doc := got.Map(); doc["card"] != nil || doc["ssn"] != "123-45-6789" {
+		t.Errorf("unexpected app.orders doc: %v", got)
+	}
+
+	b, err = m.Doc("other.users", raw)
+	if err != nil || !reflect.DeepEqual([]byte(b), []byte(raw)) {
+		t.Errorf("unexpected masking of not masked namespace: %v", err)
+	}
+
+	_, err = m.Doc("app.system.buckets.users", raw)
+	if !errors.Is(err, ErrTimeseries) {
+		t.Errorf("expected %v, got %v", ErrTimeseries, err)
+	}
+}
+
+func TestOp(t *testing.T) {
+	m := testMasker(t)
+	h := m.hash("a@b.c")
+
+	cases := []struct {
+		name string
+		op   db.Oplog
+		want bson.D
+	}{
+		{
+			name: "insert",
+			op:   db.Oplog{Operation: "i", Namespace: "app.users", Object: bson.D{{"_id", 1}, {"email", "a@b.c"}}},
+			want: bson.D{{"_id", 1}, {"email", h}},
+		},
+		{
+			name: "replacement",
+			op:   db.Oplog{Operation: "u", Namespace: "app.users", Object: bson.D{{"_id", 1}, {"ssn", "1"}}},
+			want: bson.D{{"_id", 1}, {"ssn", nil}},
+		},
+		{
+			name: "set",
+			op: db.Oplog{Operation: "u", Namespace: "app.users", Object: bson.D{
+				{"$v", 1},
+				{"$set", bson.D{{"email", "a@b.c"}, {"name", "x"}, {"note.text", "x"}, {"contacts.1", bson.D{{"phone", ""}}}}},
+			}},
+			want: bson.D{
+				{"$v", 1},
+				{"$set", bson.D{{"email", h}, {"name", "x"}, {"note.text", "redacted"}, {"contacts.1", bson.D{{"phone", ""}}}}},
+			},
+		},
+		{
+			name: "diff",
+			op: db.Oplog{Operation: "u", Namespace: "app.users", Object: bson.D{
+				{"$v", 2},
+				{"diff", bson.D{
+					{"u", bson.D{{"email", "a@b.c"}, {"name", "x"}}},
+					{"i", bson.D{{"ssn", "1"}}},
+					{"snote", bson.D{{"u", bson.D{{"text", "x"}}}}},
+				}},
+			}},
+			want: bson.D{
+				{"$v", 2},
+				{"diff", bson.D{
+					{"u", bson.D{{"email", h}, {"name", "x"}}},
+					{"i", bson.D{{"ssn", nil}}},
+					{"snote", bson.D{{"u", bson.D{{"text", "redacted"}}}}},
+				}},
+			},
+		},
+		{
+			name: "delete",
+			op:   db.Oplog{Operation: "d", Namespace: "app.users", Object: bson.D{{"_id", 1}}},
+			want: bson.D{{"_id", 1}},
+		},
+		{
+			name: "not masked",
+			op:   db.Oplog{Operation: "i", Namespace: "db.users", Object: bson.D{{"_id", 1}, {"email", "a@b.c"}}},
+			want: bson.D{{"_id", 1}, {"email", "a@b.c"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := m.Op(c.op)
+			if err != nil {
+				t.Fatalf("mask: %v", err)
+			}
+			if !reflect.DeepEqual(got.Object, c.want) {
+				t.Errorf("expected %v, got %v", c.want, got.Object)
+			}
+		})
+	}
+}
+
+func TestRandom(t *testing.T) {
+	m := testMasker(t)
+
+	for _, v := range []interface{}{int32(2000000000), int64(-12345), 3.14, "Ab-1"} {
+		r := m.random(v)
+		if reflect.TypeOf(r) != reflect.TypeOf(v) {
+			t.Errorf("%v: the type is changed: %T", v, r)
+		}
+	}
+
+	if n := m.random(int64(-12345)).(int64); n > -10000 || n <= -100000 {
+		t.Errorf("unexpected random number %d", n)
+	}
+	if s := m.random("Ab-1").(string); !regexp.MustCompile(`^[A-Z][a-z]-\d$`).MatchString(s) {
+		t.Errorf("unexpected random string %q", s)
+	}
+}
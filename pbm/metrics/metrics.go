@@ -0,0 +1,263 @@
+// Package metrics is a minimal registry of metrics exposed
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Type string
+
+const (
+	Gauge   Type = "gauge"
+	Counter Type = "counter"
+)
+
+// Default is the registry of the process-wide metrics
+var Default = NewRegistry()
+
+var (
+	StorageUploadedBytes = Default.NewCounter("pbm_storage_uploaded_bytes_total",
+		"Bytes uploaded to the storage", "storage")
+	StorageDownloadedBytes = Default.NewCounter("pbm_storage_downloaded_bytes_total",
+		"Bytes downloaded from the storage", "storage")
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+// Registry holds metrics and renders them on scrape.
+type Registry struct {
+	mx         sync.Mutex
+	constLbls  []label
+	vecs       []*Vec
+	collectors []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// SetConstLabel adds the label to all metrics of the registry
+// (e.g. replset and node names).
+func (r *Registry) SetConstLabel(name, value string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for i, l := range r.constLbls {
+		if l.name == name {
+			r.constLbls[i].value = value
+			return
+		}
+	}
+	r.constLbls = append(r.constLbls, label{name, value})
+}
+
+// OnCollect registers f to be called before each scrape. It is meant
+// to update the metrics which values are read from the database.
+func (r *Registry) OnCollect(f func()) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.collectors = append(r.collectors, f)
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Vec {
+	return r.newVec(name, help, Gauge, labels)
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Vec {
+	return r.newVec(name, help, Counter, labels)
+}
+
+func (r *Registry) newVec(name, help string, t Type, labels []string) *Vec {
+	v := &Vec{
+		name:   name,
+		help:   help,
+		typ:    t,
+		labels: labels,
+		vals:   make(map[string]*Value),
+	}
+
+	r.mx.Lock()
+	r.vecs = append(r.vecs, v)
+	r.mx.Unlock()
+
+	return v
+}
+
+// Write runs collectors and writes all metrics to w
+// in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mx.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	r.mx.Unlock()
+
+	for _, f := range collectors {
+		f()
+	}
+
+	r.mx.Lock()
+	constLbls := append([]label{}, r.constLbls...)
+	vecs := append([]*Vec{}, r.vecs...)
+	r.mx.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, v := range vecs {
+		v.write(bw, constLbls)
+	}
+
+	return bw.Flush()
+}
+
+// Handler returns http handler that serves the metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.Write(w)
+	})
+}
+
+// Vec is a metric with the set of values partitioned by labels.
+type Vec struct {
+	name   string
+	help   string
+	typ    Type
+	labels []string
+
+	mx   sync.Mutex
+	vals map[string]*Value
+}
+
+// With returns the value for the given label values.
+// The number of values must match the number of the Vec labels.
+func (v *Vec) With(lvs ...string) *Value {
+	if len(lvs) != len(v.labels) {
+		panic("metrics: " + v.name + ": wrong number of label values")
+	}
+
+	k := strings.Join(lvs, "\xff")
+
+	v.mx.Lock()
+	defer v.mx.Unlock()
+
+	val, ok := v.vals[k]
+	if !ok {
+		val = &Value{lvs: lvs}
+		v.vals[k] = val
+	}
+
+	return val
+}
+
+// Reset removes all values. Useful for gauges which label values
+// may disappear between scrapes (e.g. locks).
+func (v *Vec) Reset() {
+	v.mx.Lock()
+	defer v.mx.Unlock()
+
+	v.vals = make(map[string]*Value)
+}
+
+func (v *Vec) write(w *bufio.Writer, constLbls []label) {
+	v.mx.Lock()
+	vals := make([]*Value, 0, len(v.vals))
+	for _, val := range v.vals {
+		vals = append(vals, val)
+	}
+	v.mx.Unlock()
+
+	if len(vals) == 0 {
+		return
+	}
+	sort.Slice(vals, func(i, j int) bool {
+		return strings.Join(vals[i].lvs, "\xff") < strings.Join(vals[j].lvs, "\xff")
+	})
+
+	w.WriteString("# HELP " + v.name + " " + escape(v.help, false) + "\n")
+	w.WriteString("# TYPE " + v.name + " " + string(v.typ) + "\n")
+	for _, val := range vals {
+		w.WriteString(v.name)
+
+		lbls := append([]label{}, constLbls...)
+		for i, n := range v.labels {
+			lbls = append(lbls, label{n, val.lvs[i]})
+		}
+		if len(lbls) > 0 {
+			w.WriteByte('{')
+			for i, l := range lbls {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				w.WriteString(l.name + `="` + escape(l.value, true) + `"`)
+			}
+			w.WriteByte('}')
+		}
+
+		w.WriteString(" " + formatFloat(val.Get()) + "\n")
+	}
+}
+
+// Value is a single float64 value of the metric.
+type Value struct {
+	lvs  []string
+	bits uint64
+}
+
+func (v *Value) Set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *Value) Add(f float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		nw := math.Float64bits(math.Float64frombits(old) + f)
+		if atomic.CompareAndSwapUint64(&v.bits, old, nw) {
+			return
+		}
+	}
+}
+
+func (v *Value) Get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// SetBool sets the value to 1 if b is true and to 0 otherwise.
+func (v *Value) SetBool(b bool) {
+	if b {
+		v.Set(1)
+	} else {
+		v.Set(0)
+	}
+}
+
+func escape(s string, quote bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quote {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
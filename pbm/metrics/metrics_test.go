@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	r.SetConstLabel("rs", "rs0")
+	r.SetConstLabel("node", "host:27017")
+
+	up := r.NewCounter("pbm_uploaded_bytes_total", "Bytes uploaded", "storage")
+	lag := r.NewGauge("pbm_pitr_lag_seconds", "PITR lag")
+	r.NewGauge("pbm_empty", "Never set")
+
+	collected := 0
+	r.OnCollect(func() {
+		collected++
+		lag.With().Set(1.5)
+	})
+
+	up.With("s3").Add(100)
+	up.With("s3").Add(28)
+	up.With(`fs"x`).Add(1)
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP pbm_uploaded_bytes_total Bytes uploaded
+# TYPE pbm_uploaded_bytes_total counter
+pbm_uploaded_bytes_total{rs="rs0",node="host:27017",storage="fs\"x"} 1
+pbm_uploaded_bytes_total{rs="rs0",node="host:27017",storage="s3"} 128
+# HELP pbm_pitr_lag_seconds PITR lag
+# TYPE pbm_pitr_lag_seconds gauge
+pbm_pitr_lag_seconds{rs="rs0",node="host:27017"} 1.5
+`
+	if buf.String() != want {
+		t.Errorf("wrong output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	if collected != 1 {
+		t.Errorf("expected collectors to run once, got %d", collected)
+	}
+}
@@ -58,6 +58,16 @@ func NewNode(ctx context.Context, curi string, dumpConns int) (*Node, error) {
 	return n, nil
 }
 
+// NewNodeConn creates a node over an already established connection.
+// It doesn't check the connection nor requests the node info. So it's
+// usable for standalone (not a replset member) mongod as well.
+func NewNodeConn(ctx context.Context, cn *mongo.Client) *Node {
+	return &Node{
+		ctx: ctx,
+		cn:  cn,
+	}
+}
+
 // ID returns node ID
 func (n *Node) ID() string {
 	return fmt.Sprintf("%s/%s", n.rs, n.me)
@@ -245,6 +255,36 @@ func (n *Node) ReplicationLag() (int, error) {
 	return primaryOptime - nodeOptime, nil
 }
 
+// WTCacheUsage returns the WiredTiger cache fill and dirty data ratios
+// in percents. Both are zero if the node doesn't run WiredTiger.
+func (n *Node) WTCacheUsage() (used, dirty float64, err error) {
+	var stat struct {
+		WiredTiger struct {
+			Cache struct {
+				Max   float64 `bson:"maximum bytes configured"`
+				Used  float64 `bson:"bytes currently in the cache"`
+				Dirty float64 `bson:"tracked dirty bytes in the cache"`
+			} `bson:"cache"`
+		} `bson:"wiredTiger"`
+	}
+	err = n.cn.Database("admin").RunCommand(n.ctx, bson.D{
+		{"serverStatus", 1},
+		{"repl", 0},
+		{"metrics", 0},
+		{"locks", 0},
+	}).Decode(&stat)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "run mongo command serverStatus")
+	}
+
+	c := stat.WiredTiger.Cache
+	if c.Max == 0 {
+		return 0, 0, nil
+	}
+
+	return c.Used / c.Max * 100, c.Dirty / c.Max * 100, nil
+}
+
 func (n *Node) ConnURI() string {
 	return n.curi
 }
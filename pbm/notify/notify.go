@@ -0,0 +1,269 @@
+// Package notify delivers notifications about the outcome of PBM
+// operations to the configured webhooks.
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/version"
+)
+
+// Operations the events are sent for
+const (
+	OpBackup  = "backup"
+	OpRestore = "restore"
+	OpPITR    = "pitr"
+	OpDelete  = "delete"
+)
+
+// HTTP headers of the webhook requests
+const (
+	HeaderEvent     = "X-PBM-Event"
+	HeaderSignature = "X-PBM-Signature"
+)
+
+const (
+	maxAttempts    = 5
+	initialBackoff = time.Second
+	maxBackoff     = time.Second * 30
+	requestTimeout = time.Second * 10
+)
+
+// Event is the webhook payload. It is sent on the transition of an
+// operation to a terminal state.
+type Event struct {
+	// Event is "<op>.<status>". E.g. "backup.done" or "restore.error"
+	Event  string     `json:"event"`
+	Op     string     `json:"op"`
+	Status pbm.Status `json:"status"`
+	// Name of the backup or the restore. For the deletes it is
+	// the backup name or the time the older data was deleted before.
+	Name string `json:"name,omitempty"`
+	// Type is the backup type for backups and restores,
+	// or what was deleted for deletes
+	Type string `json:"type,omitempty"`
+	// Backup is the backup the restore was made from
+	Backup string `json:"backup,omitempty"`
+	OPID   string `json:"opid,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	// StartTS and EndTS are unix timestamps of the operation start
+	// and the transition to the terminal state
+	StartTS  int64     `json:"start_ts,omitempty"`
+	EndTS    int64     `json:"end_ts"`
+	Duration int64     `json:"duration_sec"`
+	Error    string    `json:"error,omitempty"`
+	Replsets []Replset `json:"replsets,omitempty"`
+}
+
+// Replset is the replset part of the operation
+type Replset struct {
+	Name   string     `json:"name"`
+	Status pbm.Status `json:"status"`
+	Node   string     `json:"node,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// NewEvent creates the event of the op transition to the status
+// at the current time
+func NewEvent(op string, status pbm.Status, name string) *Event {
+	return &Event{
+		Event:  op + "." + string(status),
+		Op:     op,
+		Status: status,
+		Name:   name,
+		EndTS:  time.Now().UTC().Unix(),
+	}
+}
+
+// BackupEvent creates the event of the backup state
+func BackupEvent(m *pbm.BackupMeta) *Event {
+	e := NewEvent(OpBackup, m.Status, m.Name)
+	e.Type = string(m.Type)
+	e.OPID = m.OPID
+	e.Size = m.Size
+	e.Error = m.Err
+	e.setTimes(m.StartTS, m.LastTransitionTS)
+	for _, rs := range m.Replsets {
+		e.Replsets = append(e.Replsets, Replset{
+			Name:   rs.Name,
+			Status: rs.Status,
+			Node:   rs.Node,
+			Error:  rs.Error,
+		})
+	}
+
+	return e
+}
+
+// RestoreEvent creates the event of the restore state
+func RestoreEvent(m *pbm.RestoreMeta) *Event {
+	e := NewEvent(OpRestore, m.Status, m.Name)
+	e.Type = string(m.Type)
+	e.Backup = m.Backup
+	e.OPID = m.OPID
+	e.Error = m.Error
+	e.setTimes(m.StartTS, m.LastTransitionTS)
+	for _, rs := range m.Replsets {
+		e.Replsets = append(e.Replsets, Replset{
+			Name:   rs.Name,
+			Status: rs.Status,
+			Error:  rs.Error,
+		})
+	}
+
+	return e
+}
+
+func (e *Event) setTimes(start, end int64) {
+	e.StartTS = start
+	if end != 0 {
+		e.EndTS = end
+	}
+	if e.StartTS != 0 && e.EndTS > e.StartTS {
+		e.Duration = e.EndTS - e.StartTS
+	}
+}
+
+// IsTerminal returns true if the status is the final one of an operation
+func IsTerminal(s pbm.Status) bool {
+	switch s {
+	case pbm.StatusDone, pbm.StatusError, pbm.StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// Match checks if the event passes the filter. The filter entries are
+// either an operation ("backup" matches any of its events) or a pattern
+// of the event name ("*.error" or "restore.done"). An empty filter
+// matches everything.
+func Match(filter []string, event string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	for _, f := range filter {
+		if !strings.Contains(f, ".") {
+			f += ".*"
+		}
+		if ok, _ := path.Match(f, event); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sign returns the signature of the payload sent in the HeaderSignature
+func Sign(secret string, payload []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(payload)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// Send posts the event to the webhooks which filters match it.
+// It returns when all deliveries either succeed or run out of retries.
+func Send(hooks []pbm.WebhookConf, e *Event, l *log.Event) {
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		l.Error("notify: marshal event: %v", err)
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	for _, h := range hooks {
+		if !Match(h.Events, e.Event) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(h pbm.WebhookConf) {
+			defer wg.Done()
+
+			err := deliver(h, e.Event, payload)
+			if err != nil {
+				l.Error("notify %s: %s: %v", h.URL, e.Event, err)
+				return
+			}
+			l.Debug("notify %s: %s: delivered", h.URL, e.Event)
+		}(h)
+	}
+	wg.Wait()
+}
+
+// deliver posts the payload retrying with the exponential backoff
+func deliver(h pbm.WebhookConf, event string, payload []byte) error {
+	cl := &http.Client{Timeout: requestTimeout}
+
+	var err error
+	backoff := initialBackoff
+	for i := 0; i < maxAttempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+
+		var retry bool
+		retry, err = post(cl, h, event, payload)
+		if err == nil || !retry {
+			return err
+		}
+	}
+
+	return errors.WithMessagef(err, "gave up after %d attempts", maxAttempts)
+}
+
+// post makes a single delivery attempt. It returns true if the failed
+// attempt makes sense to retry.
+func post(cl *http.Client, h pbm.WebhookConf, event string, payload []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, errors.Wrap(err, "create request")
+	}
+
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pbm-agent/"+version.Current().Version)
+	req.Header.Set(HeaderEvent, event)
+	if h.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(h.Secret, payload))
+	}
+
+	resp, err := cl.Do(req)
+	if err != nil {
+		return true, errors.Wrap(err, "post")
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusRequestTimeout:
+		return true, errors.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	return false, errors.Errorf("unexpected response: %s", resp.Status)
+}
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		filter []string
+		event  string
+		want   bool
+	}{
+		{nil, "backup.done", true},
+		{[]string{"backup"}, "backup.error", true},
+		{[]string{"backup"}, "restore.error", false},
+		{[]string{"*.error"}, "pitr.error", true},
+		{[]string{"*.error"}, "pitr.done", false},
+		{[]string{"restore.done", "delete"}, "delete.error", true},
+		{[]string{"restore.done", "delete"}, "restore.canceled", false},
+	}
+
+	for _, c := range cases {
+		if got := Match(c.filter, c.event); got != c.want {
+			t.Errorf("Match(%v, %q): got %v, want %v", c.filter, c.event, got, c.want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	var calls int32
+	var got Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first attempt fails to check the retry
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		if s := r.Header.Get(HeaderSignature); s != Sign("secret", b) {
+			t.Errorf("wrong signature %q", s)
+		}
+		if h := r.Header.Get("Authorization"); h != "Bearer token" {
+			t.Errorf("wrong Authorization header %q", h)
+		}
+		if h := r.Header.Get(HeaderEvent); h != "backup.error" {
+			t.Errorf("wrong event header %q", h)
+		}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Errorf("unmarshal payload: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	hooks := []pbm.WebhookConf{
+		{
+			URL:     srv.URL,
+			Headers: map[string]string{"Authorization": "Bearer token"},
+			Secret:  "secret",
+			Events:  []string{"*.error"},
+		},
+		{
+			URL:    srv.URL + "/done",
+			Events: []string{"backup.done"},
+		},
+	}
+
+	e := BackupEvent(&pbm.BackupMeta{
+		Name:             "2023-01-01T00:00:00Z",
+		Type:             pbm.LogicalBackup,
+		Status:           pbm.StatusError,
+		Err:              "some error",
+		StartTS:          100,
+		LastTransitionTS: 160,
+		Replsets: []pbm.BackupReplset{
+			{Name: "rs0", Status: pbm.StatusError, Node: "rs0:27017", Error: "some error"},
+		},
+	})
+	Send(hooks, e, log.New(nil, "", "").NewEvent("", "", "", primitive.Timestamp{}))
+
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected 2 calls, got %d", n)
+	}
+	if got.Event != "backup.error" || got.Duration != 60 || got.Error != "some error" ||
+		len(got.Replsets) != 1 || got.Replsets[0].Node != "rs0:27017" {
+		t.Errorf("unexpected payload: %+v", got)
+	}
+}
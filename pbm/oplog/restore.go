@@ -27,6 +27,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
 	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
 )
 
@@ -38,6 +40,10 @@ type OpFilter func(*Record) bool
 
 func DefaultOpFilter(*Record) bool { return true }
 
+// OpTransform can be used to change oplog records before applying
+// (e.g. to mask the data). It's called before the namespaces renaming.
+type OpTransform func(Record) (Record, error)
+
 var excludeFromOplog = []string{
 	"config.rangeDeletions",
 	pbm.DB + "." + pbm.TmpUsersCollection,
@@ -98,6 +104,12 @@ type OplogRestore struct {
 	excludeNS         *ns.Matcher
 	includeNS         map[string]map[string]bool
 	noUUIDns          *ns.Matcher
+	// renamer maps the namespaces of the ops to the ones to restore to.
+	// nil if there is no renaming.
+	renamer *ns.Renamer
+	// notExcludedNS filters out the namespaces excluded from the restore
+	// by the user. nil if there are none.
+	notExcludedNS archive.NSFilterFn
 
 	txn        chan pbm.RestoreTxn
 	txnSyncErr chan error
@@ -106,13 +118,16 @@ type OplogRestore struct {
 	// if we've moved further in general. No need in
 	// `I` precision.
 	lastOpT uint32
+	// The number of applied (observed) ops
+	applied int64
 
 	preserveUUID bool
 	cnamespase   string
 
 	unsafe bool
 
-	filter OpFilter
+	filter    OpFilter
+	transform OpTransform
 }
 
 // NewOplogRestore creates an object for an oplog applying
@@ -158,6 +173,11 @@ func (o *OplogRestore) SetOpFilter(f OpFilter) {
 	o.filter = f
 }
 
+// SetOpTransform sets the transformation of the applied ops. nil means none.
+func (o *OplogRestore) SetOpTransform(f OpTransform) {
+	o.transform = f
+}
+
 // SetTimeframe sets boundaries for the replayed operations. All operations
 // that happened before `start` and after `end` are going to be discarded.
 // Zero `end` (primitive.Timestamp{T:0}) means all chunks will be replayed
@@ -204,6 +224,7 @@ func (o *OplogRestore) Apply(src io.ReadCloser) (lts primitive.Timestamp, err er
 		lts = oe.Timestamp
 		// keeping track of last applied (observed) clusterTime
 		atomic.StoreUint32(&o.lastOpT, oe.Timestamp.T)
+		atomic.AddInt64(&o.applied, 1)
 	}
 
 	return lts, bsonSource.Err()
@@ -236,7 +257,82 @@ func (o *OplogRestore) SetIncludeNS(nss []string) {
 	o.includeNS = dbs
 }
 
+// SetNSRename sets the renaming of the namespaces of applied ops
+// (e.g. "db.*" to "db_restored.*"). Renamed collections get new UUIDs,
+// so the UUIDs of the ops aren't preserved.
+func (o *OplogRestore) SetNSRename(from, to []string) error {
+	if len(from) == 0 {
+		o.renamer = nil
+		return nil
+	}
+
+	r, err := ns.NewRenamer(from, to)
+	if err != nil {
+		return errors.Wrap(err, "create renamer")
+	}
+
+	o.renamer = r
+	o.preserveUUIDopt = false
+	o.preserveUUID = false
+	return nil
+}
+
+// SetExcludeNS sets the patterns of the namespaces (e.g. "logs.*" or
+// "*.tmp_*") which ops are skipped
+func (o *OplogRestore) SetExcludeNS(patterns []string) error {
+	if len(patterns) == 0 {
+		o.notExcludedNS = nil
+		return nil
+	}
+
+	f, err := sel.MakeNSExcludePred(patterns)
+	if err != nil {
+		return err
+	}
+
+	o.notExcludedNS = f
+	return nil
+}
+
+// isOpExcluded returns true if the op belongs to the excluded namespaces.
+// The commands are checked by their target collections.
+func (o *OplogRestore) isOpExcluded(oe *Record) bool {
+	if o.notExcludedNS == nil {
+		return false
+	}
+	if oe.Operation != "c" {
+		return !o.notExcludedNS(oe.Namespace)
+	}
+	if len(oe.Object) == 0 {
+		return false
+	}
+
+	d, _, _ := strings.Cut(oe.Namespace, ".")
+	cmd := oe.Object[0].Key
+	if cmd == "renameCollection" {
+		for _, e := range oe.Object {
+			if e.Key != "renameCollection" && e.Key != "to" {
+				continue
+			}
+			if s, ok := e.Value.(string); ok && !o.notExcludedNS(s) {
+				return true
+			}
+		}
+		return false
+	}
+	if _, ok := collCommands[cmd]; !ok {
+		return false
+	}
+
+	coll, _ := oe.Object[0].Value.(string)
+	return !o.notExcludedNS(d + "." + coll)
+}
+
 func (o *OplogRestore) isOpSelected(oe *Record) bool {
+	if o.isOpExcluded(oe) {
+		return false
+	}
+
 	if o.includeNS == nil || o.includeNS[""] != nil {
 		return true
 	}
@@ -247,10 +343,16 @@ func (o *OplogRestore) isOpSelected(oe *Record) bool {
 		return true
 	}
 
-	if oe.Operation != "c" || c != "$cmd" {
+	if oe.Operation != "c" || c != "$cmd" || len(oe.Object) == 0 {
 		return false
 	}
 
+	// the ops of applyOps and transactions are checked one by one
+	switch oe.Object[0].Key {
+	case "applyOps", "commitTransaction", "abortTransaction":
+		return true
+	}
+
 	m := oe.Object.Map()
 	for _, cmd := range selectedNSSupportedCommands {
 		if ns, ok := m[cmd]; ok {
@@ -266,6 +368,11 @@ func (o *OplogRestore) LastOpTS() uint32 {
 	return atomic.LoadUint32(&o.lastOpT)
 }
 
+// Applied returns the number of ops applied so far
+func (o *OplogRestore) Applied() int64 {
+	return atomic.LoadInt64(&o.applied)
+}
+
 func (o *OplogRestore) handleOp(oe db.Oplog) error {
 	// skip if operation happened after the desired time frame (oe.Timestamp > o.lastTS)
 	if o.endTS.T > 0 && primitive.CompareTimestamp(oe.Timestamp, o.endTS) == 1 {
@@ -289,6 +396,19 @@ func (o *OplogRestore) handleOp(oe db.Oplog) error {
 		return nil
 	}
 
+	if o.transform != nil {
+		var err error
+		oe, err = o.transform(oe)
+		if err != nil {
+			return errors.Wrap(err, "transform op")
+		}
+	}
+
+	oe, ok := o.rename(oe)
+	if !ok {
+		return nil
+	}
+
 	if oe.Operation == "c" && len(oe.Object) > 0 &&
 		(oe.Object[0].Key == "startIndexBuild" || oe.Object[0].Key == "abortIndexBuild") {
 		return nil
@@ -437,6 +557,19 @@ Loop:
 			if !ok {
 				break Loop
 			}
+			if !o.isOpSelected(&op) {
+				continue
+			}
+			if o.transform != nil {
+				op, err = o.transform(op)
+				if err != nil {
+					return errors.Wrap(err, "transform transaction op")
+				}
+			}
+			op, ok = o.rename(op)
+			if !ok {
+				continue
+			}
 			err = o.handleNonTxnOp(op)
 			if err != nil {
 				return errors.Wrap(err, "applying transaction op")
@@ -625,6 +758,104 @@ func (o *OplogRestore) handleNonTxnOp(op db.Oplog) error {
 	return nil
 }
 
+// collCommands are the commands which value is the collection name
+var collCommands = map[string]struct{}{
+	"create":           {},
+	"convertToCapped":  {},
+	"emptycapped":      {},
+	"drop":             {},
+	"createIndexes":    {},
+	"deleteIndex":      {},
+	"deleteIndexes":    {},
+	"dropIndex":        {},
+	"dropIndexes":      {},
+	"collMod":          {},
+	"dbCheck":          {},
+	"startIndexBuild":  {},
+	"abortIndexBuild":  {},
+	"commitIndexBuild": {},
+}
+
+// rename maps the namespaces of the op according to the renaming. It
+// returns false if the op has to be skipped. That's the case of the
+// collection renamed between the renamed and not renamed namespaces as
+// it would change the data outside of the restored namespaces.
+func (o *OplogRestore) rename(op db.Oplog) (db.Oplog, bool) {
+	if o.renamer == nil {
+		return op, true
+	}
+
+	if op.Operation != "c" {
+		op.Namespace = o.renamer.Get(op.Namespace)
+		return op, true
+	}
+	if len(op.Object) == 0 {
+		return op, true
+	}
+
+	obj := make(bson.D, len(op.Object))
+	copy(obj, op.Object)
+
+	d, _, _ := strings.Cut(op.Namespace, ".")
+	cmd := obj[0].Key
+	switch {
+	case cmd == "dropDatabase":
+		op.Namespace = o.renamer.Get(op.Namespace)
+	case cmd == "renameCollection":
+		var renamed int
+		for i, e := range obj {
+			if e.Key != "renameCollection" && e.Key != "to" {
+				continue
+			}
+			from, _ := e.Value.(string)
+			to := o.renamer.Get(from)
+			if to != from {
+				renamed++
+			}
+			obj[i].Value = to
+		}
+		if renamed == 1 {
+			return op, false
+		}
+		if from, ok := obj[0].Value.(string); ok {
+			nd, _, _ := strings.Cut(from, ".")
+			op.Namespace = nd + ".$cmd"
+		}
+	default:
+		if _, ok := collCommands[cmd]; !ok {
+			return op, true
+		}
+		coll, ok := obj[0].Value.(string)
+		if !ok {
+			return op, true
+		}
+		nns := o.renamer.Get(d + "." + coll)
+		nd, nc, _ := strings.Cut(nns, ".")
+		op.Namespace = nd + ".$cmd"
+		obj[0].Value = nc
+
+		// the _id index spec of the create has the namespace on older versions
+		for i, e := range obj {
+			if e.Key != "idIndex" {
+				continue
+			}
+			if idx, ok := e.Value.(bson.D); ok {
+				nidx := make(bson.D, len(idx))
+				copy(nidx, idx)
+				for j := range nidx {
+					if nidx[j].Key == "ns" {
+						nidx[j].Value = nns
+					}
+				}
+				obj[i].Value = nidx
+			}
+		}
+	}
+
+	op.Object = obj
+	return op, true
+}
+
 // extractIndexDocumentFromCommitIndexBuilds extracts the index specs out of  "createIndexes" oplog entry and convert to IndexDocument
 // returns collection name and index spec
 func extractIndexDocumentFromCreateIndexes(op db.Oplog) (string, *idx.IndexDocument) {
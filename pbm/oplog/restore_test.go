@@ -0,0 +1,135 @@
+package oplog
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRename(t *testing.T) {
+	o := &OplogRestore{}
+	err := o.SetNSRename([]string{"orders.*", "db.c"}, []string{"orders_restored.*", "db.c1"})
+	if err != nil {
+		t.Fatalf("set rename: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		op   db.Oplog
+		want db.Oplog
+		skip bool
+	}{
+		{
+			name: "insert",
+			op:   db.Oplog{Operation: "i", Namespace: "orders.items"},
+			want: db.Oplog{Operation: "i", Namespace: "orders_restored.items"},
+		},
+		{
+			name: "not renamed",
+			op:   db.Oplog{Operation: "u", Namespace: "db.other"},
+			want: db.Oplog{Operation: "u", Namespace: "db.other"},
+		},
+		{
+			name: "create",
+			op: db.Oplog{Operation: "c", Namespace: "db.$cmd", Object: bson.D{
+				{"create", "c"},
+				{"idIndex", bson.D{{"v", 2}, {"ns", "db.c"}}},
+			}},
+			want: db.Oplog{Operation: "c", Namespace: "db.$cmd", Object: bson.D{
+				{"create", "c1"},
+				{"idIndex", bson.D{{"v", 2}, {"ns", "db.c1"}}},
+			}},
+		},
+		{
+			name: "createIndexes",
+			op: db.Oplog{Operation: "c", Namespace: "orders.$cmd", Object: bson.D{
+				{"createIndexes", "items"}, {"v", 2}, {"key", bson.D{{"a", 1}}}, {"name", "a_1"},
+			}},
+			want: db.Oplog{Operation: "c", Namespace: "orders_restored.$cmd", Object: bson.D{
+				{"createIndexes", "items"}, {"v", 2}, {"key", bson.D{{"a", 1}}}, {"name", "a_1"},
+			}},
+		},
+		{
+			name: "dropDatabase",
+			op:   db.Oplog{Operation: "c", Namespace: "orders.$cmd", Object: bson.D{{"dropDatabase", 1}}},
+			want: db.Oplog{Operation: "c", Namespace: "orders_restored.$cmd", Object: bson.D{{"dropDatabase", 1}}},
+		},
+		{
+			name: "renameCollection",
+			op: db.Oplog{Operation: "c", Namespace: "orders.$cmd", Object: bson.D{
+				{"renameCollection", "orders.a"}, {"to", "orders.b"},
+			}},
+			want: db.Oplog{Operation: "c", Namespace: "orders_restored.$cmd", Object: bson.D{
+				{"renameCollection", "orders_restored.a"}, {"to", "orders_restored.b"},
+			}},
+		},
+		{
+			name: "renameCollection out of renamed",
+			op: db.Oplog{Operation: "c", Namespace: "orders.$cmd", Object: bson.D{
+				{"renameCollection", "orders.a"}, {"to", "db.a"},
+			}},
+			skip: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			orig, err := bson.Marshal(c.op)
+			if err != nil {
+				t.Fatalf("marshal op: %v", err)
+			}
+
+			got, ok := o.rename(c.op)
+			if ok == c.skip {
+				t.Fatalf("expected skip %v, got %v", c.skip, !ok)
+			}
+			if b, _ := bson.Marshal(c.op); !reflect.DeepEqual(b, orig) {
+				t.Errorf("the original op is modified: %v", c.op.Object)
+			}
+			if c.skip {
+				return
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("expected %+v, got %+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestIsOpExcluded(t *testing.T) {
+	o := &OplogRestore{}
+	err := o.SetExcludeNS([]string{"logs.*", "*.tmp_*"})
+	if err != nil {
+		t.Fatalf("set exclude: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		op   db.Oplog
+		want bool
+	}{
+		{"insert", db.Oplog{Operation: "i", Namespace: "logs.audit"}, true},
+		{"wildcard", db.Oplog{Operation: "u", Namespace: "app.tmp_1"}, true},
+		{"not excluded", db.Oplog{Operation: "d", Namespace: "app.users"}, false},
+		{"create", db.Oplog{Operation: "c", Namespace: "app.$cmd", Object: bson.D{{"create", "tmp_2"}}}, true},
+		{"createIndexes", db.Oplog{Operation: "c", Namespace: "app.$cmd", Object: bson.D{{"createIndexes", "users"}}}, false},
+		{
+			"rename to excluded",
+			db.Oplog{Operation: "c", Namespace: "app.$cmd", Object: bson.D{{"renameCollection", "app.a"}, {"to", "app.tmp_a"}}},
+			true,
+		},
+		{"applyOps", db.Oplog{Operation: "c", Namespace: "admin.$cmd", Object: bson.D{{"applyOps", bson.A{}}}}, false},
+		{"dropDatabase", db.Oplog{Operation: "c", Namespace: "logs.$cmd", Object: bson.D{{"dropDatabase", 1}}}, false},
+	}
+
+	for _, c := range cases {
+		if got := o.isOpExcluded(&c.op); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+		if c.want && o.isOpSelected(&c.op) {
+			t.Errorf("%s: excluded op is selected", c.name)
+		}
+	}
+}
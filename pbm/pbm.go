@@ -22,6 +22,9 @@ import (
 
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/mask"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
 const (
@@ -50,6 +53,8 @@ const (
 	PBMOpLogCollection = "pbmOpLog"
 	// AgentsStatusCollection is an agents registry with its status/health checks
 	AgentsStatusCollection = "pbmAgents"
+	// ScheduleCollection keeps the state of the backup schedule entries
+	ScheduleCollection = "pbmSchedule"
 
 	// MetadataFileSuffix is a suffix for the metadata file on a storage
 	MetadataFileSuffix = ".pbm.json"
@@ -62,17 +67,19 @@ var ErrNotFound = errors.New("not found")
 type Command string
 
 const (
-	CmdUndefined    Command = ""
-	CmdBackup       Command = "backup"
-	CmdRestore      Command = "restore"
-	CmdReplay       Command = "replay"
-	CmdCancelBackup Command = "cancelBackup"
-	CmdResync       Command = "resync"
-	CmdPITR         Command = "pitr"
-	CmdPITRestore   Command = "pitrestore"
-	CmdDeleteBackup Command = "delete"
-	CmdDeletePITR   Command = "deletePitr"
-	CmdCleanup      Command = "cleanup"
+	CmdUndefined     Command = ""
+	CmdBackup        Command = "backup"
+	CmdRestore       Command = "restore"
+	CmdReplay        Command = "replay"
+	CmdCancelBackup  Command = "cancelBackup"
+	CmdCancelRestore Command = "cancelRestore"
+	CmdResync        Command = "resync"
+	CmdPITR          Command = "pitr"
+	CmdPITRestore    Command = "pitrestore"
+	CmdDeleteBackup  Command = "delete"
+	CmdDeletePITR    Command = "deletePitr"
+	CmdCleanup       Command = "cleanup"
+	CmdSchedule      Command = "schedule"
 )
 
 func (c Command) String() string {
@@ -85,6 +92,8 @@ func (c Command) String() string {
 		return "Oplog replay"
 	case CmdCancelBackup:
 		return "Backup cancellation"
+	case CmdCancelRestore:
+		return "Restore cancellation"
 	case CmdResync:
 		return "Resync storage"
 	case CmdPITR:
@@ -97,6 +106,8 @@ func (c Command) String() string {
 		return "Delete PITR chunks"
 	case CmdCleanup:
 		return "Cleanup backups and PITR chunks"
+	case CmdSchedule:
+		return "Backup schedule"
 	default:
 		return "Undefined"
 	}
@@ -166,6 +177,16 @@ type BackupCmd struct {
 	Namespaces       []string                 `bson:"nss,omitempty"`
 	Compression      compress.CompressionType `bson:"compression"`
 	CompressionLevel *int                     `bson:"level,omitempty"`
+	// Throttle overrides the config limits of the storage traffic
+	Throttle *storage.Limits `bson:"throttle,omitempty"`
+	// Profile is the storage profile to save the backup to.
+	// Empty means the main storage.
+	Profile string `bson:"profile,omitempty"`
+	// NSExclude are the patterns of the namespaces excluded from the backup
+	NSExclude []string `bson:"nsExclude,omitempty"`
+	// NSQuery are the filters of the collections documents.
+	// Such a backup is partial.
+	NSQuery []sel.NSQuery `bson:"nsQuery,omitempty"`
 }
 
 func (b BackupCmd) String() string {
@@ -183,6 +204,16 @@ type RestoreCmd struct {
 	BackupName string            `bson:"backupName"`
 	Namespaces []string          `bson:"nss,omitempty"`
 	RSMap      map[string]string `bson:"rsMap,omitempty"`
+	// NSFrom and NSTo are the namespaces patterns to restore the data
+	// under other names (e.g. "db.*" to "db_restored.*")
+	NSFrom []string `bson:"nsFrom,omitempty"`
+	NSTo   []string `bson:"nsTo,omitempty"`
+	// Mask is the masking of the restored data
+	Mask *mask.Spec `bson:"mask,omitempty"`
+	// Throttle overrides the config limits of the storage traffic
+	Throttle *storage.Limits `bson:"throttle,omitempty"`
+	// NSExclude are the patterns of the namespaces excluded from the restore
+	NSExclude []string `bson:"nsExclude,omitempty"`
 }
 
 func (r RestoreCmd) String() string {
@@ -194,6 +225,14 @@ type ReplayCmd struct {
 	Start primitive.Timestamp `bson:"start,omitempty"`
 	End   primitive.Timestamp `bson:"end,omitempty"`
 	RSMap map[string]string   `bson:"rsMap,omitempty"`
+	// NSFrom and NSTo are the namespaces patterns to restore the data
+	// under other names (e.g. "db.*" to "db_restored.*")
+	NSFrom []string `bson:"nsFrom,omitempty"`
+	NSTo   []string `bson:"nsTo,omitempty"`
+	// Mask is the masking of the restored data
+	Mask *mask.Spec `bson:"mask,omitempty"`
+	// Throttle overrides the config limits of the storage traffic
+	Throttle *storage.Limits `bson:"throttle,omitempty"`
 }
 
 func (c ReplayCmd) String() string {
@@ -207,6 +246,19 @@ type PITRestoreCmd struct {
 	Bcp        string            `bson:"bcp"`
 	Namespaces []string          `bson:"nss,omitempty"`
 	RSMap      map[string]string `bson:"rsMap,omitempty"`
+	// NSFrom and NSTo are the namespaces patterns to restore the data
+	// under other names (e.g. "db.*" to "db_restored.*")
+	NSFrom []string `bson:"nsFrom,omitempty"`
+	NSTo   []string `bson:"nsTo,omitempty"`
+	// Mask is the masking of the restored data
+	Mask *mask.Spec `bson:"mask,omitempty"`
+	// Throttle overrides the config limits of the storage traffic
+	Throttle *storage.Limits `bson:"throttle,omitempty"`
+	// NSExclude are the patterns of the namespaces excluded from the restore
+	NSExclude []string `bson:"nsExclude,omitempty"`
+	// AllowPartial allows to apply the oplog on top of a partial backup
+	// (see BackupMeta.IsPartial)
+	AllowPartial bool `bson:"allowPartial,omitempty"`
 }
 
 func (p PITRestoreCmd) String() string {
@@ -227,6 +279,9 @@ type DeletePITRCmd struct {
 
 type CleanupCmd struct {
 	OlderThan primitive.Timestamp `bson:"olderThan"`
+	// Retention means to delete backups and chunks according to
+	// the configured retention policy instead of OlderThan
+	Retention bool `bson:"retention,omitempty"`
 }
 
 func (d DeleteBackupCmd) String() string {
@@ -480,27 +535,45 @@ type BackupMeta struct {
 	// Empty means this is a full backup (and a base for further incremental bcps).
 	SrcBackup string `bson:"src_backup,omitempty" json:"src_backup,omitempty"`
 
-	Namespaces       []string                 `bson:"nss,omitempty" json:"nss,omitempty"`
-	Replsets         []BackupReplset          `bson:"replsets" json:"replsets"`
-	Compression      compress.CompressionType `bson:"compression" json:"compression"`
-	Store            StorageConf              `bson:"store" json:"store"`
-	Size             int64                    `bson:"size" json:"size"`
-	MongoVersion     string                   `bson:"mongodb_version" json:"mongodb_version,omitempty"`
-	FCV              string                   `bson:"fcv" json:"fcv"`
-	StartTS          int64                    `bson:"start_ts" json:"start_ts"`
-	LastTransitionTS int64                    `bson:"last_transition_ts" json:"last_transition_ts"`
-	FirstWriteTS     primitive.Timestamp      `bson:"first_write_ts" json:"first_write_ts"`
-	LastWriteTS      primitive.Timestamp      `bson:"last_write_ts" json:"last_write_ts"`
-	Hb               primitive.Timestamp      `bson:"hb" json:"hb"`
-	Status           Status                   `bson:"status" json:"status"`
-	Conditions       []Condition              `bson:"conditions" json:"conditions"`
-	Nomination       []BackupRsNomination     `bson:"n" json:"n"`
-	Err              string                   `bson:"error,omitempty" json:"error,omitempty"`
-	PBMVersion       string                   `bson:"pbm_version,omitempty" json:"pbm_version,omitempty"`
-	BalancerStatus   BalancerMode             `bson:"balancer" json:"balancer"`
+	// NSExclude are the patterns of the namespaces excluded from the backup
+	NSExclude []string `bson:"ns_exclude,omitempty" json:"ns_exclude,omitempty"`
+
+	// NSQuery are the filters of the collections documents. Only the
+	// matching documents are in the backup.
+	NSQuery []sel.NSQuery `bson:"ns_query,omitempty" json:"ns_query,omitempty"`
+
+	Namespaces  []string                 `bson:"nss,omitempty" json:"nss,omitempty"`
+	Replsets    []BackupReplset          `bson:"replsets" json:"replsets"`
+	Compression compress.CompressionType `bson:"compression" json:"compression"`
+	Store       BackupStore              `bson:"store" json:"store"`
+	// KeyID is the id of the key the backup data was encrypted with.
+	// Empty means the data is not encrypted.
+	KeyID            string               `bson:"key_id,omitempty" json:"key_id,omitempty"`
+	Size             int64                `bson:"size" json:"size"`
+	MongoVersion     string               `bson:"mongodb_version" json:"mongodb_version,omitempty"`
+	FCV              string               `bson:"fcv" json:"fcv"`
+	StartTS          int64                `bson:"start_ts" json:"start_ts"`
+	LastTransitionTS int64                `bson:"last_transition_ts" json:"last_transition_ts"`
+	FirstWriteTS     primitive.Timestamp  `bson:"first_write_ts" json:"first_write_ts"`
+	LastWriteTS      primitive.Timestamp  `bson:"last_write_ts" json:"last_write_ts"`
+	Hb               primitive.Timestamp  `bson:"hb" json:"hb"`
+	Status           Status               `bson:"status" json:"status"`
+	Conditions       []Condition          `bson:"conditions" json:"conditions"`
+	Nomination       []BackupRsNomination `bson:"n" json:"n"`
+	Err              string               `bson:"error,omitempty" json:"error,omitempty"`
+	PBMVersion       string               `bson:"pbm_version,omitempty" json:"pbm_version,omitempty"`
+	BalancerStatus   BalancerMode         `bson:"balancer" json:"balancer"`
 	runtimeError     error
 }
 
+// BackupStore is the storage the backup is saved to
+type BackupStore struct {
+	// Profile is the name of the storage profile.
+	// Empty means the main storage.
+	Profile     string `bson:"profile,omitempty" json:"profile,omitempty"`
+	StorageConf `bson:",inline" json:",inline"`
+}
+
 func (b *BackupMeta) Error() error {
 	switch {
 	case b.runtimeError != nil:
@@ -517,6 +590,25 @@ func (b *BackupMeta) SetRuntimeError(err error) {
 	b.Status = StatusError
 }
 
+// IsPartial tells whether only some documents of the collections are in
+// the backup. The oplog on top of such a backup may refer to missing ones.
+func (b *BackupMeta) IsPartial() bool {
+	return len(b.NSQuery) != 0
+}
+
+// LastVerification returns the result of the last verification
+// of the backup. It returns nil if the backup has never been verified.
+func (b *BackupMeta) LastVerification() *Condition {
+	for i := len(b.Conditions) - 1; i >= 0; i-- {
+		switch b.Conditions[i].Status {
+		case StatusVerified, StatusVerifyFailed:
+			return &b.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
 // BackupRsNomination is used to choose (nominate and elect) nodes for the backup
 // within a replica set
 type BackupRsNomination struct {
@@ -537,6 +629,7 @@ type BackupReplset struct {
 	Files            []File              `bson:"files,omitempty" json:"files,omitempty"`
 	DumpName         string              `bson:"dump_name,omitempty" json:"backup_name,omitempty"`
 	OplogName        string              `bson:"oplog_name,omitempty" json:"oplog_name,omitempty"`
+	OplogChecksum    string              `bson:"oplog_checksum,omitempty" json:"oplog_checksum,omitempty"`
 	StartTS          int64               `bson:"start_ts" json:"start_ts"`
 	Status           Status              `bson:"status" json:"status"`
 	IsConfigSvr      *bool               `bson:"iscs,omitempty" json:"iscs,omitempty"`
@@ -547,15 +640,28 @@ type BackupReplset struct {
 	Error            string              `bson:"error,omitempty" json:"error,omitempty"`
 	Conditions       []Condition         `bson:"conditions" json:"conditions"`
 	MongodOpts       *MongodOpts         `bson:"mongod_opts,omitempty" json:"mongod_opts,omitempty"`
+	Progress         *BackupProgress     `bson:"progress,omitempty" json:"progress,omitempty"`
+	ThrottleEvents   []ThrottleEvent     `bson:"throttle_events,omitempty" json:"throttle_events,omitempty"`
+}
+
+// ThrottleEvent is a change of the adaptive throttling level
+// of a logical backup
+type ThrottleEvent struct {
+	Timestamp int64 `bson:"timestamp" json:"timestamp"`
+	// Level is the new throttling level. 0 means the backup isn't throttled
+	Level int `bson:"level" json:"level"`
+	// Reason describes the exceeded thresholds
+	Reason string `bson:"reason,omitempty" json:"reason,omitempty"`
 }
 
 type File struct {
-	Name    string      `bson:"filename" json:"filename"`
-	Off     int64       `bson:"offset" json:"offset"` // offset for incremental backups
-	Len     int64       `bson:"length" json:"length"` // length of chunk after the offset
-	Size    int64       `bson:"fileSize" json:"fileSize"`
-	StgSize int64       `bson:"stgSize" json:"stgSize"`
-	Fmode   os.FileMode `bson:"fmode" json:"fmode"`
+	Name     string      `bson:"filename" json:"filename"`
+	Off      int64       `bson:"offset" json:"offset"` // offset for incremental backups
+	Len      int64       `bson:"length" json:"length"` // length of chunk after the offset
+	Size     int64       `bson:"fileSize" json:"fileSize"`
+	StgSize  int64       `bson:"stgSize" json:"stgSize"`
+	Fmode    os.FileMode `bson:"fmode" json:"fmode"`
+	Checksum string      `bson:"checksum,omitempty" json:"checksum,omitempty"`
 }
 
 func (f File) String() string {
@@ -596,6 +702,11 @@ const (
 	StatusDone       Status = "done"
 	StatusCancelled  Status = "canceled"
 	StatusError      Status = "error"
+
+	// backup verification results. They are recorded as the backup's
+	// conditions only and don't change the backup status
+	StatusVerified     Status = "verified"
+	StatusVerifyFailed Status = "verifyFailed"
 )
 
 func (p *PBM) SetBackupMeta(m *BackupMeta) error {
@@ -729,6 +840,18 @@ func (p *PBM) ChangeRSState(bcpName string, rsName string, s Status, msg string)
 	return err
 }
 
+// AddBackupCondition appends the condition to the backup's history
+// without changing the backup status
+func (p *PBM) AddBackupCondition(bcpName string, c Condition) error {
+	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", bcpName}},
+		bson.D{{"$push", bson.M{"conditions": c}}},
+	)
+
+	return err
+}
+
 func (p *PBM) IncBackupSize(ctx context.Context, bcpName string, size int64) error {
 	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(ctx,
 		bson.D{{"name", bcpName}},
@@ -762,6 +885,42 @@ func (p *PBM) SetRSLastWrite(bcpName string, rsName string, ts primitive.Timesta
 	return err
 }
 
+func (p *PBM) SetRSOplogChecksum(bcpName string, rsName string, sum string) error {
+	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{
+			{"$set", bson.M{"replsets.$.oplog_checksum": sum}},
+		},
+	)
+
+	return err
+}
+
+func (p *PBM) SetRSProgress(bcpName string, rsName string, prg *BackupProgress) error {
+	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{
+			{"$set", bson.M{"replsets.$.progress": prg}},
+		},
+	)
+
+	return err
+}
+
+func (p *PBM) AddRSThrottleEvent(bcpName string, rsName string, e ThrottleEvent) error {
+	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{
+			{"$push", bson.M{"replsets.$.throttle_events": e}},
+		},
+	)
+
+	return err
+}
+
 func (p *PBM) GetBackupMeta(name string) (*BackupMeta, error) {
 	return p.getBackupMeta(bson.D{{"name", name}})
 }
@@ -790,11 +949,24 @@ func (p *PBM) LastIncrementalBackup() (*BackupMeta, error) {
 
 // GetLastBackup returns last successfully finished backup
 // or nil if there is no such backup yet. If ts isn't nil it will
-// search for the most recent backup that finished before specified timestamp
+// search for the most recent backup that finished before specified timestamp.
+// Partial backups (see BackupMeta.IsPartial) are skipped.
 func (p *PBM) GetLastBackup(before *primitive.Timestamp) (*BackupMeta, error) {
+	return p.getRecentBackup(nil, before, -1,
+		bson.D{{"nss", nil}, {"ns_query", nil}, {"type", string(LogicalBackup)}})
+}
+
+// GetLastBackupWithPartial is GetLastBackup that also considers partial backups
+func (p *PBM) GetLastBackupWithPartial(before *primitive.Timestamp) (*BackupMeta, error) {
 	return p.getRecentBackup(nil, before, -1, bson.D{{"nss", nil}, {"type", string(LogicalBackup)}})
 }
 
+// LastSuccessfulBackup returns the most recent successfully finished
+// backup of any type or ErrNotFound if there is no such backup yet
+func (p *PBM) LastSuccessfulBackup() (*BackupMeta, error) {
+	return p.getRecentBackup(nil, nil, -1, bson.D{})
+}
+
 func (p *PBM) GetFirstBackup(after *primitive.Timestamp) (*BackupMeta, error) {
 	return p.getRecentBackup(after, nil, 1, bson.D{{"nss", nil}, {"type", string(LogicalBackup)}})
 }
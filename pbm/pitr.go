@@ -33,6 +33,10 @@ type OplogChunk struct {
 	StartTS     primitive.Timestamp      `bson:"start_ts"`
 	EndTS       primitive.Timestamp      `bson:"end_ts"`
 	Size        int64                    `bson:"size"`
+	Checksum    string                   `bson:"checksum,omitempty"`
+	// KeyID is the id of the key the chunk was encrypted with.
+	// Chunks without it are read as is.
+	KeyID string `bson:"key_id,omitempty"`
 }
 
 // IsPITR checks if PITR is enabled
@@ -15,6 +15,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm"
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
@@ -56,6 +57,11 @@ func (s *Slicer) GetSpan() time.Duration {
 	return time.Duration(atomic.LoadInt64(&s.span))
 }
 
+// LastTS returns the timestamp up to which the oplog is saved
+func (s *Slicer) LastTS() primitive.Timestamp {
+	return s.lastTS
+}
+
 // Catchup seeks for the last saved (backed up) TS - the starting point. It should be run only
 // if the timeline was lost (e.g. on (re)start, restart after backup, node's fail).
 // The starting point sets to the last backup's or last PITR chunk's TS whichever is the most recent.
@@ -196,10 +202,11 @@ func (s *Slicer) OplogOnlyCatchup() (err error) {
 }
 
 func (s *Slicer) copyFromBcp(bcp *pbm.BackupMeta) error {
-	var oplog string
+	var oplog, sum string
 	for _, r := range bcp.Replsets {
 		if r.Name == s.rs {
 			oplog = r.OplogName
+			sum = r.OplogChecksum
 			break
 		}
 	}
@@ -208,9 +215,9 @@ func (s *Slicer) copyFromBcp(bcp *pbm.BackupMeta) error {
 	}
 
 	n := s.chunkPath(bcp.FirstWriteTS, bcp.LastWriteTS, bcp.Compression)
-	err := s.storage.Copy(oplog, n)
+	err := s.copyOplog(bcp, oplog, n)
 	if err != nil {
-		return errors.Wrap(err, "storage copy")
+		return err
 	}
 	stat, err := s.storage.FileStat(n)
 	if err != nil {
@@ -224,6 +231,12 @@ func (s *Slicer) copyFromBcp(bcp *pbm.BackupMeta) error {
 		StartTS:     bcp.FirstWriteTS,
 		EndTS:       bcp.LastWriteTS,
 		Size:        stat.Size,
+		Checksum:    sum,
+		KeyID:       bcp.KeyID,
+	}
+	// the oplog streamed from a profile is encrypted by the slicer storage
+	if bcp.Store.Profile != "" {
+		meta.KeyID = crypt.KeyID(s.storage)
 	}
 	err = s.pbm.PITRAddChunk(meta)
 	if err != nil {
@@ -233,6 +246,51 @@ func (s *Slicer) copyFromBcp(bcp *pbm.BackupMeta) error {
 	return nil
 }
 
+// copyOplog copies the backup oplog object src into the chunk dst. The oplog
+// of the backup saved to a storage profile is streamed from that storage.
+func (s *Slicer) copyOplog(bcp *pbm.BackupMeta, src, dst string) error {
+	if bcp.Store.Profile == "" {
+		return errors.Wrap(s.storage.Copy(src, dst), "storage copy")
+	}
+
+	cfg, err := s.pbm.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+	cfg, err = cfg.WithProfile(bcp.Store.Profile)
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+	stg, err := pbm.Storage(cfg, s.l)
+	if err != nil {
+		return errors.Wrapf(err, "get storage %q", bcp.Store.Profile)
+	}
+	keys, err := crypt.NewKeyProvider(cfg.Encryption)
+	if err != nil {
+		return errors.Wrap(err, "init encryption")
+	}
+
+	err = streamObject(crypt.WrapRead(stg, keys, bcp.KeyID), s.storage, src, dst)
+	return errors.Wrapf(err, "copy from storage %q", bcp.Store.Profile)
+}
+
+// streamObject copies the object src of the from storage into the dst of
+// the to storage. The data is decrypted on read and encrypted on save as
+// the storages do.
+func streamObject(from, to storage.Storage, src, dst string) error {
+	stat, err := from.FileStat(src)
+	if err != nil {
+		return errors.Wrapf(err, "file stat %s", src)
+	}
+	r, err := from.SourceReader(src)
+	if err != nil {
+		return errors.Wrapf(err, "get object %s", src)
+	}
+	defer r.Close()
+
+	return errors.Wrapf(to.Save(dst, r, stat.Size), "save %s", dst)
+}
+
 // ErrOpMoved is the error signaling that slicing op
 // now being run by the other node
 type ErrOpMoved struct {
@@ -407,7 +465,7 @@ func (s *Slicer) upload(from, to primitive.Timestamp, compression compress.Compr
 	s.oplog.SetTailingSpan(from, to)
 	fname := s.chunkPath(from, to, compression)
 	// if use parent ctx, upload will be canceled on the "done" signal
-	size, err := backup.Upload(context.Background(), s.oplog, s.storage, compression, level, fname, -1)
+	size, sum, err := backup.Upload(context.Background(), s.oplog, s.storage, compression, level, fname, -1)
 	if err != nil {
 		// PITR chunks have no metadata to indicate any failed state and if something went
 		// wrong during the data read we may end up with an already created file. Although
@@ -428,6 +486,8 @@ func (s *Slicer) upload(from, to primitive.Timestamp, compression compress.Compr
 		StartTS:     from,
 		EndTS:       to,
 		Size:        size,
+		Checksum:    sum,
+		KeyID:       crypt.KeyID(s.storage),
 	}
 	err = s.pbm.PITRAddChunk(meta)
 	if err != nil {
@@ -0,0 +1,46 @@
+package pitr
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+// the oplog of the backup saved to a storage profile
+// isn't on the main storage
+func TestStreamObjectFromProfile(t *testing.T) {
+	mainStg := fs.New(fs.Conf{Path: t.TempDir()})
+	profile := fs.New(fs.Conf{Path: t.TempDir()})
+
+	src := "bcp/rs0/local.oplog.rs.bson.s2"
+	dst := "pbmPitr/rs0/20230101/20230101000000-1.20230101000100-1.oplog.s2"
+	data := []byte("oplog data")
+	err := profile.Save(src, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mainStg.Copy(src, dst); err == nil {
+		t.Fatal("expected the object to be missing on the main storage")
+	}
+
+	err = streamObject(profile, mainStg, src, dst)
+	if err != nil {
+		t.Fatalf("stream object: %v", err)
+	}
+
+	r, err := mainStg.SourceReader(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+}
@@ -0,0 +1,160 @@
+package pbm
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// BackupProgress is the progress of copying the replset data during
+// the backup. It is updated periodically by the agent doing the backup.
+type BackupProgress struct {
+	// BytesRead is the amount of data read from the node
+	BytesRead int64 `bson:"bytes_read" json:"bytes_read"`
+	// BytesUploaded is the amount of data sent to the storage
+	// (after the compression)
+	BytesUploaded int64 `bson:"bytes_uploaded" json:"bytes_uploaded"`
+	// BytesTotal is the estimated amount of data to read
+	BytesTotal int64 `bson:"bytes_total" json:"bytes_total"`
+	// CollsDone and CollsTotal are set for logical backups
+	CollsDone  int `bson:"colls_done,omitempty" json:"colls_done,omitempty"`
+	CollsTotal int `bson:"colls_total,omitempty" json:"colls_total,omitempty"`
+	// FilesDone and FilesTotal are set for physical backups
+	FilesDone  int `bson:"files_done,omitempty" json:"files_done,omitempty"`
+	FilesTotal int `bson:"files_total,omitempty" json:"files_total,omitempty"`
+	// ETA is the estimated unix time when the data copying is done.
+	// 0 means it can't be estimated yet.
+	ETA int64 `bson:"eta,omitempty" json:"eta,omitempty"`
+	// UpdatedTS is the unix time of the last update
+	UpdatedTS int64 `bson:"updated_ts" json:"updated_ts"`
+}
+
+// EstimateETA sets ETA assuming the rest of the data is read at the
+// same average rate as it was since the start (unix time) till now.
+func (p *BackupProgress) EstimateETA(start, now int64) {
+	p.ETA = 0
+	if p.BytesRead <= 0 || now <= start {
+		return
+	}
+
+	left := p.BytesTotal - p.BytesRead
+	if left <= 0 {
+		p.ETA = now
+		return
+	}
+
+	p.ETA = now + int64(float64(now-start)*float64(left)/float64(p.BytesRead))
+}
+
+// Percent returns the share of the data read so far or -1 if the total
+// is unknown. Since the total is just an estimation, the share is
+// capped at 99 till the copying is done.
+func (p *BackupProgress) Percent() int {
+	if p.BytesTotal <= 0 {
+		return -1
+	}
+
+	v := int(p.BytesRead * 100 / p.BytesTotal)
+	if v > 99 {
+		v = 99
+	}
+	return v
+}
+
+const (
+	// RestoreStageData is the stage of copying the backup data
+	RestoreStageData = "data"
+	// RestoreStageOplog is the stage of the oplog replay
+	RestoreStageOplog = "oplog"
+)
+
+// RestoreProgress is the progress of the replset restore. It is updated
+// periodically by the agent doing the restore. For physical restores,
+// it comes via the stat files on the storage and shows the slowest
+// node of the replset.
+type RestoreProgress struct {
+	// Stage is either RestoreStageData or RestoreStageOplog
+	Stage string `bson:"stage" json:"stage"`
+	// BytesDownloaded is the amount of the backup data fetched from
+	// the storage (after the decompression)
+	BytesDownloaded int64 `bson:"bytes_downloaded" json:"bytes_downloaded"`
+	// BytesTotal is the amount of the backup data to fetch
+	BytesTotal int64 `bson:"bytes_total" json:"bytes_total"`
+	// NSDone, NSTotal and DocsInserted are set for logical restores
+	NSDone       int   `bson:"ns_done,omitempty" json:"ns_done,omitempty"`
+	NSTotal      int   `bson:"ns_total,omitempty" json:"ns_total,omitempty"`
+	DocsInserted int64 `bson:"docs_inserted,omitempty" json:"docs_inserted,omitempty"`
+	// FilesDone and FilesTotal are set for physical restores
+	FilesDone  int `bson:"files_done,omitempty" json:"files_done,omitempty"`
+	FilesTotal int `bson:"files_total,omitempty" json:"files_total,omitempty"`
+	// OplogApplied is the number of oplog entries applied so far.
+	// OplogLastTS is the last applied one and OplogTargetTS is the one
+	// the replay is going to stop on.
+	OplogApplied  int64               `bson:"oplog_applied,omitempty" json:"oplog_applied,omitempty"`
+	OplogStartTS  primitive.Timestamp `bson:"oplog_start_ts,omitempty" json:"oplog_start_ts,omitempty"`
+	OplogLastTS   primitive.Timestamp `bson:"oplog_last_ts,omitempty" json:"oplog_last_ts,omitempty"`
+	OplogTargetTS primitive.Timestamp `bson:"oplog_target_ts,omitempty" json:"oplog_target_ts,omitempty"`
+	// ETA is the estimated unix time when the current stage is done.
+	// 0 means it can't be estimated yet.
+	ETA int64 `bson:"eta,omitempty" json:"eta,omitempty"`
+	// UpdatedTS is the unix time of the last update
+	UpdatedTS int64 `bson:"updated_ts" json:"updated_ts"`
+}
+
+// done returns the share of the current stage done or -1 if it is unknown
+func (p *RestoreProgress) done() float64 {
+	if p.Stage == RestoreStageOplog {
+		if p.OplogTargetTS.T <= p.OplogStartTS.T {
+			return -1
+		}
+		if p.OplogLastTS.T <= p.OplogStartTS.T {
+			return 0
+		}
+
+		return float64(p.OplogLastTS.T-p.OplogStartTS.T) /
+			float64(p.OplogTargetTS.T-p.OplogStartTS.T)
+	}
+
+	if p.BytesTotal <= 0 {
+		return -1
+	}
+	return float64(p.BytesDownloaded) / float64(p.BytesTotal)
+}
+
+// EstimateETA sets ETA assuming the rest of the current stage goes at
+// the same average rate as it was since the stage start (unix time)
+// till now.
+func (p *RestoreProgress) EstimateETA(start, now int64) {
+	p.ETA = 0
+	d := p.done()
+	if d <= 0 || now <= start {
+		return
+	}
+
+	if d >= 1 {
+		p.ETA = now
+		return
+	}
+
+	p.ETA = now + int64(float64(now-start)*(1-d)/d)
+}
+
+// Percent returns the share of the current stage done so far or -1 if
+// it is unknown. It is capped at 99 till the stage is finished.
+func (p *RestoreProgress) Percent() int {
+	d := p.done()
+	if d < 0 {
+		return -1
+	}
+
+	v := int(d * 100)
+	if v > 99 {
+		v = 99
+	}
+	return v
+}
+
+// behind tells whether the progress is behind the given one
+func (p *RestoreProgress) behind(o *RestoreProgress) bool {
+	if p.Stage != o.Stage {
+		return p.Stage != RestoreStageOplog
+	}
+
+	return p.done() < o.done()
+}
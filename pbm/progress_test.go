@@ -0,0 +1,112 @@
+package pbm
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBackupProgressETA(t *testing.T) {
+	cases := []struct {
+		name     string
+		read     int64
+		total    int64
+		start    int64
+		now      int64
+		expected int64
+	}{
+		{name: "nothing read", read: 0, total: 100, start: 10, now: 20, expected: 0},
+		{name: "no time passed", read: 10, total: 100, start: 10, now: 10, expected: 0},
+		{name: "quarter", read: 25, total: 100, start: 10, now: 20, expected: 50},
+		{name: "half", read: 50, total: 100, start: 0, now: 60, expected: 120},
+		{name: "total underestimated", read: 120, total: 100, start: 0, now: 60, expected: 60},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := BackupProgress{BytesRead: c.read, BytesTotal: c.total}
+			p.EstimateETA(c.start, c.now)
+			if p.ETA != c.expected {
+				t.Errorf("expected ETA %d, got %d", c.expected, p.ETA)
+			}
+		})
+	}
+}
+
+func TestBackupProgressPercent(t *testing.T) {
+	cases := []struct {
+		read     int64
+		total    int64
+		expected int
+	}{
+		{read: 10, total: 0, expected: -1},
+		{read: 0, total: 100, expected: 0},
+		{read: 42, total: 100, expected: 42},
+		{read: 100, total: 100, expected: 99},
+		{read: 150, total: 100, expected: 99},
+	}
+
+	for _, c := range cases {
+		p := BackupProgress{BytesRead: c.read, BytesTotal: c.total}
+		if v := p.Percent(); v != c.expected {
+			t.Errorf("%d of %d: expected %d, got %d", c.read, c.total, c.expected, v)
+		}
+	}
+}
+
+func TestRestoreProgressPercent(t *testing.T) {
+	ts := func(t uint32) primitive.Timestamp { return primitive.Timestamp{T: t} }
+
+	cases := []struct {
+		name     string
+		p        RestoreProgress
+		expected int
+	}{
+		{name: "data unknown total", p: RestoreProgress{Stage: RestoreStageData, BytesDownloaded: 10}, expected: -1},
+		{name: "data", p: RestoreProgress{Stage: RestoreStageData, BytesDownloaded: 30, BytesTotal: 120}, expected: 25},
+		{name: "data overflow", p: RestoreProgress{Stage: RestoreStageData, BytesDownloaded: 130, BytesTotal: 120}, expected: 99},
+		{name: "oplog no target", p: RestoreProgress{Stage: RestoreStageOplog, OplogStartTS: ts(100), OplogLastTS: ts(150)}, expected: -1},
+		{name: "oplog not started", p: RestoreProgress{Stage: RestoreStageOplog, OplogStartTS: ts(100), OplogTargetTS: ts(200)}, expected: 0},
+		{name: "oplog", p: RestoreProgress{Stage: RestoreStageOplog, OplogStartTS: ts(100), OplogLastTS: ts(140), OplogTargetTS: ts(200)}, expected: 40},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if v := c.p.Percent(); v != c.expected {
+				t.Errorf("expected %d, got %d", c.expected, v)
+			}
+		})
+	}
+}
+
+func TestRestoreProgressETA(t *testing.T) {
+	p := RestoreProgress{
+		Stage:         RestoreStageOplog,
+		OplogStartTS:  primitive.Timestamp{T: 1000},
+		OplogLastTS:   primitive.Timestamp{T: 1250},
+		OplogTargetTS: primitive.Timestamp{T: 2000},
+	}
+	p.EstimateETA(10, 40)
+	if p.ETA != 130 {
+		t.Errorf("expected ETA 130, got %d", p.ETA)
+	}
+
+	p = RestoreProgress{Stage: RestoreStageData, BytesTotal: 100}
+	p.EstimateETA(10, 40)
+	if p.ETA != 0 {
+		t.Errorf("expected no ETA, got %d", p.ETA)
+	}
+}
+
+func TestRestoreProgressBehind(t *testing.T) {
+	data := &RestoreProgress{Stage: RestoreStageData, BytesDownloaded: 90, BytesTotal: 100}
+	oplog := &RestoreProgress{Stage: RestoreStageOplog}
+	if !data.behind(oplog) || oplog.behind(data) {
+		t.Error("data stage should be behind the oplog one")
+	}
+
+	slow := &RestoreProgress{Stage: RestoreStageData, BytesDownloaded: 10, BytesTotal: 100}
+	if !slow.behind(data) || data.behind(slow) {
+		t.Error("10% should be behind 90%")
+	}
+}
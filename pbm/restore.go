@@ -45,10 +45,14 @@ type RestoreReplset struct {
 	CurrentOp        primitive.Timestamp `bson:"op" json:"op"`
 	LastTransitionTS int64               `bson:"last_transition_ts" json:"last_transition_ts"`
 	LastWriteTS      primitive.Timestamp `bson:"last_write_ts" json:"last_write_ts"`
-	Nodes            []RestoreNode       `bson:"nodes,omitempty" json:"nodes,omitempty"`
-	Error            string              `bson:"error,omitempty" json:"error,omitempty"`
-	Conditions       Conditions          `bson:"conditions" json:"conditions"`
-	Hb               primitive.Timestamp `bson:"hb" json:"hb"`
+	// LastAppliedTS is the last oplog operation applied before
+	// the restore was canceled
+	LastAppliedTS primitive.Timestamp `bson:"last_applied_ts" json:"last_applied_ts"`
+	Nodes         []RestoreNode       `bson:"nodes,omitempty" json:"nodes,omitempty"`
+	Progress      *RestoreProgress    `bson:"progress,omitempty" json:"progress,omitempty"`
+	Error         string              `bson:"error,omitempty" json:"error,omitempty"`
+	Conditions    Conditions          `bson:"conditions" json:"conditions"`
+	Hb            primitive.Timestamp `bson:"hb" json:"hb"`
 }
 
 type Conditions []*Condition
@@ -113,6 +117,28 @@ func (p *PBM) SetCurrentOp(name string, rsName string, ts primitive.Timestamp) e
 	return err
 }
 
+// SetRestoreRSLastApplied sets the timestamp of the last applied oplog
+// operation on the replset
+func (p *PBM) SetRestoreRSLastApplied(name string, rsName string, ts primitive.Timestamp) error {
+	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.last_applied_ts": ts}}},
+	)
+
+	return err
+}
+
+func (p *PBM) SetRestoreRSProgress(name string, rsName string, prg *RestoreProgress) error {
+	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.progress": prg}}},
+	)
+
+	return err
+}
+
 func (p *PBM) SetRestoreMeta(m *RestoreMeta) error {
 	m.LastTransitionTS = m.StartTS
 	m.Conditions = append(m.Conditions, &Condition{
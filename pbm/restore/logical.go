@@ -8,15 +8,17 @@ import (
 	"strings"
 	"time"
 
-	"github.com/golang/snappy"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/mask"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/sel"
 	"github.com/percona/percona-backup-mongodb/pbm/snapshot"
@@ -31,6 +33,12 @@ type Restore struct {
 	stopHB   chan struct{}
 	nodeInfo *pbm.NodeInfo
 	stg      storage.Storage
+	// bcpStg is the storage of the backup being restored. It differs
+	// from stg if the backup was made to a storage profile. PITR chunks
+	// are always read from stg.
+	bcpStg storage.Storage
+	// keys decrypt the backup data. nil if encryption isn't configured.
+	keys crypt.KeyProvider
 	// Shards to participate in restore. Num of shards in bcp could
 	// be less than in the cluster and this is ok. Only these shards
 	// would be expected to run restore (distributed transactions sync,
@@ -39,10 +47,23 @@ type Restore struct {
 	// Only the restore leader would have this info.
 	shards []pbm.Shard
 	rsMap  map[string]string
+	// throttle overrides the config limits of the storage traffic
+	throttle *storage.Limits
+	// nsFrom and nsTo are the namespaces renaming patterns
+	nsFrom []string
+	nsTo   []string
+	// mask is the masking of the restored data. nil if there is none.
+	mask *mask.Masker
+	// nsExclude are the patterns of the namespaces excluded from the restore
+	nsExclude []string
 
 	oplog *oplog.OplogRestore
 	log   *log.Event
 	opid  string
+
+	// prg is the progress of the replset restore. It is nil till
+	// the data copying is started.
+	prg *progress
 }
 
 // New creates a new restore object
@@ -67,7 +88,12 @@ func (r *Restore) Close() {
 }
 
 func (r *Restore) exit(err error, l *log.Event) {
-	if err != nil && !errors.Is(err, ErrNoDataForShard) {
+	if errors.Is(err, ErrCancelled) {
+		ferr := r.MarkCancelled()
+		if ferr != nil {
+			l.Error("mark restore as canceled: %v", ferr)
+		}
+	} else if err != nil && !errors.Is(err, ErrNoDataForShard) {
 		ferr := r.MarkFailed(err)
 		if ferr != nil {
 			l.Error("mark restore as failed `%v`: %v", err, ferr)
@@ -77,10 +103,12 @@ func (r *Restore) exit(err error, l *log.Event) {
 	r.Close()
 }
 
-// Snapshot do the snapshot's (mongo dump) restore
-func (r *Restore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event) (err error) {
+// Snapshot do the snapshot's (mongo dump) restore.
+// It stops with ErrCancelled once the ctx is canceled.
+func (r *Restore) Snapshot(ctx context.Context, cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event) (err error) {
 	defer func() { r.exit(err, l) }() // !!! has to be in a closure
 
+	r.throttle = cmd.Throttle
 	bcp, err := r.SnapshotMeta(cmd.BackupName)
 	if err != nil {
 		return err
@@ -91,7 +119,29 @@ func (r *Restore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event) (er
 		return err
 	}
 
+	err = r.setNSRename(cmd.NSFrom, cmd.NSTo)
+	if err != nil {
+		return err
+	}
+	r.mask, err = mask.New(cmd.Mask)
+	if err != nil {
+		return errors.WithMessage(err, "masking")
+	}
+
+	err = r.setNSExclude(cmd.NSExclude, bcp)
+	if err != nil {
+		return err
+	}
+	if bcp.IsPartial() {
+		l.Warning("backup %s is partial: only the documents matching the query are restored for %s",
+			bcp.Name, partialNSs(bcp))
+	}
+
 	nss := cmd.Namespaces
+	if !sel.IsSelective(nss) {
+		// only the renamed namespaces are restored
+		nss = r.nsFrom
+	}
 	if !sel.IsSelective(nss) {
 		nss = bcp.Namespaces
 	}
@@ -111,6 +161,11 @@ func (r *Restore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event) (er
 		return err
 	}
 
+	err = r.setBackupStorage(bcp)
+	if err != nil {
+		return err
+	}
+
 	dump, oplog, err := r.snapshotObjects(bcp)
 	if err != nil {
 		return err
@@ -121,7 +176,10 @@ func (r *Restore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event) (er
 		return err
 	}
 
-	err = r.RunSnapshot(dump, bcp, nss)
+	stopProgress := r.startProgress()
+	defer stopProgress()
+
+	err = r.RunSnapshot(ctx, dump, bcp, nss)
 	if err != nil {
 		return err
 	}
@@ -137,13 +195,7 @@ func (r *Restore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event) (er
 		oplogOption.filter = newConfigsvrOpFilter(nss)
 	}
 
-	err = r.applyOplog([]pbm.OplogChunk{{
-		RS:          r.nodeInfo.SetName,
-		FName:       oplog,
-		Compression: bcp.Compression,
-		StartTS:     bcp.FirstWriteTS,
-		EndTS:       bcp.LastWriteTS,
-	}}, oplogOption)
+	err = r.applyOplog(ctx, []pbm.OplogChunk{oplog}, oplogOption)
 	if err != nil {
 		return err
 	}
@@ -178,21 +230,36 @@ func newConfigsvrOpFilter(nss []string) oplog.OpFilter {
 	}
 }
 
-// PITR do the Point-in-Time Recovery
-func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err error) {
+// PITR do the Point-in-Time Recovery.
+// It stops with ErrCancelled once the ctx is canceled.
+func (r *Restore) PITR(ctx context.Context, cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err error) {
 	defer func() { r.exit(err, l) }() // !!! has to be in a closure
 
+	r.throttle = cmd.Throttle
 	err = r.init(cmd.Name, opid, l)
 	if err != nil {
 		return err
 	}
 
+	err = r.setNSRename(cmd.NSFrom, cmd.NSTo)
+	if err != nil {
+		return err
+	}
+	r.mask, err = mask.New(cmd.Mask)
+	if err != nil {
+		return errors.WithMessage(err, "masking")
+	}
+
 	tsTo := primitive.Timestamp{T: uint32(cmd.TS), I: uint32(cmd.I)}
 	var bcp *pbm.BackupMeta
 	if cmd.Bcp == "" {
-		bcp, err = r.cn.GetLastBackup(&tsTo)
+		if cmd.AllowPartial {
+			bcp, err = r.cn.GetLastBackupWithPartial(&tsTo)
+		} else {
+			bcp, err = r.cn.GetLastBackup(&tsTo)
+		}
 		if errors.Is(err, pbm.ErrNotFound) {
-			return errors.Errorf("no backup found before ts %v", tsTo)
+			return errors.Errorf("no logical backup found before ts %v. Use --base-snapshot to restore on top of a physical one", tsTo)
 		}
 		if err != nil {
 			return errors.Wrap(err, "define last backup")
@@ -207,7 +274,25 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 		}
 	}
 
+	err = r.setNSExclude(cmd.NSExclude, bcp)
+	if err != nil {
+		return err
+	}
+	if bcp.IsPartial() {
+		// the oplog updates of the documents missing in the backup
+		// would be applied as upserts of the partial documents
+		if !cmd.AllowPartial {
+			return errors.Errorf("backup %s is partial: only some documents of %s are in the backup. "+
+				"Use --allow-partial to restore on top of it anyway or set another --base-snapshot", bcp.Name, partialNSs(bcp))
+		}
+		l.Warning("backup %s is partial: only the documents matching the query are restored for %s",
+			bcp.Name, partialNSs(bcp))
+	}
+
 	nss := cmd.Namespaces
+	if len(nss) == 0 {
+		nss = r.nsFrom
+	}
 	if len(nss) == 0 {
 		nss = bcp.Namespaces
 	}
@@ -248,6 +333,11 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 		return err
 	}
 
+	err = r.setBackupStorage(bcp)
+	if err != nil {
+		return err
+	}
+
 	dump, oplog, err := r.snapshotObjects(bcp)
 	if err != nil {
 		return err
@@ -258,7 +348,10 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 		return err
 	}
 
-	err = r.RunSnapshot(dump, bcp, nss)
+	stopProgress := r.startProgress()
+	defer stopProgress()
+
+	err = r.RunSnapshot(ctx, dump, bcp, nss)
 	if err != nil {
 		return err
 	}
@@ -268,21 +361,13 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 		return err
 	}
 
-	snapshotChunk := pbm.OplogChunk{
-		RS:          r.nodeInfo.SetName,
-		FName:       oplog,
-		Compression: bcp.Compression,
-		StartTS:     bcp.FirstWriteTS,
-		EndTS:       bcp.LastWriteTS,
-	}
-
 	oplogOption := applyOplogOption{end: &tsTo, nss: nss}
 	if r.nodeInfo.IsConfigSrv() && sel.IsSelective(nss) {
 		oplogOption.nss = []string{"config.databases"}
 		oplogOption.filter = newConfigsvrOpFilter(nss)
 	}
 
-	err = r.applyOplog(append([]pbm.OplogChunk{snapshotChunk}, chunks...), &oplogOption)
+	err = r.applyOplog(ctx, append([]pbm.OplogChunk{oplog}, chunks...), &oplogOption)
 	if err != nil {
 		return err
 	}
@@ -294,13 +379,25 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 	return r.Done()
 }
 
-func (r *Restore) ReplayOplog(cmd *pbm.ReplayCmd, opid pbm.OPID, l *log.Event) (err error) {
+// ReplayOplog applies oplog chunks in the given time range.
+// It stops with ErrCancelled once the ctx is canceled.
+func (r *Restore) ReplayOplog(ctx context.Context, cmd *pbm.ReplayCmd, opid pbm.OPID, l *log.Event) (err error) {
 	defer func() { r.exit(err, l) }() // !!! has to be in a closure
 
+	r.throttle = cmd.Throttle
 	if err = r.init(cmd.Name, opid, l); err != nil {
 		return errors.Wrap(err, "init")
 	}
 
+	err = r.setNSRename(cmd.NSFrom, cmd.NSTo)
+	if err != nil {
+		return err
+	}
+	r.mask, err = mask.New(cmd.Mask)
+	if err != nil {
+		return errors.WithMessage(err, "masking")
+	}
+
 	if !r.nodeInfo.IsPrimary {
 		return errors.Errorf("%q is not primary", r.nodeInfo.SetName)
 	}
@@ -341,18 +438,36 @@ func (r *Restore) ReplayOplog(cmd *pbm.ReplayCmd, opid pbm.OPID, l *log.Event) (
 		return err
 	}
 
+	stopProgress := r.startProgress()
+	defer stopProgress()
+
 	oplogOption := applyOplogOption{
 		start:  &cmd.Start,
 		end:    &cmd.End,
+		nss:    r.nsFrom,
 		unsafe: true,
 	}
-	if err = r.applyOplog(chunks, &oplogOption); err != nil {
+	if err = r.applyOplog(ctx, chunks, &oplogOption); err != nil {
 		return err
 	}
 
 	return r.Done()
 }
 
+// startProgress starts saving the replset restore progress to the
+// restore meta. The returned func stops it.
+func (r *Restore) startProgress() func() {
+	r.prg = newProgress()
+	r.prg.countDocs = func(ns string) (int64, error) {
+		db, coll, _ := strings.Cut(ns, ".")
+		return r.node.Session().Database(db).Collection(coll).EstimatedDocumentCount(r.cn.Context())
+	}
+
+	return reportProgress(r.prg, func(p *pbm.RestoreProgress) error {
+		return r.cn.SetRestoreRSProgress(r.name, r.nodeInfo.SetName, p)
+	}, r.log)
+}
+
 func (r *Restore) init(name string, opid pbm.OPID, l *log.Event) (err error) {
 	r.log = l
 
@@ -423,11 +538,84 @@ func (r *Restore) init(name string, opid pbm.OPID, l *log.Event) (err error) {
 		return errors.Wrap(err, "add shard's metadata")
 	}
 
-	r.stg, err = r.cn.GetStorage(r.log)
+	r.stg, err = r.cn.GetThrottledStorage(pbm.ThrottleRestore, r.throttle, r.log)
 	if err != nil {
 		return errors.Wrap(err, "get backup storage")
 	}
 
+	r.keys, err = r.cn.GetKeyProvider()
+	if err != nil {
+		return errors.Wrap(err, "init encryption")
+	}
+	r.stg = crypt.Wrap(r.stg, nil, r.keys)
+
+	return nil
+}
+
+// setNSRename sets the renaming of the restored namespaces
+func (r *Restore) setNSRename(from, to []string) error {
+	if len(from) == 0 && len(to) == 0 {
+		return nil
+	}
+	if r.nodeInfo.IsSharded() {
+		return errors.New("namespaces renaming is not supported for sharded clusters")
+	}
+
+	err := sel.ValidateNSRename(from, to)
+	if err != nil {
+		return errors.WithMessage(err, "namespaces renaming")
+	}
+
+	r.nsFrom, r.nsTo = from, to
+	return nil
+}
+
+// partialNSs returns the comma separated namespaces
+// filtered by query in the partial backup
+func partialNSs(bcp *pbm.BackupMeta) string {
+	nss := make([]string, len(bcp.NSQuery))
+	for i, q := range bcp.NSQuery {
+		nss[i] = q.NS
+	}
+
+	return strings.Join(nss, ", ")
+}
+
+// setNSExclude sets the namespaces excluded from the restore. The namespaces
+// excluded from the backup are excluded as well, so the oplog doesn't bring
+// them back partially.
+func (r *Restore) setNSExclude(nss []string, bcp *pbm.BackupMeta) error {
+	nss = append(append([]string{}, bcp.NSExclude...), nss...)
+	err := sel.ValidateNSExclude(nss)
+	if err != nil {
+		return errors.WithMessage(err, "namespaces exclusion")
+	}
+
+	r.nsExclude = nss
+	return nil
+}
+
+// setBackupStorage sets the storage the backup is kept on
+func (r *Restore) setBackupStorage(bcp *pbm.BackupMeta) error {
+	if bcp.Store.Profile == "" {
+		r.bcpStg = crypt.WrapRead(r.stg, r.keys, bcp.KeyID)
+		return nil
+	}
+
+	cfg, err := r.cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+	cfg, err = cfg.WithProfile(bcp.Store.Profile)
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+	stg, err := pbm.ThrottledStorage(cfg, pbm.ThrottleRestore, r.throttle, r.log)
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+
+	r.bcpStg = crypt.WrapRead(stg, r.keys, bcp.KeyID)
 	return nil
 }
 
@@ -460,40 +648,18 @@ func (r *Restore) checkTopologyForOplog(ctx context.Context, currShards []pbm.Sh
 // is contiguous - there are no gaps), checks for respective files on storage and returns
 // chunks list if all checks passed
 func (r *Restore) chunks(from, to primitive.Timestamp) ([]pbm.OplogChunk, error) {
-	mapRevRS := pbm.MakeReverseRSMapFunc(r.rsMap)
-	chunks, err := r.cn.PITRGetChunksSlice(mapRevRS(r.nodeInfo.SetName), from, to)
-	if err != nil {
-		return nil, errors.Wrap(err, "get chunks index")
-	}
-
-	if len(chunks) == 0 {
-		return nil, errors.New("no chunks found")
-	}
-
-	if primitive.CompareTimestamp(chunks[len(chunks)-1].EndTS, to) == -1 {
-		return nil, errors.Errorf("no chunk with the target time, the last chunk ends on %v", chunks[len(chunks)-1].EndTS)
-	}
-
-	last := from
-	for _, c := range chunks {
-		if primitive.CompareTimestamp(last, c.StartTS) == -1 {
-			return nil, errors.Errorf("integrity vilolated, expect chunk with start_ts %v, but got %v", last, c.StartTS)
-		}
-		last = c.EndTS
-
-		_, err := r.stg.FileStat(c.FName)
-		if err != nil {
-			return nil, errors.Errorf("failed to ensure chunk %v.%v on the storage, file: %s, error: %v", c.StartTS, c.EndTS, c.FName, err)
-		}
-	}
-
-	return chunks, nil
+	return chunks(r.cn, r.stg, from, to, pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName))
 }
 
 func (r *Restore) SnapshotMeta(backupName string) (bcp *pbm.BackupMeta, err error) {
 	bcp, err = r.cn.GetBackupMeta(backupName)
 	if errors.Is(err, pbm.ErrNotFound) {
-		bcp, err = GetMetaFromStore(r.stg, backupName)
+		var cfg pbm.Config
+		cfg, err = r.cn.GetConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "get config")
+		}
+		bcp, err = GetMetaFromStores(cfg, backupName, r.log)
 	}
 	if err != nil {
 		return nil, errors.Wrap(err, "get backup metadata")
@@ -542,7 +708,9 @@ func (r *Restore) setShards(bcp *pbm.BackupMeta) error {
 
 var ErrNoDataForShard = errors.New("no data for shard")
 
-func (r *Restore) snapshotObjects(bcp *pbm.BackupMeta) (dump, oplog string, err error) {
+// snapshotObjects returns the dump metafile and the oplog chunk
+// of the backup for the current replset
+func (r *Restore) snapshotObjects(bcp *pbm.BackupMeta) (dump string, oplog pbm.OplogChunk, err error) {
 	mapRS := pbm.MakeRSMapFunc(r.rsMap)
 
 	var ok bool
@@ -551,26 +719,34 @@ func (r *Restore) snapshotObjects(bcp *pbm.BackupMeta) (dump, oplog string, err
 
 		if name == r.nodeInfo.SetName {
 			dump = v.DumpName
-			oplog = v.OplogName
+			oplog = pbm.OplogChunk{
+				RS:          r.nodeInfo.SetName,
+				FName:       v.OplogName,
+				Compression: bcp.Compression,
+				StartTS:     bcp.FirstWriteTS,
+				EndTS:       bcp.LastWriteTS,
+				Checksum:    v.OplogChecksum,
+				KeyID:       bcp.KeyID,
+			}
 			ok = true
 			break
 		}
 	}
 	if !ok {
 		if r.nodeInfo.IsLeader() {
-			return "", "", errors.New("no data for the config server or sole rs in backup")
+			return "", oplog, errors.New("no data for the config server or sole rs in backup")
 		}
-		return "", "", ErrNoDataForShard
+		return "", oplog, ErrNoDataForShard
 	}
 
-	_, err = r.stg.FileStat(dump)
+	_, err = r.bcpStg.FileStat(dump)
 	if err != nil {
-		return "", "", errors.Errorf("failed to ensure snapshot file %s: %v", dump, err)
+		return "", oplog, errors.Errorf("failed to ensure snapshot file %s: %v", dump, err)
 	}
 
-	_, err = r.stg.FileStat(oplog)
+	_, err = r.bcpStg.FileStat(oplog.FName)
 	if err != nil {
-		return "", "", errors.Errorf("failed to ensure oplog file %s: %v", oplog, err)
+		return "", oplog, errors.Errorf("failed to ensure oplog file %s: %v", oplog.FName, err)
 	}
 
 	return dump, oplog, nil
@@ -585,6 +761,11 @@ func (r *Restore) checkSnapshot(bcp *pbm.BackupMeta) error {
 		return errors.Errorf("backup PBM v%s is incompatible with the running PBM v%s", bcp.PBMVersion, version.DefaultInfo.Version)
 	}
 
+	err := checkKey(r.keys, bcp)
+	if err != nil {
+		return err
+	}
+
 	if bcp.FCV != "" {
 		fcv, err := r.node.GetFeatureCompatibilityVersion()
 		if err != nil {
@@ -618,11 +799,18 @@ func (r *Restore) toState(status pbm.Status, wait *time.Duration) error {
 	return err
 }
 
-func (r *Restore) RunSnapshot(dump string, bcp *pbm.BackupMeta, nss []string) (err error) {
+func (r *Restore) RunSnapshot(ctx context.Context, dump string, bcp *pbm.BackupMeta, nss []string) (err error) {
 	var rdr io.ReadCloser
 
 	if version.IsLegacyArchive(bcp.PBMVersion) {
-		sr, err := r.stg.SourceReader(dump)
+		if r.mask != nil {
+			return errors.New("masking is not supported for the backups of legacy format")
+		}
+		if len(r.nsExclude) != 0 {
+			return errors.New("namespaces exclusion is not supported for the backups of legacy format")
+		}
+
+		sr, err := r.bcpStg.SourceReader(dump)
 		if err != nil {
 			return errors.Wrapf(err, "get object %s for the storage", dump)
 		}
@@ -655,20 +843,42 @@ func (r *Restore) RunSnapshot(dump string, bcp *pbm.BackupMeta, nss []string) (e
 		if err != nil {
 			return errors.WithMessage(err, "get config")
 		}
+		cfg, err = cfg.WithProfile(bcp.Store.Profile)
+		if err != nil {
+			return errors.WithMessage(err, "get backup storage")
+		}
 
+		var prg snapshot.DownloadProgress
+		if r.prg != nil {
+			prg = r.prg
+		}
+		var transform archive.DocTransformFn
+		if r.mask != nil {
+			transform = r.mask.Doc
+		}
+		selected := sel.MakeSelectedPred(nss)
+		notExcluded, err := sel.MakeNSExcludePred(r.nsExclude)
+		if err != nil {
+			return errors.WithMessage(err, "namespaces exclusion")
+		}
 		rdr, err = snapshot.DownloadDump(
 			func(ns string) (io.ReadCloser, error) {
-				stg, err := pbm.Storage(cfg, r.log)
+				stg, err := pbm.ThrottledStorage(cfg, pbm.ThrottleRestore, r.throttle, r.log)
 				if err != nil {
 					return nil, errors.WithMessage(err, "get storage")
 				}
 				// while importing backup made by RS with another name
 				// that current RS we can't use our r.node.RS() to point files
 				// we have to use mapping passed by --replset-mapping option
+				stg = crypt.WrapRead(stg, r.keys, bcp.KeyID)
 				return stg.SourceReader(path.Join(bcp.Name, mapRS(r.node.RS()), ns))
 			},
 			bcp.Compression,
-			sel.MakeSelectedPred(nss))
+			func(ns string) bool {
+				return selected(ns) && notExcluded(ns)
+			},
+			transform,
+			prg)
 	}
 	if err != nil {
 		return err
@@ -676,7 +886,7 @@ func (r *Restore) RunSnapshot(dump string, bcp *pbm.BackupMeta, nss []string) (e
 	defer rdr.Close()
 
 	// Restore snapshot (mongorestore)
-	err = r.snapshot(rdr)
+	err = r.snapshot(ctx, rdr)
 	if err != nil {
 		return errors.Wrap(err, "mongorestore")
 	}
@@ -902,7 +1112,7 @@ type applyOplogOption struct {
 // any new (unobserved before) waiting for the transaction, posts last observed opTime. We go with `checkWaitingTxns`
 // instead of just updating each observed `opTime`  since the latter would add an extra 1 write to each oplog op on
 // sharded clusters even if there are no dist txns at all.
-func (r *Restore) applyOplog(chunks []pbm.OplogChunk, options *applyOplogOption) error {
+func (r *Restore) applyOplog(ctx context.Context, chunks []pbm.OplogChunk, options *applyOplogOption) error {
 	r.log.Info("starting oplog replay")
 	var err error
 
@@ -926,6 +1136,17 @@ func (r *Restore) applyOplog(chunks []pbm.OplogChunk, options *applyOplogOption)
 	}
 
 	r.oplog.SetOpFilter(options.filter)
+	err = r.oplog.SetNSRename(r.nsFrom, r.nsTo)
+	if err != nil {
+		return errors.Wrap(err, "set namespaces renaming")
+	}
+	if r.mask != nil {
+		r.oplog.SetOpTransform(r.mask.Op)
+	}
+	err = r.oplog.SetExcludeNS(r.nsExclude)
+	if err != nil {
+		return errors.Wrap(err, "set namespaces exclusion")
+	}
 
 	var startTS, endTS primitive.Timestamp
 	if options.start != nil {
@@ -937,6 +1158,16 @@ func (r *Restore) applyOplog(chunks []pbm.OplogChunk, options *applyOplogOption)
 	r.oplog.SetTimeframe(startTS, endTS)
 	r.oplog.SetIncludeNS(options.nss)
 
+	if r.prg != nil && len(chunks) > 0 {
+		if startTS.IsZero() {
+			startTS = chunks[0].StartTS
+		}
+		if endTS.IsZero() {
+			endTS = chunks[len(chunks)-1].EndTS
+		}
+		r.prg.oplogStarted(r.oplog, startTS, endTS)
+	}
+
 	var waitTxnErr error
 	if r.nodeInfo.IsSharded() {
 		r.log.Debug("starting sharded txn sync")
@@ -953,19 +1184,23 @@ func (r *Restore) applyOplog(chunks []pbm.OplogChunk, options *applyOplogOption)
 	for _, chnk := range chunks {
 		r.log.Debug("+ applying %v", chnk)
 
-		// If the compression is Snappy and it failed we try S2.
-		// Up until v1.7.0 the compression of pitr chunks was always S2.
-		// But it was a mess in the code which lead to saving pitr chunk files
-		// with the `.snappy`` extension although it was S2 in fact. And during
-		// the restore, decompression treated .snappy as S2 ¯\_(ツ)_/¯ It wasn’t
-		// an issue since there was no choice. Now, Snappy produces `.snappy` files
-		// and S2 - `.s2` which is ok. But this means the old chunks (made by previous
-		// PBM versions) won’t be compatible - during the restore, PBM will treat such
-		// files as Snappy (judging by its suffix) but in fact, they are s2 files
-		// and restore will fail with snappy: corrupt input. So we try S2 in such a case.
-		lts, err = r.replayChunk(chnk.FName, chnk.Compression)
-		if err != nil && errors.Is(err, snappy.ErrCorrupt) {
-			lts, err = r.replayChunk(chnk.FName, compress.CompressionTypeS2)
+		var clts primitive.Timestamp
+		stg := r.stg
+		if r.bcpStg != nil && !strings.HasPrefix(chnk.FName, pbm.PITRfsPrefix) {
+			// the oplog of the backup itself
+			stg = r.bcpStg
+		}
+		clts, err = replayChunk(ctx, crypt.WrapRead(stg, r.keys, chnk.KeyID), r.oplog, chnk)
+		if !clts.IsZero() {
+			lts = clts
+		}
+		if ctx.Err() != nil {
+			r.log.Info("oplog replay canceled, last applied %v", lts)
+			err := r.cn.SetRestoreRSLastApplied(r.name, r.nodeInfo.SetName, lts)
+			if err != nil {
+				r.log.Error("set last applied timestamp: %v", err)
+			}
+			return ErrCancelled
 		}
 		if err != nil {
 			return errors.Wrapf(err, "replay chunk %v.%v", chnk.StartTS.T, chnk.EndTS.T)
@@ -1100,37 +1335,27 @@ func (r *Restore) checkTxn(txn pbm.RestoreTxn) (pbm.TxnState, error) {
 	return pbm.TxnUnknown, nil
 }
 
-func (r *Restore) snapshot(input io.Reader) (err error) {
+func (r *Restore) snapshot(ctx context.Context, input io.Reader) (err error) {
 	cfg, err := r.cn.GetConfig()
 	if err != nil {
 		return errors.Wrap(err, "unable to get PBM config settings")
 	}
 
-	rf, err := snapshot.NewRestore(r.node.ConnURI(), &cfg)
+	var prg snapshot.RestoreProgress
+	if r.prg != nil {
+		prg = r.prg
+	}
+	rf, err := snapshot.NewRestore(r.node.ConnURI(), &cfg, r.nsFrom, r.nsTo, prg)
 	if err != nil {
 		return err
 	}
 
-	_, err = rf.ReadFrom(input)
-	return err
-}
-
-func (r *Restore) replayChunk(file string, c compress.CompressionType) (lts primitive.Timestamp, err error) {
-	or, err := r.stg.SourceReader(file)
-	if err != nil {
-		return lts, errors.Wrapf(err, "get object %s form the storage", file)
+	_, err = rf.ReadFrom(&cancelReader{ctx, input})
+	if ctx.Err() != nil {
+		return ErrCancelled
 	}
-	defer or.Close()
 
-	oplogReader, err := compress.Decompress(or, c)
-	if err != nil {
-		return lts, errors.Wrapf(err, "decompress object %s", file)
-	}
-	defer oplogReader.Close()
-
-	lts, err = r.oplog.Apply(oplogReader)
-
-	return lts, errors.Wrap(err, "apply oplog for chunk")
+	return err
 }
 
 // Done waits for the replicas to finish the job
@@ -1226,6 +1451,16 @@ func (r *Restore) waitForStatus(status pbm.Status) error {
 	return waitForStatus(r.cn, r.name, status)
 }
 
+// MarkCancelled sets the restore and rs state as canceled
+func (r *Restore) MarkCancelled() error {
+	err := r.cn.ChangeRestoreState(r.name, pbm.StatusCancelled, ErrCancelled.Error())
+	if err != nil {
+		return errors.Wrap(err, "set restore state")
+	}
+	err = r.cn.ChangeRestoreRSState(r.name, r.nodeInfo.SetName, pbm.StatusCancelled, ErrCancelled.Error())
+	return errors.Wrap(err, "set replset state")
+}
+
 // MarkFailed sets the restore and rs state as failed with the given message
 func (r *Restore) MarkFailed(e error) error {
 	err := r.cn.ChangeRestoreState(r.name, pbm.StatusError, e.Error())
@@ -17,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -28,9 +29,13 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/checksum"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/gcs"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
 	"github.com/percona/percona-backup-mongodb/version"
 )
@@ -47,6 +52,7 @@ const (
 type files struct {
 	BcpName string
 	Cmpr    compress.CompressionType
+	KeyID   string
 	Data    []pbm.File
 
 	// dbpath to cut from destination if there is any (see PBM-1058)
@@ -70,8 +76,26 @@ type PhysRestore struct {
 	opid     string
 	nodeInfo *pbm.NodeInfo
 	stg      storage.Storage
-	bcp      *pbm.BackupMeta
-	files    []files
+	// bcpStg is the storage of the backup files. It differs from stg
+	// if the backup was made to a storage profile. The restore sync
+	// files and PITR chunks are always on stg.
+	bcpStg storage.Storage
+	// keys decrypt the backup data. nil if encryption isn't configured.
+	keys  crypt.KeyProvider
+	bcp   *pbm.BackupMeta
+	files []files
+
+	// rsMap maps replset names in the backup to the cluster ones
+	rsMap map[string]string
+	// throttle overrides the config limits of the storage traffic
+	throttle *storage.Limits
+	// bcpRS is the name of the node's replset in the backup
+	bcpRS string
+
+	// point-in-time to restore to (if any) and oplog chunks to replay
+	// on top of the backup's data
+	pitr   primitive.Timestamp
+	chunks []pbm.OplogChunk
 
 	confOpts pbm.RestoreConf
 
@@ -92,6 +116,12 @@ type PhysRestore struct {
 
 	stopHB chan struct{}
 
+	// prg is the progress of the node restore. It is nil till
+	// the data copying is started.
+	prg *progress
+	// dstat is the download stat of the backup data (S3 only)
+	dstat atomic.Pointer[s3.DownloadStat]
+
 	log *log.Event
 }
 
@@ -603,9 +633,11 @@ func (l *logBuff) Flush() error {
 //     to the backup's `last write`. `oplogTruncateAfterPoint` set the time up
 //     to which journals would be replayed.
 //   - Starts standalone mongod to recover oplog from journals.
+//   - If `pitr` is set, starts standalone mongod and replays oplog chunks
+//     from the backup's `last write` up to the `pitr` time.
 //   - Cleans up data and resets replicaset config to the working state.
 //   - Shuts down mongod and agent (the leader also dumps metadata to the storage).
-func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event, stopAgentC chan<- struct{}, pauseHB func()) (err error) {
+func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, pitr primitive.Timestamp, opid pbm.OPID, l *log.Event, stopAgentC chan<- struct{}, pauseHB func()) (err error) {
 	l.Debug("port: %d", r.tmpPort)
 
 	meta := &pbm.RestoreMeta{
@@ -613,6 +645,7 @@ func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event,
 		OPID:     opid.String(),
 		Name:     cmd.Name,
 		Backup:   cmd.BackupName,
+		PITR:     int64(pitr.T),
 		StartTS:  time.Now().Unix(),
 		Status:   pbm.StatusInit,
 		Replsets: []pbm.RestoreReplset{{Name: r.nodeInfo.Me}},
@@ -632,16 +665,27 @@ func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event,
 		r.close(err == nil, progress.is(restoreStared) && !progress.is(restoreDone))
 	}()
 
+	r.throttle = cmd.Throttle
 	err = r.init(cmd.Name, opid, l)
 	if err != nil {
 		return errors.Wrap(err, "init")
 	}
 
+	r.rsMap = cmd.RSMap
+	r.bcpRS = pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)
+
 	err = r.prepareBackup(cmd.BackupName)
 	if err != nil {
 		return err
 	}
 	meta.Type = r.bcp.Type
+
+	if !pitr.IsZero() {
+		err = r.preparePITR(pitr)
+		if err != nil {
+			return err
+		}
+	}
 	err = r.setTmpConf()
 	if err != nil {
 		return errors.Wrap(err, "set tmp config")
@@ -704,15 +748,17 @@ func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event,
 	// own (which sets the no-return point).
 	progress |= restoreStared
 
+	r.prg = newProgress()
+	r.prg.filesTotal, r.prg.bytesTotal = copySize(r.files)
+	stopProgress := reportProgress(r.prg, r.writeStat, l)
+	defer stopProgress()
+
 	l.Info("copying backup data")
 	dstat, err := r.copyFiles()
 	if err != nil {
 		return errors.Wrap(err, "copy files")
 	}
-	err = r.writeStat(dstat)
-	if err != nil {
-		r.log.Warning("write download stat: %v", err)
-	}
+	r.dstat.Store(dstat)
 
 	l.Info("preparing data")
 	err = r.prepareData()
@@ -726,6 +772,15 @@ func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event,
 		return errors.Wrap(err, "recover oplog as standalone")
 	}
 
+	if len(r.chunks) > 0 {
+		l.Info("replaying oplog up to %v", r.pitr)
+		err = r.replayOplog()
+		if err != nil {
+			return errors.Wrap(err, "replay oplog")
+		}
+	}
+	stopProgress()
+
 	l.Info("clean-up and reset replicaset config")
 	err = r.resetRS()
 	if err != nil {
@@ -752,11 +807,15 @@ func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event,
 	return nil
 }
 
-func (r *PhysRestore) writeStat(stat any) error {
+// writeStat saves the node restore progress along with the download
+// stat (if any) to the node's stat file on the storage
+func (r *PhysRestore) writeStat(prg *pbm.RestoreProgress) error {
 	d := struct {
-		D any `json:"d"`
+		D *s3.DownloadStat     `json:"d,omitempty"`
+		P *pbm.RestoreProgress `json:"p,omitempty"`
 	}{
-		D: stat,
+		D: r.dstat.Load(),
+		P: prg,
 	}
 	b, err := json.Marshal(d)
 	if err != nil {
@@ -820,8 +879,9 @@ func (r *PhysRestore) dumpMeta(meta *pbm.RestoreMeta, s pbm.Status, msg string)
 }
 
 func (r *PhysRestore) copyFiles() (stat *s3.DownloadStat, err error) {
-	readFn := r.stg.SourceReader
-	if t, ok := r.stg.(*s3.S3); ok {
+	stg := storage.Unwrap(r.bcpStg)
+	readFn := stg.SourceReader
+	if t, ok := stg.(*s3.S3); ok {
 		d := t.NewDownload(r.confOpts.NumDownloadWorkers, r.confOpts.MaxDownloadBufferMb, r.confOpts.DownloadChunkMb)
 		readFn = d.SourceReader
 		defer func() {
@@ -829,12 +889,16 @@ func (r *PhysRestore) copyFiles() (stat *s3.DownloadStat, err error) {
 			stat = &s
 			r.log.Debug("download stat: %s", s)
 		}()
+	} else if t, ok := stg.(*gcs.GCS); ok {
+		d := t.NewDownload(r.confOpts.NumDownloadWorkers, r.confOpts.MaxDownloadBufferMb, r.confOpts.DownloadChunkMb)
+		readFn = d.SourceReader
 	}
+	readFn = storage.WrapSourceReader(r.bcpStg, readFn)
 	cpbuf := make([]byte, 32*1024)
 	for i := len(r.files) - 1; i >= 0; i-- {
 		set := r.files[i]
 		for _, f := range set.Data {
-			src := filepath.Join(set.BcpName, r.nodeInfo.SetName, f.Name+set.Cmpr.Suffix())
+			src := filepath.Join(set.BcpName, r.bcpRS, f.Name+set.Cmpr.Suffix())
 			if f.Len != 0 {
 				src += fmt.Sprintf(".%d-%d", f.Off, f.Len)
 			}
@@ -862,7 +926,17 @@ func (r *PhysRestore) copyFiles() (stat *s3.DownloadStat, err error) {
 			}
 			defer sr.Close()
 
-			data, err := compress.Decompress(sr, set.Cmpr)
+			// files of the encrypted backups must not be read as plaintext
+			newReader := crypt.NewReader
+			if set.KeyID != "" {
+				newReader = crypt.NewEncryptedReader
+			}
+			dr, err := newReader(sr, r.keys)
+			if err != nil {
+				return stat, errors.Wrapf(err, "decrypt object %s", src)
+			}
+
+			data, err := compress.Decompress(dr, set.Cmpr)
 			if err != nil {
 				return stat, errors.Wrapf(err, "decompress object %s", src)
 			}
@@ -879,7 +953,7 @@ func (r *PhysRestore) copyFiles() (stat *s3.DownloadStat, err error) {
 					return stat, errors.Wrapf(err, "set file offset <%s>|%d", dst, f.Off)
 				}
 			}
-			_, err = io.CopyBuffer(fw, data, cpbuf)
+			_, err = io.CopyBuffer(fw, &readCounter{r: checksum.NewReader(data, f.Checksum), fn: r.prg.Downloaded}, cpbuf)
 			if err != nil {
 				return stat, errors.Wrapf(err, "copy file <%s>", dst)
 			}
@@ -889,6 +963,7 @@ func (r *PhysRestore) copyFiles() (stat *s3.DownloadStat, err error) {
 					return stat, errors.Wrapf(err, "truncate file <%s>|%d", dst, f.Size)
 				}
 			}
+			r.prg.fileDone()
 		}
 	}
 	return stat, nil
@@ -1007,6 +1082,18 @@ func (r *PhysRestore) resetRS() error {
 		if err != nil {
 			return errors.Wrap(err, "drop config.lockpings")
 		}
+
+		if len(r.rsMap) != 0 {
+			err = renameShards(ctx, c, r.rsMap)
+			if err != nil {
+				return errors.Wrap(err, "rename shards in config.shards")
+			}
+			err = updateRouterTables(ctx, c, r.rsMap)
+			if err != nil {
+				return errors.Wrap(err, "update router tables")
+			}
+		}
+
 		for id, host := range r.shards {
 			_, err = c.Database("config").Collection("shards").UpdateOne(
 				ctx,
@@ -1021,11 +1108,15 @@ func (r *PhysRestore) resetRS() error {
 			}
 		}
 	} else {
+		set := bson.M{"configsvrConnectionString": r.cfgConn}
+		if len(r.rsMap) != 0 {
+			set["shardName"] = r.nodeInfo.SetName
+		}
 		_, err = c.Database("admin").Collection("system.version").UpdateOne(
 			ctx,
 			bson.D{{"_id", "shardIdentity"}},
 			bson.D{
-				{"$set", bson.M{"configsvrConnectionString": r.cfgConn}},
+				{"$set", set},
 			},
 		)
 		if err != nil {
@@ -1101,6 +1192,125 @@ func (r *PhysRestore) resetRS() error {
 	return nil
 }
 
+// renameShards renames shards in config.shards according to rsMap.
+// The _id can't be updated, so documents are recreated. All old ones are
+// deleted first as names can be swapped (e.g. rs0=rs1,rs1=rs0).
+func renameShards(ctx context.Context, m *mongo.Client, rsMap map[string]string) error {
+	coll := m.Database("config").Collection("shards")
+
+	oldNames := make(primitive.A, 0, len(rsMap))
+	for k := range rsMap {
+		oldNames = append(oldNames, k)
+	}
+
+	q := bson.D{{"_id", bson.M{"$in": oldNames}}}
+	cur, err := coll.Find(ctx, q)
+	if err != nil {
+		return errors.Wrap(err, "query")
+	}
+
+	var docs []interface{}
+	for cur.Next(ctx) {
+		var doc bson.D
+		if err := cur.Decode(&doc); err != nil {
+			return errors.Wrap(err, "decode")
+		}
+		for i := range doc {
+			if doc[i].Key == "_id" {
+				id, _ := doc[i].Value.(string)
+				doc[i].Value = rsMap[id]
+			}
+		}
+		docs = append(docs, doc)
+	}
+	if err := cur.Err(); err != nil {
+		return errors.Wrap(err, "cursor")
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	_, err = coll.DeleteMany(ctx, q)
+	if err != nil {
+		return errors.Wrap(err, "delete old")
+	}
+	_, err = coll.InsertMany(ctx, docs)
+	return errors.Wrap(err, "insert renamed")
+}
+
+// preparePITR checks if the oplog chunks are available to restore from the
+// backup's `last write` up to the given time. It has to be done before
+// the mongod shutdown as the chunks list lives in the PBM collections.
+func (r *PhysRestore) preparePITR(pitr primitive.Timestamp) (err error) {
+	if primitive.CompareTimestamp(r.bcp.LastWriteTS, pitr) >= 0 {
+		return errors.New("snapshot's last write is later than the target time. Try to set an earlier snapshot")
+	}
+
+	r.chunks, err = chunks(r.cn, r.stg, r.bcp.LastWriteTS, pitr, r.bcpRS)
+	if err != nil {
+		return errors.Wrap(err, "define oplog chunks")
+	}
+	r.pitr = pitr
+
+	return nil
+}
+
+// replayOplog applies oplog chunks on top of the recovered data.
+//
+// Oplog is applied on each node independently on the standalone mongod.
+// Hence the data ends up the same on all nodes of the replicaset, and
+// there is no need to resync nodes after the restore. Distributed
+// transactions can't be synced across shards in that state, so they are
+// applied as observed in the shard's oplog.
+func (r *PhysRestore) replayOplog() error {
+	err := r.startMongo("--dbpath", r.dbpath,
+		"--setParameter", "disableLogicalSessionCacheRefresh=true",
+		"--setParameter", "skipShardingConfigurationChecks=true")
+	if err != nil {
+		return errors.Wrap(err, "start mongo")
+	}
+
+	c, err := tryConn(5, time.Minute*5, r.tmpPort, path.Join(r.dbpath, internalMongodLog))
+	if err != nil {
+		return errors.Wrap(err, "connect to mongo")
+	}
+
+	ctx := context.Background()
+
+	mgoV, err := pbm.GetMongoVersion(ctx, c)
+	if err != nil || len(mgoV.Version) < 1 {
+		return errors.Wrap(err, "define mongo version")
+	}
+
+	oplogRestore, err := oplog.NewOplogRestore(pbm.NewNodeConn(ctx, c), &mgoV, false, true, nil, nil)
+	if err != nil {
+		return errors.Wrap(err, "create oplog")
+	}
+	// the backup's last write is already in the data, start right after it
+	oplogRestore.SetTimeframe(primitive.Timestamp{T: r.bcp.LastWriteTS.T, I: r.bcp.LastWriteTS.I + 1}, r.pitr)
+	if r.prg != nil {
+		r.prg.oplogStarted(oplogRestore, r.bcp.LastWriteTS, r.pitr)
+	}
+
+	var lts primitive.Timestamp
+	for _, chnk := range r.chunks {
+		r.log.Debug("+ applying %v", chnk)
+
+		lts, err = replayChunk(ctx, crypt.WrapRead(r.stg, r.keys, chnk.KeyID), oplogRestore, chnk)
+		if err != nil {
+			return errors.Wrapf(err, "replay chunk %v.%v", chnk.StartTS.T, chnk.EndTS.T)
+		}
+	}
+	r.log.Info("oplog replay finished on %v", lts)
+
+	err = shutdown(c, r.dbpath)
+	if err != nil {
+		return errors.Wrap(err, "shutdown mongo")
+	}
+
+	return nil
+}
+
 // Tries to connect to mongo n times, timeout is applied for each try.
 // If a try is unsuccessful, it will check the mongo logs and retry if
 // there are no errors or fatals.
@@ -1206,11 +1416,16 @@ func (r *PhysRestore) init(name string, opid pbm.OPID, l *log.Event) (err error)
 		return errors.Wrap(err, "get pbm config")
 	}
 
-	r.stg, err = pbm.Storage(cfg, l)
+	r.stg, err = pbm.ThrottledStorage(cfg, pbm.ThrottleRestore, r.throttle, l)
 	if err != nil {
 		return errors.Wrap(err, "get storage")
 	}
 
+	r.keys, err = crypt.NewKeyProvider(cfg.Encryption)
+	if err != nil {
+		return errors.Wrap(err, "init encryption")
+	}
+
 	r.confOpts = cfg.Restore
 
 	r.mongod = "mongod" // run from $PATH by default
@@ -1344,7 +1559,7 @@ func (r *PhysRestore) checkHB(file string) error {
 func (r *PhysRestore) setTmpConf() (err error) {
 	opts := new(pbm.MongodOpts)
 	for _, v := range r.bcp.Replsets {
-		if v.Name == r.nodeInfo.SetName {
+		if v.Name == r.bcpRS {
 			if v.MongodOpts == nil {
 				return nil
 			}
@@ -1398,9 +1613,9 @@ const bcpDir = "__dir__"
 func (r *PhysRestore) setBcpFiles() (err error) {
 	bcp := r.bcp
 
-	rs := getRS(bcp, r.nodeInfo.SetName)
+	rs := getRS(bcp, r.bcpRS)
 	if rs == nil {
-		return errors.Errorf("no data in the backup for the replica set %s", r.nodeInfo.SetName)
+		return errors.Errorf("no data in the backup for the replica set %s", r.bcpRS)
 	}
 
 	targetFiles := make(map[string]bool)
@@ -1409,9 +1624,15 @@ func (r *PhysRestore) setBcpFiles() (err error) {
 	}
 
 	for {
+		err = checkKey(r.keys, bcp)
+		if err != nil {
+			return errors.Wrapf(err, "backup %s", bcp.Name)
+		}
+
 		data := files{
 			BcpName: bcp.Name,
 			Cmpr:    bcp.Compression,
+			KeyID:   bcp.KeyID,
 			Data:    []pbm.File{},
 		}
 		// PBM-1058
@@ -1441,7 +1662,7 @@ func (r *PhysRestore) setBcpFiles() (err error) {
 		if err != nil {
 			return errors.Wrapf(err, "get source backup")
 		}
-		rs = getRS(bcp, r.nodeInfo.SetName)
+		rs = getRS(bcp, r.bcpRS)
 	}
 
 	// Directories only. Incremental $backupCusor returns collections that
@@ -1512,9 +1733,14 @@ func getRS(bcp *pbm.BackupMeta, rs string) *pbm.BackupReplset {
 }
 
 func (r *PhysRestore) prepareBackup(backupName string) (err error) {
+	cfg, err := r.cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get pbm config")
+	}
+
 	r.bcp, err = r.cn.GetBackupMeta(backupName)
 	if errors.Is(err, pbm.ErrNotFound) {
-		r.bcp, err = GetMetaFromStore(r.stg, backupName)
+		r.bcp, err = GetMetaFromStores(cfg, backupName, r.log)
 	}
 	if err != nil {
 		return errors.Wrap(err, "get backup metadata")
@@ -1524,6 +1750,18 @@ func (r *PhysRestore) prepareBackup(backupName string) (err error) {
 		return errors.New("snapshot name doesn't set")
 	}
 
+	r.bcpStg = r.stg
+	if r.bcp.Store.Profile != "" {
+		cfg, err = cfg.WithProfile(r.bcp.Store.Profile)
+		if err != nil {
+			return errors.Wrap(err, "get backup storage")
+		}
+		r.bcpStg, err = pbm.ThrottledStorage(cfg, pbm.ThrottleRestore, r.throttle, r.log)
+		if err != nil {
+			return errors.Wrap(err, "get backup storage")
+		}
+	}
+
 	err = r.cn.SetRestoreBackup(r.name, r.bcp.Name, nil)
 	if err != nil {
 		return errors.Wrap(err, "set backup name")
@@ -1569,10 +1807,13 @@ func (r *PhysRestore) prepareBackup(backupName string) (err error) {
 		r.syncPathShards[fmt.Sprintf("%s/%s/rs.%s/rs", pbm.PhysRestoresDir, r.name, rs.RS)] = struct{}{}
 	}
 
+	mapRS, mapRevRS := pbm.MakeRSMapFunc(r.rsMap), pbm.MakeReverseRSMapFunc(r.rsMap)
+
 	var nors []string
 	for _, sh := range r.bcp.Replsets {
-		if _, ok := fl[sh.Name]; !ok {
-			nors = append(nors, sh.Name)
+		name := mapRS(sh.Name)
+		if _, ok := fl[name]; !ok || mapRevRS(name) != sh.Name {
+			nors = append(nors, name)
 		}
 	}
 
@@ -1582,7 +1823,7 @@ func (r *PhysRestore) prepareBackup(backupName string) (err error) {
 
 	var ok bool
 	for _, v := range r.bcp.Replsets {
-		if v.Name == r.nodeInfo.SetName {
+		if v.Name == r.bcpRS {
 			ok = true
 			break
 		}
@@ -0,0 +1,191 @@
+package restore
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+)
+
+const progressReportInterval = time.Second * 10
+
+// progress tracks the replset restore.
+// Counters are updated concurrently.
+type progress struct {
+	bytesDownloaded int64
+	bytesTotal      int64
+	nsDone          int64
+	nsTotal         int64
+	docsInserted    int64
+	filesDone       int64
+
+	filesTotal int
+	// countDocs (if set) returns the number of documents in
+	// the restored namespace
+	countDocs func(ns string) (int64, error)
+
+	mu          sync.Mutex
+	stage       string
+	start       int64 // start of the current stage
+	oplog       *oplog.OplogRestore
+	oplogStart  primitive.Timestamp
+	oplogTarget primitive.Timestamp
+}
+
+func newProgress() *progress {
+	return &progress{
+		stage: pbm.RestoreStageData,
+		start: time.Now().Unix(),
+	}
+}
+
+func (p *progress) Total(nss int, size int64) {
+	atomic.StoreInt64(&p.nsTotal, int64(nss))
+	atomic.StoreInt64(&p.bytesTotal, size)
+}
+
+func (p *progress) Downloaded(n int64) {
+	atomic.AddInt64(&p.bytesDownloaded, n)
+}
+
+func (p *progress) NSDone(ns string) {
+	atomic.AddInt64(&p.nsDone, 1)
+
+	if p.countDocs == nil {
+		return
+	}
+	// the collection is dropped before the restore, so everything
+	// in there has been inserted by the restore
+	n, err := p.countDocs(ns)
+	if err == nil {
+		atomic.AddInt64(&p.docsInserted, n)
+	}
+}
+
+func (p *progress) fileDone() {
+	atomic.AddInt64(&p.filesDone, 1)
+}
+
+// oplogStarted switches the progress to the oplog replay stage
+func (p *progress) oplogStarted(o *oplog.OplogRestore, start, target primitive.Timestamp) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stage = pbm.RestoreStageOplog
+	p.start = time.Now().Unix()
+	p.oplog = o
+	p.oplogStart = start
+	p.oplogTarget = target
+}
+
+func (p *progress) get(now int64) *pbm.RestoreProgress {
+	rv := &pbm.RestoreProgress{
+		BytesDownloaded: atomic.LoadInt64(&p.bytesDownloaded),
+		BytesTotal:      atomic.LoadInt64(&p.bytesTotal),
+		NSDone:          int(atomic.LoadInt64(&p.nsDone)),
+		NSTotal:         int(atomic.LoadInt64(&p.nsTotal)),
+		DocsInserted:    atomic.LoadInt64(&p.docsInserted),
+		FilesDone:       int(atomic.LoadInt64(&p.filesDone)),
+		FilesTotal:      p.filesTotal,
+		UpdatedTS:       now,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rv.Stage = p.stage
+	if p.oplog != nil {
+		rv.OplogApplied = p.oplog.Applied()
+		rv.OplogLastTS = primitive.Timestamp{T: p.oplog.LastOpTS()}
+		rv.OplogStartTS = p.oplogStart
+		rv.OplogTargetTS = p.oplogTarget
+	}
+	rv.EstimateETA(p.start, now)
+
+	return rv
+}
+
+// reportProgress calls save with the current progress every
+// progressReportInterval until the returned func is called. The latter
+// saves the final state and is safe to call more than once.
+func reportProgress(p *progress, save func(*pbm.RestoreProgress) error, l *log.Event) func() {
+	saveNow := func() {
+		err := save(p.get(time.Now().Unix()))
+		if err != nil {
+			l.Warning("save progress: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		tk := time.NewTicker(progressReportInterval)
+		defer tk.Stop()
+
+		for {
+			select {
+			case <-tk.C:
+				saveNow()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			saveNow()
+		})
+	}
+}
+
+// copySize returns the number of files copyFiles would write and the
+// amount of data to download for them
+func copySize(sets []files) (n int, size int64) {
+	for _, set := range sets {
+		if set.BcpName == bcpDir {
+			continue
+		}
+
+		for _, f := range set.Data {
+			n++
+			switch {
+			case f.Len == 0:
+				size += f.Size
+			case f.Off+f.Len > f.Size:
+				if f.Off < f.Size {
+					size += f.Size - f.Off
+				}
+			default:
+				size += f.Len
+			}
+		}
+	}
+
+	return n, size
+}
+
+// readCounter calls fn with the amount of each read
+type readCounter struct {
+	r  io.Reader
+	fn func(int64)
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.fn(int64(n))
+	}
+	return n, err
+}
@@ -1,16 +1,25 @@
 package restore
 
 import (
+	"context"
 	"encoding/json"
+	"io"
+	"os"
 	"time"
 
+	"github.com/golang/snappy"
 	mlog "github.com/mongodb/mongo-tools/common/log"
 	"github.com/mongodb/mongo-tools/common/options"
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/checksum"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
@@ -25,6 +34,24 @@ func init() {
 	})
 }
 
+// ErrCancelled means restore was canceled
+var ErrCancelled = errors.New("restore canceled")
+
+// cancelReader fails reads with ErrCancelled once the ctx is done.
+// So mongorestore and the oplog applier stop on the next read.
+type cancelReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *cancelReader) Read(p []byte) (int, error) {
+	if c.ctx.Err() != nil {
+		return 0, ErrCancelled
+	}
+
+	return c.r.Read(p)
+}
+
 func GetMetaFromStore(stg storage.Storage, bcpName string) (*pbm.BackupMeta, error) {
 	rd, err := stg.SourceReader(bcpName + pbm.MetadataFileSuffix)
 	if err != nil {
@@ -38,6 +65,52 @@ func GetMetaFromStore(stg storage.Storage, bcpName string) (*pbm.BackupMeta, err
 	return b, errors.Wrap(err, "decode")
 }
 
+// GetMetaFromStores looks for the backup metadata on the main storage
+// and then on the storage profiles. The profile of the found meta is
+// set to the one it was found on.
+func GetMetaFromStores(cfg pbm.Config, bcpName string, l *log.Event) (*pbm.BackupMeta, error) {
+	profiles := []string{""}
+	for _, p := range cfg.Profiles {
+		profiles = append(profiles, p.Name)
+	}
+
+	var err error
+	for _, name := range profiles {
+		var c pbm.Config
+		c, err = cfg.WithProfile(name)
+		if err != nil {
+			return nil, err
+		}
+		var stg storage.Storage
+		stg, err = pbm.Storage(c, l)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get storage %q", name)
+		}
+
+		var bcp *pbm.BackupMeta
+		bcp, err = GetMetaFromStore(stg, bcpName)
+		if err == nil {
+			bcp.Store.Profile = name
+			return bcp, nil
+		}
+	}
+
+	return nil, err
+}
+
+// checkKey checks if the backup data can be decrypted with the given keys
+func checkKey(keys crypt.KeyProvider, bcp *pbm.BackupMeta) error {
+	if bcp.KeyID == "" {
+		return nil
+	}
+	if keys == nil {
+		return errors.Errorf("backup is encrypted with key %q but encryption isn't configured", bcp.KeyID)
+	}
+
+	_, err := keys.KeyByID(bcp.KeyID)
+	return errors.Wrapf(err, "get encryption key %q", bcp.KeyID)
+}
+
 func toState(cn *pbm.PBM, status pbm.Status, bcp string, inf *pbm.NodeInfo, reconcileFn reconcileStatus, wait *time.Duration) (meta *pbm.RestoreMeta, err error) {
 	err = cn.ChangeRestoreRSState(bcp, inf.SetName, status, "")
 	if err != nil {
@@ -153,6 +226,8 @@ func converged(cn *pbm.PBM, name, opid string, shards []pbm.Shard, status pbm.St
 					bmeta.Status = pbm.StatusError
 					bmeta.Error = shard.Error
 					return false, nil, errors.Errorf("restore on the shard %s failed with: %s", shard.Name, shard.Error)
+				case pbm.StatusCancelled:
+					return false, nil, ErrCancelled
 				}
 			}
 		}
@@ -197,6 +272,8 @@ func waitForStatus(cn *pbm.PBM, name string, status pbm.Status) error {
 				return nil
 			case pbm.StatusError:
 				return errors.Errorf("cluster failed: %s", meta.Error)
+			case pbm.StatusCancelled:
+				return ErrCancelled
 			}
 		case <-cn.Context().Done():
 			return nil
@@ -234,6 +311,8 @@ func waitForStatusT(cn *pbm.PBM, name string, status pbm.Status, t time.Duration
 				return nil
 			case pbm.StatusError:
 				return errors.Errorf("cluster failed: %s", meta.Error)
+			case pbm.StatusCancelled:
+				return ErrCancelled
 			}
 		case <-tout.C:
 			return errConvergeTimeOut
@@ -242,3 +321,111 @@ func waitForStatusT(cn *pbm.PBM, name string, status pbm.Status, t time.Duration
 		}
 	}
 }
+
+// chunks defines chunks of oplog slice in given range, ensures its integrity (timeline
+// is contiguous - there are no gaps), checks for respective files on storage and returns
+// chunks list if all checks passed
+func chunks(cn *pbm.PBM, stg storage.Storage, from, to primitive.Timestamp, rsName string) ([]pbm.OplogChunk, error) {
+	chunks, err := cn.PITRGetChunksSlice(rsName, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "get chunks index")
+	}
+
+	if len(chunks) == 0 {
+		return nil, errors.New("no chunks found")
+	}
+
+	if primitive.CompareTimestamp(chunks[len(chunks)-1].EndTS, to) == -1 {
+		return nil, errors.Errorf("no chunk with the target time, the last chunk ends on %v", chunks[len(chunks)-1].EndTS)
+	}
+
+	last := from
+	for _, c := range chunks {
+		if primitive.CompareTimestamp(last, c.StartTS) == -1 {
+			return nil, errors.Errorf("integrity vilolated, expect chunk with start_ts %v, but got %v", last, c.StartTS)
+		}
+		last = c.EndTS
+
+		_, err := stg.FileStat(c.FName)
+		if err != nil {
+			return nil, errors.Errorf("failed to ensure chunk %v.%v on the storage, file: %s, error: %v", c.StartTS, c.EndTS, c.FName, err)
+		}
+	}
+
+	return chunks, nil
+}
+
+// replayChunk applies the given oplog chunk with the oplog applier
+func replayChunk(ctx context.Context, stg storage.Storage, o *oplog.OplogRestore, chnk pbm.OplogChunk) (lts primitive.Timestamp, err error) {
+	// If the compression is Snappy and it failed we try S2.
+	// Up until v1.7.0 the compression of pitr chunks was always S2.
+	// But it was a mess in the code which lead to saving pitr chunk files
+	// with the `.snappy`` extension although it was S2 in fact. And during
+	// the restore, decompression treated .snappy as S2 ¯\_(ツ)_/¯ It wasn’t
+	// an issue since there was no choice. Now, Snappy produces `.snappy` files
+	// and S2 - `.s2` which is ok. But this means the old chunks (made by previous
+	// PBM versions) won’t be compatible - during the restore, PBM will treat such
+	// files as Snappy (judging by its suffix) but in fact, they are s2 files
+	// and restore will fail with snappy: corrupt input. So we try S2 in such a case.
+	lts, err = applyOplogFile(ctx, stg, o, chnk.FName, chnk.Compression, chnk.Checksum)
+	if err != nil && errors.Is(err, snappy.ErrCorrupt) {
+		lts, err = applyOplogFile(ctx, stg, o, chnk.FName, compress.CompressionTypeS2, chnk.Checksum)
+	}
+
+	return lts, err
+}
+
+func applyOplogFile(ctx context.Context, stg storage.Storage, o *oplog.OplogRestore, file string, c compress.CompressionType, sum string) (lts primitive.Timestamp, err error) {
+	or, err := stg.SourceReader(file)
+	if err != nil {
+		return lts, errors.Wrapf(err, "get object %s form the storage", file)
+	}
+	defer or.Close()
+
+	oplogReader, err := compress.Decompress(or, c)
+	if err != nil {
+		return lts, errors.Wrapf(err, "decompress object %s", file)
+	}
+	defer oplogReader.Close()
+
+	var src io.Reader = &cancelReader{ctx, oplogReader}
+	// The applier stops at the end of the restore range, so the checksum
+	// of the chunk is verified while it's spooled to the local file. That
+	// way no op of the corrupted chunk is applied.
+	if sum != "" {
+		f, err := spoolOplog(src, sum)
+		if err != nil {
+			return lts, errors.Wrapf(err, "download object %s", file)
+		}
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+		src = f
+	}
+
+	lts, err = o.Apply(io.NopCloser(src))
+
+	return lts, errors.Wrap(err, "apply oplog for chunk")
+}
+
+// spoolOplog writes the oplog into the temp file and checks its checksum.
+// The returned file is at the start and should be removed by the caller.
+func spoolOplog(r io.Reader, sum string) (*os.File, error) {
+	f, err := os.CreateTemp("", "pbm-oplog-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp file")
+	}
+
+	_, err = io.Copy(f, checksum.NewReader(r, sum))
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,80 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/checksum"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestReplayChunkCorrupted(t *testing.T) {
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+
+	var data []byte
+	for i := 1; i <= 3; i++ {
+		op, err := bson.Marshal(bson.D{{"ts", primitive.Timestamp{T: uint32(i)}}, {"op", "n"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, op...)
+	}
+	h := checksum.New()
+	h.Write(data)
+	sum := checksum.Sum(h)
+
+	// the last op is corrupted after the chunk was saved
+	data[len(data)-2] ^= 0xff
+	chnk := pbm.OplogChunk{
+		FName:       "pbmPitr/rs0/20230101/20230101000000-1.20230101000000-3.oplog",
+		Compression: compress.CompressionTypeNone,
+		StartTS:     primitive.Timestamp{T: 1},
+		EndTS:       primitive.Timestamp{T: 3},
+		Checksum:    sum,
+	}
+	err := stg.Save(chnk.FName, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nil applier: no op may be applied from the corrupted chunk
+	_, err = replayChunk(context.Background(), stg, nil, chnk)
+	if !errors.Is(err, checksum.ErrMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestSpoolOplog(t *testing.T) {
+	data := []byte("oplog data")
+	h := checksum.New()
+	h.Write(data)
+
+	f, err := spoolOplog(bytes.NewReader(data), checksum.Sum(h))
+	if err != nil {
+		t.Fatalf("spool: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q", data, got)
+	}
+
+	_, err = spoolOplog(bytes.NewReader(data[1:]), checksum.Sum(h))
+	if !errors.Is(err, checksum.ErrMismatch) {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+}
@@ -26,7 +26,7 @@ const maxBulkWriteCount = 500
 
 // configsvrRestore restores for selected namespaces
 func (r *Restore) configsvrRestore(bcp *pbm.BackupMeta, nss []string, mapRS pbm.RSMapFunc) error {
-	available, err := fetchAvailability(bcp, r.stg)
+	available, err := fetchAvailability(bcp, r.bcpStg)
 	if err != nil {
 		return err
 	}
@@ -87,7 +87,7 @@ func fetchAvailability(bcp *pbm.BackupMeta, stg storage.Storage) (map[string]boo
 // for selected databases
 func (r *Restore) configsvrRestoreDatabases(bcp *pbm.BackupMeta, nss []string, mapRS pbm.RSMapFunc) error {
 	filepath := path.Join(bcp.Name, mapRS(r.node.RS()), "config.databases"+bcp.Compression.Suffix())
-	rdr, err := r.stg.SourceReader(filepath)
+	rdr, err := r.bcpStg.SourceReader(filepath)
 	if err != nil {
 		return err
 	}
@@ -165,7 +165,7 @@ func (r *Restore) configsvrRestoreCollections(bcp *pbm.BackupMeta, nss []string,
 	}
 
 	filepath := path.Join(bcp.Name, mapRS(r.node.RS()), "config.collections"+bcp.Compression.Suffix())
-	rdr, err := r.stg.SourceReader(filepath)
+	rdr, err := r.bcpStg.SourceReader(filepath)
 	if err != nil {
 		return nil, err
 	}
@@ -223,7 +223,7 @@ func (r *Restore) configsvrRestoreCollections(bcp *pbm.BackupMeta, nss []string,
 // configsvrRestoreChunks upserts config.chunks documents for selected namespaces
 func (r *Restore) configsvrRestoreChunks(bcp *pbm.BackupMeta, selector sel.ChunkSelector, mapRS pbm.RSMapFunc) error {
 	filepath := path.Join(bcp.Name, mapRS(r.node.RS()), "config.chunks"+bcp.Compression.Suffix())
-	rdr, err := r.stg.SourceReader(filepath)
+	rdr, err := r.bcpStg.SourceReader(filepath)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,229 @@
+package pbm
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MakeRetentionInfo returns backups and PITR chunks that should be deleted
+// according to the retention policy at the time now.
+//
+// Backups older than the oldest kept backup (or the start of the PITR
+// window if it's earlier) and chunks older than the base snapshot of the
+// PITR window (or the oldest kept backup if there's no window) are defined
+// by MakeCleanupInfo. So the base snapshot and the incremental chain needed
+// for PITR and next backups are kept. Newer backups that don't fit the
+// policy are deleted as well unless they are a part of a kept incremental
+// chain or a base of a PITR timeline within the window.
+func (p *PBM) MakeRetentionInfo(r *RetentionConf, now time.Time) (CleanupInfo, error) {
+	if !r.Enabled() {
+		return CleanupInfo{}, nil
+	}
+
+	all, err := listBackupsBefore(p.ctx, p.Conn, primitive.Timestamp{T: math.MaxUint32})
+	if err != nil {
+		return CleanupInfo{}, errors.WithMessage(err, "list backups")
+	}
+
+	gfs := r.GFS()
+
+	keep := make(map[string]bool)
+	if gfs {
+		keep = retentionKeep(all, r)
+		if len(keep) == 0 {
+			// no successful backups to keep. leave everything as is
+			return CleanupInfo{}, nil
+		}
+	}
+
+	cutoff, chunksCutoff, pitrStart := retentionCutoffs(all, keep, r, now)
+
+	info, err := MakeCleanupInfo(p.ctx, p.Conn, cutoff)
+	if err != nil {
+		return CleanupInfo{}, errors.WithMessage(err, "make cleanup info")
+	}
+	chunks := info.Chunks
+	if chunksCutoff != cutoff {
+		cinfo, err := MakeCleanupInfo(p.ctx, p.Conn, chunksCutoff)
+		if err != nil {
+			return CleanupInfo{}, errors.WithMessage(err, "make chunks cleanup info")
+		}
+		chunks = cinfo.Chunks
+	}
+
+	protected, err := p.retentionProtected(all, keep, pitrStart)
+	if err != nil {
+		return CleanupInfo{}, err
+	}
+
+	backups := []BackupMeta{}
+	deleted := make(map[string]bool)
+	for i := range info.Backups {
+		b := &info.Backups[i]
+		if !protected[b.Name] && isFinished(b) {
+			backups = append(backups, *b)
+			deleted[b.Name] = true
+		}
+	}
+
+	// backups after the cutoff that don't fit the policy
+	if gfs {
+		for i := range all {
+			b := &all[i]
+			if primitive.CompareTimestamp(b.LastWriteTS, cutoff) == -1 || deleted[b.Name] {
+				continue
+			}
+			if !keep[b.Name] && !protected[b.Name] && isFinished(b) {
+				backups = append(backups, *b)
+			}
+		}
+	}
+
+	return CleanupInfo{Backups: backups, Chunks: chunks}, nil
+}
+
+// retentionCutoffs returns the points in time before which backups and
+// chunks are deleted and the start of the PITR window (if it's set).
+// Backups are kept since the oldest backup kept by the policy or the PITR
+// window start whichever is earlier. While chunks are kept for the PITR
+// window only. Without the window, chunks follow the backups.
+func retentionCutoffs(
+	all []BackupMeta,
+	keep map[string]bool,
+	r *RetentionConf,
+	now time.Time,
+) (bcps, chunks, pitrStart primitive.Timestamp) {
+	gfs := r.GFS()
+	if gfs {
+		for i := range all {
+			if keep[all[i].Name] {
+				bcps = all[i].LastWriteTS
+				break
+			}
+		}
+	}
+	chunks = bcps
+
+	if r.PITRDays > 0 {
+		pitrStart = primitive.Timestamp{T: uint32(now.AddDate(0, 0, -r.PITRDays).Unix())}
+		chunks = pitrStart
+		if !gfs || primitive.CompareTimestamp(pitrStart, bcps) == -1 {
+			bcps = pitrStart
+		}
+	}
+
+	return bcps, chunks, pitrStart
+}
+
+// retentionKeep returns names of backups to keep by the policy. It's the
+// most recent successful backup of each of the last r.Daily days, r.Weekly
+// weeks and r.Monthly months that have backups.
+func retentionKeep(bcps []BackupMeta, r *RetentionConf) map[string]bool {
+	rules := []struct {
+		n   int
+		key func(t time.Time) string
+	}{
+		{r.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{r.Weekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", y, w)
+		}},
+		{r.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	seen := make([]map[string]bool, len(rules))
+	for i := range seen {
+		seen[i] = make(map[string]bool)
+	}
+
+	keep := make(map[string]bool)
+	for i := len(bcps) - 1; i >= 0; i-- {
+		b := &bcps[i]
+		if b.Status != StatusDone {
+			continue
+		}
+
+		t := time.Unix(int64(b.LastWriteTS.T), 0).UTC()
+		for j, rl := range rules {
+			k := rl.key(t)
+			if len(seen[j]) < rl.n && !seen[j][k] {
+				seen[j][k] = true
+				keep[b.Name] = true
+			}
+		}
+	}
+
+	return keep
+}
+
+// retentionProtected returns names of backups that can't be deleted:
+// kept backups and their incremental chains, the last incremental chain
+// (needed for next increments) and bases of PITR timelines ending after
+// the pitrStart (if it's set)
+func (p *PBM) retentionProtected(
+	all []BackupMeta,
+	keep map[string]bool,
+	pitrStart primitive.Timestamp,
+) (map[string]bool, error) {
+	byName := make(map[string]*BackupMeta, len(all))
+	for i := range all {
+		byName[all[i].Name] = &all[i]
+	}
+
+	protected := make(map[string]bool)
+	for name := range keep {
+		for b := byName[name]; b != nil; b = byName[b.SrcBackup] {
+			protected[b.Name] = true
+			if b.SrcBackup == "" {
+				break
+			}
+		}
+	}
+
+	// extractLastIncrementalChain removes the chain from the list
+	rest, err := extractLastIncrementalChain(p.ctx, p.Conn, append([]BackupMeta{}, all...))
+	if err != nil {
+		return nil, errors.WithMessage(err, "extract last incremental chain")
+	}
+	inRest := make(map[string]bool, len(rest))
+	for i := range rest {
+		inRest[rest[i].Name] = true
+	}
+	for i := range all {
+		if !inRest[all[i].Name] {
+			protected[all[i].Name] = true
+		}
+	}
+
+	if pitrStart.IsZero() {
+		return protected, nil
+	}
+
+	tlns, err := p.PITRTimelines()
+	if err != nil {
+		return nil, errors.Wrap(err, "get PITR timelines")
+	}
+	for _, t := range tlns {
+		if t.End < pitrStart.T {
+			continue
+		}
+		for i := range all {
+			if all[i].LastWriteTS.T == t.Start {
+				protected[all[i].Name] = true
+			}
+		}
+	}
+
+	return protected, nil
+}
+
+func isFinished(b *BackupMeta) bool {
+	switch b.Status {
+	case StatusDone, StatusCancelled, StatusError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package pbm
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRetentionKeep(t *testing.T) {
+	// backups every 12 hours from Mon 2023-01-02 till Sun 2023-03-05
+	var bcps []BackupMeta
+	start := time.Date(2023, time.January, 2, 6, 0, 0, 0, time.UTC)
+	for ts := start; ts.Before(time.Date(2023, time.March, 6, 0, 0, 0, 0, time.UTC)); ts = ts.Add(12 * time.Hour) {
+		bcps = append(bcps, BackupMeta{
+			Name:        ts.Format(time.RFC3339),
+			Status:      StatusDone,
+			LastWriteTS: primitive.Timestamp{T: uint32(ts.Unix())},
+		})
+	}
+	// the last one is failed
+	bcps[len(bcps)-1].Status = StatusError
+
+	cases := []struct {
+		r    RetentionConf
+		keep []string
+	}{
+		{
+			RetentionConf{Daily: 2},
+			[]string{"2023-03-04T18:00:00Z", "2023-03-05T06:00:00Z"},
+		},
+		{
+			RetentionConf{Weekly: 2},
+			[]string{"2023-02-26T18:00:00Z", "2023-03-05T06:00:00Z"},
+		},
+		{
+			RetentionConf{Monthly: 3},
+			[]string{"2023-01-31T18:00:00Z", "2023-02-28T18:00:00Z", "2023-03-05T06:00:00Z"},
+		},
+		{
+			RetentionConf{Daily: 1, Weekly: 2, Monthly: 2},
+			[]string{"2023-02-26T18:00:00Z", "2023-02-28T18:00:00Z", "2023-03-05T06:00:00Z"},
+		},
+	}
+
+	for _, c := range cases {
+		keep := retentionKeep(bcps, &c.r)
+
+		got := []string{}
+		for name := range keep {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+
+		if !reflect.DeepEqual(got, c.keep) {
+			t.Errorf("%+v: expected %v, got %v", c.r, c.keep, got)
+		}
+	}
+}
+
+func TestRetentionCutoffsGFSAndPITR(t *testing.T) {
+	now := time.Date(2023, time.March, 6, 0, 0, 0, 0, time.UTC)
+	ts := func(days int) primitive.Timestamp {
+		return primitive.Timestamp{T: uint32(now.AddDate(0, 0, -days).Unix())}
+	}
+	bcps := []BackupMeta{
+		{Name: "monthly", Status: StatusDone, LastWriteTS: ts(30)},
+		{Name: "daily", Status: StatusDone, LastWriteTS: ts(1)},
+	}
+	keep := map[string]bool{"monthly": true, "daily": true}
+
+	cases := []struct {
+		r                       RetentionConf
+		bcps, chunks, pitrStart primitive.Timestamp
+	}{
+		// the oldest kept backup
+		{RetentionConf{Monthly: 2}, ts(30), ts(30), primitive.Timestamp{}},
+		// the window only
+		{RetentionConf{PITRDays: 3}, ts(3), ts(3), ts(3)},
+		// backups are kept by the policy while chunks by the window
+		{RetentionConf{Monthly: 2, PITRDays: 3}, ts(30), ts(3), ts(3)},
+		// the window is longer than the policy
+		{RetentionConf{Monthly: 2, PITRDays: 40}, ts(40), ts(40), ts(40)},
+	}
+
+	for _, c := range cases {
+		k := keep
+		if !c.r.GFS() {
+			k = map[string]bool{}
+		}
+		b, ch, ps := retentionCutoffs(bcps, k, &c.r, now)
+		if b != c.bcps || ch != c.chunks || ps != c.pitrStart {
+			t.Errorf("%+v: expected %v %v %v, got %v %v %v", c.r, c.bcps, c.chunks, c.pitrStart, b, ch, ps)
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/crypt"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
@@ -68,44 +69,53 @@ func (p *PBM) ResyncStorage(l *log.Event) error {
 		}
 	}
 
-	bcps, err := stg.List("", MetadataFileSuffix)
+	keys, err := p.GetKeyProvider()
 	if err != nil {
-		return errors.Wrap(err, "get a backups list from the storage")
+		return errors.Wrap(err, "init encryption")
 	}
-	l.Debug("got backups list: %v", len(bcps))
 
-	_, err = p.Conn.Database(DB).Collection(BcpCollection).DeleteMany(p.ctx, bson.M{})
+	ins, err := readBackupsMeta(p.ctx, stg, "", keys, l)
 	if err != nil {
-		return errors.Wrapf(err, "clean up %s", BcpCollection)
+		return err
 	}
 
-	_, err = p.Conn.Database(DB).Collection(PITRChunksCollection).DeleteMany(p.ctx, bson.M{})
+	cfg, err := p.GetConfig()
 	if err != nil {
-		return errors.Wrapf(err, "clean up %s", PITRChunksCollection)
-	}
-
-	var ins []interface{}
-	for _, b := range bcps {
-		l.Debug("bcp: %v", b.Name)
-
-		d, err := stg.SourceReader(b.Name)
+		return errors.Wrap(err, "get config")
+	}
+	// backups on the storage profiles. They are read before the
+	// current metadata is cleaned up so it stays intact if any
+	// of the profiles is unavailable.
+	for _, prof := range cfg.Profiles {
+		pcfg, _ := cfg.WithProfile(prof.Name)
+		pstg, err := Storage(pcfg, l)
 		if err != nil {
-			return errors.Wrapf(err, "read meta for %v", b.Name)
+			return errors.Wrapf(err, "get storage of profile %q", prof.Name)
 		}
 
-		v := BackupMeta{}
-		err = json.NewDecoder(d).Decode(&v)
-		d.Close()
+		_, err = pstg.FileStat(StorInitFile)
+		if errors.Is(err, storage.ErrNotExist) {
+			err = pstg.Save(StorInitFile, bytes.NewBufferString(version.DefaultInfo.Version), 0)
+		}
 		if err != nil {
-			return errors.Wrapf(err, "unmarshal backup meta [%s]", b.Name)
+			return errors.Wrapf(err, "init storage of profile %q", prof.Name)
 		}
-		err = checkBackupFiles(p.ctx, &v, stg)
+
+		pins, err := readBackupsMeta(p.ctx, pstg, prof.Name, keys, l)
 		if err != nil {
-			l.Warning("skip snapshot %s: %v", v.Name, err)
-			v.Status = StatusError
-			v.Err = err.Error()
+			return errors.WithMessagef(err, "profile %q", prof.Name)
 		}
-		ins = append(ins, v)
+		ins = append(ins, pins...)
+	}
+
+	_, err = p.Conn.Database(DB).Collection(BcpCollection).DeleteMany(p.ctx, bson.M{})
+	if err != nil {
+		return errors.Wrapf(err, "clean up %s", BcpCollection)
+	}
+
+	_, err = p.Conn.Database(DB).Collection(PITRChunksCollection).DeleteMany(p.ctx, bson.M{})
+	if err != nil {
+		return errors.Wrapf(err, "clean up %s", PITRChunksCollection)
 	}
 
 	if len(ins) != 0 {
@@ -149,6 +159,44 @@ func (p *PBM) ResyncStorage(l *log.Event) error {
 	return nil
 }
 
+// readBackupsMeta reads the metadata of the backups on the storage.
+// profile is the name of the storage profile, empty for the main storage.
+func readBackupsMeta(ctx context.Context, stg storage.Storage, profile string, keys crypt.KeyProvider, l *log.Event) ([]interface{}, error) {
+	bcps, err := stg.List("", MetadataFileSuffix)
+	if err != nil {
+		return nil, errors.Wrap(err, "get a backups list from the storage")
+	}
+	l.Debug("got backups list: %v", len(bcps))
+
+	var ins []interface{}
+	for _, b := range bcps {
+		l.Debug("bcp: %v", b.Name)
+
+		d, err := stg.SourceReader(b.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read meta for %v", b.Name)
+		}
+
+		v := BackupMeta{}
+		err = json.NewDecoder(d).Decode(&v)
+		d.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unmarshal backup meta [%s]", b.Name)
+		}
+		// the storage might be moved to (or from) a profile since the backup
+		v.Store.Profile = profile
+		err = checkBackupFiles(ctx, &v, crypt.WrapRead(stg, keys, v.KeyID))
+		if err != nil {
+			l.Warning("skip snapshot %s: %v", v.Name, err)
+			v.Status = StatusError
+			v.Err = err.Error()
+		}
+		ins = append(ins, v)
+	}
+
+	return ins, nil
+}
+
 func checkBackupFiles(ctx context.Context, bcp *BackupMeta, stg storage.Storage) error {
 	// !!! TODO: Check physical files ?
 	if bcp.Type == PhysicalBackup || bcp.Type == IncrementalBackup {
@@ -344,22 +392,31 @@ func ParsePhysRestoreStatus(restore string, stg storage.Storage, l *log.Event) (
 					l.Error("get stat file %s: %v", f.Name, err)
 					break
 				}
-				if meta.Stat == nil {
-					meta.Stat = &RestoreStat{Download: make(map[string]map[string]s3.DownloadStat)}
-				}
 				st := struct {
-					D s3.DownloadStat `json:"d"`
+					D *s3.DownloadStat `json:"d"`
+					P *RestoreProgress `json:"p"`
 				}{}
 				err = json.NewDecoder(src).Decode(&st)
+				src.Close()
 				if err != nil {
 					l.Error("unmarshal stat file %s: %v", f.Name, err)
 					break
 				}
+				// the replset is as far as its slowest node
+				if st.P != nil && (rs.rs.Progress == nil || st.P.behind(rs.rs.Progress)) {
+					rs.rs.Progress = st.P
+				}
+				if st.D == nil {
+					break
+				}
+				if meta.Stat == nil {
+					meta.Stat = &RestoreStat{Download: make(map[string]map[string]s3.DownloadStat)}
+				}
 				if _, ok := meta.Stat.Download[rsName]; !ok {
 					meta.Stat.Download[rsName] = make(map[string]s3.DownloadStat)
 				}
 				nName := strings.Join(p[1:], ".")
-				meta.Stat.Download[rsName][nName] = st.D
+				meta.Stat.Download[rsName][nName] = *st.D
 			}
 			rss[rsName] = rs
 
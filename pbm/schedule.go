@@ -0,0 +1,123 @@
+package pbm
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// scheduleMissedKeep is the number of recent missed runs kept in the state
+const scheduleMissedKeep = 20
+
+// ScheduleState is the state of the backup schedule entry
+type ScheduleState struct {
+	Name string `bson:"name" json:"name"`
+	// Cron is the entry's cron expression the state is calculated for.
+	// The state is reset if the expression has changed.
+	Cron string `bson:"cron" json:"cron"`
+	// LastRun is the (unix) time of the last handled activation
+	// whether the backup was started or the run was missed
+	LastRun    int64       `bson:"last_run" json:"last_run"`
+	LastBackup string      `bson:"last_backup,omitempty" json:"last_backup,omitempty"`
+	Missed     []MissedRun `bson:"missed,omitempty" json:"missed,omitempty"`
+}
+
+// MissedRun is a scheduled backup that wasn't started
+type MissedRun struct {
+	Time   int64  `bson:"time" json:"time"`
+	Reason string `bson:"reason" json:"reason"`
+}
+
+// GetScheduleState returns the state of the schedule entry.
+// It returns ErrNotFound if there is no state yet.
+func (p *PBM) GetScheduleState(name string) (*ScheduleState, error) {
+	st := &ScheduleState{}
+	err := p.Conn.Database(DB).Collection(ScheduleCollection).
+		FindOne(p.ctx, bson.D{{"name", name}}).Decode(st)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "get")
+	}
+
+	return st, nil
+}
+
+// GetScheduleStates returns states of all schedule entries
+func (p *PBM) GetScheduleStates() ([]ScheduleState, error) {
+	cur, err := p.Conn.Database(DB).Collection(ScheduleCollection).Find(p.ctx, bson.D{})
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	states := []ScheduleState{}
+	err = cur.All(p.ctx, &states)
+	return states, errors.Wrap(err, "decode")
+}
+
+// ResetScheduleState (re)creates the state of the entry with
+// the given cron expression and the last run time
+func (p *PBM) ResetScheduleState(name, cron string, lastRun int64) error {
+	_, err := p.Conn.Database(DB).Collection(ScheduleCollection).ReplaceOne(
+		p.ctx,
+		bson.D{{"name", name}},
+		ScheduleState{Name: name, Cron: cron, LastRun: lastRun},
+		options.Replace().SetUpsert(true),
+	)
+
+	return err
+}
+
+// ClaimScheduleRun moves the last run time of the entry from prev to run.
+// It returns false if the state has been changed meanwhile, i.e. the run
+// is handled by another agent.
+func (p *PBM) ClaimScheduleRun(name string, prev, run int64) (bool, error) {
+	res, err := p.Conn.Database(DB).Collection(ScheduleCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}, {"last_run", prev}},
+		bson.D{{"$set", bson.M{"last_run": run}}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+// SetScheduleLastBackup sets the name of the last backup started by the entry
+func (p *PBM) SetScheduleLastBackup(name, bcpName string) error {
+	_, err := p.Conn.Database(DB).Collection(ScheduleCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"last_backup": bcpName}}},
+	)
+
+	return err
+}
+
+// AddScheduleMissed records missed runs of the entry.
+// Only the recent scheduleMissedKeep runs are kept.
+func (p *PBM) AddScheduleMissed(name string, runs ...MissedRun) error {
+	_, err := p.Conn.Database(DB).Collection(ScheduleCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$push", bson.M{"missed": bson.D{
+			{"$each", runs},
+			{"$slice", -scheduleMissedKeep},
+		}}}},
+	)
+
+	return err
+}
+
+// DeleteScheduleStates deletes states of the entries that aren't in names
+func (p *PBM) DeleteScheduleStates(names []string) error {
+	_, err := p.Conn.Database(DB).Collection(ScheduleCollection).DeleteMany(
+		p.ctx,
+		bson.D{{"name", bson.M{"$nin": names}}},
+	)
+
+	return err
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/hex"
 	"strings"
 
+	"github.com/mongodb/mongo-tools/mongorestore/ns"
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
@@ -105,3 +107,256 @@ func (s uuidChunkMap) BuildFilter() bson.D {
 
 	return bson.D{{"uuid", bson.M{"$in": uuids}}}
 }
+
+var (
+	ErrInvalidNamespace    = errors.New("invalid namespace")
+	ErrForbiddenDatabase   = errors.New(`"admin", "config", "local" databases are not allowed`)
+	ErrForbiddenCollection = errors.New(`"system.*" collections are not allowed`)
+	ErrAmbiguousNamespace  = errors.New("ambiguous namespace")
+)
+
+// ParseNSOption parses comma separated namespaces (e.g. "db1.*,db2.coll2").
+// It returns nil if all namespaces are selected.
+func ParseNSOption(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "*.*" {
+		return nil, nil
+	}
+
+	m := make(map[string]map[string]struct{})
+	for _, ns := range strings.Split(s, ",") {
+		db, coll, ok := strings.Cut(strings.TrimSpace(ns), ".")
+		if !ok {
+			return nil, errors.WithMessage(ErrInvalidNamespace, ns)
+		}
+		if db == "" || coll == "" || (db == "*" && coll != "*") {
+			return nil, errors.WithMessage(ErrInvalidNamespace, ns)
+		}
+		if db == "admin" || db == "config" || db == "local" {
+			return nil, ErrForbiddenDatabase
+		}
+		if strings.HasPrefix(coll, "system.") {
+			return nil, ErrForbiddenCollection
+		}
+
+		if _, ok := m[db]; !ok {
+			m[db] = make(map[string]struct{})
+		}
+		m[db][coll] = struct{}{}
+	}
+
+	if _, ok := m["*"]; ok && len(m) != 1 {
+		return nil, errors.WithMessage(ErrAmbiguousNamespace,
+			"cannot use * with other databases")
+	}
+
+	rv := []string{}
+	for db, colls := range m {
+		if _, ok := colls["*"]; ok && len(colls) != 1 {
+			return nil, errors.WithMessagef(ErrAmbiguousNamespace,
+				"cannot use * with other collections in %q database", db)
+		}
+
+		for coll := range colls {
+			rv = append(rv, db+"."+coll)
+		}
+	}
+
+	return rv, nil
+}
+
+// ParseNSRenameOption parses comma separated namespaces patterns of the
+// --ns-from and --ns-to options (e.g. "db1.*,db2.c1" and "db1_r.*,db2.c1_r").
+// The i-th pattern of from is renamed to the i-th one of to.
+func ParseNSRenameOption(from, to string) ([]string, []string, error) {
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if from == "" && to == "" {
+		return nil, nil, nil
+	}
+
+	var nsFrom, nsTo []string
+	if from != "" {
+		for _, s := range strings.Split(from, ",") {
+			nsFrom = append(nsFrom, strings.TrimSpace(s))
+		}
+	}
+	if to != "" {
+		for _, s := range strings.Split(to, ",") {
+			nsTo = append(nsTo, strings.TrimSpace(s))
+		}
+	}
+
+	err := ValidateNSRename(nsFrom, nsTo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nsFrom, nsTo, nil
+}
+
+// ValidateNSRename checks the namespaces renaming patterns. The patterns
+// are either "db.coll" or "db.*". A whole database is renamed only to
+// another database ("db.*" to "db_new.*").
+func ValidateNSRename(from, to []string) error {
+	if len(from) != len(to) {
+		return errors.Errorf("%d namespaces to rename from but %d to rename to", len(from), len(to))
+	}
+
+	for _, p := range append(append([]string{}, from...), to...) {
+		db, coll, ok := strings.Cut(p, ".")
+		if !ok || db == "" || coll == "" {
+			return errors.WithMessage(ErrInvalidNamespace, p)
+		}
+		if db == "admin" || db == "config" || db == "local" {
+			return ErrForbiddenDatabase
+		}
+		if strings.HasPrefix(coll, "system.") {
+			return ErrForbiddenCollection
+		}
+		if strings.Contains(db, "*") || (coll != "*" && strings.Contains(coll, "*")) {
+			return errors.WithMessagef(ErrInvalidNamespace, "%s: only the whole collection name could be *", p)
+		}
+		if strings.Contains(p, "$") {
+			return errors.WithMessagef(ErrInvalidNamespace, "%s: '$' is not allowed", p)
+		}
+	}
+
+	_, err := ns.NewRenamer(from, to)
+	return errors.WithMessage(err, "rename")
+}
+
+// ParseNSExcludeOption parses comma separated patterns of the namespaces
+// excluded from a backup or a restore (e.g. "logs.*,*.tmp_*"). The "*"
+// matches any part of the name.
+func ParseNSExcludeOption(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	var rv []string
+	for _, p := range strings.Split(s, ",") {
+		rv = append(rv, strings.TrimSpace(p))
+	}
+
+	err := ValidateNSExclude(rv)
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// ValidateNSExclude checks the patterns of the excluded namespaces
+func ValidateNSExclude(patterns []string) error {
+	for _, p := range patterns {
+		db, coll, ok := strings.Cut(p, ".")
+		if !ok || db == "" || coll == "" {
+			return errors.WithMessage(ErrInvalidNamespace, p)
+		}
+		if p == "*.*" {
+			return errors.WithMessage(ErrInvalidNamespace, "*.* excludes everything")
+		}
+		if db == "admin" || db == "config" || db == "local" {
+			return ErrForbiddenDatabase
+		}
+		if strings.HasPrefix(coll, "system.") {
+			return ErrForbiddenCollection
+		}
+		if strings.Contains(p, "$") {
+			return errors.WithMessagef(ErrInvalidNamespace, "%s: '$' is not allowed", p)
+		}
+	}
+
+	return nil
+}
+
+// MakeNSExcludePred returns the filter of the namespaces which don't match
+// the excluded patterns. The namespaces of "admin", "config" and "local"
+// databases and the system collections are never excluded.
+func MakeNSExcludePred(patterns []string) (archive.NSFilterFn, error) {
+	if len(patterns) == 0 {
+		return archive.DefaultNSFilter, nil
+	}
+
+	m, err := ns.NewMatcher(patterns)
+	if err != nil {
+		return nil, errors.Wrap(err, "create matcher")
+	}
+
+	return func(ns string) bool {
+		db, coll, _ := strings.Cut(ns, ".")
+		if db == "admin" || db == "config" || db == "local" {
+			return true
+		}
+		if strings.HasPrefix(coll, "system.") && !strings.HasPrefix(coll, "system.buckets.") {
+			return true
+		}
+
+		return !m.Has(archive.NSify(db, coll))
+	}, nil
+}
+
+// NSQuery is the filter of the documents of the collection. Only
+// the matching documents get into the backup.
+type NSQuery struct {
+	NS string `bson:"ns" json:"ns" yaml:"ns"`
+	// Query is the filter in MongoDB Extended JSON (e.g. {"ts":{"$gte":...}})
+	Query string `bson:"query" json:"query" yaml:"query"`
+}
+
+// ParseNSQueryOption parses the --ns-query options. Each one is
+// "db.coll=<query>" (e.g. `events.clicks={"ts":{"$gte":{"$date":"2023-01-01T00:00:00Z"}}}`).
+func ParseNSQueryOption(opts []string) ([]NSQuery, error) {
+	var rv []NSQuery
+	for _, s := range opts {
+		ns, q, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, errors.Errorf("%q: expected <db.collection>=<query>", s)
+		}
+
+		rv = append(rv, NSQuery{NS: strings.TrimSpace(ns), Query: strings.TrimSpace(q)})
+	}
+
+	err := ValidateNSQuery(rv)
+	if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
+
+// ValidateNSQuery checks the namespaces and the queries. A query is set
+// for a single collection and at most once.
+func ValidateNSQuery(queries []NSQuery) error {
+	seen := make(map[string]bool, len(queries))
+	for _, q := range queries {
+		db, coll, ok := strings.Cut(q.NS, ".")
+		if !ok || db == "" || coll == "" {
+			return errors.WithMessage(ErrInvalidNamespace, q.NS)
+		}
+		if strings.Contains(q.NS, "*") {
+			return errors.WithMessagef(ErrInvalidNamespace, "%s: a query is allowed for a collection only", q.NS)
+		}
+		if db == "admin" || db == "config" || db == "local" {
+			return ErrForbiddenDatabase
+		}
+		if strings.HasPrefix(coll, "system.") {
+			return ErrForbiddenCollection
+		}
+		if strings.Contains(q.NS, "$") {
+			return errors.WithMessagef(ErrInvalidNamespace, "%s: '$' is not allowed", q.NS)
+		}
+		if seen[q.NS] {
+			return errors.Errorf("%s: duplicate query", q.NS)
+		}
+		seen[q.NS] = true
+
+		var filter bson.D
+		if err := bson.UnmarshalExtJSON([]byte(q.Query), false, &filter); err != nil {
+			return errors.Wrapf(err, "%s: parse query", q.NS)
+		}
+	}
+
+	return nil
+}
@@ -45,3 +45,96 @@ func TestSelectedPred(t *testing.T) {
 		}
 	}
 }
+
+func TestParseNSRenameOption(t *testing.T) {
+	cases := []struct {
+		from, to string
+		nsFrom   []string
+		nsTo     []string
+		ok       bool
+	}{
+		{"", "", nil, nil, true},
+		{"orders.*", "orders_restored.*", []string{"orders.*"}, []string{"orders_restored.*"}, true},
+		{"db.a, db.b", "db.a1,db2.b", []string{"db.a", "db.b"}, []string{"db.a1", "db2.b"}, true},
+		{"orders.*", "", nil, nil, false},
+		{"db.a,db.b", "db.c", nil, nil, false},
+		{"orders.*", "orders_restored.c", nil, nil, false},
+		{"db.a*", "db.b*", nil, nil, false},
+		{"admin.*", "db.*", nil, nil, false},
+		{"db.a", "db.system.a", nil, nil, false},
+		{"db", "db2", nil, nil, false},
+	}
+
+	for _, c := range cases {
+		from, to, err := sel.ParseNSRenameOption(c.from, c.to)
+		if (err == nil) != c.ok {
+			t.Errorf("%q -> %q: unexpected result: %v", c.from, c.to, err)
+			continue
+		}
+		if !reflect.DeepEqual(from, c.nsFrom) || !reflect.DeepEqual(to, c.nsTo) {
+			t.Errorf("%q -> %q: expected %v -> %v, got %v -> %v", c.from, c.to, c.nsFrom, c.nsTo, from, to)
+		}
+	}
+}
+
+func TestNSExclude(t *testing.T) {
+	for _, s := range []string{"*.*", "logs", "admin.*", "db.system.views", "db.$cmd"} {
+		if _, err := sel.ParseNSExcludeOption(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+
+	nss, err := sel.ParseNSExcludeOption(" logs.*, *.tmp_*")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(nss, []string{"logs.*", "*.tmp_*"}) {
+		t.Fatalf("unexpected patterns: %v", nss)
+	}
+
+	notExcluded, err := sel.MakeNSExcludePred(nss)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cases := map[string]bool{
+		"logs.audit":              false,
+		"logs.system.buckets.ts":  false,
+		"app.tmp_1":               false,
+		"app.users":               true,
+		"logsx.audit":             true,
+		"config.tmp_x":            true,
+		"logs.system.views":       true,
+		"app.system.buckets.tmp_": false,
+	}
+	for ns, want := range cases {
+		if got := notExcluded(ns); got != want {
+			t.Errorf("%s: expected %v, got %v", ns, want, got)
+		}
+	}
+}
+
+func TestParseNSQueryOption(t *testing.T) {
+	q := `{"ts":{"$gte":{"$date":"2023-01-01T00:00:00Z"}}}`
+	got, err := sel.ParseNSQueryOption([]string{"events.clicks=" + q, " db.c = {} "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []sel.NSQuery{{NS: "events.clicks", Query: q}, {NS: "db.c", Query: "{}"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	for _, s := range [][]string{
+		{"events.clicks"},
+		{"events.*={}"},
+		{"events={}"},
+		{"config.c={}"},
+		{"db.system.views={}"},
+		{"db.c={ts:1}"},
+		{"db.c={}", "db.c={}"},
+	} {
+		if _, err := sel.ParseNSQueryOption(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/mongodb/mongo-tools/common/archive"
@@ -19,10 +20,35 @@ import (
 type backuper struct {
 	d     *mongodump.MongoDump
 	pm    *progress.BarWriter
+	gate  *paceGate
 	stopC chan struct{}
 }
 
-func NewBackup(curi string, conns int, d, c string) (io.WriterTo, error) {
+// Pacer tells how much the dump should be slowed down at the moment
+type Pacer interface {
+	// Pace returns the max number of collections to be read concurrently
+	// (0 means no limit) and the delay before each write of the dump data
+	Pace() (readers int, delay time.Duration)
+}
+
+// DumpOptions defines what the mongodump dumps
+type DumpOptions struct {
+	// DB and Collection narrow the dump down to the database or the
+	// collection. Empty DB means the whole instance.
+	DB         string
+	Collection string
+	// Query (Extended JSON) filters the documents of the Collection
+	Query string
+	// ExcludeColls are the collections of the DB to skip
+	ExcludeColls []string
+	// UsersAndRoles dumps users and roles along with the admin database.
+	// The whole instance dump always includes them.
+	UsersAndRoles bool
+}
+
+// NewBackup creates mongodump defined by o. The dump is slowed down
+// as the pacer (if any) tells.
+func NewBackup(curi string, conns int, o DumpOptions, pacer Pacer) (io.WriterTo, error) {
 	if conns <= 0 {
 		conns = 1
 	}
@@ -42,7 +68,7 @@ func NewBackup(curi string, conns int, d, c string) (io.WriterTo, error) {
 	}
 
 	opts.Direct = true
-	opts.Namespace = &options.Namespace{DB: d, Collection: c}
+	opts.Namespace = &options.Namespace{DB: o.DB, Collection: o.Collection}
 
 	backup := &backuper{}
 
@@ -55,11 +81,16 @@ func NewBackup(curi string, conns int, d, c string) (io.WriterTo, error) {
 			// you nee to look the code to discover it.
 			Archive:                "-",
 			NumParallelCollections: conns,
+			ExcludedCollections:    o.ExcludeColls,
 		},
-		InputOptions:      &mongodump.InputOptions{},
+		InputOptions:      &mongodump.InputOptions{Query: o.Query},
 		SessionProvider:   &db.SessionProvider{},
 		ProgressManager:   backup.pm,
-		SkipUsersAndRoles: d != "",
+		SkipUsersAndRoles: o.DB != "" && !(o.DB == "admin" && o.UsersAndRoles),
+	}
+	if pacer != nil {
+		backup.gate = newPaceGate(pacer)
+		backup.d.ProgressManager = &pacedProgress{Manager: backup.pm, g: backup.gate}
 	}
 	return backup, nil
 }
@@ -91,12 +122,16 @@ func (d *backuper) WriteTo(to io.Writer) (int64, error) {
 		select {
 		case <-ctx.Done():
 		case <-d.stopC:
+			d.gate.release()
 			d.d.HandleInterrupt()
 		}
 
 		d.stopC = nil
 	}()
 
+	if d.gate != nil {
+		to = &pacedWriter{w: to, g: d.gate}
+	}
 	d.d.OutputWriter = to
 	err = d.d.Dump()
 
@@ -115,6 +150,102 @@ func (d *backuper) Cancel() {
 	}
 }
 
+const paceCheckInterval = time.Second
+
+// paceGate holds collection readers back as the Pacer tells
+type paceGate struct {
+	p      Pacer
+	mu     sync.Mutex
+	active int
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func newPaceGate(p Pacer) *paceGate {
+	return &paceGate{p: p, stop: make(chan struct{})}
+}
+
+// enter blocks until one more collection can be read
+func (g *paceGate) enter() {
+	for {
+		n, _ := g.p.Pace()
+
+		g.mu.Lock()
+		if n <= 0 || g.active < n {
+			g.active++
+			g.mu.Unlock()
+			return
+		}
+		g.mu.Unlock()
+
+		select {
+		case <-time.After(paceCheckInterval):
+		case <-g.stop:
+			g.mu.Lock()
+			g.active++
+			g.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (g *paceGate) leave() {
+	g.mu.Lock()
+	g.active--
+	g.mu.Unlock()
+}
+
+// wait pauses the writing of the dump data
+func (g *paceGate) wait() {
+	_, d := g.p.Pace()
+	if d <= 0 {
+		return
+	}
+
+	select {
+	case <-time.After(d):
+	case <-g.stop:
+	}
+}
+
+// release lets everything through. E.g. to let the dump be interrupted.
+func (g *paceGate) release() {
+	if g == nil {
+		return
+	}
+
+	g.once.Do(func() { close(g.stop) })
+}
+
+// pacedProgress makes mongodump's collection readers pass
+// the gate before reading a collection
+type pacedProgress struct {
+	progress.Manager
+	g *paceGate
+}
+
+func (p *pacedProgress) Attach(name string, pg progress.Progressor) {
+	p.g.enter()
+	p.Manager.Attach(name, pg)
+}
+
+func (p *pacedProgress) Detach(name string) {
+	p.Manager.Detach(name)
+	p.g.leave()
+}
+
+// pacedWriter delays writes of the dump. Since mongodump's collection
+// readers feed the single archive writer, it holds back all of them.
+type pacedWriter struct {
+	w io.Writer
+	g *paceGate
+}
+
+func (p *pacedWriter) Write(b []byte) (int, error) {
+	p.g.wait()
+	return p.w.Write(b)
+}
+
 type DummyBackup struct{}
 
 func (DummyBackup) WriteTo(w io.Writer) (int64, error) {
@@ -1,6 +1,7 @@
 package snapshot
 
 import (
+	"bytes"
 	"io"
 	"sync"
 	"sync/atomic"
@@ -23,6 +24,20 @@ type UploadDumpOptions struct {
 	// DocFilter checks whether a document is selected for backup.
 	// Useful when only some documents are selected for backup
 	DocFilter archive.DocFilterFn
+
+	// Progress (if not nil) receives the dump progress
+	Progress DumpProgress
+}
+
+// DumpProgress receives the progress of UploadDump.
+// Methods are called concurrently.
+type DumpProgress interface {
+	// Read is called with the amount of the dump data read
+	Read(n int64)
+	// Uploaded is called with the amount of data passed to upload
+	Uploaded(n int64)
+	// NSDone is called when the namespace has been uploaded
+	NSDone(ns string)
 }
 
 type UploadFunc func(ns, ext string, r io.Reader) error
@@ -50,9 +65,14 @@ func UploadDump(wt io.WriterTo, upload UploadFunc, opts UploadDumpOptions) (int6
 			}
 
 			rc := &readCounter{r: pr}
+			if opts.Progress != nil {
+				rc.fn = opts.Progress.Uploaded
+			}
 			err := upload(ns, ext, rc)
 			if err != nil {
 				pr.CloseWithError(errors.WithMessagef(err, "upload: %q", ns))
+			} else if opts.Progress != nil && ns != archive.MetaFile {
+				opts.Progress.NSDone(ns)
 			}
 
 			atomic.AddInt64(&size, rc.n)
@@ -67,14 +87,75 @@ func UploadDump(wt io.WriterTo, upload UploadFunc, opts UploadDumpOptions) (int6
 		return dwc, errors.WithMessagef(err, "create compressor: %q", ns)
 	}
 
-	err := archive.Decompose(pr, newWriter, opts.NSFilter, opts.DocFilter)
+	var src io.Reader = pr
+	if opts.Progress != nil {
+		src = &readCounter{r: pr, fn: opts.Progress.Read}
+	}
+
+	err := archive.Decompose(src, newWriter, opts.NSFilter, opts.DocFilter)
 	wg.Wait()
 	return size, errors.WithMessage(err, "decompose")
 }
 
+// UploadDumpParts uploads the dumps of distinct namespaces as the one dump.
+// Their metadata files are merged into the one.
+func UploadDumpParts(parts []io.WriterTo, upload UploadFunc, opts UploadDumpOptions) (int64, error) {
+	if len(parts) == 1 {
+		return UploadDump(parts[0], upload, opts)
+	}
+
+	metas := make([]io.Reader, len(parts))
+	size := int64(0)
+	for i, p := range parts {
+		meta := &bytes.Buffer{}
+		n, err := UploadDump(p, func(ns, ext string, r io.Reader) error {
+			if ns == archive.MetaFile {
+				_, err := io.Copy(meta, r)
+				return err
+			}
+			return upload(ns, ext, r)
+		}, opts)
+		if err != nil {
+			return size, errors.WithMessagef(err, "part %d", i)
+		}
+
+		size += n - int64(meta.Len())
+		metas[i] = meta
+	}
+
+	meta := &bytes.Buffer{}
+	err := archive.MergeMetadata(meta, metas...)
+	if err != nil {
+		return size, errors.WithMessage(err, "merge metadata")
+	}
+
+	size += int64(meta.Len())
+	err = upload(archive.MetaFile, "", meta)
+	return size, errors.WithMessagef(err, "upload: %q", archive.MetaFile)
+}
+
 type DownloadFunc func(filename string) (io.ReadCloser, error)
 
-func DownloadDump(download DownloadFunc, compression compress.CompressionType, match archive.NSFilterFn) (io.ReadCloser, error) {
+// DownloadProgress receives the progress of DownloadDump.
+// Methods are called concurrently.
+type DownloadProgress interface {
+	// Total is called once with the number of the selected namespaces
+	// and the amount of their (uncompressed) data
+	Total(nss int, size int64)
+	// Downloaded is called with the amount of the dump data downloaded
+	// (after the decompression)
+	Downloaded(n int64)
+}
+
+// DownloadDump composes the archive out of the dump files.
+// transform and prg could be nil.
+func DownloadDump(
+	download DownloadFunc,
+	compression compress.CompressionType,
+	match archive.NSFilterFn,
+	transform archive.DocTransformFn,
+	prg DownloadProgress,
+) (io.ReadCloser, error) {
 	pr, pw := io.Pipe()
 
 	go func() {
@@ -89,28 +170,82 @@ func DownloadDump(download DownloadFunc, compression compress.CompressionType, m
 			}
 
 			if ns == archive.MetaFile {
-				return r, nil
+				if prg == nil {
+					return r, nil
+				}
+				return dumpTotal(r, match, prg)
 			}
 
 			r, err = compress.Decompress(r, compression)
-			return r, errors.WithMessagef(err, "create decompressor: %q", ns)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "create decompressor: %q", ns)
+			}
+			if prg != nil {
+				r = &readCloseCounter{ReadCloser: r, fn: prg.Downloaded}
+			}
+			return r, nil
 		}
 
-		err := archive.Compose(pw, match, newReader)
+		err := archive.Compose(pw, match, transform, newReader)
 		pw.CloseWithError(errors.WithMessage(err, "compose"))
 	}()
 
 	return pr, nil
 }
 
+// dumpTotal reports the size of the selected namespaces from the dump
+// metadata and returns the metadata to be read again
+func dumpTotal(r io.ReadCloser, match archive.NSFilterFn, prg DownloadProgress) (io.ReadCloser, error) {
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read metadata")
+	}
+
+	meta, err := archive.ReadMetadata(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse metadata")
+	}
+
+	nss, size := 0, int64(0)
+	for _, ns := range meta.Namespaces {
+		if match(archive.NSify(ns.Database, ns.Collection)) {
+			nss++
+			size += ns.Size
+		}
+	}
+	prg.Total(nss, size)
+
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
 type readCounter struct {
 	r io.Reader
 	n int64
+	// fn (if not nil) is called with the amount of each read
+	fn func(int64)
 }
 
 func (c *readCounter) Read(p []byte) (int, error) {
 	n, err := c.r.Read(p)
 	c.n += int64(n)
+	if c.fn != nil && n > 0 {
+		c.fn(int64(n))
+	}
+	return n, err
+}
+
+type readCloseCounter struct {
+	io.ReadCloser
+	fn func(int64)
+}
+
+func (c *readCloseCounter) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		c.fn(int64(n))
+	}
 	return n, err
 }
 
@@ -4,6 +4,7 @@ import (
 	"io"
 
 	"github.com/mongodb/mongo-tools/common/options"
+	"github.com/mongodb/mongo-tools/common/progress"
 	"github.com/mongodb/mongo-tools/mongorestore"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
@@ -48,7 +49,16 @@ var ExcludeFromRestore = []string{
 
 type restorer struct{ *mongorestore.MongoRestore }
 
-func NewRestore(uri string, cfg *pbm.Config) (io.ReaderFrom, error) {
+// RestoreProgress receives the progress of the restore
+type RestoreProgress interface {
+	// NSDone is called when the namespace has been restored
+	NSDone(ns string)
+}
+
+// NewRestore creates mongorestore for the archive. prg could be nil.
+// nsFrom and nsTo are the namespaces renaming patterns (could be empty).
+// The collections UUIDs aren't preserved if there are any.
+func NewRestore(uri string, cfg *pbm.Config, nsFrom, nsTo []string, prg RestoreProgress) (io.ReaderFrom, error) {
 	topts := options.New("mongorestore", "0.0.1", "none", "", true, options.EnabledOptions{Auth: true, Connection: true, Namespace: true, URI: true})
 	var err error
 	topts.URI, err = options.NewURI(uri)
@@ -84,12 +94,14 @@ func NewRestore(uri string, cfg *pbm.Config) (io.ReaderFrom, error) {
 		Drop:                     true,
 		NumInsertionWorkers:      numInsertionWorkers,
 		NumParallelCollections:   1,
-		PreserveUUID:             preserveUUID,
+		PreserveUUID:             preserveUUID && len(nsFrom) == 0,
 		StopOnError:              true,
 		WriteConcern:             "majority",
 	}
 	mopts.NSOptions = &mongorestore.NSOptions{
 		NSExclude: ExcludeFromRestore,
+		NSFrom:    nsFrom,
+		NSTo:      nsTo,
 	}
 
 	mr, err := mongorestore.New(mopts)
@@ -97,6 +109,9 @@ func NewRestore(uri string, cfg *pbm.Config) (io.ReaderFrom, error) {
 		return nil, errors.Wrap(err, "create mongorestore obj")
 	}
 	mr.SkipUsersAndRoles = true
+	if prg != nil {
+		mr.ProgressManager = &progressManager{Manager: mr.ProgressManager, prg: prg}
+	}
 
 	return &restorer{mr}, nil
 }
@@ -111,3 +126,17 @@ func (r *restorer) ReadFrom(from io.Reader) (int64, error) {
 
 	return 0, nil
 }
+
+// progressManager notifies RestoreProgress on collections being
+// detached by mongorestore which happens once they're restored
+type progressManager struct {
+	progress.Manager
+	prg RestoreProgress
+}
+
+func (m *progressManager) Detach(name string) {
+	m.Manager.Detach(name)
+	if name != "oplog" {
+		m.prg.NSDone(name)
+	}
+}
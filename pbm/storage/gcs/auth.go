@@ -0,0 +1,187 @@
+package gcs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tokenURL    = "https://oauth2.googleapis.com/token"
+	metadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	scope       = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	// refresh the token a bit earlier than it expires
+	tokenExpiryDelta = time.Minute
+)
+
+type tokenSource interface {
+	token() (string, error)
+}
+
+// anonymous is used with the custom endpoint and no credentials,
+// e.g. fake-gcs-server in tests
+type anonymous struct{}
+
+func (anonymous) token() (string, error) { return "", nil }
+
+type token struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// cachedToken keeps the token until it expires
+type cachedToken struct {
+	fetch func() (*token, error)
+
+	mu     sync.Mutex
+	tok    string
+	expiry time.Time
+}
+
+func (c *cachedToken) token() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tok != "" && time.Now().Before(c.expiry) {
+		return c.tok, nil
+	}
+
+	t, err := c.fetch()
+	if err != nil {
+		return "", err
+	}
+
+	c.tok = t.AccessToken
+	c.expiry = time.Now().Add(time.Duration(t.ExpiresIn)*time.Second - tokenExpiryDelta)
+	return c.tok, nil
+}
+
+// serviceAccount exchanges the signed JWT for the access token.
+// See https://developers.google.com/identity/protocols/oauth2/service-account#httprest
+func serviceAccount(cl *http.Client, email, privateKey string) (tokenSource, error) {
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse private key")
+	}
+
+	return &cachedToken{
+		fetch: func() (*token, error) {
+			jwt, err := signJWT(key, email, time.Now())
+			if err != nil {
+				return nil, errors.Wrap(err, "sign jwt")
+			}
+
+			resp, err := cl.PostForm(tokenURL, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {jwt},
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, "request token")
+			}
+			defer resp.Body.Close()
+
+			return decodeToken(resp)
+		},
+	}, nil
+}
+
+// metadataServer gets the token of the attached service account
+// (or workload identity on GKE) from the metadata server
+func metadataServer(cl *http.Client) tokenSource {
+	return &cachedToken{
+		fetch: func() (*token, error) {
+			req, err := http.NewRequest(http.MethodGet, metadataURL, nil)
+			if err != nil {
+				return nil, errors.Wrap(err, "create request")
+			}
+			req.Header.Set("Metadata-Flavor", "Google")
+
+			resp, err := cl.Do(req)
+			if err != nil {
+				return nil, errors.Wrap(err, "request token from metadata server")
+			}
+			defer resp.Body.Close()
+
+			return decodeToken(resp)
+		},
+	}
+}
+
+func decodeToken(resp *http.Response) (*token, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Wrap(parseError(resp), "get token")
+	}
+
+	t := &token{}
+	err := json.NewDecoder(resp.Body).Decode(t)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode token")
+	}
+	if t.AccessToken == "" {
+		return nil, errors.New("empty access token")
+	}
+
+	return t, nil
+}
+
+func parsePrivateKey(s string) (*rsa.PrivateKey, error) {
+	// the key could be copied from the JSON file as is
+	s = strings.ReplaceAll(s, `\n`, "\n")
+
+	b, _ := pem.Decode([]byte(s))
+	if b == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		return x509.ParsePKCS1PrivateKey(b.Bytes)
+	}
+
+	rk, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+	return rk, nil
+}
+
+func signJWT(key *rsa.PrivateKey, email string, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   email,
+		"scope": scope,
+		"aud":   tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	payload := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	h := sha256.Sum256([]byte(payload))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+
+	return payload + "." + enc.EncodeToString(sig), nil
+}
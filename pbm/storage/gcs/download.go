@@ -0,0 +1,192 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// Downloading objects from the storage.
+//
+// Download requests ranges (chunks) of an object concurrently and streams
+// them to the consumer in order. Up to `cc` chunks are being downloaded or
+// wait for the consumer at the same time. So the max memory used by an
+// object's reader is about `cc * chunkSize`.
+
+const (
+	downloadChunkSizeDefault = 32 << 20
+
+	// requests are retried by GCS.do as well,
+	// these are retries of broken transfers
+	downloadRetries = 3
+)
+
+// Download is used to concurrently download objects from the storage.
+type Download struct {
+	gcs *GCS
+
+	cc        int
+	chunkSize int
+}
+
+// NewDownload returns Download with the given concurrency and chunk size.
+// If bufSizeMb is set, it's a hard limit for the memory used by a reader.
+func (g *GCS) NewDownload(cc, bufSizeMb, chunkSizeMb int) *Download {
+	if cc <= 0 {
+		cc = runtime.GOMAXPROCS(0)
+	}
+
+	chunkSize := chunkSizeMb << 20
+	if chunkSize <= 0 {
+		chunkSize = downloadChunkSizeDefault
+	}
+
+	bufSize := bufSizeMb << 20
+	if bufSize > 0 {
+		if bufSize < chunkSize {
+			chunkSize = bufSize
+		}
+		if bufSize/cc < chunkSize {
+			cc = bufSize / chunkSize
+		}
+	}
+
+	g.log.Debug("download max buf %d (chunk %d, concurrency %d)", chunkSize*cc, chunkSize, cc)
+
+	return &Download{
+		gcs:       g,
+		cc:        cc,
+		chunkSize: chunkSize,
+	}
+}
+
+func (d *Download) SourceReader(name string) (io.ReadCloser, error) {
+	inf, err := d.gcs.FileStat(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get file stat")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &rangeReader{
+		cancel: cancel,
+		chunks: make(chan chan chunkResult, d.cc-1),
+	}
+	go d.requestChunks(ctx, d.gcs.key(name), inf.Size, r.chunks)
+
+	return r, nil
+}
+
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+// requestChunks starts downloads of the object chunks in order. The number
+// of downloads in flight is limited by the capacity of the out channel.
+func (d *Download) requestChunks(ctx context.Context, key string, size int64, out chan<- chan chunkResult) {
+	defer close(out)
+
+	for off := int64(0); off < size; off += int64(d.chunkSize) {
+		end := off + int64(d.chunkSize)
+		if end > size {
+			end = size
+		}
+
+		res := make(chan chunkResult, 1)
+		select {
+		case out <- res:
+		case <-ctx.Done():
+			return
+		}
+
+		go func(off, end int64) {
+			data, err := d.getRange(ctx, key, off, end)
+			res <- chunkResult{data: data, err: err}
+		}(off, end)
+	}
+}
+
+// getRange downloads bytes [start, end) of the object
+// and retries if the download has failed
+func (d *Download) getRange(ctx context.Context, key string, start, end int64) ([]byte, error) {
+	buf := make([]byte, end-start)
+
+	var err error
+	for i := 0; i < downloadRetries; i++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		err = d.readRange(ctx, key, start, buf)
+		if err == nil {
+			return buf, nil
+		}
+		d.gcs.log.Warning("download %s bytes %d-%d: %v", key, start, end-1, err)
+	}
+
+	return nil, errors.Wrapf(err, "download %s bytes %d-%d", key, start, end-1)
+}
+
+func (d *Download) readRange(ctx context.Context, key string, start int64, buf []byte) error {
+	resp, err := d.gcs.do(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.gcs.objectURL(key)+"?alt=media", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+int64(len(buf))-1))
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the server may respond with the whole object if the range covers it
+	if resp.StatusCode != http.StatusPartialContent &&
+		!(resp.StatusCode == http.StatusOK && start == 0) {
+		return parseError(resp)
+	}
+
+	_, err = io.ReadFull(resp.Body, buf)
+	return errors.Wrap(err, "read response")
+}
+
+// rangeReader streams downloaded chunks in order
+type rangeReader struct {
+	cancel context.CancelFunc
+	chunks chan chan chunkResult
+
+	cur []byte
+	err error
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		res, ok := <-r.chunks
+		if !ok {
+			r.err = io.EOF
+			continue
+		}
+
+		c := <-res
+		r.cur, r.err = c.data, c.err
+	}
+
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+// Close cancels downloads in flight
+func (r *rangeReader) Close() error {
+	r.cancel()
+	return nil
+}
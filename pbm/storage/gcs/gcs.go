@@ -0,0 +1,399 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+const (
+	defaultEndpoint = "https://storage.googleapis.com"
+
+	defaultRetries  = 10
+	maxRetryDelay   = 30 * time.Second
+	headerTimeout   = time.Minute
+	listPageMaxSize = 1000
+)
+
+type Conf struct {
+	Bucket      string      `bson:"bucket" json:"bucket" yaml:"bucket"`
+	Prefix      string      `bson:"prefix,omitempty" json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	Credentials Credentials `bson:"credentials" json:"-" yaml:"credentials"`
+
+	// EndpointURL overrides the default API endpoint. If it's set and
+	// credentials are empty, requests are sent unauthenticated.
+	// E.g. to use fake-gcs-server in tests.
+	EndpointURL string `bson:"endpointUrl,omitempty" json:"endpointUrl,omitempty" yaml:"endpointUrl,omitempty"`
+
+	// UploadPartSize is the size (in bytes) of parts for the parallel
+	// composite upload. Objects not bigger than a part are uploaded at once.
+	UploadPartSize int `bson:"uploadPartSize,omitempty" json:"uploadPartSize,omitempty" yaml:"uploadPartSize,omitempty"`
+}
+
+// Credentials of the service account. Usually taken from the
+// service account's JSON key file. If empty, the token is requested
+// from the metadata server (attached service account or GKE workload identity).
+type Credentials struct {
+	ClientEmail string `bson:"clientEmail" json:"clientEmail,omitempty" yaml:"clientEmail,omitempty"`
+	PrivateKey  string `bson:"privateKey" json:"privateKey,omitempty" yaml:"privateKey,omitempty"`
+}
+
+type GCS struct {
+	opts     Conf
+	log      *log.Event
+	endpoint string
+	cl       *http.Client
+	auth     tokenSource
+}
+
+func New(opts Conf, l *log.Event) (*GCS, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("bucket should be set")
+	}
+
+	g := &GCS{
+		opts:     opts,
+		log:      l,
+		endpoint: defaultEndpoint,
+		cl:       &http.Client{Transport: transport()},
+	}
+	if opts.EndpointURL != "" {
+		g.endpoint = strings.TrimSuffix(opts.EndpointURL, "/")
+	}
+
+	switch {
+	case opts.Credentials.ClientEmail != "" || opts.Credentials.PrivateKey != "":
+		auth, err := serviceAccount(g.cl, opts.Credentials.ClientEmail, opts.Credentials.PrivateKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "init service account credentials")
+		}
+		g.auth = auth
+	case opts.EndpointURL != "":
+		g.auth = anonymous{}
+	default:
+		g.auth = metadataServer(g.cl)
+	}
+
+	return g, nil
+}
+
+// transport doesn't limit the whole request time as downloads of big
+// objects may take long. But the server should start to respond in time.
+func transport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = headerTimeout
+	return t
+}
+
+func (*GCS) Type() storage.Type {
+	return storage.GCS
+}
+
+func (g *GCS) key(name string) string {
+	return path.Join(g.opts.Prefix, name)
+}
+
+func (g *GCS) bucketURL() string {
+	return g.endpoint + "/storage/v1/b/" + url.PathEscape(g.opts.Bucket)
+}
+
+func (g *GCS) objectURL(key string) string {
+	return g.bucketURL() + "/o/" + url.PathEscape(key)
+}
+
+func (g *GCS) List(prefix, suffix string) ([]storage.FileInfo, error) {
+	prfx := g.key(prefix)
+	if prfx != "" && !strings.HasSuffix(prfx, "/") {
+		prfx += "/"
+	}
+
+	var files []storage.FileInfo
+	var pageToken string
+	for {
+		q := url.Values{
+			"prefix":     {prfx},
+			"maxResults": {strconv.Itoa(listPageMaxSize)},
+			"fields":     {"items(name,size),nextPageToken"},
+		}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+
+		var page struct {
+			Items         []object `json:"items"`
+			NextPageToken string   `json:"nextPageToken"`
+		}
+		err := g.getJSON(g.bucketURL()+"/o?"+q.Encode(), &page)
+		if err != nil {
+			return nil, errors.Wrap(err, "list objects")
+		}
+
+		for _, o := range page.Items {
+			f := strings.TrimPrefix(o.Name, prfx)
+			if len(f) == 0 {
+				continue
+			}
+			if f[0] == '/' {
+				f = f[1:]
+			}
+
+			if strings.HasSuffix(f, suffix) {
+				files = append(files, storage.FileInfo{
+					Name: f,
+					Size: o.size(),
+				})
+			}
+		}
+
+		if page.NextPageToken == "" {
+			return files, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (g *GCS) FileStat(name string) (inf storage.FileInfo, err error) {
+	var o object
+	err = g.getJSON(g.objectURL(g.key(name))+"?fields=name,size", &o)
+	if err != nil {
+		if isNotFound(err) {
+			return inf, storage.ErrNotExist
+		}
+		return inf, errors.Wrap(err, "get properties")
+	}
+
+	inf.Name = name
+	inf.Size = o.size()
+	if inf.Size == 0 {
+		return inf, storage.ErrEmpty
+	}
+
+	return inf, nil
+}
+
+func (g *GCS) Copy(src, dst string) error {
+	u := g.objectURL(g.key(src)) + "/rewriteTo/b/" + url.PathEscape(g.opts.Bucket) + "/o/" + url.PathEscape(g.key(dst))
+
+	// big objects could be copied in a few calls
+	var rewriteToken string
+	for {
+		ru := u
+		if rewriteToken != "" {
+			ru += "?" + url.Values{"rewriteToken": {rewriteToken}}.Encode()
+		}
+
+		var r struct {
+			Done         bool   `json:"done"`
+			RewriteToken string `json:"rewriteToken"`
+		}
+		err := g.sendJSON(http.MethodPost, ru, struct{}{}, &r)
+		if err != nil {
+			return errors.Wrap(err, "rewrite object")
+		}
+		if r.Done {
+			return nil
+		}
+		if r.RewriteToken == "" {
+			return errors.New("rewrite isn't done but no token returned")
+		}
+		rewriteToken = r.RewriteToken
+	}
+}
+
+func (g *GCS) SourceReader(name string) (io.ReadCloser, error) {
+	resp, err := g.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, g.objectURL(g.key(name))+"?alt=media", nil)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "download object")
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		err = parseError(resp)
+		if isNotFound(err) {
+			return nil, storage.ErrNotExist
+		}
+		return nil, errors.Wrap(err, "download object")
+	}
+
+	return resp.Body, nil
+}
+
+func (g *GCS) Delete(name string) error {
+	err := g.delete(g.key(name))
+	if err != nil {
+		if isNotFound(err) {
+			return storage.ErrNotExist
+		}
+		return errors.Wrap(err, "delete object")
+	}
+
+	return nil
+}
+
+func (g *GCS) delete(key string) error {
+	resp, err := g.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodDelete, g.objectURL(key), nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return parseError(resp)
+	}
+
+	return nil
+}
+
+// object is the object's resource. The JSON API returns int64 as strings.
+type object struct {
+	Name string      `json:"name"`
+	Size json.Number `json:"size"`
+}
+
+func (o object) size() int64 {
+	n, _ := o.Size.Int64()
+	return n
+}
+
+// do sends the request created by newReq and retries it on network
+// errors, 429 and 5xx responses. newReq is called for every attempt
+// as the request body can't be reused.
+func (g *GCS) do(newReq func() (*http.Request, error)) (*http.Response, error) {
+	var lastErr error
+	for i := 0; i < defaultRetries; i++ {
+		if i > 0 {
+			d := time.Duration(1<<uint(i-1)) * 100 * time.Millisecond
+			if d > maxRetryDelay {
+				d = maxRetryDelay
+			}
+			time.Sleep(d)
+		}
+
+		req, err := newReq()
+		if err != nil {
+			return nil, errors.Wrap(err, "create request")
+		}
+
+		tok, err := g.auth.token()
+		if err != nil {
+			lastErr = errors.Wrap(err, "get auth token")
+			continue
+		}
+		if tok != "" {
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+
+		resp, err := g.cl.Do(req)
+		if err != nil {
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = parseError(resp)
+			resp.Body.Close()
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, errors.Wrapf(lastErr, "failed after %d attempts", defaultRetries)
+}
+
+func (g *GCS) getJSON(u string, v interface{}) error {
+	resp, err := g.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, u, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return parseError(resp)
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "decode response")
+}
+
+func (g *GCS) sendJSON(method, u string, body, v interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "marshal request")
+	}
+
+	resp, err := g.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(method, u, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return parseError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "decode response")
+}
+
+// apiError is the error returned by the JSON API
+type apiError struct {
+	Code    int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+func parseError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var r struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	msg := strings.TrimSpace(string(b))
+	if json.Unmarshal(b, &r) == nil && r.Error.Message != "" {
+		msg = r.Error.Message
+	}
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+
+	return &apiError{Code: resp.StatusCode, Message: msg}
+}
+
+func isNotFound(err error) bool {
+	var e *apiError
+	return errors.As(err, &e) && e.Code == http.StatusNotFound
+}
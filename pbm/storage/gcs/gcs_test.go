@@ -0,0 +1,171 @@
+package gcs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// The tests run against fake-gcs-server (https://github.com/fsouza/fake-gcs-server)
+// and skipped if GCS_TEST_ENDPOINT isn't set. E.g.:
+//
+//	fake-gcs-server -scheme http -port 4443 -backend memory
+//	GCS_TEST_ENDPOINT=http://localhost:4443 go test ./pbm/storage/gcs/
+func testStorage(t *testing.T) *GCS {
+	t.Helper()
+
+	endpoint := os.Getenv("GCS_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("GCS_TEST_ENDPOINT isn't set")
+	}
+
+	bucket := "pbm-test"
+	resp, err := http.Post(endpoint+"/storage/v1/b", "application/json",
+		strings.NewReader(`{"name":"`+bucket+`"}`))
+	if err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	resp.Body.Close()
+
+	g, err := New(Conf{
+		Bucket:         bucket,
+		Prefix:         strings.ReplaceAll(t.Name(), "/", "_"),
+		EndpointURL:    endpoint,
+		UploadPartSize: minPartSize,
+	}, log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{}))
+	if err != nil {
+		t.Fatalf("create storage: %v", err)
+	}
+
+	return g
+}
+
+func TestSaveAndRead(t *testing.T) {
+	g := testStorage(t)
+
+	cases := map[string]int{
+		"empty":      0,
+		"small":      1024,
+		"part":       minPartSize,
+		"composite":  3*minPartSize + 42,
+		"hierarchic": (maxComposeSources + 2) * minPartSize,
+	}
+
+	for name, size := range cases {
+		data := make([]byte, size)
+		_, _ = rand.Read(data)
+
+		t.Run(name, func(t *testing.T) {
+			err := g.Save(name, bytes.NewReader(data), -1)
+			if err != nil {
+				t.Fatalf("save: %v", err)
+			}
+
+			inf, err := g.FileStat(name)
+			if size == 0 {
+				if !errors.Is(err, storage.ErrEmpty) {
+					t.Fatalf("stat: expected ErrEmpty, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("stat: %v", err)
+			}
+			if inf.Size != int64(size) {
+				t.Fatalf("stat: size %d, expected %d", inf.Size, size)
+			}
+
+			check := func(rc io.ReadCloser, err error) {
+				t.Helper()
+				if err != nil {
+					t.Fatalf("get reader: %v", err)
+				}
+				defer rc.Close()
+
+				got, err := io.ReadAll(rc)
+				if err != nil {
+					t.Fatalf("read: %v", err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("data mismatch: got %d bytes, expected %d", len(got), len(data))
+				}
+			}
+
+			check(g.SourceReader(name))
+			check(g.NewDownload(4, 0, 1).SourceReader(name))
+		})
+	}
+
+	// temporary parts are deleted
+	files, err := g.List(partsDir, "")
+	if err != nil {
+		t.Fatalf("list parts: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("temporary parts left: %v", files)
+	}
+}
+
+func TestListCopyDelete(t *testing.T) {
+	g := testStorage(t)
+
+	for _, name := range []string{"a/1.json", "a/2.json", "a/b/3.json", "a/4.txt", "c/5.json"} {
+		err := g.Save(name, strings.NewReader(name), -1)
+		if err != nil {
+			t.Fatalf("save %s: %v", name, err)
+		}
+	}
+
+	files, err := g.List("a", ".json")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "1.json,2.json,b/3.json" {
+		t.Fatalf("list: unexpected files %v", names)
+	}
+
+	err = g.Copy("a/1.json", "d/1.json")
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	rc, err := g.SourceReader("d/1.json")
+	if err != nil {
+		t.Fatalf("read copy: %v", err)
+	}
+	b, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(b) != "a/1.json" {
+		t.Fatalf("copy: unexpected content %q", b)
+	}
+
+	err = g.Delete("d/1.json")
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	_, err = g.FileStat("d/1.json")
+	if !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("stat deleted: expected ErrNotExist, got %v", err)
+	}
+	err = g.Delete("d/1.json")
+	if !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("delete deleted: expected ErrNotExist, got %v", err)
+	}
+	_, err = g.SourceReader("d/1.json")
+	if !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("read deleted: expected ErrNotExist, got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package gcs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Uploading objects to the storage.
+//
+// Objects that fit into a single part are uploaded with one request.
+// Bigger objects are uploaded with the parallel composite upload: data is
+// split into parts that are uploaded concurrently as temporary objects and
+// then composed into the destination object. The compose request accepts
+// up to 32 source objects, so parts are composed hierarchically. Temporary
+// objects are deleted once the upload is done.
+// See https://cloud.google.com/storage/docs/parallel-composite-uploads
+
+const (
+	defaultPartSize = 16 << 20
+	minPartSize     = 5 << 20
+
+	// composite object can't have more than 1024 components
+	maxComponents     = 1024
+	maxComposeSources = 32
+
+	// if the size of the object isn't known the part size is
+	// doubled each partsGrowStep parts so the object fits maxComponents
+	partsGrowStep = 128
+
+	partsDir = ".pbm.parts"
+)
+
+func (g *GCS) Save(name string, data io.Reader, sizeb int64) error {
+	partSize := g.partSize(sizeb)
+
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(data, buf)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		// fits into a single part
+		err = g.upload(g.key(name), buf[:n])
+		return errors.Wrap(err, "upload object")
+	case err != nil:
+		return errors.Wrap(err, "read data")
+	}
+
+	return g.compositeUpload(name, data, buf, sizeb)
+}
+
+// partSize returns the size of parts. If the object size is known, parts are
+// enlarged so the object fits maxComponents with a margin as the size might
+// be not exact (e.g. a file is growing while being uploaded).
+func (g *GCS) partSize(sizeb int64) int {
+	ps := defaultPartSize
+	if g.opts.UploadPartSize > 0 {
+		ps = g.opts.UploadPartSize
+		if ps < minPartSize {
+			ps = minPartSize
+		}
+	}
+
+	if sizeb > 0 {
+		if s := sizeb/(maxComponents/2) + 1; s > int64(ps) {
+			ps = int(s)
+		}
+	}
+
+	return ps
+}
+
+type uploadPart struct {
+	name string
+	data []byte
+}
+
+// compositeUpload uploads the data concurrently by parts. first is
+// the part that already has been read from the data.
+func (g *GCS) compositeUpload(name string, data io.Reader, first []byte, sizeb int64) error {
+	tmp := path.Join(g.opts.Prefix, partsDir, name+"."+strconv.FormatInt(time.Now().UnixNano(), 36))
+
+	cc := runtime.NumCPU() / 2
+	if cc == 0 {
+		cc = 1
+	}
+
+	var (
+		mu      sync.Mutex
+		upldErr error
+	)
+	setErr := func(err error) {
+		mu.Lock()
+		if upldErr == nil {
+			upldErr = err
+		}
+		mu.Unlock()
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return upldErr != nil
+	}
+
+	// there are up to cc+1 buffers in use: cc are being uploaded
+	// and one is being filled with data
+	free := make(chan []byte, cc+1)
+	bufs := 1
+
+	partsC := make(chan uploadPart)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < cc; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range partsC {
+				if !failed() {
+					err := g.upload(p.name, p.data)
+					if err != nil {
+						setErr(errors.Wrapf(err, "upload part %s", p.name))
+					}
+				}
+				free <- p.data
+			}
+		}()
+	}
+
+	var parts []string
+	buf, partSize := first, len(first)
+	for {
+		pname := fmt.Sprintf("%s/%06d", tmp, len(parts))
+		parts = append(parts, pname)
+		partsC <- uploadPart{name: pname, data: buf}
+
+		if failed() {
+			break
+		}
+		if len(buf) < partSize {
+			// the last part
+			break
+		}
+		if sizeb <= 0 && len(parts)%partsGrowStep == 0 {
+			partSize *= 2
+		}
+
+		if bufs <= cc {
+			buf = nil
+			bufs++
+		} else {
+			buf = <-free
+		}
+		if cap(buf) < partSize {
+			buf = make([]byte, partSize)
+		}
+		buf = buf[:partSize]
+
+		n, err := io.ReadFull(data, buf)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			if !errors.Is(err, io.EOF) {
+				setErr(errors.Wrap(err, "read data"))
+			}
+			break
+		}
+		if len(parts) == maxComponents {
+			setErr(errors.Errorf("object exceeds %d parts of %d bytes", maxComponents, partSize))
+			break
+		}
+		buf = buf[:n]
+	}
+	close(partsC)
+	wg.Wait()
+
+	created := parts
+	if upldErr == nil {
+		var tmpObjs []string
+		tmpObjs, upldErr = g.compose(g.key(name), parts, tmp)
+		created = append(created, tmpObjs...)
+	}
+
+	for _, o := range created {
+		err := g.delete(o)
+		if err != nil && !isNotFound(err) {
+			g.log.Warning("delete temporary object %s: %v", o, err)
+		}
+	}
+
+	return upldErr
+}
+
+// compose composes parts into dst. If there are more than maxComposeSources
+// parts, they're composed by groups into intermediate objects first.
+// It returns the names of created intermediate objects.
+func (g *GCS) compose(dst string, parts []string, tmp string) ([]string, error) {
+	var created []string
+	for lvl := 0; len(parts) > maxComposeSources; lvl++ {
+		var next []string
+		for i := 0; i < len(parts); i += maxComposeSources {
+			end := i + maxComposeSources
+			if end > len(parts) {
+				end = len(parts)
+			}
+
+			obj := fmt.Sprintf("%s/c%d.%06d", tmp, lvl, i/maxComposeSources)
+			created = append(created, obj)
+			err := g.composeObjects(obj, parts[i:end])
+			if err != nil {
+				return created, errors.Wrapf(err, "compose %s", obj)
+			}
+			next = append(next, obj)
+		}
+		parts = next
+	}
+
+	err := g.composeObjects(dst, parts)
+	return created, errors.Wrap(err, "compose object")
+}
+
+func (g *GCS) composeObjects(dst string, srcs []string) error {
+	type src struct {
+		Name string `json:"name"`
+	}
+	req := struct {
+		SourceObjects []src `json:"sourceObjects"`
+		Destination   struct {
+			ContentType string `json:"contentType"`
+		} `json:"destination"`
+	}{}
+	for _, s := range srcs {
+		req.SourceObjects = append(req.SourceObjects, src{Name: s})
+	}
+	req.Destination.ContentType = "application/octet-stream"
+
+	return g.sendJSON(http.MethodPost, g.objectURL(dst)+"/compose", req, nil)
+}
+
+// upload uploads data as the object with a single request
+func (g *GCS) upload(key string, data []byte) error {
+	u := g.endpoint + "/upload/storage/v1/b/" + url.PathEscape(g.opts.Bucket) +
+		"/o?" + url.Values{"uploadType": {"media"}, "name": {key}}.Encode()
+
+	resp, err := g.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return parseError(resp)
+	}
+
+	return nil
+}
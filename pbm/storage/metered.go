@@ -0,0 +1,90 @@
+package storage
+
+import "io"
+
+// Metered is a Storage that reports the amount of
+// uploaded and downloaded bytes.
+type Metered struct {
+	Storage
+	up   func(float64)
+	down func(float64)
+}
+
+// NewMetered wraps s so that up and down are called with the number
+// of bytes written to and read from the storage.
+func NewMetered(s Storage, up, down func(float64)) *Metered {
+	return &Metered{Storage: s, up: up, down: down}
+}
+
+// Unwrap returns the underlying storage.
+func (m *Metered) Unwrap() Storage {
+	return m.Storage
+}
+
+func (m *Metered) Save(name string, data io.Reader, size int64) error {
+	return m.Storage.Save(name, &countReader{r: data, fn: m.up}, size)
+}
+
+func (m *Metered) SourceReader(name string) (io.ReadCloser, error) {
+	return m.MeterSourceReader(m.Storage.SourceReader)(name)
+}
+
+// MeterSourceReader counts bytes read via the given reader func (e.g.
+// the underlying storage specific downloader) as downloaded.
+func (m *Metered) MeterSourceReader(fn func(string) (io.ReadCloser, error)) func(string) (io.ReadCloser, error) {
+	return func(name string) (io.ReadCloser, error) {
+		rc, err := fn(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{&countReader{r: rc, fn: m.down}, rc}, nil
+	}
+}
+
+// Unwrap returns the underlying storage if s is a wrapper (e.g. Metered).
+func Unwrap(s Storage) Storage {
+	for {
+		w, ok := s.(interface{ Unwrap() Storage })
+		if !ok {
+			return s
+		}
+		s = w.Unwrap()
+	}
+}
+
+// WrapSourceReader applies the wrappers of s (Metered, Throttled) to the
+// given reader func of the underlying storage (e.g. the storage specific
+// downloader).
+func WrapSourceReader(s Storage, fn func(string) (io.ReadCloser, error)) func(string) (io.ReadCloser, error) {
+	for {
+		switch w := s.(type) {
+		case *Metered:
+			fn = w.MeterSourceReader(fn)
+		case *Throttled:
+			fn = w.ThrottleSourceReader(fn)
+		}
+
+		u, ok := s.(interface{ Unwrap() Storage })
+		if !ok {
+			return fn
+		}
+		s = u.Unwrap()
+	}
+}
+
+type countReader struct {
+	r  io.Reader
+	fn func(float64)
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.fn(float64(n))
+	}
+	return n, err
+}
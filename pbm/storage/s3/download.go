@@ -18,6 +18,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
 // Downloading objects from the storage.
@@ -169,6 +170,7 @@ type partReader struct {
 
 	getSess func() (*s3.S3, error)
 	l       *log.Event
+	lim     *storage.Limiter
 	opts    *Conf
 	buf     []byte // preallocated buf for io.Copy
 
@@ -181,6 +183,7 @@ type partReader struct {
 func (s *S3) newPartReader(fname string, fsize int64, chunkSize int) *partReader {
 	return &partReader{
 		l:         s.log,
+		lim:       s.lim,
 		buf:       make([]byte, 32*1024),
 		opts:      &s.opts,
 		fname:     fname,
@@ -418,6 +421,7 @@ func (pr *partReader) getChunk(buf *arena, s *s3.S3, start, end int64) (io.ReadC
 		getObjOpts.SSECustomerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(keyMD5[:]))
 	}
 
+	pr.lim.WaitRequest()
 	s3obj, err := s.GetObject(getObjOpts)
 	if err != nil {
 		// if object size is undefined, we would read
@@ -447,7 +451,7 @@ func (pr *partReader) getChunk(buf *arena, s *s3.S3, start, end int64) (io.ReadC
 	}
 
 	ch := buf.getSpan()
-	_, err = io.CopyBuffer(ch, s3obj.Body, buf.cpbuf)
+	_, err = io.CopyBuffer(ch, storage.ThrottleReader(s3obj.Body, pr.lim), buf.cpbuf)
 	if err != nil {
 		ch.Close()
 		return nil, errors.Wrap(err, "copy")
@@ -222,6 +222,8 @@ type S3 struct {
 	s3s  *s3.S3
 
 	d *Download // default downloader for small files
+	// lim limits the requests rate and downloads bandwidth. Might be nil.
+	lim *storage.Limiter
 }
 
 func New(opts Conf, l *log.Event) (*S3, error) {
@@ -256,6 +258,13 @@ func (*S3) Type() storage.Type {
 	return storage.S3
 }
 
+// SetLimiter makes uploads and downloads (incl. all concurrent download
+// workers) respect l. Every request to S3 takes a token from l. Bytes
+// of the downloaded chunks are throttled by l as well.
+func (s *S3) SetLimiter(l *storage.Limiter) {
+	s.lim = l
+}
+
 func (s *S3) Save(name string, data io.Reader, sizeb int64) error {
 	switch s.opts.Provider {
 	default:
@@ -320,6 +329,7 @@ func (s *S3) Save(name string, data io.Reader, sizeb int64) error {
 			u.Concurrency = cc
 
 			u.RequestOptions = append(u.RequestOptions, func(r *request.Request) {
+				s.lim.WaitRequest()
 				if s.opts.Retryer != nil {
 					r.Retryer = client.DefaultRetryer{
 						NumMaxRetries: s.opts.Retryer.NumMaxRetries,
@@ -364,6 +374,7 @@ func (s *S3) Save(name string, data io.Reader, sizeb int64) error {
 			}
 		}
 
+		s.lim.WaitRequest()
 		_, err = mc.PutObject(s.opts.Bucket, path.Join(s.opts.Prefix, name), data, -1, putOpts)
 		return errors.Wrap(err, "upload to GCS")
 	}
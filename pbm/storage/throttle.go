@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limits are the storage traffic limits. Zero means no limit.
+type Limits struct {
+	// MaxMBps is the max bandwidth in megabytes per second
+	MaxMBps float64 `bson:"maxMBps,omitempty" json:"maxMBps,omitempty" yaml:"maxMBps,omitempty"`
+	// MaxRPS is the max number of the storage requests per second
+	MaxRPS float64 `bson:"maxRPS,omitempty" json:"maxRPS,omitempty" yaml:"maxRPS,omitempty"`
+}
+
+// With returns the limits overridden by the non-zero values of o (if any)
+func (l Limits) With(o *Limits) Limits {
+	if o == nil {
+		return l
+	}
+
+	if o.MaxMBps != 0 {
+		l.MaxMBps = o.MaxMBps
+	}
+	if o.MaxRPS != 0 {
+		l.MaxRPS = o.MaxRPS
+	}
+	return l
+}
+
+// bucket is a token bucket which can go into debt. So a big take doesn't
+// wait for the bucket to fill up over its capacity but the next ones do
+// wait for the debt to be paid off.
+type bucket struct {
+	rate   float64 // tokens per second. 0 means unlimited.
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) setRate(rate, minBurst float64) {
+	b.rate = rate
+	b.burst = rate
+	if b.burst < minBurst {
+		b.burst = minBurst
+	}
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// take takes n tokens and returns how long to wait for them
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+	} else {
+		b.tokens = b.burst
+	}
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Limiter limits the storage bandwidth and requests rate. It is safe for
+// concurrent use, so concurrent readers and writers share the limits.
+// A nil Limiter doesn't limit anything.
+type Limiter struct {
+	mu    sync.Mutex
+	lim   Limits
+	bytes bucket
+	reqs  bucket
+}
+
+func NewLimiter(l Limits) *Limiter {
+	lim := &Limiter{}
+	lim.SetLimits(l)
+	return lim
+}
+
+// SetLimits changes the limits on the fly
+func (l *Limiter) SetLimits(lim Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lim = lim
+	l.bytes.setRate(lim.MaxMBps*(1<<20), 0)
+	l.reqs.setRate(lim.MaxRPS, 1)
+}
+
+func (l *Limiter) Limits() Limits {
+	if l == nil {
+		return Limits{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lim
+}
+
+// WaitBytes blocks until n bytes can be transferred
+func (l *Limiter) WaitBytes(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	d := l.bytes.take(float64(n), time.Now())
+	l.mu.Unlock()
+
+	time.Sleep(d)
+}
+
+// WaitRequest blocks until a request to the storage can be made
+func (l *Limiter) WaitRequest() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	d := l.reqs.take(1, time.Now())
+	l.mu.Unlock()
+
+	time.Sleep(d)
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*Limiter)
+)
+
+// SharedLimiter returns the process-wide limiter of the given kind (e.g.
+// an operation type) with the limits set to lim. All storages of the
+// kind share the limiter, so the limits hold per process (agent)
+// regardless of the number of storage objects and concurrent transfers.
+func SharedLimiter(kind string, lim Limits) *Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	l, ok := limiters[kind]
+	if !ok {
+		l = NewLimiter(lim)
+		limiters[kind] = l
+		return l
+	}
+
+	if l.Limits() != lim {
+		l.SetLimits(lim)
+	}
+	return l
+}
+
+// Limitable is implemented by storages that apply the Limiter to their
+// downloads on their own. E.g. to have it respected by concurrent
+// download workers.
+type Limitable interface {
+	SetLimiter(l *Limiter)
+}
+
+// Throttled is a Storage with limited bandwidth and requests rate
+// of Save and SourceReader.
+type Throttled struct {
+	Storage
+	l *Limiter
+	// inner is true if the underlying storage limits downloads on its own
+	inner bool
+}
+
+// NewThrottled wraps s so that its uploads and downloads are limited by l.
+func NewThrottled(s Storage, l *Limiter) *Throttled {
+	t := &Throttled{Storage: s, l: l}
+	if ls, ok := Unwrap(s).(Limitable); ok {
+		ls.SetLimiter(l)
+		t.inner = true
+	}
+
+	return t
+}
+
+// Unwrap returns the underlying storage.
+func (t *Throttled) Unwrap() Storage {
+	return t.Storage
+}
+
+func (t *Throttled) Save(name string, data io.Reader, size int64) error {
+	// Limitable storage counts requests on its own as it may split the
+	// upload into several ones
+	if !t.inner {
+		t.l.WaitRequest()
+	}
+	return t.Storage.Save(name, ThrottleReader(data, t.l), size)
+}
+
+func (t *Throttled) SourceReader(name string) (io.ReadCloser, error) {
+	return t.ThrottleSourceReader(t.Storage.SourceReader)(name)
+}
+
+// ThrottleSourceReader limits reads via the given reader func (e.g. the
+// underlying storage specific downloader).
+func (t *Throttled) ThrottleSourceReader(fn func(string) (io.ReadCloser, error)) func(string) (io.ReadCloser, error) {
+	if t.inner {
+		return fn
+	}
+
+	return func(name string) (io.ReadCloser, error) {
+		t.l.WaitRequest()
+		rc, err := fn(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{ThrottleReader(rc, t.l), rc}, nil
+	}
+}
+
+// ThrottleReader returns a reader limited by l
+func ThrottleReader(r io.Reader, l *Limiter) io.Reader {
+	if l == nil {
+		return r
+	}
+
+	return &throttledReader{r: r, l: l}
+}
+
+type throttledReader struct {
+	r io.Reader
+	l *Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.l.WaitBytes(n)
+	return n, err
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBucketTake(t *testing.T) {
+	b := bucket{}
+	b.setRate(10, 1)
+
+	now := time.Unix(100, 0)
+	if d := b.take(10, now); d != 0 {
+		t.Errorf("full bucket: expected no wait, got %v", d)
+	}
+	if d := b.take(5, now); d != time.Second/2 {
+		t.Errorf("empty bucket: expected 0.5s wait, got %v", d)
+	}
+	// the debt is paid off in 0.5s and 1s more fills up 10 tokens
+	if d := b.take(10, now.Add(time.Second*3/2)); d != 0 {
+		t.Errorf("refilled bucket: expected no wait, got %v", d)
+	}
+	// a big take goes into debt instead of the infinite wait
+	if d := b.take(30, now.Add(time.Second*5/2)); d != time.Second*2 {
+		t.Errorf("big take: expected 2s wait, got %v", d)
+	}
+
+	unlim := bucket{}
+	if d := unlim.take(1<<30, now); d != 0 {
+		t.Errorf("unlimited: expected no wait, got %v", d)
+	}
+}
+
+func TestLimitsWith(t *testing.T) {
+	conf := Limits{MaxMBps: 100, MaxRPS: 50}
+
+	if l := conf.With(nil); l != conf {
+		t.Errorf("nil override: expected %v, got %v", conf, l)
+	}
+	if l := conf.With(&Limits{MaxMBps: 10}); l != (Limits{MaxMBps: 10, MaxRPS: 50}) {
+		t.Errorf("partial override: got %v", l)
+	}
+}
+
+func TestSharedLimiter(t *testing.T) {
+	l1 := SharedLimiter("test", Limits{MaxMBps: 1})
+	l2 := SharedLimiter("test", Limits{MaxMBps: 2})
+	if l1 != l2 {
+		t.Fatal("expected the same limiter for the same kind")
+	}
+	if l1.Limits().MaxMBps != 2 {
+		t.Errorf("expected limits to be updated, got %v", l1.Limits())
+	}
+	if SharedLimiter("test2", Limits{}) == l1 {
+		t.Error("expected different limiters for different kinds")
+	}
+}
+
+func TestThrottleReaderNil(t *testing.T) {
+	var l *Limiter
+	b, err := io.ReadAll(ThrottleReader(bytes.NewReader([]byte("data")), l))
+	if err != nil || string(b) != "data" {
+		t.Errorf("unexpected read %q, %v", b, err)
+	}
+	l.WaitRequest()
+}
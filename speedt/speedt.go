@@ -136,7 +136,7 @@ func Run(nodeCN *mongo.Client, stg storage.Storage, compression compress.Compres
 
 	r := &Results{}
 	ts := time.Now()
-	size, err := backup.Upload(context.Background(), src, stg, compression, level, fileName, -1)
+	size, _, err := backup.Upload(context.Background(), src, stg, compression, level, fileName, -1)
 	r.Size = Byte(size)
 	if err != nil {
 		return nil, errors.Wrap(err, "upload")